@@ -0,0 +1,321 @@
+/*
+ * MIT License
+ *
+ * Copyright (c) 2021 TechCraft Technologies Co. Ltd
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ *
+ */
+
+// Package bulk reads disbursement requests from csv files prepared by
+// finance staff and writes the results of disbursing them back to csv.
+//
+// The input file must have a header row with the columns reference, msisdn
+// and amount in any order, other columns are ignored:
+//
+//	reference,msisdn,amount
+//	PAYROLL-0001,255713123456,150000
+//	PAYROLL-0002,255654123456,98000.50
+package bulk
+
+import (
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/techcraftlabs/tigopesa/disburse"
+	"github.com/techcraftlabs/tigopesa/money"
+	"github.com/techcraftlabs/tigopesa/msisdn"
+)
+
+const (
+	ColumnReference = "reference"
+	ColumnMSISDN    = "msisdn"
+	ColumnAmount    = "amount"
+
+	// spreadsheet programs often start csv exports with a byte order mark
+	byteOrderMark = "\ufeff"
+)
+
+var (
+	ErrMissingColumn      = errors.New("missing column")
+	ErrEmptyReference     = errors.New("empty reference")
+	ErrDuplicateReference = errors.New("duplicate reference")
+	ErrInvalidMSISDN      = msisdn.ErrInvalid
+	ErrInvalidAmount      = errors.New("invalid amount")
+)
+
+var (
+	_ error = (*LineError)(nil)
+	_ error = (Errors)(nil)
+
+	resultHeader = []string{
+		ColumnReference, ColumnMSISDN, ColumnAmount,
+		"txn_id", "txn_status", "message", "status", "error",
+	}
+)
+
+type (
+	// LineError is a problem with a single value of the input file.
+	// Line is the line number in the file starting at 1.
+	LineError struct {
+		Line   int
+		Column string
+		Value  string
+		Err    error
+	}
+
+	// Errors is returned by ParseCSV with every problem found in the file
+	Errors []*LineError
+
+	// ParseOption changes how ParseCSV validates the file
+	ParseOption func(p *parser)
+
+	parser struct {
+		normalizer msisdn.Normalizer
+	}
+)
+
+// WithNormalizer sets the msisdn.Normalizer used to validate and normalize
+// the msisdn column, msisdn.Default is used otherwise.
+func WithNormalizer(normalizer msisdn.Normalizer) ParseOption {
+	return func(p *parser) {
+		if normalizer == nil {
+			return
+		}
+		p.normalizer = normalizer
+	}
+}
+
+func (e *LineError) Error() string {
+	if e.Column == "" {
+		return fmt.Sprintf("line %d: %v", e.Line, e.Err)
+	}
+
+	return fmt.Sprintf("line %d: %s %q: %v", e.Line, e.Column, e.Value, e.Err)
+}
+
+func (e *LineError) Unwrap() error {
+	return e.Err
+}
+
+func (e Errors) Error() string {
+	msgs := make([]string, len(e))
+	for i, err := range e {
+		msgs[i] = err.Error()
+	}
+
+	return strings.Join(msgs, "\n")
+}
+
+// Is reports whether any of the problems matches target
+func (e Errors) Is(target error) bool {
+	for _, err := range e {
+		if errors.Is(err, target) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// ParseCSV reads disbursement requests from r. The whole file is validated
+// before returning, when there are problems the returned error is Errors
+// with one *LineError for each of them and no requests are returned. The
+// MSISDNs of the requests are normalized.
+func ParseCSV(r io.Reader, opts ...ParseOption) ([]disburse.Request, error) {
+	p := &parser{normalizer: msisdn.Default}
+	for _, opt := range opts {
+		opt(p)
+	}
+
+	reader := csv.NewReader(r)
+	reader.TrimLeadingSpace = true
+	reader.FieldsPerRecord = -1
+
+	header, err := reader.Read()
+	if errors.Is(err, io.EOF) {
+		return nil, Errors{{Line: 1, Err: fmt.Errorf("%w: empty file", ErrMissingColumn)}}
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	columns, err := columnIndexes(header)
+	if err != nil {
+		return nil, err
+	}
+
+	var (
+		requests []disburse.Request
+		problems Errors
+		seen     = make(map[string]int)
+	)
+
+	for {
+		record, err := reader.Read()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		line, _ := reader.FieldPos(0)
+		if isBlank(record) {
+			continue
+		}
+
+		value := func(column string) string {
+			index := columns[column]
+			if index >= len(record) {
+				return ""
+			}
+			return strings.TrimSpace(record[index])
+		}
+
+		request, errs := p.parseRecord(line, value, seen)
+
+		// a reference counts as used even on a line with other problems
+		if _, ok := seen[request.ReferenceID]; !ok && request.ReferenceID != "" {
+			seen[request.ReferenceID] = line
+		}
+
+		if len(errs) > 0 {
+			problems = append(problems, errs...)
+			continue
+		}
+
+		requests = append(requests, request)
+	}
+
+	if len(problems) > 0 {
+		return nil, problems
+	}
+
+	return requests, nil
+}
+
+func (p *parser) parseRecord(line int, value func(string) string, seen map[string]int) (disburse.Request, Errors) {
+	var errs Errors
+	problem := func(column, v string, err error) {
+		errs = append(errs, &LineError{Line: line, Column: column, Value: v, Err: err})
+	}
+
+	reference := value(ColumnReference)
+	if reference == "" {
+		problem(ColumnReference, reference, ErrEmptyReference)
+	} else if first, ok := seen[reference]; ok {
+		problem(ColumnReference, reference, fmt.Errorf("%w: first used on line %d", ErrDuplicateReference, first))
+	}
+
+	rawMSISDN := value(ColumnMSISDN)
+	number, err := p.normalizer.Normalize(rawMSISDN)
+	var numberErr *msisdn.Error
+	if errors.As(err, &numberErr) {
+		err = numberErr.Err
+	}
+	if err != nil {
+		problem(ColumnMSISDN, rawMSISDN, err)
+	}
+
+	rawAmount := value(ColumnAmount)
+	amount, err := money.Parse(rawAmount)
+	if err != nil || amount.Sign() <= 0 {
+		problem(ColumnAmount, rawAmount, fmt.Errorf("%w: must be a number greater than zero", ErrInvalidAmount))
+	}
+
+	return disburse.Request{
+		ReferenceID: reference,
+		MSISDN:      number,
+		Amount:      amount,
+	}, errs
+}
+
+func columnIndexes(header []string) (map[string]int, error) {
+	columns := make(map[string]int)
+	for i, name := range header {
+		name = strings.ToLower(strings.TrimSpace(strings.TrimPrefix(name, byteOrderMark)))
+		if _, ok := columns[name]; !ok {
+			columns[name] = i
+		}
+	}
+
+	var problems Errors
+	for _, name := range []string{ColumnReference, ColumnMSISDN, ColumnAmount} {
+		if _, ok := columns[name]; !ok {
+			problems = append(problems, &LineError{Line: 1, Err: fmt.Errorf("%w: %s", ErrMissingColumn, name)})
+		}
+	}
+
+	if len(problems) > 0 {
+		return nil, problems
+	}
+
+	return columns, nil
+}
+
+func isBlank(record []string) bool {
+	for _, field := range record {
+		if strings.TrimSpace(field) != "" {
+			return false
+		}
+	}
+
+	return true
+}
+
+// WriteResultCSV writes a row for every item of report in the order the
+// requests were passed to disburse.Client.DisburseBatch. The input columns
+// are followed by the TxnID, TxnStatus and Message returned by tigo and by
+// the status of the item and the error, if any.
+func WriteResultCSV(w io.Writer, report disburse.BatchReport) error {
+	writer := csv.NewWriter(w)
+	if err := writer.Write(resultHeader); err != nil {
+		return err
+	}
+
+	for _, item := range report.Items {
+		var errMsg string
+		if item.Err != nil {
+			errMsg = item.Err.Error()
+		}
+
+		record := []string{
+			item.Request.ReferenceID,
+			item.Request.MSISDN,
+			item.Request.Amount.String(),
+			item.Response.TxnID,
+			item.Response.TxnStatus,
+			item.Response.Message,
+			string(item.Status),
+			errMsg,
+		}
+
+		if err := writer.Write(record); err != nil {
+			return err
+		}
+	}
+
+	writer.Flush()
+
+	return writer.Error()
+}
@@ -0,0 +1,158 @@
+/*
+ * MIT License
+ *
+ * Copyright (c) 2021 TechCraft Technologies Co. Ltd
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ *
+ */
+
+package bulk_test
+
+import (
+	"bytes"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/techcraftlabs/tigopesa/bulk"
+	"github.com/techcraftlabs/tigopesa/disburse"
+	"github.com/techcraftlabs/tigopesa/money"
+	"github.com/techcraftlabs/tigopesa/msisdn"
+)
+
+func TestParseCSV(t *testing.T) {
+	input := "\ufeffAmount,Reference,MSISDN,Name\n" +
+		"150000,PAYROLL-0001,255713123456,John\n" +
+		"\n" +
+		"98000.50,PAYROLL-0002,0654 123 456,Jane\n"
+
+	requests, err := bulk.ParseCSV(strings.NewReader(input))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := []disburse.Request{
+		{ReferenceID: "PAYROLL-0001", MSISDN: "255713123456", Amount: money.Shillings(150000)},
+		{ReferenceID: "PAYROLL-0002", MSISDN: "255654123456", Amount: money.MustParse("98000.50")},
+	}
+	if len(requests) != len(want) {
+		t.Fatalf("got %d requests want %d", len(requests), len(want))
+	}
+	for i := range want {
+		if requests[i] != want[i] {
+			t.Errorf("request %d: got %+v want %+v", i, requests[i], want[i])
+		}
+	}
+}
+
+func TestParseCSV_Errors(t *testing.T) {
+	input := "reference,msisdn,amount\n" +
+		"PAYROLL-0001,255713123456,1000\n" +
+		"PAYROLL-0001,255713123457,1000\n" +
+		"PAYROLL-0003,07131234ab,1000\n" +
+		"PAYROLL-0004,255713123456,-5\n" +
+		",255713123456,abc\n" +
+		"PAYROLL-0007,0754123456,1000\n"
+
+	_, err := bulk.ParseCSV(strings.NewReader(input))
+
+	var problems bulk.Errors
+	if !errors.As(err, &problems) {
+		t.Fatalf("expected bulk.Errors got %v", err)
+	}
+
+	want := []struct {
+		line int
+		err  error
+	}{
+		{3, bulk.ErrDuplicateReference},
+		{4, bulk.ErrInvalidMSISDN},
+		{5, bulk.ErrInvalidAmount},
+		{6, bulk.ErrEmptyReference},
+		{6, bulk.ErrInvalidAmount},
+		{7, msisdn.ErrNotTigo},
+	}
+	if len(problems) != len(want) {
+		t.Fatalf("got %d problems want %d:\n%v", len(problems), len(want), err)
+	}
+	for i, w := range want {
+		if problems[i].Line != w.line || !errors.Is(problems[i], w.err) {
+			t.Errorf("problem %d: got %v want line %d %v", i, problems[i], w.line, w.err)
+		}
+	}
+}
+
+func TestParseCSV_DuplicateOfInvalidLine(t *testing.T) {
+	input := "reference,msisdn,amount\n" +
+		"PAYROLL-0001,255713123456,abc\n" +
+		"PAYROLL-0001,255713123457,1000\n"
+
+	_, err := bulk.ParseCSV(strings.NewReader(input))
+
+	var problems bulk.Errors
+	if !errors.As(err, &problems) {
+		t.Fatalf("expected bulk.Errors got %v", err)
+	}
+
+	if len(problems) != 2 {
+		t.Fatalf("got %d problems want 2:\n%v", len(problems), err)
+	}
+
+	if problems[1].Line != 3 || !errors.Is(problems[1], bulk.ErrDuplicateReference) {
+		t.Errorf("got %v want line 3 %v", problems[1], bulk.ErrDuplicateReference)
+	}
+}
+
+func TestParseCSV_MissingColumn(t *testing.T) {
+	_, err := bulk.ParseCSV(strings.NewReader("reference,amount\nREF,100\n"))
+	if !errors.Is(err, bulk.ErrMissingColumn) {
+		t.Fatalf("expected missing column error got %v", err)
+	}
+}
+
+func TestWriteResultCSV(t *testing.T) {
+	report := disburse.BatchReport{
+		Items: []disburse.ItemResult{
+			{
+				Request:  disburse.Request{ReferenceID: "PAYROLL-0001", MSISDN: "255713123456", Amount: money.MustParse("1500.5")},
+				Response: disburse.Response{TxnID: "TXN1", TxnStatus: "error000", Message: "done"},
+				Status:   disburse.StatusSucceeded,
+			},
+			{
+				Request:  disburse.Request{ReferenceID: "PAYROLL-0002", MSISDN: "255713123457", Amount: money.Shillings(2000)},
+				Response: disburse.Response{TxnStatus: "error013", Message: "insufficient"},
+				Status:   disburse.StatusFailed,
+				Err:      errors.New("amount insufficient"),
+			},
+		},
+	}
+
+	var buf bytes.Buffer
+	if err := bulk.WriteResultCSV(&buf, report); err != nil {
+		t.Fatal(err)
+	}
+
+	want := "reference,msisdn,amount,txn_id,txn_status,message,status,error\n" +
+		"PAYROLL-0001,255713123456,1500.50,TXN1,error000,done,succeeded,\n" +
+		"PAYROLL-0002,255713123457,2000,,error013,insufficient,failed,amount insufficient\n"
+	if buf.String() != want {
+		t.Errorf("got:\n%s\nwant:\n%s", buf.String(), want)
+	}
+}
@@ -0,0 +1,344 @@
+/*
+ * MIT License
+ *
+ * Copyright (c) 2021 TechCraft Technologies Co. Ltd
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ *
+ */
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/techcraftlabs/tigopesa"
+	"github.com/techcraftlabs/tigopesa/bulk"
+	"github.com/techcraftlabs/tigopesa/disburse"
+	"github.com/techcraftlabs/tigopesa/money"
+	"github.com/techcraftlabs/tigopesa/push"
+)
+
+const (
+	sectionPush sections = 1 << iota
+	sectionDisburse
+	sectionUssd
+)
+
+var errNotConfigured = errors.New("not configured")
+
+type (
+	// sections are the parts of tigopesa.Config a command needs
+	sections int
+
+	// tokenOutput is printed by the token command, the access token is
+	// masked unless -reveal is set
+	tokenOutput struct {
+		AccessToken string `json:"access_token"`
+		TokenType   string `json:"token_type"`
+		ExpiresIn   int    `json:"expires_in"`
+	}
+
+	// batchItem adds the error of an item, which disburse.ItemResult
+	// leaves out of its json
+	batchItem struct {
+		disburse.ItemResult
+		Error string `json:"error,omitempty"`
+	}
+
+	batchOutput struct {
+		disburse.BatchReport
+		Items []batchItem `json:"items"`
+	}
+)
+
+func (s sections) check(config *tigopesa.Config) error {
+	var missing []string
+	if s&sectionPush != 0 && config.Push == nil {
+		missing = append(missing, "push (TIGO_PUSH_*)")
+	}
+	if s&sectionDisburse != 0 && config.Disburse == nil {
+		missing = append(missing, "disburse (TIGO_ATW_*)")
+	}
+	if s&sectionUssd != 0 && config.Ussd == nil {
+		missing = append(missing, "ussd (TIGO_WTA_*)")
+	}
+
+	if len(missing) > 0 {
+		return fmt.Errorf("%w: %v", errNotConfigured, missing)
+	}
+
+	return nil
+}
+
+func runToken(ctx context.Context, a *app, args []string) error {
+	var (
+		c      common
+		reveal bool
+	)
+	fs := a.flags("token", "", &c, defaultTimeout)
+	fs.BoolVar(&reveal, "reveal", false, "print the whole access token instead of masking it")
+	if err := parse(fs, args, 0); err != nil {
+		return err
+	}
+
+	client, err := a.client(c, sectionPush, nil, nil, nil)
+	if err != nil {
+		return err
+	}
+
+	if c.dryRun {
+		return a.print(dryRun{DryRun: true, Command: "token"})
+	}
+
+	ctx, cancel := c.context(ctx)
+	defer cancel()
+
+	token, err := client.Token(ctx)
+	if err != nil {
+		return err
+	}
+
+	out := tokenOutput{AccessToken: token.AccessToken, TokenType: token.TokenType, ExpiresIn: token.ExpiresIn}
+	if !reveal {
+		out.AccessToken = mask(out.AccessToken)
+	}
+
+	return a.print(out)
+}
+
+func runPush(ctx context.Context, a *app, args []string) error {
+	var (
+		c       common
+		request push.Request
+		amount  string
+	)
+	fs := a.flags("push", "", &c, defaultTimeout)
+	fs.StringVar(&request.MSISDN, "msisdn", "", "customer `msisdn` to ask for the payment (required)")
+	fs.StringVar(&amount, "amount", "", "`amount` in TZS (required)")
+	fs.StringVar(&request.ReferenceID, "reference", "", "`reference` of the payment, prefixed with the biller code (required)")
+	fs.StringVar(&request.Remarks, "remarks", "", "`remarks` shown to the customer")
+	if err := parse(fs, args, 0); err != nil {
+		return err
+	}
+
+	if err := required(fs, "msisdn", request.MSISDN, "amount", amount, "reference", request.ReferenceID); err != nil {
+		return err
+	}
+	parsed, err := money.Parse(amount)
+	if err != nil {
+		return err
+	}
+	request.Amount = parsed
+
+	client, err := a.client(c, sectionPush, nil, nil, nil)
+	if err != nil {
+		return err
+	}
+
+	if c.dryRun {
+		checked, err := client.CheckPay(request)
+		if err != nil {
+			return err
+		}
+		return a.print(dryRun{DryRun: true, Command: "push", Request: checked})
+	}
+
+	ctx, cancel := c.context(ctx)
+	defer cancel()
+
+	response, err := client.Pay(ctx, request)
+	if err != nil {
+		return err
+	}
+
+	return a.print(response)
+}
+
+func runDisburse(ctx context.Context, a *app, args []string) error {
+	var (
+		c       common
+		request disburse.Request
+		amount  string
+	)
+	fs := a.flags("disburse", "", &c, defaultTimeout)
+	fs.StringVar(&request.MSISDN, "msisdn", "", "`msisdn` of the wallet to pay (required)")
+	fs.StringVar(&amount, "amount", "", "`amount` in TZS (required)")
+	fs.StringVar(&request.ReferenceID, "reference", "", "unique `reference` of the disbursement (required)")
+	if err := parse(fs, args, 0); err != nil {
+		return err
+	}
+
+	if err := required(fs, "msisdn", request.MSISDN, "amount", amount, "reference", request.ReferenceID); err != nil {
+		return err
+	}
+	parsed, err := money.Parse(amount)
+	if err != nil {
+		return err
+	}
+	request.Amount = parsed
+
+	client, err := a.client(c, sectionDisburse, nil, nil, nil)
+	if err != nil {
+		return err
+	}
+
+	if c.dryRun {
+		checked, err := client.CheckDisburse(request)
+		if err != nil {
+			return err
+		}
+		return a.print(dryRun{DryRun: true, Command: "disburse", Request: checked})
+	}
+
+	ctx, cancel := c.context(ctx)
+	defer cancel()
+
+	response, err := client.Disburse(ctx, request)
+	if err != nil {
+		return err
+	}
+
+	return a.print(response)
+}
+
+func runDisburseBatch(ctx context.Context, a *app, args []string) (err error) {
+	var (
+		c          common
+		opts       disburse.BatchOptions
+		checkpoint string
+		results    string
+	)
+	fs := a.flags("disburse-batch", "<file>", &c, defaultTimeout)
+	fs.IntVar(&opts.Concurrency, "concurrency", 1, "number of disbursements sent at the same time")
+	fs.Float64Var(&opts.RateLimit, "rate", 0, "maximum disbursements sent per second, 0 means no limit")
+	fs.IntVar(&opts.MaxFailures, "max-failures", 0, "stop after this many failures, 0 means never")
+	fs.StringVar(&checkpoint, "checkpoint", "", "`file` recording the progress, rerun with it to skip what succeeded and hold back what may have been paid")
+	fs.StringVar(&results, "results", "", "also write the results as csv to `file`")
+	if err := parse(fs, args, 1); err != nil {
+		return err
+	}
+
+	file, err := os.Open(fs.Arg(0))
+	if err != nil {
+		return err
+	}
+	requests, err := bulk.ParseCSV(file)
+	_ = file.Close()
+	if err != nil {
+		return err
+	}
+
+	client, err := a.client(c, sectionDisburse, nil, nil, nil)
+	if err != nil {
+		return err
+	}
+
+	if c.dryRun {
+		checked := make([]disburse.Request, len(requests))
+		for i, request := range requests {
+			if checked[i], err = client.CheckDisburse(request); err != nil {
+				return fmt.Errorf("%s: %w", request.ReferenceID, err)
+			}
+		}
+		return a.print(dryRun{DryRun: true, Command: "disburse-batch", Request: checked})
+	}
+
+	if checkpoint != "" {
+		cp, err := disburse.OpenFileCheckpoint(checkpoint)
+		if err != nil {
+			return err
+		}
+		defer func() {
+			if cerr := cp.Close(); err == nil {
+				err = cerr
+			}
+		}()
+		opts.Checkpoint = cp
+	}
+
+	ctx, cancel := c.context(ctx)
+	defer cancel()
+
+	report, batchErr := client.DisburseBatch(ctx, requests, opts)
+
+	out := batchOutput{BatchReport: report, Items: make([]batchItem, len(report.Items))}
+	for i, item := range report.Items {
+		out.Items[i] = batchItem{ItemResult: item}
+		if item.Err != nil {
+			out.Items[i].Error = item.Err.Error()
+		}
+	}
+	if err := a.print(out); err != nil {
+		return err
+	}
+
+	if results != "" {
+		if err := writeResults(results, report); err != nil {
+			return err
+		}
+	}
+
+	return batchErr
+}
+
+func writeResults(path string, report disburse.BatchReport) error {
+	file, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+
+	if err := bulk.WriteResultCSV(file, report); err != nil {
+		_ = file.Close()
+		return err
+	}
+
+	return file.Close()
+}
+
+// required checks that the flags given as name and value pairs are set
+func required(fs *flag.FlagSet, pairs ...string) error {
+	var missing []string
+	for i := 0; i+1 < len(pairs); i += 2 {
+		if pairs[i+1] == "" {
+			missing = append(missing, "-"+pairs[i])
+		}
+	}
+
+	if len(missing) > 0 {
+		_, _ = fmt.Fprintf(fs.Output(), "missing required flags: %s\n", strings.Join(missing, " "))
+		fs.Usage()
+		return errUsage
+	}
+
+	return nil
+}
+
+// mask keeps the first and last four characters of secret
+func mask(secret string) string {
+	if len(secret) <= 8 {
+		return "****"
+	}
+
+	return secret[:4] + "****" + secret[len(secret)-4:]
+}
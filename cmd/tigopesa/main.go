@@ -0,0 +1,243 @@
+/*
+ * MIT License
+ *
+ * Copyright (c) 2021 TechCraft Technologies Co. Ltd
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ *
+ */
+// Command tigopesa lets operators get push pay tokens, send push pay
+// requests and disbursements and run the endpoints tigo calls, without
+// writing Go.
+//
+// The configuration is read from the TIGO_* environment variables
+// described in docs/INTEGRATION.md, only the settings of the commands used
+// are needed. Results are printed to stdout as json, errors and debug logs
+// go to stderr.
+//
+//	tigopesa token
+//	tigopesa push -msisdn 255713123456 -amount 1000 -reference INV001
+//	tigopesa disburse -msisdn 255713123456 -amount 1000 -reference PAYROLL-0001
+//	tigopesa disburse-batch -concurrency 4 -results results.csv payroll.csv
+//	tigopesa serve -addr :8080
+//
+// Every command accepts -dry-run, which validates the configuration and
+// the input, normalizing MSISDNs and checking amounts as the clients do,
+// and prints what would be sent without calling tigo. serve masks secrets
+// and MSISDNs in the requests it prints.
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/techcraftlabs/tigopesa"
+	"github.com/techcraftlabs/tigopesa/push"
+	"github.com/techcraftlabs/tigopesa/ussd"
+)
+
+const (
+	exitOK      = 0
+	exitFailure = 1
+	exitUsage   = 2
+
+	defaultTimeout = 2 * time.Minute
+)
+
+// errUsage is returned by commands called with invalid arguments, the
+// usage of the command has already been printed.
+var errUsage = errors.New("invalid usage")
+
+type (
+	// command is a subcommand of the tool. run is given the arguments that
+	// follow the name of the command.
+	command struct {
+		name    string
+		summary string
+		run     func(ctx context.Context, app *app, args []string) error
+	}
+
+	app struct {
+		stdout io.Writer
+		stderr io.Writer
+
+		// loadConfig is tigopesa.LoadConfigFromEnv, replaced in tests
+		loadConfig func() (*tigopesa.Config, error)
+	}
+
+	// common are the flags shared by every command
+	common struct {
+		dryRun  bool
+		debug   bool
+		timeout time.Duration
+	}
+
+	// dryRun is printed instead of calling tigo when -dry-run is set
+	dryRun struct {
+		DryRun  bool        `json:"dryRun"`
+		Command string      `json:"command"`
+		Request interface{} `json:"request,omitempty"`
+	}
+)
+
+var commands = []command{
+	{name: "token", summary: "get a push pay access token", run: runToken},
+	{name: "push", summary: "send a push pay request to a customer", run: runPush},
+	{name: "disburse", summary: "send money to a wallet", run: runDisburse},
+	{name: "disburse-batch", summary: "send the disbursements listed in a csv file", run: runDisburseBatch},
+	{name: "serve", summary: "run the callback, namecheck and payment endpoints", run: runServe},
+}
+
+func main() {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	a := &app{
+		stdout:     os.Stdout,
+		stderr:     os.Stderr,
+		loadConfig: tigopesa.LoadConfigFromEnv,
+	}
+	code := a.run(ctx, os.Args[1:])
+	stop()
+	os.Exit(code)
+}
+
+// run runs the command named by the first argument and returns the exit
+// code of the process
+func (a *app) run(ctx context.Context, args []string) int {
+	if len(args) == 0 {
+		a.usage()
+		return exitUsage
+	}
+
+	name := args[0]
+	if name == "help" || name == "-h" || name == "-help" || name == "--help" {
+		a.usage()
+		return exitOK
+	}
+
+	for _, cmd := range commands {
+		if cmd.name != name {
+			continue
+		}
+
+		err := cmd.run(ctx, a, args[1:])
+		switch {
+		case err == nil:
+			return exitOK
+		case errors.Is(err, flag.ErrHelp):
+			return exitOK
+		case errors.Is(err, errUsage):
+			return exitUsage
+		default:
+			_, _ = fmt.Fprintf(a.stderr, "tigopesa %s: %v\n", name, err)
+			return exitFailure
+		}
+	}
+
+	_, _ = fmt.Fprintf(a.stderr, "tigopesa: unknown command %q\n\n", name)
+	a.usage()
+	return exitUsage
+}
+
+func (a *app) usage() {
+	_, _ = fmt.Fprintf(a.stderr, "usage: tigopesa <command> [flags] [args]\n\ncommands:\n")
+	for _, cmd := range commands {
+		_, _ = fmt.Fprintf(a.stderr, "  %-15s %s\n", cmd.name, cmd.summary)
+	}
+	_, _ = fmt.Fprintf(a.stderr, "\nrun tigopesa <command> -h for the flags of a command\n")
+}
+
+// flags returns the flag set of the command name with the common flags
+// registered in c, timeout is the default of the -timeout flag
+func (a *app) flags(name, args string, c *common, timeout time.Duration) *flag.FlagSet {
+	fs := flag.NewFlagSet(name, flag.ContinueOnError)
+	fs.SetOutput(a.stderr)
+	fs.Usage = func() {
+		_, _ = fmt.Fprintf(a.stderr, "usage: tigopesa %s [flags] %s\n\nflags:\n", name, args)
+		fs.PrintDefaults()
+	}
+
+	fs.BoolVar(&c.dryRun, "dry-run", false, "validate the configuration and input and print what would be sent")
+	fs.BoolVar(&c.debug, "debug", false, "log the requests and responses to stderr")
+	fs.DurationVar(&c.timeout, "timeout", timeout, "give up after this long, 0 means no limit")
+
+	return fs
+}
+
+// parse parses args with fs and checks that exactly want positional
+// arguments are left
+func parse(fs *flag.FlagSet, args []string, want int) error {
+	if err := fs.Parse(args); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return err
+		}
+		return errUsage
+	}
+
+	if fs.NArg() != want {
+		_, _ = fmt.Fprintf(fs.Output(), "expected %d argument(s), got %d\n", want, fs.NArg())
+		fs.Usage()
+		return errUsage
+	}
+
+	return nil
+}
+
+// client loads the configuration and checks that the sections needed are
+// present, then returns a tigopesa.Client using the handlers
+func (a *app) client(c common, sections sections, callbacks push.CallbackHandler,
+	payments ussd.PaymentHandler, names ussd.NameQueryHandler) (*tigopesa.Client, error) {
+	config, err := a.loadConfig()
+	if err != nil {
+		return nil, err
+	}
+
+	if err := sections.check(config); err != nil {
+		return nil, err
+	}
+
+	return tigopesa.NewClient(config, callbacks, payments, names,
+		tigopesa.WithDebugMode(c.debug),
+		tigopesa.WithLogger(a.stderr),
+	), nil
+}
+
+// context returns ctx with the timeout set with the -timeout flag
+func (c common) context(ctx context.Context) (context.Context, context.CancelFunc) {
+	if c.timeout <= 0 {
+		return context.WithCancel(ctx)
+	}
+
+	return context.WithTimeout(ctx, c.timeout)
+}
+
+// print writes v to stdout as indented json
+func (a *app) print(v interface{}) error {
+	encoder := json.NewEncoder(a.stdout)
+	encoder.SetIndent("", "  ")
+
+	return encoder.Encode(v)
+}
@@ -0,0 +1,431 @@
+/*
+ * MIT License
+ *
+ * Copyright (c) 2021 TechCraft Technologies Co. Ltd
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ *
+ */
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/techcraftlabs/tigopesa"
+	"github.com/techcraftlabs/tigopesa/disburse"
+	"github.com/techcraftlabs/tigopesa/money"
+	"github.com/techcraftlabs/tigopesa/push"
+	"github.com/techcraftlabs/tigopesa/redact"
+	"github.com/techcraftlabs/tigopesa/tigotest"
+	"github.com/techcraftlabs/tigopesa/ussd"
+)
+
+// newApp returns an app configured to talk to server with its output in
+// stdout and stderr
+func newApp(config *tigopesa.Config) (*app, *bytes.Buffer, *bytes.Buffer) {
+	stdout, stderr := new(bytes.Buffer), new(bytes.Buffer)
+	return &app{
+		stdout: stdout,
+		stderr: stderr,
+		loadConfig: func() (*tigopesa.Config, error) {
+			return config, nil
+		},
+	}, stdout, stderr
+}
+
+func testConfig(server *tigotest.Server) *tigopesa.Config {
+	return &tigopesa.Config{
+		Push:     server.PushConfig(),
+		Disburse: server.DisburseConfig(),
+		Ussd:     &ussd.Config{AccountName: "COMPANY", AccountMSISDN: "255713000000", BillerNumber: "1001"},
+	}
+}
+
+func decode(t *testing.T, data []byte, v interface{}) {
+	t.Helper()
+	if err := json.Unmarshal(data, v); err != nil {
+		t.Fatalf("decode %s: %v", data, err)
+	}
+}
+
+func TestRun_Usage(t *testing.T) {
+	tests := []struct {
+		name string
+		args []string
+		code int
+	}{
+		{"no command", nil, exitUsage},
+		{"help", []string{"help"}, exitOK},
+		{"unknown", []string{"refund"}, exitUsage},
+		{"command help", []string{"push", "-h"}, exitOK},
+		{"bad flag", []string{"token", "-nope"}, exitUsage},
+		{"missing flags", []string{"push", "-msisdn", "255713123456"}, exitUsage},
+		{"missing file", []string{"disburse-batch"}, exitUsage},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a, stdout, stderr := newApp(&tigopesa.Config{})
+			if code := a.run(context.Background(), tt.args); code != tt.code {
+				t.Errorf("got exit code %d, want %d: %s", code, tt.code, stderr)
+			}
+			if stdout.Len() != 0 {
+				t.Errorf("unexpected output %s", stdout)
+			}
+			if !strings.Contains(stderr.String(), "usage: tigopesa") {
+				t.Errorf("usage not printed: %s", stderr)
+			}
+		})
+	}
+}
+
+func TestRun_NotConfigured(t *testing.T) {
+	server := tigotest.NewServer()
+	defer server.Close()
+
+	a, _, stderr := newApp(&tigopesa.Config{Push: server.PushConfig()})
+	code := a.run(context.Background(), []string{"disburse", "-msisdn", "255713123456", "-amount", "1000", "-reference", "R1"})
+	if code != exitFailure || !strings.Contains(stderr.String(), "TIGO_ATW_") {
+		t.Errorf("got exit code %d: %s", code, stderr)
+	}
+	if len(server.DisburseRequests()) != 0 {
+		t.Error("disbursement sent without configuration")
+	}
+}
+
+func TestRun_Token(t *testing.T) {
+	server := tigotest.NewServer()
+	defer server.Close()
+
+	a, stdout, stderr := newApp(testConfig(server))
+	if code := a.run(context.Background(), []string{"token"}); code != exitOK {
+		t.Fatalf("got exit code %d: %s", code, stderr)
+	}
+
+	var masked tokenOutput
+	decode(t, stdout.Bytes(), &masked)
+	if !strings.Contains(masked.AccessToken, "****") || masked.ExpiresIn == 0 {
+		t.Errorf("got %+v", masked)
+	}
+
+	stdout.Reset()
+	if code := a.run(context.Background(), []string{"token", "-reveal"}); code != exitOK {
+		t.Fatalf("got exit code %d: %s", code, stderr)
+	}
+
+	var revealed tokenOutput
+	decode(t, stdout.Bytes(), &revealed)
+	if strings.Contains(revealed.AccessToken, "****") || revealed.AccessToken == "" {
+		t.Errorf("got %+v", revealed)
+	}
+}
+
+func TestRun_Push(t *testing.T) {
+	server := tigotest.NewServer()
+	defer server.Close()
+	server.ScriptPush(tigotest.Outcome{NoCallback: true})
+
+	a, stdout, stderr := newApp(testConfig(server))
+	args := []string{"push", "-msisdn", "0713123456", "-amount", "1500", "-reference", "INV001", "-remarks", "invoice"}
+	if code := a.run(context.Background(), args); code != exitOK {
+		t.Fatalf("got exit code %d: %s", code, stderr)
+	}
+
+	var response push.PayResponse
+	decode(t, stdout.Bytes(), &response)
+	if response.ResponseCode != push.SuccessCode || response.ReferenceID != tigotest.BillerCode+"INV001" {
+		t.Errorf("got %+v", response)
+	}
+
+	requests := server.PushRequests()
+	if len(requests) != 1 || requests[0].CustomerMSISDN != "255713123456" || requests[0].Amount != "1500" {
+		t.Errorf("tigo got %+v", requests)
+	}
+}
+
+func TestRun_Disburse(t *testing.T) {
+	server := tigotest.NewServer()
+	defer server.Close()
+
+	a, stdout, stderr := newApp(testConfig(server))
+	args := []string{"disburse", "-msisdn", "255713123456", "-amount", "2500.50", "-reference", "PAYROLL-0001"}
+	if code := a.run(context.Background(), args); code != exitOK {
+		t.Fatalf("got exit code %d: %s", code, stderr)
+	}
+
+	var response disburse.Response
+	decode(t, stdout.Bytes(), &response)
+	if response.ReferenceID != "PAYROLL-0001" || response.TxnID == "" {
+		t.Errorf("got %+v", response)
+	}
+
+	requests := server.DisburseRequests()
+	if len(requests) != 1 || requests[0].Amount != "2500.50" {
+		t.Errorf("tigo got %+v", requests)
+	}
+}
+
+func TestRun_DryRun(t *testing.T) {
+	server := tigotest.NewServer()
+	defer server.Close()
+
+	dir := t.TempDir()
+	batch := filepath.Join(dir, "batch.csv")
+	if err := os.WriteFile(batch, []byte("reference,msisdn,amount\nP1,255713123456,1000\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := map[string][]string{
+		"token":          {"token", "-dry-run"},
+		"push":           {"push", "--dry-run", "-msisdn", "255713123456", "-amount", "1000", "-reference", "INV001"},
+		"disburse":       {"disburse", "--dry-run", "-msisdn", "255713123456", "-amount", "1000", "-reference", "P1"},
+		"disburse-batch": {"disburse-batch", "--dry-run", batch},
+		"serve":          {"serve", "--dry-run", "-addr", "127.0.0.1:0"},
+	}
+
+	for name, args := range tests {
+		t.Run(name, func(t *testing.T) {
+			a, stdout, stderr := newApp(testConfig(server))
+			if code := a.run(context.Background(), args); code != exitOK {
+				t.Fatalf("got exit code %d: %s", code, stderr)
+			}
+
+			var out dryRun
+			decode(t, stdout.Bytes(), &out)
+			if !out.DryRun || out.Command != name {
+				t.Errorf("got %+v", out)
+			}
+		})
+	}
+
+	if server.TokenRequests() != 0 || len(server.PushRequests()) != 0 || len(server.DisburseRequests()) != 0 {
+		t.Error("dry run called tigo")
+	}
+}
+
+func TestRun_DryRunChecks(t *testing.T) {
+	server := tigotest.NewServer()
+	defer server.Close()
+
+	tests := []struct {
+		name       string
+		args       []string
+		wantCode   int
+		wantMSISDN string
+	}{
+		{"push normalized", []string{"push", "-dry-run", "-msisdn", "0713123456", "-amount", "1000", "-reference", "INV001"},
+			exitOK, "255713123456"},
+		{"disburse normalized", []string{"disburse", "-dry-run", "-msisdn", "+255 713 123 456", "-amount", "1000", "-reference", "P1"},
+			exitOK, "255713123456"},
+		{"push invalid msisdn", []string{"push", "-dry-run", "-msisdn", "12345", "-amount", "1000", "-reference", "INV001"},
+			exitFailure, ""},
+		{"push zero amount", []string{"push", "-dry-run", "-msisdn", "255713123456", "-amount", "0", "-reference", "INV001"},
+			exitFailure, ""},
+		{"disburse invalid msisdn", []string{"disburse", "-dry-run", "-msisdn", "12345", "-amount", "1000", "-reference", "P1"},
+			exitFailure, ""},
+		{"disburse negative amount", []string{"disburse", "-dry-run", "-msisdn", "255713123456", "-amount", "-5", "-reference", "P1"},
+			exitFailure, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a, stdout, stderr := newApp(testConfig(server))
+			if code := a.run(context.Background(), tt.args); code != tt.wantCode {
+				t.Fatalf("got exit code %d want %d: %s", code, tt.wantCode, stderr)
+			}
+			if tt.wantCode != exitOK {
+				return
+			}
+
+			var out struct {
+				Request struct {
+					MSISDN string
+				}
+			}
+			decode(t, stdout.Bytes(), &out)
+			if out.Request.MSISDN != tt.wantMSISDN {
+				t.Errorf("got msisdn %q want %q", out.Request.MSISDN, tt.wantMSISDN)
+			}
+		})
+	}
+
+	if server.TokenRequests() != 0 || len(server.PushRequests()) != 0 || len(server.DisburseRequests()) != 0 {
+		t.Error("dry run called tigo")
+	}
+}
+
+func TestRun_DisburseBatch(t *testing.T) {
+	server := tigotest.NewServer()
+	defer server.Close()
+	server.ScriptDisburse(tigotest.Outcome{}, tigotest.Outcome{Code: disburse.ErrAmountInsufficient})
+
+	dir := t.TempDir()
+	batch := filepath.Join(dir, "batch.csv")
+	results := filepath.Join(dir, "results.csv")
+	input := "reference,msisdn,amount\nP1,255713123456,1000\nP2,255654123456,2000\n"
+	if err := os.WriteFile(batch, []byte(input), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	a, stdout, stderr := newApp(testConfig(server))
+	args := []string{"disburse-batch", "-results", results, "-checkpoint", filepath.Join(dir, "checkpoint"), batch}
+	if code := a.run(context.Background(), args); code != exitOK {
+		t.Fatalf("got exit code %d: %s", code, stderr)
+	}
+
+	var report struct {
+		Items []struct {
+			Request disburse.Request    `json:"request"`
+			Status  disburse.ItemStatus `json:"status"`
+			Error   string              `json:"error"`
+		} `json:"items"`
+		Succeeded int `json:"succeeded"`
+		Failed    int `json:"failed"`
+	}
+	decode(t, stdout.Bytes(), &report)
+	if report.Succeeded != 1 || report.Failed != 1 || len(report.Items) != 2 {
+		t.Fatalf("got %+v", report)
+	}
+	if item := report.Items[1]; item.Request.Amount != money.Shillings(2000) || item.Error == "" {
+		t.Errorf("failed item: got %+v", item)
+	}
+
+	file, err := os.Open(results)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	records, err := csv.NewReader(file).ReadAll()
+	if err != nil || len(records) != 3 {
+		t.Errorf("results: got %v %v", records, err)
+	}
+}
+
+func TestServe_Endpoints(t *testing.T) {
+	server := tigotest.NewServer()
+	defer server.Close()
+
+	stdout := new(bytes.Buffer)
+	log := &requestLog{out: stdout, redactor: redact.New(redact.WithMSISDNMasking())}
+	callbacks, payments, names := log.handlers("")
+	client := tigopesa.NewClient(testConfig(server), callbacks, payments, names, tigopesa.WithDebugMode(false))
+
+	e := endpoints{Callback: "/callback", Namecheck: "/namecheck", Payment: "/payment"}
+	endpoint := httptest.NewServer(e.mux(client))
+	defer endpoint.Close()
+
+	ctx := context.Background()
+	name, err := server.NameQuery(ctx, endpoint.URL+e.Namecheck, ussd.NameRequest{
+		Msisdn:              "255713123456",
+		CompanyName:         "COMPANY",
+		CustomerReferenceID: "ACC001",
+	})
+	if err != nil || name.Content != "ACC001" {
+		t.Fatalf("name query: %+v %v", name, err)
+	}
+
+	pay, err := server.BillPay(ctx, endpoint.URL+e.Payment, ussd.PayRequest{
+		TxnID:               "TXN001",
+		Msisdn:              "255713123456",
+		Amount:              money.Shillings(2500),
+		CompanyName:         "COMPANY",
+		CustomerReferenceID: "ACC001",
+	})
+	if err != nil || pay.ErrorCode != ussd.ErrSuccessTxn {
+		t.Fatalf("bill pay: %+v %v", pay, err)
+	}
+
+	body, _ := json.Marshal(push.CallbackRequest{Status: true, ReferenceID: "TESTINV001", Amount: money.Shillings(1000)})
+	res, err := http.Post(endpoint.URL+e.Callback, "application/json", bytes.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	_ = res.Body.Close()
+
+	var logged []string
+	for _, line := range strings.Split(strings.TrimSpace(stdout.String()), "\n") {
+		var entry logEntry
+		decode(t, []byte(line), &entry)
+		logged = append(logged, entry.Endpoint)
+	}
+	if strings.Join(logged, ",") != "namecheck,payment,callback" {
+		t.Errorf("logged %v", logged)
+	}
+	if strings.Contains(stdout.String(), "255713123456") || !strings.Contains(stdout.String(), "255******456") {
+		t.Errorf("msisdns are not masked: %s", stdout)
+	}
+}
+
+func TestServe_OnlyConfigured(t *testing.T) {
+	server := tigotest.NewServer()
+	defer server.Close()
+
+	client := tigopesa.NewClient(&tigopesa.Config{Push: server.PushConfig()}, nil, nil, nil, tigopesa.WithDebugMode(false))
+	e := endpoints{Callback: "/callback", Namecheck: "/namecheck", Payment: "/payment"}
+	endpoint := httptest.NewServer(e.mux(client))
+	defer endpoint.Close()
+
+	if e.Namecheck != "" || e.Payment != "" || e.Callback == "" {
+		t.Errorf("got %+v", e)
+	}
+
+	res, err := http.Post(endpoint.URL+"/payment", "application/xml", strings.NewReader("<COMMAND/>"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	_ = res.Body.Close()
+	if res.StatusCode != http.StatusNotFound {
+		t.Errorf("payment endpoint served without ussd configuration: %d", res.StatusCode)
+	}
+}
+
+func TestRun_Env(t *testing.T) {
+	server := tigotest.NewServer()
+	defer server.Close()
+
+	env := map[string]string{
+		"TIGO_PUSH_USERNAME":      tigotest.Username,
+		"TIGO_PUSH_PASSWORD":      tigotest.Password,
+		"TIGO_PUSH_BILLER_MSISDN": tigotest.BillerMSISDN,
+		"TIGO_PUSH_BILLER_CODE":   tigotest.BillerCode,
+		"TIGO_PUSH_TOKEN_URL":     server.URL + tigotest.TokenEndpoint,
+		"TIGO_PUSH_URL":           server.URL + tigotest.PushPayEndpoint,
+	}
+	for key, value := range env {
+		t.Setenv(key, value)
+	}
+
+	a, stdout, stderr := newApp(nil)
+	a.loadConfig = tigopesa.LoadConfigFromEnv
+	if code := a.run(context.Background(), []string{"token"}); code != exitOK {
+		t.Fatalf("got exit code %d: %s", code, stderr)
+	}
+	if server.TokenRequests() != 1 || !strings.Contains(stdout.String(), "access_token") {
+		t.Errorf("got %s", stdout)
+	}
+}
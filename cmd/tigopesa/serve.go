@@ -0,0 +1,241 @@
+/*
+ * MIT License
+ *
+ * Copyright (c) 2021 TechCraft Technologies Co. Ltd
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ *
+ */
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/techcraftlabs/tigopesa"
+	"github.com/techcraftlabs/tigopesa/push"
+	"github.com/techcraftlabs/tigopesa/redact"
+	"github.com/techcraftlabs/tigopesa/ussd"
+)
+
+const shutdownTimeout = 10 * time.Second
+
+type (
+	// endpoints are the paths the serve command handles, empty paths are
+	// not served
+	endpoints struct {
+		Callback  string `json:"callback,omitempty"`
+		Namecheck string `json:"namecheck,omitempty"`
+		Payment   string `json:"payment,omitempty"`
+	}
+
+	serveOutput struct {
+		Listening string    `json:"listening"`
+		Endpoints endpoints `json:"endpoints"`
+	}
+
+	// logEntry is printed for every request tigo makes
+	logEntry struct {
+		Time     time.Time   `json:"time"`
+		Endpoint string      `json:"endpoint"`
+		Request  interface{} `json:"request"`
+		Response interface{} `json:"response"`
+	}
+
+	// requestLog writes a line of json for every request to out, with
+	// secrets and MSISDNs masked by redactor
+	requestLog struct {
+		mu       sync.Mutex
+		out      io.Writer
+		redactor *redact.Redactor
+	}
+)
+
+func (l *requestLog) log(endpoint string, request, response interface{}) {
+	entry, err := json.Marshal(logEntry{
+		Time:     time.Now().UTC(),
+		Endpoint: endpoint,
+		Request:  request,
+		Response: response,
+	})
+	if err != nil {
+		return
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	_, _ = io.WriteString(l.out, l.redactor.Redact(string(entry))+"\n")
+}
+
+// handlers return the push callback, payment and name query handlers that
+// log every request and accept it. Name queries are answered with name, or
+// with the customer reference when name is empty.
+func (l *requestLog) handlers(name string) (push.CallbackHandler, ussd.PaymentHandler, ussd.NameQueryHandler) {
+	callbacks := push.CallbackHandlerFunc(func(_ context.Context, request push.CallbackRequest) (push.CallbackResponse, error) {
+		response := push.CallbackResponse{
+			ResponseCode:        push.SuccessCode,
+			ResponseDescription: request.Description,
+			ResponseStatus:      request.Status,
+			ReferenceID:         request.ReferenceID,
+		}
+		l.log("callback", request, response)
+		return response, nil
+	})
+
+	payments := ussd.PaymentHandleFunc(func(_ context.Context, request ussd.PayRequest) (ussd.PayResponse, error) {
+		response := ussd.PayResponse{
+			TxnID:     request.TxnID,
+			RefID:     request.TxnID,
+			Result:    "TS",
+			ErrorCode: ussd.ErrSuccessTxn,
+			Msisdn:    request.Msisdn,
+		}
+		l.log("payment", request, response)
+		return response, nil
+	})
+
+	names := ussd.NameQueryFunc(func(_ context.Context, request ussd.NameRequest) (ussd.NameResponse, error) {
+		content := name
+		if content == "" {
+			content = request.CustomerReferenceID
+		}
+		response := ussd.NameResponse{
+			Result:    "TS",
+			ErrorCode: ussd.NoNamecheckErr,
+			Msisdn:    request.Msisdn,
+			Content:   content,
+		}
+		l.log("namecheck", request, response)
+		return response, nil
+	})
+
+	return callbacks, payments, names
+}
+
+// mux routes the endpoints to client. The callback is only served when push
+// pay is configured and the namecheck and payment endpoints when ussd is.
+func (e *endpoints) mux(client *tigopesa.Client) *http.ServeMux {
+	mux := http.NewServeMux()
+
+	if client.Config.Push == nil {
+		e.Callback = ""
+	}
+	if client.Config.Ussd == nil {
+		e.Namecheck, e.Payment = "", ""
+	}
+
+	if e.Callback != "" {
+		mux.HandleFunc(e.Callback, client.CallbackServeHTTP)
+	}
+	if e.Namecheck != "" {
+		mux.HandleFunc(e.Namecheck, client.NameQueryServeHTTP)
+	}
+	if e.Payment != "" {
+		mux.HandleFunc(e.Payment, client.PaymentServeHTTP)
+	}
+
+	return mux
+}
+
+func runServe(ctx context.Context, a *app, args []string) error {
+	var (
+		c    common
+		addr string
+		name string
+		e    endpoints
+	)
+	fs := a.flags("serve", "", &c, 0)
+	fs.StringVar(&addr, "addr", ":8080", "`address` to listen on")
+	fs.StringVar(&e.Callback, "callback-path", "/tigopesa/callback", "`path` of the push pay callback endpoint")
+	fs.StringVar(&e.Namecheck, "namecheck-path", "/tigopesa/namecheck", "`path` of the ussd name query endpoint")
+	fs.StringVar(&e.Payment, "payment-path", "/tigopesa/payment", "`path` of the ussd payment endpoint")
+	fs.StringVar(&name, "name", "", "customer `name` returned to name queries, the customer reference by default")
+	if err := parse(fs, args, 0); err != nil {
+		return err
+	}
+
+	log := &requestLog{out: a.stdout, redactor: redact.New(redact.WithMSISDNMasking())}
+	callbacks, payments, names := log.handlers(name)
+
+	client, err := a.client(c, 0, callbacks, payments, names)
+	if err != nil {
+		return err
+	}
+	if client.Config.Push == nil && client.Config.Ussd == nil {
+		return fmt.Errorf("%w: push (TIGO_PUSH_*) or ussd (TIGO_WTA_*)", errNotConfigured)
+	}
+
+	mux := e.mux(client)
+
+	if c.dryRun {
+		return a.print(dryRun{DryRun: true, Command: "serve", Request: serveOutput{Listening: addr, Endpoints: e}})
+	}
+
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := c.context(ctx)
+	defer cancel()
+
+	if err := a.print(serveOutput{Listening: listener.Addr().String(), Endpoints: e}); err != nil {
+		_ = listener.Close()
+		return err
+	}
+
+	return serve(ctx, listener, mux)
+}
+
+// serve serves handler on listener until ctx is done, then waits for the
+// requests in flight to complete.
+func serve(ctx context.Context, listener net.Listener, handler http.Handler) error {
+	server := &http.Server{Handler: handler, ReadHeaderTimeout: time.Minute}
+
+	errs := make(chan error, 1)
+	go func() {
+		errs <- server.Serve(listener)
+	}()
+
+	select {
+	case err := <-errs:
+		return err
+	case <-ctx.Done():
+	}
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+
+	if err := server.Shutdown(shutdownCtx); err != nil {
+		return err
+	}
+	if err := <-errs; !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+
+	return nil
+}
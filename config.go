@@ -0,0 +1,590 @@
+/*
+ * MIT License
+ *
+ * Copyright (c) 2021 TechCraft Technologies Co. Ltd
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ *
+ */
+
+package tigopesa
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+	"github.com/techcraftlabs/tigopesa/disburse"
+	"github.com/techcraftlabs/tigopesa/push"
+	"github.com/techcraftlabs/tigopesa/ussd"
+	"gopkg.in/yaml.v3"
+)
+
+const defaultPasswordGrantType = "password"
+
+var (
+	// ErrMissingSetting is matched by the error of a required setting
+	// that has not been set
+	ErrMissingSetting = errors.New("missing required setting")
+
+	// ErrInvalidURL is matched by the error of a setting that is not an
+	// absolute http or https URL
+	ErrInvalidURL = errors.New("invalid url")
+
+	// ErrUnknownSetting is matched by the error of a key in a config file
+	// that is not a setting, usually a misspelled one
+	ErrUnknownSetting = errors.New("unknown setting")
+
+	// ErrNoConfig is returned when none of the disburse, push or ussd
+	// settings are present
+	ErrNoConfig = errors.New("no tigo configuration found")
+
+	_ error = (*ConfigError)(nil)
+	_ error = (*SettingError)(nil)
+)
+
+type (
+	// SettingError is a problem with a single setting. Setting is the name
+	// of the environment variable or the key in the config file.
+	SettingError struct {
+		Setting string
+		Err     error
+	}
+
+	// ConfigError is returned by the config loaders with every problem
+	// found in the configuration.
+	ConfigError struct {
+		Problems []error
+	}
+
+	// setting is a single value together with the name it has in its source
+	setting struct {
+		name  string
+		value string
+	}
+
+	settings struct {
+		disburse struct {
+			accountName, accountMSISDN, brandID, pin, requestURL setting
+		}
+		push struct {
+			username, password, passwordGrantType, baseURL, tokenURL, payURL setting
+			statusURL, billerMSISDN, billerCode, callbackURL                 setting
+		}
+		ussd struct {
+			accountName, accountMSISDN, billerNumber, requestURL, namecheckURL setting
+		}
+	}
+
+	// fileConfig is the layout of yaml, json and toml config files
+	fileConfig struct {
+		Disburse struct {
+			AccountName   string `json:"account_name" yaml:"account_name" toml:"account_name"`
+			AccountMSISDN string `json:"account_msisdn" yaml:"account_msisdn" toml:"account_msisdn"`
+			BrandID       string `json:"brand_id" yaml:"brand_id" toml:"brand_id"`
+			PIN           string `json:"pin" yaml:"pin" toml:"pin"`
+			RequestURL    string `json:"request_url" yaml:"request_url" toml:"request_url"`
+		} `json:"disburse" yaml:"disburse" toml:"disburse"`
+
+		Push struct {
+			Username          string `json:"username" yaml:"username" toml:"username"`
+			Password          string `json:"password" yaml:"password" toml:"password"`
+			PasswordGrantType string `json:"password_grant_type" yaml:"password_grant_type" toml:"password_grant_type"`
+			BaseURL           string `json:"base_url" yaml:"base_url" toml:"base_url"`
+			TokenURL          string `json:"token_url" yaml:"token_url" toml:"token_url"`
+			PayURL            string `json:"pay_url" yaml:"pay_url" toml:"pay_url"`
+			StatusURL         string `json:"status_url" yaml:"status_url" toml:"status_url"`
+			BillerMSISDN      string `json:"biller_msisdn" yaml:"biller_msisdn" toml:"biller_msisdn"`
+			BillerCode        string `json:"biller_code" yaml:"biller_code" toml:"biller_code"`
+			CallbackURL       string `json:"callback_url" yaml:"callback_url" toml:"callback_url"`
+		} `json:"push" yaml:"push" toml:"push"`
+
+		Ussd struct {
+			AccountName   string `json:"account_name" yaml:"account_name" toml:"account_name"`
+			AccountMSISDN string `json:"account_msisdn" yaml:"account_msisdn" toml:"account_msisdn"`
+			BillerNumber  string `json:"biller_number" yaml:"biller_number" toml:"biller_number"`
+			RequestURL    string `json:"request_url" yaml:"request_url" toml:"request_url"`
+			NamecheckURL  string `json:"namecheck_url" yaml:"namecheck_url" toml:"namecheck_url"`
+		} `json:"ussd" yaml:"ussd" toml:"ussd"`
+	}
+)
+
+func (e *SettingError) Error() string {
+	return fmt.Sprintf("%s: %v", e.Setting, e.Err)
+}
+
+func (e *SettingError) Unwrap() error {
+	return e.Err
+}
+
+func (e *ConfigError) Error() string {
+	msgs := make([]string, len(e.Problems))
+	for i, problem := range e.Problems {
+		msgs[i] = problem.Error()
+	}
+
+	return fmt.Sprintf("tigopesa: invalid config: %s", strings.Join(msgs, "; "))
+}
+
+// Is reports whether any of the problems matches target
+func (e *ConfigError) Is(target error) bool {
+	for _, problem := range e.Problems {
+		if errors.Is(problem, target) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// LoadConfigFromEnv reads the Config from the environment variables listed
+// in docs/INTEGRATION.md:
+//
+//	TIGO_ATW_ACCOUNT_NAME, TIGO_ATW_ACCOUNT_MSISDN, TIGO_ATW_BRAND_ID,
+//	TIGO_ATW_DISBURSEMENT_PIN, TIGO_ATW_DISBURSEMENT_URL
+//
+//	TIGO_WTA_ACCOUNT_NAME, TIGO_WTA_ACCOUNT_MSISDN, TIGO_WTA_BILLER_NUMBER,
+//	TIGO_WTA_REQUEST_URL, TIGO_NAMECHECK_URL
+//
+//	TIGO_PUSH_USERNAME, TIGO_PUSH_PASSWORD, TIGO_PUSH_BILLER_MSISDN,
+//	TIGO_PUSH_BILLER_CODE, TIGO_PUSH_TOKEN_URL, TIGO_PUSH_URL,
+//	TIGO_PUSH_CALLBACK_URL
+//
+// TIGO_PUSH_TOKEN_URL and TIGO_PUSH_URL are full URLs on the same host unless
+// TIGO_PUSH_BASE_URL is set, in which case they can be paths relative to it.
+// TIGO_PUSH_PASSWORD_GRANT_TYPE defaults to "password". The optional
+// TIGO_PUSH_STATUS_URL enables push.Client.QueryStatus.
+//
+// Only the services with at least one variable set are configured, the
+// others are left nil. Every missing or invalid setting is reported in a
+// single *ConfigError.
+func LoadConfigFromEnv() (*Config, error) {
+	return loadConfigEnv(os.LookupEnv)
+}
+
+func loadConfigEnv(lookup func(string) (string, bool)) (*Config, error) {
+	env := func(name string) setting {
+		value, _ := lookup(name)
+		return setting{name: name, value: strings.TrimSpace(value)}
+	}
+
+	var s settings
+	s.disburse.accountName = env("TIGO_ATW_ACCOUNT_NAME")
+	s.disburse.accountMSISDN = env("TIGO_ATW_ACCOUNT_MSISDN")
+	s.disburse.brandID = env("TIGO_ATW_BRAND_ID")
+	s.disburse.pin = env("TIGO_ATW_DISBURSEMENT_PIN")
+	s.disburse.requestURL = env("TIGO_ATW_DISBURSEMENT_URL")
+
+	s.ussd.accountName = env("TIGO_WTA_ACCOUNT_NAME")
+	s.ussd.accountMSISDN = env("TIGO_WTA_ACCOUNT_MSISDN")
+	s.ussd.billerNumber = env("TIGO_WTA_BILLER_NUMBER")
+	s.ussd.requestURL = env("TIGO_WTA_REQUEST_URL")
+	s.ussd.namecheckURL = env("TIGO_NAMECHECK_URL")
+
+	s.push.username = env("TIGO_PUSH_USERNAME")
+	s.push.password = env("TIGO_PUSH_PASSWORD")
+	s.push.passwordGrantType = env("TIGO_PUSH_PASSWORD_GRANT_TYPE")
+	s.push.baseURL = env("TIGO_PUSH_BASE_URL")
+	s.push.tokenURL = env("TIGO_PUSH_TOKEN_URL")
+	s.push.payURL = env("TIGO_PUSH_URL")
+	s.push.statusURL = env("TIGO_PUSH_STATUS_URL")
+	s.push.billerMSISDN = env("TIGO_PUSH_BILLER_MSISDN")
+	s.push.billerCode = env("TIGO_PUSH_BILLER_CODE")
+	s.push.callbackURL = env("TIGO_PUSH_CALLBACK_URL")
+
+	return s.config()
+}
+
+// LoadConfigFile reads the Config from a yaml, json or toml file, the format
+// is picked using the file extension. See LoadConfigYAML for the layout.
+func LoadConfigFile(path string) (*Config, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	switch ext := strings.ToLower(filepath.Ext(path)); ext {
+	case ".yaml", ".yml":
+		return LoadConfigYAML(file)
+	case ".json":
+		return LoadConfigJSON(file)
+	case ".toml":
+		return LoadConfigTOML(file)
+	default:
+		return nil, fmt.Errorf("tigopesa: unsupported config file format %q", ext)
+	}
+}
+
+// LoadConfigYAML reads the Config from yaml with the following layout, the
+// json and toml layouts use the same keys. Settings are validated the same
+// way as in LoadConfigFromEnv.
+//
+//	disburse:
+//	  account_name: COMPANY
+//	  account_msisdn: "255713000000"
+//	  brand_id: "1234"
+//	  pin: "0000"
+//	  request_url: https://tigo.example/disburse
+//	push:
+//	  username: user
+//	  password: pass
+//	  password_grant_type: password
+//	  token_url: https://tigo.example/token
+//	  pay_url: https://tigo.example/push
+//	  status_url: https://tigo.example/push/status
+//	  biller_msisdn: "255713000000"
+//	  biller_code: COMPANY
+//	  callback_url: https://company.example/tigo/callback
+//	ussd:
+//	  account_name: COMPANY
+//	  account_msisdn: "255713000000"
+//	  biller_number: "123456"
+//	  request_url: https://company.example/tigo/payment
+//	  namecheck_url: https://company.example/tigo/namecheck
+//
+// Keys that are not settings are reported as ErrUnknownSetting together with
+// the missing and invalid settings.
+func LoadConfigYAML(r io.Reader) (*Config, error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+
+	var doc map[string]interface{}
+	if err := yaml.Unmarshal(data, &doc); err != nil {
+		return nil, fmt.Errorf("tigopesa: decode yaml config: %w", err)
+	}
+
+	var fc fileConfig
+	if err := yaml.Unmarshal(data, &fc); err != nil {
+		return nil, fmt.Errorf("tigopesa: decode yaml config: %w", err)
+	}
+
+	return fc.configWith(unknownSettings(doc, reflect.TypeOf(fc), "yaml", false, ""))
+}
+
+// LoadConfigJSON reads the Config from json, see LoadConfigYAML
+func LoadConfigJSON(r io.Reader) (*Config, error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+
+	var (
+		doc map[string]interface{}
+		fc  fileConfig
+	)
+	if len(bytes.TrimSpace(data)) > 0 {
+		if err := json.Unmarshal(data, &doc); err != nil {
+			return nil, fmt.Errorf("tigopesa: decode json config: %w", err)
+		}
+		if err := json.Unmarshal(data, &fc); err != nil {
+			return nil, fmt.Errorf("tigopesa: decode json config: %w", err)
+		}
+	}
+
+	// encoding/json matches keys ignoring case
+	return fc.configWith(unknownSettings(doc, reflect.TypeOf(fc), "json", true, ""))
+}
+
+// LoadConfigTOML reads the Config from toml, see LoadConfigYAML
+func LoadConfigTOML(r io.Reader) (*Config, error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+
+	var doc map[string]interface{}
+	if _, err := toml.Decode(string(data), &doc); err != nil {
+		return nil, fmt.Errorf("tigopesa: decode toml config: %w", err)
+	}
+
+	var fc fileConfig
+	if _, err := toml.Decode(string(data), &fc); err != nil {
+		return nil, fmt.Errorf("tigopesa: decode toml config: %w", err)
+	}
+
+	// the toml decoder falls back to matching keys ignoring case
+	return fc.configWith(unknownSettings(doc, reflect.TypeOf(fc), "toml", true, ""))
+}
+
+// unknownSettings returns an ErrUnknownSetting problem for every key of doc
+// that does not name a field of t in its tag, with the path of the key,
+// e.g. push.pay_ur. Sections are walked into, keys are reported sorted.
+func unknownSettings(doc map[string]interface{}, t reflect.Type, tag string, fold bool, prefix string) []error {
+	keys := make([]string, 0, len(doc))
+	for key := range doc {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	var problems []error
+	for _, key := range keys {
+		field, ok := fieldByTag(t, tag, key, fold)
+		if !ok {
+			problems = append(problems, &SettingError{Setting: prefix + key, Err: ErrUnknownSetting})
+			continue
+		}
+
+		if section, isMap := doc[key].(map[string]interface{}); isMap && field.Type.Kind() == reflect.Struct {
+			problems = append(problems, unknownSettings(section, field.Type, tag, fold, prefix+key+".")...)
+		}
+	}
+
+	return problems
+}
+
+func fieldByTag(t reflect.Type, tag, key string, fold bool) (reflect.StructField, bool) {
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		name := strings.Split(field.Tag.Get(tag), ",")[0]
+		if name == key || fold && strings.EqualFold(name, key) {
+			return field, true
+		}
+	}
+
+	return reflect.StructField{}, false
+}
+
+// configWith builds the Config from fc and reports the unknown keys found in
+// the file together with the problems of the settings.
+func (fc fileConfig) configWith(unknown []error) (*Config, error) {
+	config, err := fc.config()
+	if len(unknown) == 0 {
+		return config, err
+	}
+
+	problems := unknown
+	var configErr *ConfigError
+	if errors.As(err, &configErr) {
+		problems = append(problems, configErr.Problems...)
+	}
+
+	return nil, &ConfigError{Problems: problems}
+}
+
+func (fc fileConfig) config() (*Config, error) {
+	key := func(name, value string) setting {
+		return setting{name: name, value: strings.TrimSpace(value)}
+	}
+
+	var s settings
+	d, p, u := fc.Disburse, fc.Push, fc.Ussd
+	s.disburse.accountName = key("disburse.account_name", d.AccountName)
+	s.disburse.accountMSISDN = key("disburse.account_msisdn", d.AccountMSISDN)
+	s.disburse.brandID = key("disburse.brand_id", d.BrandID)
+	s.disburse.pin = key("disburse.pin", d.PIN)
+	s.disburse.requestURL = key("disburse.request_url", d.RequestURL)
+
+	s.ussd.accountName = key("ussd.account_name", u.AccountName)
+	s.ussd.accountMSISDN = key("ussd.account_msisdn", u.AccountMSISDN)
+	s.ussd.billerNumber = key("ussd.biller_number", u.BillerNumber)
+	s.ussd.requestURL = key("ussd.request_url", u.RequestURL)
+	s.ussd.namecheckURL = key("ussd.namecheck_url", u.NamecheckURL)
+
+	s.push.username = key("push.username", p.Username)
+	s.push.password = key("push.password", p.Password)
+	s.push.passwordGrantType = key("push.password_grant_type", p.PasswordGrantType)
+	s.push.baseURL = key("push.base_url", p.BaseURL)
+	s.push.tokenURL = key("push.token_url", p.TokenURL)
+	s.push.payURL = key("push.pay_url", p.PayURL)
+	s.push.statusURL = key("push.status_url", p.StatusURL)
+	s.push.billerMSISDN = key("push.biller_msisdn", p.BillerMSISDN)
+	s.push.billerCode = key("push.biller_code", p.BillerCode)
+	s.push.callbackURL = key("push.callback_url", p.CallbackURL)
+
+	return s.config()
+}
+
+// validator collects every problem found while building the Config
+type validator struct {
+	problems []error
+}
+
+func (v *validator) required(settings ...setting) {
+	for _, s := range settings {
+		if s.value == "" {
+			v.problems = append(v.problems, &SettingError{Setting: s.name, Err: ErrMissingSetting})
+		}
+	}
+}
+
+// url checks that the set settings are absolute http or https URLs
+func (v *validator) url(settings ...setting) {
+	for _, s := range settings {
+		if s.value == "" {
+			continue
+		}
+
+		if !isAbsoluteURL(s.value) {
+			v.problems = append(v.problems, &SettingError{
+				Setting: s.name,
+				Err:     fmt.Errorf("%w: %q must be an absolute http or https url", ErrInvalidURL, s.value),
+			})
+		}
+	}
+}
+
+func isAbsoluteURL(raw string) bool {
+	u, err := url.Parse(raw)
+	if err != nil {
+		return false
+	}
+
+	return (u.Scheme == "http" || u.Scheme == "https") && u.Host != ""
+}
+
+func anySet(settings ...setting) bool {
+	for _, s := range settings {
+		if s.value != "" {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (s settings) config() (*Config, error) {
+	var (
+		v      = &validator{}
+		config = &Config{}
+	)
+
+	d := s.disburse
+	if anySet(d.accountName, d.accountMSISDN, d.brandID, d.pin, d.requestURL) {
+		v.required(d.accountName, d.accountMSISDN, d.brandID, d.pin, d.requestURL)
+		v.url(d.requestURL)
+		config.Disburse = &disburse.Config{
+			AccountName:   d.accountName.value,
+			AccountMSISDN: d.accountMSISDN.value,
+			BrandID:       d.brandID.value,
+			PIN:           d.pin.value,
+			RequestURL:    d.requestURL.value,
+		}
+	}
+
+	p := s.push
+	if anySet(p.username, p.password, p.passwordGrantType, p.baseURL, p.tokenURL, p.payURL,
+		p.statusURL, p.billerMSISDN, p.billerCode, p.callbackURL) {
+		v.required(p.username, p.password, p.tokenURL, p.payURL, p.billerMSISDN, p.billerCode)
+		v.url(p.baseURL, p.callbackURL)
+		config.Push = s.pushConfig(v)
+	}
+
+	u := s.ussd
+	if anySet(u.accountName, u.accountMSISDN, u.billerNumber, u.requestURL, u.namecheckURL) {
+		v.required(u.accountName, u.accountMSISDN, u.billerNumber)
+		v.url(u.requestURL, u.namecheckURL)
+		config.Ussd = &ussd.Config{
+			AccountName:   u.accountName.value,
+			AccountMSISDN: u.accountMSISDN.value,
+			BillerNumber:  u.billerNumber.value,
+			RequestURL:    u.requestURL.value,
+			NamecheckURL:  u.namecheckURL.value,
+		}
+	}
+
+	if config.Disburse == nil && config.Push == nil && config.Ussd == nil {
+		return nil, &ConfigError{Problems: []error{ErrNoConfig}}
+	}
+
+	if len(v.problems) > 0 {
+		return nil, &ConfigError{Problems: v.problems}
+	}
+
+	return config, nil
+}
+
+// pushConfig splits the token, pay and status URLs into the push.Config
+// BaseURL and endpoints. Without a base URL they must be absolute URLs on
+// the same host.
+func (s settings) pushConfig(v *validator) *push.Config {
+	p := s.push
+	config := &push.Config{
+		Username:          p.username.value,
+		Password:          p.password.value,
+		PasswordGrantType: p.passwordGrantType.value,
+		BaseURL:           p.baseURL.value,
+		TokenEndpoint:     p.tokenURL.value,
+		BillerMSISDN:      p.billerMSISDN.value,
+		BillerCode:        p.billerCode.value,
+		PushPayEndpoint:   p.payURL.value,
+		StatusEndpoint:    p.statusURL.value,
+		CallbackURL:       p.callbackURL.value,
+	}
+
+	if config.PasswordGrantType == "" {
+		config.PasswordGrantType = defaultPasswordGrantType
+	}
+
+	if p.baseURL.value != "" {
+		for _, endpoint := range []setting{p.tokenURL, p.payURL, p.statusURL} {
+			if strings.Contains(endpoint.value, "://") {
+				v.url(endpoint)
+			}
+		}
+		return config
+	}
+
+	endpoints := []setting{p.tokenURL, p.payURL}
+	if p.statusURL.value != "" {
+		endpoints = append(endpoints, p.statusURL)
+	}
+
+	v.url(endpoints...)
+	for _, endpoint := range endpoints {
+		if !isAbsoluteURL(endpoint.value) {
+			return config
+		}
+	}
+
+	tokenURL, _ := url.Parse(p.tokenURL.value)
+	uris := make([]string, len(endpoints))
+	for i, endpoint := range endpoints {
+		u, _ := url.Parse(endpoint.value)
+		if tokenURL.Scheme != u.Scheme || tokenURL.Host != u.Host {
+			v.problems = append(v.problems, &SettingError{
+				Setting: endpoint.name,
+				Err: fmt.Errorf("%w: %q is not on the same host as %s, set %s",
+					ErrInvalidURL, endpoint.value, p.tokenURL.name, p.baseURL.name),
+			})
+			return config
+		}
+		uris[i] = u.RequestURI()
+	}
+
+	config.BaseURL = fmt.Sprintf("%s://%s", tokenURL.Scheme, tokenURL.Host)
+	config.TokenEndpoint = uris[0]
+	config.PushPayEndpoint = uris[1]
+	if len(uris) > 2 {
+		config.StatusEndpoint = uris[2]
+	}
+
+	return config
+}
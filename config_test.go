@@ -0,0 +1,217 @@
+/*
+ * MIT License
+ *
+ * Copyright (c) 2021 TechCraft Technologies Co. Ltd
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ *
+ */
+
+package tigopesa_test
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/techcraftlabs/tigopesa"
+	"github.com/techcraftlabs/tigopesa/push"
+)
+
+func TestLoadConfigFromEnv(t *testing.T) {
+	env := map[string]string{
+		"TIGO_ATW_ACCOUNT_NAME":     "COMPANY",
+		"TIGO_ATW_ACCOUNT_MSISDN":   "255713000000",
+		"TIGO_ATW_BRAND_ID":         "1234",
+		"TIGO_ATW_DISBURSEMENT_PIN": "0000",
+		"TIGO_ATW_DISBURSEMENT_URL": "https://tigo.example/disburse",
+		"TIGO_PUSH_USERNAME":        "user",
+		"TIGO_PUSH_PASSWORD":        "pass",
+		"TIGO_PUSH_BILLER_MSISDN":   "255713000000",
+		"TIGO_PUSH_BILLER_CODE":     "COMPANY",
+		"TIGO_PUSH_TOKEN_URL":       "https://tigo.example:8443/v1/oauth/token",
+		"TIGO_PUSH_URL":             "https://tigo.example:8443/v1/push/billpay",
+		"TIGO_PUSH_STATUS_URL":      "https://tigo.example:8443/v1/push/status",
+		"TIGO_PUSH_CALLBACK_URL":    "https://company.example/callback",
+	}
+	for key, value := range env {
+		t.Setenv(key, value)
+	}
+
+	config, err := tigopesa.LoadConfigFromEnv()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if config.Ussd != nil {
+		t.Errorf("ussd should not be configured: %+v", config.Ussd)
+	}
+
+	if config.Disburse == nil || config.Disburse.PIN != "0000" || config.Disburse.RequestURL != "https://tigo.example/disburse" {
+		t.Errorf("unexpected disburse config: %+v", config.Disburse)
+	}
+
+	want := push.Config{
+		Username:          "user",
+		Password:          "pass",
+		PasswordGrantType: "password",
+		BaseURL:           "https://tigo.example:8443",
+		TokenEndpoint:     "/v1/oauth/token",
+		BillerMSISDN:      "255713000000",
+		BillerCode:        "COMPANY",
+		PushPayEndpoint:   "/v1/push/billpay",
+		StatusEndpoint:    "/v1/push/status",
+		CallbackURL:       "https://company.example/callback",
+	}
+	if config.Push == nil || *config.Push != want {
+		t.Errorf("push config: got %+v want %+v", config.Push, want)
+	}
+}
+
+func TestLoadConfigFromEnv_Problems(t *testing.T) {
+	t.Setenv("TIGO_PUSH_USERNAME", "user")
+	t.Setenv("TIGO_PUSH_TOKEN_URL", "tigo.example/token")
+	t.Setenv("TIGO_WTA_ACCOUNT_NAME", "COMPANY")
+	t.Setenv("TIGO_NAMECHECK_URL", "://bad")
+
+	_, err := tigopesa.LoadConfigFromEnv()
+
+	var configErr *tigopesa.ConfigError
+	if !errors.As(err, &configErr) {
+		t.Fatalf("expected *ConfigError got %v", err)
+	}
+
+	if !errors.Is(err, tigopesa.ErrMissingSetting) || !errors.Is(err, tigopesa.ErrInvalidURL) {
+		t.Errorf("expected missing and invalid settings: %v", err)
+	}
+
+	for _, name := range []string{
+		"TIGO_PUSH_PASSWORD", "TIGO_PUSH_URL", "TIGO_PUSH_BILLER_MSISDN", "TIGO_PUSH_BILLER_CODE",
+		"TIGO_PUSH_TOKEN_URL", "TIGO_WTA_ACCOUNT_MSISDN", "TIGO_WTA_BILLER_NUMBER", "TIGO_NAMECHECK_URL",
+	} {
+		if !strings.Contains(err.Error(), name) {
+			t.Errorf("%s not reported in %v", name, err)
+		}
+	}
+}
+
+func TestLoadConfigFile(t *testing.T) {
+	files := map[string]string{
+		"tigo.yaml": `
+ussd:
+  account_name: COMPANY
+  account_msisdn: "255713000000"
+  biller_number: "123456"
+  namecheck_url: https://company.example/namecheck
+`,
+		"tigo.json": `{"ussd": {"account_name": "COMPANY", "account_msisdn": "255713000000",
+"biller_number": "123456", "namecheck_url": "https://company.example/namecheck"}}`,
+		"tigo.toml": `
+[ussd]
+account_name = "COMPANY"
+account_msisdn = "255713000000"
+biller_number = "123456"
+namecheck_url = "https://company.example/namecheck"
+`,
+	}
+
+	dir := t.TempDir()
+	for name, content := range files {
+		t.Run(name, func(t *testing.T) {
+			path := filepath.Join(dir, name)
+			if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+				t.Fatal(err)
+			}
+
+			config, err := tigopesa.LoadConfigFile(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if config.Push != nil || config.Disburse != nil {
+				t.Errorf("only ussd should be configured: %+v", config)
+			}
+
+			if config.Ussd == nil || config.Ussd.BillerNumber != "123456" ||
+				config.Ussd.NamecheckURL != "https://company.example/namecheck" {
+				t.Errorf("unexpected ussd config: %+v", config.Ussd)
+			}
+		})
+	}
+}
+
+func TestLoadConfigFile_Empty(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "empty.yaml")
+	if err := os.WriteFile(path, nil, 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := tigopesa.LoadConfigFile(path); !errors.Is(err, tigopesa.ErrNoConfig) {
+		t.Fatalf("expected ErrNoConfig got %v", err)
+	}
+}
+
+func TestLoadConfigFile_UnknownSetting(t *testing.T) {
+	files := map[string]string{
+		"tigo.yaml": "timeout: 30s\nussd:\n  account_name: COMPANY\n  biller_numbr: \"123456\"\n  request_url: localhost\n",
+		"tigo.json": `{"timeout": "30s", "ussd": {"account_name": "COMPANY", "biller_numbr": "123456", "request_url": "localhost"}}`,
+		"tigo.toml": "timeout = \"30s\"\n[ussd]\naccount_name = \"COMPANY\"\nbiller_numbr = \"123456\"\nrequest_url = \"localhost\"\n",
+	}
+
+	dir := t.TempDir()
+	for name, content := range files {
+		t.Run(name, func(t *testing.T) {
+			path := filepath.Join(dir, name)
+			if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+				t.Fatal(err)
+			}
+
+			_, err := tigopesa.LoadConfigFile(path)
+
+			var configErr *tigopesa.ConfigError
+			if !errors.As(err, &configErr) || !errors.Is(err, tigopesa.ErrUnknownSetting) {
+				t.Fatalf("expected unknown setting error got %v", err)
+			}
+
+			// every unknown key is reported with its path, together with
+			// the missing and invalid settings
+			for _, want := range []string{"timeout", "ussd.biller_numbr", "ussd.biller_number", "ussd.request_url"} {
+				if !settingReported(configErr, want) {
+					t.Errorf("%s not reported in %v", want, err)
+				}
+			}
+			if !errors.Is(err, tigopesa.ErrMissingSetting) || !errors.Is(err, tigopesa.ErrInvalidURL) {
+				t.Errorf("missing and invalid settings not reported in %v", err)
+			}
+		})
+	}
+}
+
+func settingReported(err *tigopesa.ConfigError, name string) bool {
+	for _, problem := range err.Problems {
+		var settingErr *tigopesa.SettingError
+		if errors.As(problem, &settingErr) && settingErr.Setting == name {
+			return true
+		}
+	}
+
+	return false
+}
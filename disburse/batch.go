@@ -0,0 +1,375 @@
+/*
+ * MIT License
+ *
+ * Copyright (c) 2021 TechCraft Technologies Co. Ltd
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ *
+ */
+
+package disburse
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+const (
+	StatusSucceeded    ItemStatus = "succeeded"
+	StatusFailed       ItemStatus = "failed"
+	StatusUnknown      ItemStatus = "unknown"
+	StatusSkipped      ItemStatus = "skipped"
+	StatusNotAttempted ItemStatus = "not_attempted"
+
+	// StatusPending is recorded in the Checkpoint right before a request
+	// is sent, it is replaced by the outcome once it is known.
+	StatusPending ItemStatus = "pending"
+
+	// StatusUnresolved is reported for requests the Checkpoint has as
+	// pending or unknown from an earlier run, they are not sent again.
+	StatusUnresolved ItemStatus = "unresolved"
+)
+
+// ErrDuplicateReference is returned by DisburseBatch when two requests in
+// the batch share the same ReferenceID.
+var ErrDuplicateReference = errors.New("disburse: duplicate reference in batch")
+
+// ErrUnresolved is the error of a StatusUnresolved item, tigo may or may not
+// have paid it in an earlier run.
+var ErrUnresolved = errors.New("disburse: outcome of an earlier run unknown")
+
+type (
+	// ItemStatus is the state of a single request in a batch
+	ItemStatus string
+
+	// BatchOptions controls how DisburseBatch sends the requests
+	BatchOptions struct {
+		// Concurrency is the number of requests in flight at the same
+		// time. Values less than 1 mean one request at a time.
+		Concurrency int
+
+		// RateLimit is the maximum number of requests sent per second,
+		// zero means no limit.
+		RateLimit float64
+
+		// MaxFailures stops the batch once this many requests failed or
+		// ended with an unknown outcome. Requests already in flight are
+		// allowed to finish. Zero means the batch never stops early.
+		MaxFailures int
+
+		// OnProgress is called after every request completes. Calls are
+		// serialized.
+		OnProgress func(Progress)
+
+		// Checkpoint records every request as pending before it is sent
+		// and its result once it is known. When a batch is run again with
+		// the same Checkpoint, requests that already succeeded are
+		// skipped and failed ones are sent again with their original
+		// ReferenceID. Requests still pending, because the process died
+		// while they were in flight, and those with an unknown outcome
+		// are reported as StatusUnresolved and not sent. Check them with
+		// tigo and Put their outcome in the Checkpoint before resuming.
+		Checkpoint Checkpoint
+	}
+
+	// ItemResult is the result of a single request in a batch. Index
+	// is the position of Request in the slice passed to DisburseBatch.
+	ItemResult struct {
+		Index    int        `json:"index"`
+		Request  Request    `json:"request"`
+		Response Response   `json:"response"`
+		Status   ItemStatus `json:"status"`
+		Err      error      `json:"-"`
+	}
+
+	// Progress is passed to BatchOptions.OnProgress, Item is the request
+	// that just completed.
+	Progress struct {
+		Total      int
+		Completed  int
+		Succeeded  int
+		Failed     int
+		Unknown    int
+		Skipped    int
+		Unresolved int
+		Item       ItemResult
+	}
+
+	// BatchReport has a result for every request passed to DisburseBatch in
+	// the same order.
+	BatchReport struct {
+		Items        []ItemResult `json:"items"`
+		Succeeded    int          `json:"succeeded"`
+		Failed       int          `json:"failed"`
+		Unknown      int          `json:"unknown"`
+		Skipped      int          `json:"skipped"`
+		Unresolved   int          `json:"unresolved"`
+		NotAttempted int          `json:"notAttempted"`
+
+		// Stopped is set when the batch ended early because
+		// BatchOptions.MaxFailures was reached.
+		Stopped bool `json:"stopped"`
+	}
+
+	batch struct {
+		client   *Client
+		opts     BatchOptions
+		report   *BatchReport
+		mu       sync.Mutex
+		progress Progress
+		stop     chan struct{}
+		stopOnce sync.Once
+		err      error
+	}
+)
+
+// DisburseBatch sends all requests using a pool of workers and returns a
+// report with the result of each of them. Every request goes through
+// Disburse so the client RetryPolicy applies to each of them.
+//
+// The returned error is not about individual requests, those are in the
+// report, but about the batch itself: duplicate references, a failing
+// Checkpoint or ctx being done before all requests were sent.
+func (client *Client) DisburseBatch(ctx context.Context, requests []Request, opts BatchOptions) (BatchReport, error) {
+	report := BatchReport{
+		Items: make([]ItemResult, len(requests)),
+	}
+
+	seen := make(map[string]int, len(requests))
+	for i, request := range requests {
+		if j, ok := seen[request.ReferenceID]; ok {
+			return report, fmt.Errorf("%w: %q at %d and %d", ErrDuplicateReference, request.ReferenceID, j, i)
+		}
+		seen[request.ReferenceID] = i
+		report.Items[i] = ItemResult{
+			Index:   i,
+			Request: request,
+			Status:  StatusNotAttempted,
+		}
+	}
+
+	b := &batch{
+		client:   client,
+		opts:     opts,
+		report:   &report,
+		progress: Progress{Total: len(requests)},
+		stop:     make(chan struct{}),
+	}
+
+	concurrency := opts.Concurrency
+	if concurrency < 1 {
+		concurrency = 1
+	}
+
+	jobs := make(chan int)
+	var wg sync.WaitGroup
+	for i := 0; i < concurrency; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for index := range jobs {
+				b.run(ctx, index)
+			}
+		}()
+	}
+
+	err := b.dispatch(ctx, jobs, len(requests))
+	close(jobs)
+	wg.Wait()
+
+	for _, item := range report.Items {
+		if item.Status == StatusNotAttempted {
+			report.NotAttempted++
+		}
+	}
+
+	if b.err != nil {
+		return report, b.err
+	}
+
+	return report, err
+}
+
+func (b *batch) dispatch(ctx context.Context, jobs chan<- int, n int) error {
+	var tick <-chan time.Time
+	if b.opts.RateLimit > 0 {
+		ticker := time.NewTicker(time.Duration(float64(time.Second) / b.opts.RateLimit))
+		defer ticker.Stop()
+		tick = ticker.C
+	}
+
+	sent := 0
+	for index := 0; index < n; index++ {
+		if skipped, err := b.skip(ctx, index); err != nil {
+			b.halt(err)
+			return nil
+		} else if skipped {
+			continue
+		}
+
+		// the first request goes out right away, the ticker spaces out
+		// the ones after it
+		if tick != nil && sent > 0 {
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
+			case <-b.stop:
+				return nil
+			case <-tick:
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-b.stop:
+			return nil
+		case jobs <- index:
+			sent++
+		}
+	}
+
+	return nil
+}
+
+// skip marks the request at index as skipped if the Checkpoint says it has
+// already succeeded and as unresolved if an earlier run may have paid it.
+func (b *batch) skip(ctx context.Context, index int) (bool, error) {
+	if b.opts.Checkpoint == nil {
+		return false, nil
+	}
+
+	request := b.report.Items[index].Request
+	entry, ok, err := b.opts.Checkpoint.Get(ctx, request.ReferenceID)
+	if err != nil {
+		return false, err
+	}
+
+	if !ok {
+		return false, nil
+	}
+
+	item := ItemResult{
+		Index:    index,
+		Request:  request,
+		Response: entry.Response,
+	}
+
+	switch entry.Status {
+	case StatusSucceeded:
+		item.Status = StatusSkipped
+	case StatusPending, StatusUnknown:
+		item.Status = StatusUnresolved
+		item.Err = fmt.Errorf("%w: %s %s", ErrUnresolved, request.ReferenceID, entry.Status)
+	default:
+		return false, nil
+	}
+
+	b.complete(item)
+
+	return true, nil
+}
+
+func (b *batch) run(ctx context.Context, index int) {
+	request := b.report.Items[index].Request
+
+	// a crash while the request is in flight must not lead to sending
+	// it again on resume
+	if b.opts.Checkpoint != nil {
+		pending := CheckpointEntry{ReferenceID: request.ReferenceID, Status: StatusPending}
+		if err := b.opts.Checkpoint.Put(ctx, pending); err != nil {
+			b.halt(fmt.Errorf("disburse: checkpoint %s: %w", request.ReferenceID, err))
+			return
+		}
+	}
+
+	response, err := b.client.Disburse(ctx, request)
+
+	item := ItemResult{
+		Index:    index,
+		Request:  request,
+		Response: response,
+		Status:   StatusSucceeded,
+		Err:      err,
+	}
+
+	switch {
+	case errors.Is(err, ErrOutcomeUnknown):
+		item.Status = StatusUnknown
+	case err != nil:
+		item.Status = StatusFailed
+	}
+
+	if b.opts.Checkpoint != nil {
+		if cErr := b.opts.Checkpoint.Put(ctx, newCheckpointEntry(item)); cErr != nil {
+			b.halt(fmt.Errorf("disburse: checkpoint %s: %w", request.ReferenceID, cErr))
+		}
+	}
+
+	b.complete(item)
+}
+
+func (b *batch) complete(item ItemResult) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.report.Items[item.Index] = item
+	b.progress.Completed++
+	b.progress.Item = item
+
+	switch item.Status {
+	case StatusSucceeded:
+		b.report.Succeeded++
+		b.progress.Succeeded++
+	case StatusFailed:
+		b.report.Failed++
+		b.progress.Failed++
+	case StatusUnknown:
+		b.report.Unknown++
+		b.progress.Unknown++
+	case StatusSkipped:
+		b.report.Skipped++
+		b.progress.Skipped++
+	case StatusUnresolved:
+		b.report.Unresolved++
+		b.progress.Unresolved++
+	}
+
+	if limit := b.opts.MaxFailures; limit > 0 && b.report.Failed+b.report.Unknown >= limit && !b.report.Stopped {
+		b.report.Stopped = true
+		b.stopOnce.Do(func() { close(b.stop) })
+	}
+
+	if b.opts.OnProgress != nil {
+		b.opts.OnProgress(b.progress)
+	}
+}
+
+// halt stops the batch because of err, only the first error is kept
+func (b *batch) halt(err error) {
+	b.mu.Lock()
+	if b.err == nil {
+		b.err = err
+	}
+	b.mu.Unlock()
+	b.stopOnce.Do(func() { close(b.stop) })
+}
@@ -0,0 +1,298 @@
+/*
+ * MIT License
+ *
+ * Copyright (c) 2021 TechCraft Technologies Co. Ltd
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ *
+ */
+
+package disburse_test
+
+import (
+	"context"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"reflect"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/techcraftlabs/tigopesa/disburse"
+	"github.com/techcraftlabs/tigopesa/money"
+)
+
+// batchServer fails the references in failing and tracks how many
+// requests it is serving at the same time.
+type batchServer struct {
+	failing  map[string]string
+	inflight int32
+	peak     int32
+	mu       sync.Mutex
+	received map[string]int
+}
+
+func (s *batchServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	n := atomic.AddInt32(&s.inflight, 1)
+	defer atomic.AddInt32(&s.inflight, -1)
+	for {
+		peak := atomic.LoadInt32(&s.peak)
+		if n <= peak || atomic.CompareAndSwapInt32(&s.peak, peak, n) {
+			break
+		}
+	}
+
+	var req struct {
+		ReferenceID string `xml:"REFERENCEID"`
+	}
+	_ = xml.NewDecoder(r.Body).Decode(&req)
+
+	s.mu.Lock()
+	s.received[req.ReferenceID]++
+	s.mu.Unlock()
+
+	time.Sleep(5 * time.Millisecond)
+
+	status, ok := s.failing[req.ReferenceID]
+	if !ok {
+		status = disburse.ErrSuccessTxn
+	}
+
+	w.Header().Set("Content-Type", "application/xml")
+	_, _ = fmt.Fprintf(w, `<COMMAND><TYPE>RMFCI</TYPE><REFERENCEID>%s</REFERENCEID>`+
+		`<TXNID>TXN%s</TXNID><TXNSTATUS>%s</TXNSTATUS><MESSAGE>message</MESSAGE></COMMAND>`,
+		req.ReferenceID, req.ReferenceID, status)
+}
+
+func newBatchServer(failing map[string]string) *batchServer {
+	return &batchServer{failing: failing, received: make(map[string]int)}
+}
+
+func batchRequests(n int) []disburse.Request {
+	requests := make([]disburse.Request, n)
+	for i := range requests {
+		requests[i] = disburse.Request{
+			ReferenceID: fmt.Sprintf("PAYROLL%03d", i),
+			MSISDN:      "255713123456",
+			Amount:      money.Shillings(5000),
+		}
+	}
+	return requests
+}
+
+func TestClient_DisburseBatch(t *testing.T) {
+	s := newBatchServer(map[string]string{"PAYROLL007": disburse.ErrAmountInsufficient})
+	server := httptest.NewServer(s)
+	defer server.Close()
+
+	var calls int32
+	client := newRetryClient(server.URL, disburse.RetryPolicy{})
+	report, err := client.DisburseBatch(context.Background(), batchRequests(30), disburse.BatchOptions{
+		Concurrency: 4,
+		OnProgress: func(p disburse.Progress) {
+			atomic.AddInt32(&calls, 1)
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if report.Succeeded != 29 || report.Failed != 1 {
+		t.Errorf("unexpected report totals: %+v", report)
+	}
+
+	if item := report.Items[7]; item.Status != disburse.StatusFailed || item.Err == nil {
+		t.Errorf("item 7: %+v", item)
+	}
+
+	if peak := atomic.LoadInt32(&s.peak); peak > 4 {
+		t.Errorf("concurrency: got %d want at most 4", peak)
+	}
+
+	if calls != 30 {
+		t.Errorf("progress calls: got %d want 30", calls)
+	}
+}
+
+func TestClient_DisburseBatchStopsOnFailures(t *testing.T) {
+	s := newBatchServer(map[string]string{
+		"PAYROLL000": disburse.ErrGeneralError,
+		"PAYROLL001": disburse.ErrGeneralError,
+	})
+	server := httptest.NewServer(s)
+	defer server.Close()
+
+	client := newRetryClient(server.URL, disburse.RetryPolicy{})
+	report, err := client.DisburseBatch(context.Background(), batchRequests(20), disburse.BatchOptions{
+		Concurrency: 1,
+		MaxFailures: 2,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !report.Stopped || report.Failed != 2 || report.NotAttempted != 18 {
+		t.Errorf("unexpected report totals: %+v", report)
+	}
+}
+
+func TestClient_DisburseBatchResume(t *testing.T) {
+	s := newBatchServer(map[string]string{"PAYROLL002": disburse.ErrServiceNotAvailable})
+	server := httptest.NewServer(s)
+	defer server.Close()
+
+	path := filepath.Join(t.TempDir(), "payroll.checkpoint")
+	checkpoint, err := disburse.OpenFileCheckpoint(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	client := newRetryClient(server.URL, disburse.RetryPolicy{})
+	requests := batchRequests(5)
+	if _, err := client.DisburseBatch(context.Background(), requests, disburse.BatchOptions{
+		Checkpoint: checkpoint,
+	}); err != nil {
+		t.Fatal(err)
+	}
+	_ = checkpoint.Close()
+
+	// the process restarts and the batch is run again
+	delete(s.failing, "PAYROLL002")
+	checkpoint, err = disburse.OpenFileCheckpoint(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer checkpoint.Close()
+
+	report, err := client.DisburseBatch(context.Background(), requests, disburse.BatchOptions{
+		Checkpoint: checkpoint,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if report.Skipped != 4 || report.Succeeded != 1 {
+		t.Errorf("unexpected report totals: %+v", report)
+	}
+
+	for ref, n := range s.received {
+		want := 1
+		if ref == "PAYROLL002" {
+			want = 2
+		}
+		if n != want {
+			t.Errorf("%s sent %d times want %d", ref, n, want)
+		}
+	}
+}
+
+// recordingCheckpoint keeps every status Put for a reference in order
+type recordingCheckpoint struct {
+	disburse.Checkpoint
+	mu       sync.Mutex
+	statuses map[string][]disburse.ItemStatus
+}
+
+func (r *recordingCheckpoint) Put(ctx context.Context, entry disburse.CheckpointEntry) error {
+	r.mu.Lock()
+	r.statuses[entry.ReferenceID] = append(r.statuses[entry.ReferenceID], entry.Status)
+	r.mu.Unlock()
+	return r.Checkpoint.Put(ctx, entry)
+}
+
+func TestClient_DisburseBatchUnresolved(t *testing.T) {
+	s := newBatchServer(nil)
+	server := httptest.NewServer(s)
+	defer server.Close()
+
+	checkpoint := &recordingCheckpoint{
+		Checkpoint: disburse.NewMemoryCheckpoint(),
+		statuses:   make(map[string][]disburse.ItemStatus),
+	}
+
+	// an earlier run crashed with PAYROLL001 in flight and could not tell
+	// whether PAYROLL002 was paid
+	ctx := context.Background()
+	_ = checkpoint.Checkpoint.Put(ctx, disburse.CheckpointEntry{ReferenceID: "PAYROLL001", Status: disburse.StatusPending})
+	_ = checkpoint.Checkpoint.Put(ctx, disburse.CheckpointEntry{ReferenceID: "PAYROLL002", Status: disburse.StatusUnknown})
+	_ = checkpoint.Checkpoint.Put(ctx, disburse.CheckpointEntry{ReferenceID: "PAYROLL003", Status: disburse.StatusFailed})
+
+	client := newRetryClient(server.URL, disburse.RetryPolicy{})
+	report, err := client.DisburseBatch(ctx, batchRequests(4), disburse.BatchOptions{
+		Checkpoint: checkpoint,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if report.Unresolved != 2 || report.Succeeded != 2 {
+		t.Errorf("unexpected report totals: %+v", report)
+	}
+
+	for _, i := range []int{1, 2} {
+		item := report.Items[i]
+		if item.Status != disburse.StatusUnresolved || !errors.Is(item.Err, disburse.ErrUnresolved) {
+			t.Errorf("item %d: %+v", i, item)
+		}
+		if n := s.received[item.Request.ReferenceID]; n != 0 {
+			t.Errorf("%s sent %d times want 0", item.Request.ReferenceID, n)
+		}
+	}
+
+	want := []disburse.ItemStatus{disburse.StatusPending, disburse.StatusSucceeded}
+	for _, ref := range []string{"PAYROLL000", "PAYROLL003"} {
+		if got := checkpoint.statuses[ref]; !reflect.DeepEqual(got, want) {
+			t.Errorf("%s checkpoint statuses: got %v want %v", ref, got, want)
+		}
+	}
+}
+
+func TestClient_DisburseBatchDuplicateReference(t *testing.T) {
+	client := newRetryClient("http://127.0.0.1:0", disburse.RetryPolicy{})
+	requests := batchRequests(3)
+	requests[2].ReferenceID = requests[0].ReferenceID
+
+	if _, err := client.DisburseBatch(context.Background(), requests, disburse.BatchOptions{}); err == nil {
+		t.Fatal("expected duplicate reference error")
+	}
+}
+
+func TestClient_DisburseBatchRateLimit(t *testing.T) {
+	server := httptest.NewServer(newBatchServer(nil))
+	defer server.Close()
+
+	client := newRetryClient(server.URL, disburse.RetryPolicy{})
+	start := time.Now()
+	report, err := client.DisburseBatch(context.Background(), batchRequests(3), disburse.BatchOptions{
+		RateLimit: 2,
+	})
+	if err != nil || report.Succeeded != 3 {
+		t.Fatalf("report: %+v %v", report, err)
+	}
+
+	// the first request is sent right away and the other two 500ms apart
+	if elapsed := time.Since(start); elapsed < 900*time.Millisecond || elapsed > 1400*time.Millisecond {
+		t.Errorf("3 requests at 2 per second took %s", elapsed)
+	}
+}
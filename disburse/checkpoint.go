@@ -0,0 +1,205 @@
+/*
+ * MIT License
+ *
+ * Copyright (c) 2021 TechCraft Technologies Co. Ltd
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ *
+ */
+
+package disburse
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+)
+
+var (
+	_ Checkpoint = (*memoryCheckpoint)(nil)
+	_ Checkpoint = (*FileCheckpoint)(nil)
+)
+
+type (
+	// Checkpoint records the outcome of each request of a batch so that an
+	// interrupted batch can be resumed, see BatchOptions.Checkpoint. Get
+	// returns ok false when reference has not been recorded.
+	Checkpoint interface {
+		Get(ctx context.Context, reference string) (entry CheckpointEntry, ok bool, err error)
+		Put(ctx context.Context, entry CheckpointEntry) error
+	}
+
+	// CheckpointEntry is what a Checkpoint records for every request
+	CheckpointEntry struct {
+		ReferenceID string     `json:"reference"`
+		Status      ItemStatus `json:"status"`
+		Response    Response   `json:"response"`
+		Error       string     `json:"error,omitempty"`
+	}
+
+	memoryCheckpoint struct {
+		mu      sync.RWMutex
+		entries map[string]CheckpointEntry
+	}
+
+	// FileCheckpoint is a Checkpoint that appends every entry to a file as a
+	// json line, the last entry of a reference wins. The file survives a crash
+	// of the process running the batch which makes it possible to resume it.
+	FileCheckpoint struct {
+		mu      sync.Mutex
+		file    *os.File
+		entries map[string]CheckpointEntry
+	}
+)
+
+func newCheckpointEntry(item ItemResult) CheckpointEntry {
+	entry := CheckpointEntry{
+		ReferenceID: item.Request.ReferenceID,
+		Status:      item.Status,
+		Response:    item.Response,
+	}
+
+	if item.Err != nil {
+		entry.Error = item.Err.Error()
+	}
+
+	return entry
+}
+
+// NewMemoryCheckpoint returns a Checkpoint that lives as long as the process,
+// it is useful for running the same batch again after some requests failed.
+func NewMemoryCheckpoint() Checkpoint {
+	return &memoryCheckpoint{
+		entries: make(map[string]CheckpointEntry),
+	}
+}
+
+func (m *memoryCheckpoint) Get(_ context.Context, reference string) (CheckpointEntry, bool, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	entry, ok := m.entries[reference]
+	return entry, ok, nil
+}
+
+func (m *memoryCheckpoint) Put(_ context.Context, entry CheckpointEntry) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.entries[entry.ReferenceID] = entry
+	return nil
+}
+
+// OpenFileCheckpoint opens the checkpoint at path creating it if it does
+// not exist. Entries already in the file are loaded so that the batch can
+// be resumed.
+func OpenFileCheckpoint(path string) (*FileCheckpoint, error) {
+	buf, err := os.ReadFile(path)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return nil, err
+	}
+
+	entries := make(map[string]CheckpointEntry)
+	lines := bytes.Split(buf, []byte("\n"))
+	for i, line := range lines {
+		if len(bytes.TrimSpace(line)) == 0 {
+			continue
+		}
+
+		var entry CheckpointEntry
+		if err := json.Unmarshal(line, &entry); err != nil {
+			// a crash while writing leaves a truncated last line, any
+			// other broken line means the file is not a checkpoint
+			if i == len(lines)-1 {
+				continue
+			}
+			return nil, fmt.Errorf("disburse: checkpoint %s line %d: %w", path, i+1, err)
+		}
+		entries[entry.ReferenceID] = entry
+	}
+
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o600)
+	if err != nil {
+		return nil, err
+	}
+
+	// start on a fresh line after a truncated write
+	if len(buf) > 0 && buf[len(buf)-1] != '\n' {
+		if _, err := file.Write([]byte("\n")); err != nil {
+			_ = file.Close()
+			return nil, err
+		}
+	}
+
+	return &FileCheckpoint{
+		file:    file,
+		entries: entries,
+	}, nil
+}
+
+func (f *FileCheckpoint) Get(_ context.Context, reference string) (CheckpointEntry, bool, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	entry, ok := f.entries[reference]
+	return entry, ok, nil
+}
+
+// Put appends entry to the file and syncs it to disk before returning
+func (f *FileCheckpoint) Put(_ context.Context, entry CheckpointEntry) error {
+	buf, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.file == nil {
+		return errors.New("disburse: checkpoint is closed")
+	}
+
+	if _, err := f.file.Write(append(buf, '\n')); err != nil {
+		return err
+	}
+
+	if err := f.file.Sync(); err != nil {
+		return err
+	}
+
+	f.entries[entry.ReferenceID] = entry
+
+	return nil
+}
+
+// Close closes the underlying file
+func (f *FileCheckpoint) Close() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.file == nil {
+		return nil
+	}
+
+	err := f.file.Close()
+	f.file = nil
+
+	return err
+}
@@ -28,23 +28,33 @@ package disburse
 import (
 	"context"
 	"encoding/xml"
+	"fmt"
 	"github.com/techcraftlabs/base"
-	"math"
+	"github.com/techcraftlabs/tigopesa/events"
+	"github.com/techcraftlabs/tigopesa/internal/timeout"
+	"github.com/techcraftlabs/tigopesa/internal/tracing"
+	"github.com/techcraftlabs/tigopesa/metrics"
+	"github.com/techcraftlabs/tigopesa/money"
+	"github.com/techcraftlabs/tigopesa/msisdn"
+	"github.com/techcraftlabs/tigopesa/redact"
+	"github.com/techcraftlabs/tigopesa/tigoerr"
+	"go.opentelemetry.io/otel/trace"
 	"net/http"
+	"time"
 )
 
 const (
-	ErrSuccessTxn               = "error000"
-	ErrServiceNotAvailable      = "error001"
-	ErrInvalidCustomerRefNumber = "error010"
-	ErrCustomerRefNumLocked     = "error011"
-	ErrInvalidAmount            = "error012"
-	ErrAmountInsufficient       = "error013"
-	ErrAmountTooHigh            = "error014"
-	ErrAmountTooLow             = "error015"
-	ErrInvalidPayment           = "error016"
-	ErrGeneralError             = "error100"
-	ErrRetryConditionNoResponse = "error111"
+	ErrSuccessTxn               = tigoerr.CodeSuccess
+	ErrServiceNotAvailable      = tigoerr.CodeServiceNotAvailable
+	ErrInvalidCustomerRefNumber = tigoerr.CodeInvalidCustomerRefNum
+	ErrCustomerRefNumLocked     = tigoerr.CodeCustomerRefNumLocked
+	ErrInvalidAmount            = tigoerr.CodeInvalidAmount
+	ErrAmountInsufficient       = tigoerr.CodeAmountInsufficient
+	ErrAmountTooHigh            = tigoerr.CodeAmountTooHigh
+	ErrAmountTooLow             = tigoerr.CodeAmountTooLow
+	ErrInvalidPayment           = tigoerr.CodeInvalidPayment
+	ErrGeneralError             = tigoerr.CodeGeneralError
+	ErrRetryConditionNoResponse = tigoerr.CodeRetryConditionNoResponse
 
 	requestType    = "REQMFCI"
 	senderLanguage = "EN"
@@ -64,23 +74,23 @@ type (
 	}
 
 	Request struct {
-		ReferenceID string  `json:"reference"`
-		MSISDN      string  `json:"msisdn"`
-		Amount      float64 `json:"amount"`
+		ReferenceID string       `json:"reference"`
+		MSISDN      string       `json:"msisdn"`
+		Amount      money.Amount `json:"amount"`
 	}
 
 	disburseRequest struct {
-		XMLName     xml.Name `xml:"COMMAND"`
-		Text        string   `xml:",chardata"`
-		Type        string   `xml:"TYPE"`
-		ReferenceID string   `xml:"REFERENCEID"`
-		Msisdn      string   `xml:"MSISDN"`
-		PIN         string   `xml:"PIN"`
-		Msisdn1     string   `xml:"MSISDN1"`
-		Amount      float64  `xml:"AMOUNT"`
-		SenderName  string   `xml:"SENDERNAME"`
-		Language1   string   `xml:"LANGUAGE1"`
-		BrandID     string   `xml:"BRAND_ID"`
+		XMLName     xml.Name     `xml:"COMMAND"`
+		Text        string       `xml:",chardata"`
+		Type        string       `xml:"TYPE"`
+		ReferenceID string       `xml:"REFERENCEID"`
+		Msisdn      string       `xml:"MSISDN"`
+		PIN         string       `xml:"PIN"`
+		Msisdn1     string       `xml:"MSISDN1"`
+		Amount      money.Amount `xml:"AMOUNT"`
+		SenderName  string       `xml:"SENDERNAME"`
+		Language1   string       `xml:"LANGUAGE1"`
+		BrandID     string       `xml:"BRAND_ID"`
 	}
 
 	Response struct {
@@ -102,41 +112,113 @@ type (
 
 	Client struct {
 		*Config
-		base *base.Client
+		base        *base.Client
+		retryPolicy RetryPolicy
+		redactor    *redact.Redactor
+		limits      money.Limits
+		normalizer  msisdn.Normalizer
+		metrics     metrics.Recorder
+		tracer      trace.Tracer
+		timeouts    Timeouts
+		events      events.Sink
 	}
 )
 
 func NewClient(config *Config, opts ...ClientOption) *Client {
 	client := &Client{
-		Config: config,
-		base:   base.NewClient(),
+		Config:     config,
+		base:       base.NewClient(),
+		redactor:   redact.New(),
+		normalizer: msisdn.Default,
+		metrics:    metrics.Noop,
+		tracer:     tracing.Tracer(nil, "disburse"),
+		timeouts:   DefaultTimeouts,
+		events:     events.Discard,
 	}
 
 	for _, opt := range opts {
 		opt(client)
 	}
 
+	client.base.Logger = client.redactor.Writer(client.base.Logger)
+
 	return client
 }
 
+// Check returns request as Disburse sends it, with its MSISDN normalized, or
+// the error Disburse fails with before sending anything: an amount outside
+// the limits set with WithAmountLimits or an invalid MSISDN.
+func (client *Client) Check(request Request) (Request, error) {
+	if err := client.limits.Check(request.Amount); err != nil {
+		return request, err
+	}
+
+	normalized, err := client.normalizer.Normalize(request.MSISDN)
+	if err != nil {
+		return request, err
+	}
+	request.MSISDN = normalized
+
+	return request, nil
+}
+
+// Disburse sends money from the disbursement account to request.MSISDN, which
+// is normalized first, see WithMSISDNNormalizer. When tigo replies with a
+// TXNSTATUS other than ErrSuccessTxn the Response is returned together with
+// a *tigoerr.Error describing the failure.
+//
+// Retryable failures are retried as configured by WithRetryPolicy, when the
+// outcome is still not known after the last attempt the error matches
+// ErrOutcomeUnknown.
 func (client *Client) Disburse(ctx context.Context, request Request) (response Response, err error) {
+	ctx, span := client.tracer.Start(ctx, "tigopesa.disburse",
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(tracing.ReferenceID.String(request.ReferenceID)))
+
+	start := time.Now()
+	defer func() {
+		client.metrics.Observe(metrics.Disburse, metrics.Code(response.TxnStatus, err), time.Since(start))
+		if response.TxnID != "" {
+			span.SetAttributes(tracing.TxnID.String(response.TxnID))
+		}
+		tracing.End(span, response.TxnStatus, err)
+		client.publish(ctx, request, response, err)
+	}()
+
+	if request, err = client.Check(request); err != nil {
+		return response, err
+	}
+
 	req := client.requestAdapt(request)
-	res, err := client.disburse(ctx, req)
+	return client.disburseWithRetry(ctx, req)
+}
+
+// send makes a single disbursement attempt
+func (client *Client) send(ctx context.Context, request disburseRequest) (Response, error) {
+	ctx, cancel := timeout.With(ctx, client.timeouts.Attempt)
+	defer cancel()
+
+	res, err := client.disburse(ctx, request)
 	if err != nil {
 		return Response{}, err
 	}
-	return client.responseAdapt(res), nil
+
+	response := client.responseAdapt(res)
+	if response.TxnStatus == "" {
+		return response, errNoTxnStatus
+	}
+
+	return response, tigoerr.FromCode(response.TxnStatus, response.Message)
 }
 
 func (client *Client) requestAdapt(request Request) disburseRequest {
-	amount := math.Floor(request.Amount*100)/100
 	r := disburseRequest{
 		Type:        requestType,
 		ReferenceID: request.ReferenceID,
 		Msisdn:      client.Config.AccountMSISDN,
 		PIN:         client.Config.PIN,
 		Msisdn1:     request.MSISDN,
-		Amount:      amount,
+		Amount:      request.Amount,
 		SenderName:  client.Config.AccountName,
 		Language1:   senderLanguage,
 		BrandID:     client.Config.BrandID,
@@ -159,6 +241,7 @@ func (client *Client) disburse(ctx context.Context, request disburseRequest) (re
 	headers := map[string]string{
 		"Content-Type": "application/xml",
 	}
+	tracing.Inject(ctx, headers)
 	headersOpt := base.WithRequestHeaders(headers)
 	reqOpts = append(reqOpts, headersOpt)
 
@@ -169,8 +252,9 @@ func (client *Client) disburse(ctx context.Context, request disburseRequest) (re
 		return response{}, err
 	}
 
-	if do.Error != nil {
-		return response{}, do.Error
+	// an http error still counts as a reply when it carries a TXNSTATUS
+	if do.Error != nil && res.TxnStatus == "" {
+		return response{}, fmt.Errorf("%w: %d", do.Error, do.StatusCode)
 	}
 
 	return *res, nil
@@ -0,0 +1,62 @@
+/*
+ * MIT License
+ *
+ * Copyright (c) 2021 TechCraft Technologies Co. Ltd
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ *
+ */
+
+package disburse_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/techcraftlabs/tigopesa/disburse"
+	"github.com/techcraftlabs/tigopesa/money"
+	"github.com/techcraftlabs/tigopesa/msisdn"
+	"github.com/techcraftlabs/tigopesa/tigoerr"
+	"github.com/techcraftlabs/tigopesa/tigotest"
+)
+
+func TestClient_DisburseAmountLimits(t *testing.T) {
+	server := tigotest.NewServer()
+	defer server.Close()
+
+	limits := money.Limits{Min: money.Shillings(500), Max: money.Shillings(1000000)}
+
+	client := disburse.NewClient(server.DisburseConfig(), disburse.WithDebugMode(false), disburse.WithAmountLimits(limits))
+	_, err := client.Disburse(context.Background(), disburse.Request{ReferenceID: "PAY1", MSISDN: "255713123456", Amount: money.Shillings(2000000)})
+	if !errors.Is(err, tigoerr.ErrAmountTooHigh) || len(server.DisburseRequests()) != 0 {
+		t.Errorf("disburse: %v, %d requests sent", err, len(server.DisburseRequests()))
+	}
+}
+
+func TestClient_DisburseMSISDNNormalization(t *testing.T) {
+	server := tigotest.NewServer()
+	defer server.Close()
+
+	client := disburse.NewClient(server.DisburseConfig(), disburse.WithDebugMode(false))
+	_, err := client.Disburse(context.Background(), disburse.Request{ReferenceID: "PAY1", MSISDN: "0754123456", Amount: money.Shillings(1000)})
+	if !errors.Is(err, msisdn.ErrNotTigo) || len(server.DisburseRequests()) != 0 {
+		t.Errorf("disburse: %v, %d requests sent", err, len(server.DisburseRequests()))
+	}
+}
@@ -0,0 +1,60 @@
+/*
+ * MIT License
+ *
+ * Copyright (c) 2021 TechCraft Technologies Co. Ltd
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ *
+ */
+package disburse
+
+import (
+	"context"
+	"time"
+
+	"github.com/techcraftlabs/tigopesa/events"
+)
+
+// publish publishes events.DisbursementSent when the disbursement of
+// request succeeded and events.DisbursementFailed otherwise.
+func (client *Client) publish(ctx context.Context, request Request, response Response, err error) {
+	if err == nil {
+		client.events.Publish(ctx, events.DisbursementSent{
+			Time:        time.Now(),
+			ReferenceID: request.ReferenceID,
+			MSISDN:      request.MSISDN,
+			Amount:      request.Amount,
+			TxnID:       response.TxnID,
+			TxnStatus:   response.TxnStatus,
+			Message:     response.Message,
+		})
+		return
+	}
+
+	client.events.Publish(ctx, events.DisbursementFailed{
+		Time:        time.Now(),
+		ReferenceID: request.ReferenceID,
+		MSISDN:      request.MSISDN,
+		Amount:      request.Amount,
+		TxnID:       response.TxnID,
+		TxnStatus:   response.TxnStatus,
+		Message:     response.Message,
+		Err:         err,
+	})
+}
@@ -26,6 +26,14 @@
 package disburse
 
 import (
+	"github.com/techcraftlabs/tigopesa/events"
+	"github.com/techcraftlabs/tigopesa/internal/timeout"
+	"github.com/techcraftlabs/tigopesa/internal/tracing"
+	"github.com/techcraftlabs/tigopesa/metrics"
+	"github.com/techcraftlabs/tigopesa/money"
+	"github.com/techcraftlabs/tigopesa/msisdn"
+	"github.com/techcraftlabs/tigopesa/redact"
+	"go.opentelemetry.io/otel/trace"
 	"io"
 	"net/http"
 )
@@ -72,3 +80,85 @@ func WithHTTPClient(httpClient *http.Client) ClientOption {
 		client.base.Http = httpClient
 	}
 }
+
+// WithRetryPolicy sets how Disburse retries requests that failed with a
+// retryable error. By default every request is sent only once, use
+// DefaultRetryPolicy for sensible defaults.
+func WithRetryPolicy(policy RetryPolicy) ClientOption {
+	return func(client *Client) {
+		client.retryPolicy = policy
+	}
+}
+
+// WithRedactor sets the redact.Redactor used to mask secrets like the PIN,
+// passwords and tokens in debug logs. By default secrets are masked and
+// MSISDNs are not, a nil r is ignored.
+func WithRedactor(r *redact.Redactor) ClientOption {
+	return func(client *Client) {
+		if r == nil {
+			return
+		}
+		client.redactor = r
+	}
+}
+
+// WithAmountLimits sets the smallest and largest amounts Disburse sends, zero
+// values mean no limit. Amounts that are not positive are always rejected.
+func WithAmountLimits(limits money.Limits) ClientOption {
+	return func(client *Client) {
+		client.limits = limits
+	}
+}
+
+// WithMSISDNNormalizer sets the msisdn.Normalizer applied to Request.MSISDN
+// before it is sent, numbers it rejects fail Disburse.
+// By default msisdn.Default is used, msisdn.Identity turns normalization
+// off. A nil normalizer is ignored.
+func WithMSISDNNormalizer(normalizer msisdn.Normalizer) ClientOption {
+	return func(client *Client) {
+		if normalizer == nil {
+			return
+		}
+		client.normalizer = normalizer
+	}
+}
+
+// WithMetrics sets the metrics.Recorder that observes every Disburse call,
+// a nil recorder is ignored.
+func WithMetrics(recorder metrics.Recorder) ClientOption {
+	return func(client *Client) {
+		if recorder == nil {
+			return
+		}
+		client.metrics = recorder
+	}
+}
+
+// WithTracerProvider sets the OpenTelemetry TracerProvider used to trace
+// Disburse, the global provider is used by default.
+func WithTracerProvider(provider trace.TracerProvider) ClientOption {
+	return func(client *Client) {
+		client.tracer = tracing.Tracer(provider, "disburse")
+	}
+}
+
+// WithTimeouts sets how long a single disbursement attempt may take, zero
+// fields keep the value from DefaultTimeouts.
+func WithTimeouts(timeouts Timeouts) ClientOption {
+	return func(client *Client) {
+		client.timeouts = timeouts
+		timeout.Defaults(&client.timeouts, DefaultTimeouts)
+	}
+}
+
+// WithEventSink sets the events.Sink that gets an event for every
+// disbursement, a sink that panics does not affect the client. A nil sink
+// is ignored.
+func WithEventSink(sink events.Sink) ClientOption {
+	return func(client *Client) {
+		if sink == nil {
+			return
+		}
+		client.events = events.Safe(sink)
+	}
+}
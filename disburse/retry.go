@@ -0,0 +1,155 @@
+/*
+ * MIT License
+ *
+ * Copyright (c) 2021 TechCraft Technologies Co. Ltd
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ *
+ */
+
+package disburse
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/techcraftlabs/tigopesa/internal/backoff"
+	"github.com/techcraftlabs/tigopesa/tigoerr"
+)
+
+// ErrOutcomeUnknown is matched by the error Disburse returns when it could not
+// find out whether the money was sent. The disbursement needs to be reconciled
+// using its ReferenceID, see OutcomeUnknownError.
+var ErrOutcomeUnknown = errors.New("disburse: outcome unknown")
+
+// errNoTxnStatus is returned for a reply tigo sent without a TXNSTATUS
+var errNoTxnStatus = errors.New("disburse: no TXNSTATUS in response")
+
+var _ error = (*OutcomeUnknownError)(nil)
+
+type (
+	// RetryPolicy decides how Disburse handles responses that tigo marks as
+	// retryable (see tigoerr.IsRetryable) and requests that got no response
+	// at all. Every attempt reuses the same REFERENCEID so tigo does not
+	// process the same disbursement twice.
+	//
+	// The wait before attempt n+1 is InitialBackoff * Multiplier^(n-1) capped
+	// at MaxBackoff.
+	RetryPolicy struct {
+		// MaxAttempts is the total number of attempts including the first
+		// one. Values less than 1 mean a single attempt.
+		MaxAttempts    int
+		InitialBackoff time.Duration
+		MaxBackoff     time.Duration
+		// Multiplier values less than 1 mean the backoff stays the same
+		Multiplier float64
+	}
+
+	// OutcomeUnknownError is returned by Disburse when the last attempt
+	// ended with tigoerr.ErrNoResponse or without a TXNSTATUS from tigo,
+	// be it because no response arrived or because the response was an
+	// http error or could not be decoded.
+	// Err is the error of the last attempt.
+	OutcomeUnknownError struct {
+		ReferenceID string
+		Attempts    int
+		Err         error
+	}
+)
+
+// DefaultRetryPolicy makes up to 4 attempts waiting 2s, 4s and 8s in between.
+var DefaultRetryPolicy = RetryPolicy{
+	MaxAttempts:    4,
+	InitialBackoff: 2 * time.Second,
+	MaxBackoff:     30 * time.Second,
+	Multiplier:     2,
+}
+
+func (e *OutcomeUnknownError) Error() string {
+	return fmt.Sprintf("disburse: outcome of %s unknown after %d attempt(s): %v",
+		e.ReferenceID, e.Attempts, e.Err)
+}
+
+func (e *OutcomeUnknownError) Unwrap() error {
+	return e.Err
+}
+
+func (e *OutcomeUnknownError) Is(target error) bool {
+	return target == ErrOutcomeUnknown
+}
+
+func (p RetryPolicy) attempts() int {
+	return backoff.Policy(p).Attempts()
+}
+
+// backoff returns how long to wait after the given attempt
+func (p RetryPolicy) backoff(attempt int) time.Duration {
+	return backoff.Policy(p).Wait(attempt)
+}
+
+// noResponse reports whether err leaves the outcome of the request unknown,
+// either tigo said so with error111 or no TXNSTATUS could be read from the
+// reply. Only a *tigoerr.Error carries a TXNSTATUS.
+func noResponse(err error) bool {
+	if err == nil {
+		return false
+	}
+
+	var tigoErr *tigoerr.Error
+	return errors.Is(err, tigoerr.ErrNoResponse) || !errors.As(err, &tigoErr)
+}
+
+func retryable(err error) bool {
+	return errors.Is(err, tigoerr.ErrRetryable) || noResponse(err)
+}
+
+func (client *Client) disburseWithRetry(ctx context.Context, request disburseRequest) (response Response, err error) {
+	policy := client.retryPolicy
+	attempt := 1
+	for ; ; attempt++ {
+		response, err = client.send(ctx, request)
+		if !retryable(err) || attempt >= policy.attempts() {
+			break
+		}
+
+		timer := time.NewTimer(policy.backoff(attempt))
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return response, client.outcome(request, attempt, err)
+		case <-timer.C:
+		}
+	}
+
+	return response, client.outcome(request, attempt, err)
+}
+
+func (client *Client) outcome(request disburseRequest, attempts int, err error) error {
+	if !noResponse(err) {
+		return err
+	}
+
+	return &OutcomeUnknownError{
+		ReferenceID: request.ReferenceID,
+		Attempts:    attempts,
+		Err:         err,
+	}
+}
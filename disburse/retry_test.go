@@ -0,0 +1,268 @@
+/*
+ * MIT License
+ *
+ * Copyright (c) 2021 TechCraft Technologies Co. Ltd
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ *
+ */
+
+package disburse_test
+
+import (
+	"context"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/techcraftlabs/tigopesa/disburse"
+	"github.com/techcraftlabs/tigopesa/money"
+	"github.com/techcraftlabs/tigopesa/tigoerr"
+)
+
+// scripted replies with the next status in statuses for every request
+// and records the REFERENCEID of every request it receives.
+type scripted struct {
+	mu         sync.Mutex
+	statuses   []string
+	references []string
+}
+
+func (s *scripted) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		ReferenceID string `xml:"REFERENCEID"`
+	}
+	_ = xml.NewDecoder(r.Body).Decode(&req)
+
+	s.mu.Lock()
+	status := disburse.ErrSuccessTxn
+	if len(s.statuses) > 0 {
+		status, s.statuses = s.statuses[0], s.statuses[1:]
+	}
+	s.references = append(s.references, req.ReferenceID)
+	s.mu.Unlock()
+
+	w.Header().Set("Content-Type", "application/xml")
+	_, _ = fmt.Fprintf(w, `<COMMAND><TYPE>RMFCI</TYPE><REFERENCEID>%s</REFERENCEID>`+
+		`<TXNID>MP210603.1234.A00001</TXNID><TXNSTATUS>%s</TXNSTATUS><MESSAGE>message</MESSAGE></COMMAND>`,
+		req.ReferenceID, status)
+}
+
+func (s *scripted) sent() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]string(nil), s.references...)
+}
+
+func newRetryClient(url string, policy disburse.RetryPolicy, opts ...disburse.ClientOption) *disburse.Client {
+	conf := &disburse.Config{
+		AccountName:   "ACCOUNT",
+		AccountMSISDN: "255713000000",
+		BrandID:       "1234",
+		PIN:           "0000",
+		RequestURL:    url,
+	}
+	opts = append([]disburse.ClientOption{disburse.WithDebugMode(false), disburse.WithRetryPolicy(policy)}, opts...)
+	return disburse.NewClient(conf, opts...)
+}
+
+var fastRetries = disburse.RetryPolicy{
+	MaxAttempts:    3,
+	InitialBackoff: time.Millisecond,
+	MaxBackoff:     5 * time.Millisecond,
+	Multiplier:     2,
+}
+
+func TestClient_DisburseRetriesNoResponse(t *testing.T) {
+	s := &scripted{statuses: []string{disburse.ErrRetryConditionNoResponse, disburse.ErrRetryConditionNoResponse}}
+	server := httptest.NewServer(s)
+	defer server.Close()
+
+	res, err := newRetryClient(server.URL, fastRetries).Disburse(context.Background(), disburse.Request{
+		ReferenceID: "REF001",
+		MSISDN:      "255713123456",
+		Amount:      money.Shillings(1000),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if res.TxnStatus != disburse.ErrSuccessTxn {
+		t.Errorf("status: got %s want %s", res.TxnStatus, disburse.ErrSuccessTxn)
+	}
+
+	sent := s.sent()
+	if len(sent) != 3 {
+		t.Fatalf("attempts: got %d want 3", len(sent))
+	}
+	for _, ref := range sent {
+		if ref != "REF001" {
+			t.Errorf("retry used reference %s want REF001", ref)
+		}
+	}
+}
+
+func TestClient_DisburseOutcomeUnknown(t *testing.T) {
+	s := &scripted{statuses: []string{"error111", "error111", "error111", "error111"}}
+	server := httptest.NewServer(s)
+	defer server.Close()
+
+	_, err := newRetryClient(server.URL, fastRetries).Disburse(context.Background(), disburse.Request{
+		ReferenceID: "REF002",
+		MSISDN:      "255713123456",
+		Amount:      money.Shillings(1000),
+	})
+
+	var unknown *disburse.OutcomeUnknownError
+	if !errors.As(err, &unknown) || !errors.Is(err, disburse.ErrOutcomeUnknown) {
+		t.Fatalf("expected outcome unknown error got %v", err)
+	}
+
+	if unknown.Attempts != 3 || unknown.ReferenceID != "REF002" {
+		t.Errorf("unexpected error details: %+v", unknown)
+	}
+
+	if !errors.Is(err, tigoerr.ErrNoResponse) {
+		t.Errorf("expected %v to wrap tigoerr.ErrNoResponse", err)
+	}
+}
+
+func TestClient_DisburseFinalError(t *testing.T) {
+	s := &scripted{statuses: []string{disburse.ErrAmountInsufficient}}
+	server := httptest.NewServer(s)
+	defer server.Close()
+
+	res, err := newRetryClient(server.URL, fastRetries).Disburse(context.Background(), disburse.Request{
+		ReferenceID: "REF003",
+		MSISDN:      "255713123456",
+		Amount:      money.Shillings(1000),
+	})
+	if !errors.Is(err, tigoerr.ErrInsufficientFunds) {
+		t.Fatalf("expected insufficient funds got %v", err)
+	}
+
+	if res.TxnStatus != disburse.ErrAmountInsufficient {
+		t.Errorf("response not returned with error: %+v", res)
+	}
+
+	if got := len(s.sent()); got != 1 {
+		t.Errorf("attempts: got %d want 1", got)
+	}
+}
+
+func TestClient_DisburseTimeouts(t *testing.T) {
+	var (
+		mu       sync.Mutex
+		attempts int
+	)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		attempts++
+		mu.Unlock()
+		// the server only notices the client going away once the body is read
+		_, _ = io.Copy(io.Discard, r.Body)
+		<-r.Context().Done()
+	}))
+	defer server.Close()
+
+	request := disburse.Request{ReferenceID: "REF003", MSISDN: "255713123456", Amount: money.Shillings(1000)}
+
+	client := newRetryClient(server.URL, fastRetries, disburse.WithTimeouts(disburse.Timeouts{Attempt: 50 * time.Millisecond}))
+
+	_, err := client.Disburse(context.Background(), request)
+	if !errors.Is(err, disburse.ErrOutcomeUnknown) || !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("expected outcome unknown after timeouts got %v", err)
+	}
+	mu.Lock()
+	if attempts != 3 {
+		t.Errorf("attempts: got %d want 3", attempts)
+	}
+	attempts = 0
+	mu.Unlock()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(50*time.Millisecond, cancel)
+
+	start := time.Now()
+	_, err = newRetryClient(server.URL, fastRetries).Disburse(ctx, request)
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf("expected context canceled got %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Errorf("disburse returned %v after the context was canceled", elapsed)
+	}
+	mu.Lock()
+	if attempts != 1 {
+		t.Errorf("attempts after cancel: got %d want 1", attempts)
+	}
+	mu.Unlock()
+}
+
+func TestClient_DisburseUnreadableReplyOutcomeUnknown(t *testing.T) {
+	tests := []struct {
+		name        string
+		status      int
+		contentType string
+		body        string
+	}{
+		{"bad gateway", http.StatusBadGateway, "text/html", "<html><body>502 Bad Gateway</body></html>"},
+		{"bad gateway xml", http.StatusBadGateway, "application/xml", "<COMMAND></COMMAND>"},
+		{"garbage body", http.StatusOK, "application/xml", "<COMMAND><TXNSTATUS>error"},
+		{"missing status", http.StatusOK, "application/xml", "<COMMAND><TYPE>RMFCI</TYPE></COMMAND>"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var (
+				mu       sync.Mutex
+				attempts int
+			)
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				mu.Lock()
+				attempts++
+				mu.Unlock()
+				w.Header().Set("Content-Type", tt.contentType)
+				w.WriteHeader(tt.status)
+				_, _ = io.WriteString(w, tt.body)
+			}))
+			defer server.Close()
+
+			_, err := newRetryClient(server.URL, fastRetries).Disburse(context.Background(), disburse.Request{
+				ReferenceID: "REF004",
+				MSISDN:      "255713123456",
+				Amount:      money.Shillings(1000),
+			})
+			if !errors.Is(err, disburse.ErrOutcomeUnknown) {
+				t.Fatalf("expected outcome unknown got %v", err)
+			}
+
+			mu.Lock()
+			defer mu.Unlock()
+			if attempts != fastRetries.MaxAttempts {
+				t.Errorf("attempts: got %d want %d", attempts, fastRetries.MaxAttempts)
+			}
+		})
+	}
+}
@@ -0,0 +1,48 @@
+/*
+ * MIT License
+ *
+ * Copyright (c) 2021 TechCraft Technologies Co. Ltd
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ *
+ */
+package disburse
+
+import (
+	"time"
+
+	"github.com/techcraftlabs/tigopesa/internal/timeout"
+)
+
+// Timeouts bounds the disbursement requests, the caller's context still
+// applies on top of them. A zero field keeps the default from
+// DefaultTimeouts, a negative one leaves the request bounded only by its
+// context.
+type Timeouts struct {
+	// Attempt bounds every single attempt made by Disburse. An attempt
+	// that runs out of time leaves the outcome unknown and is retried as
+	// configured by WithRetryPolicy, use the context passed to Disburse to
+	// bound all the attempts together.
+	Attempt time.Duration
+}
+
+// DefaultTimeouts are the Timeouts used unless WithTimeouts says otherwise.
+var DefaultTimeouts = Timeouts{
+	Attempt: timeout.Default,
+}
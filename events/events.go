@@ -0,0 +1,172 @@
+/*
+ * MIT License
+ *
+ * Copyright (c) 2021 TechCraft Technologies Co. Ltd
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ *
+ */
+// Package events describes the lifecycle of the collections and payouts
+// made through the clients. Sinks are plugged in with the WithEventSink
+// option of each client, see Channel and FanOut for in process delivery.
+package events
+
+import (
+	"time"
+
+	"github.com/techcraftlabs/tigopesa/money"
+)
+
+const (
+	TypePushInitiated       Type = "push.initiated"
+	TypePushCompleted       Type = "push.completed"
+	TypePushFailed          Type = "push.failed"
+	TypeDisbursementSent    Type = "disbursement.sent"
+	TypeDisbursementFailed  Type = "disbursement.failed"
+	TypeNameQueried         Type = "ussd.name_queried"
+	TypeUSSDPaymentReceived Type = "ussd.payment_received"
+)
+
+var (
+	_ Event = PushInitiated{}
+	_ Event = PushCompleted{}
+	_ Event = PushFailed{}
+	_ Event = DisbursementSent{}
+	_ Event = DisbursementFailed{}
+	_ Event = NameQueried{}
+	_ Event = USSDPaymentReceived{}
+)
+
+type (
+	// Type names an event
+	Type string
+
+	// Event is one of the event types of this package, use a type switch
+	// to get at its details.
+	Event interface {
+		Type() Type
+		OccurredAt() time.Time
+	}
+
+	// PushInitiated is published when tigo accepts a push pay request and
+	// prompts the customer. ReferenceID is the one sent to tigo, i.e. with
+	// the biller code prefix. A callback posted before the acknowledgement
+	// reaches the client is published first.
+	PushInitiated struct {
+		Time         time.Time
+		ReferenceID  string
+		MSISDN       string
+		Amount       money.Amount
+		ResponseCode string
+		Description  string
+	}
+
+	// PushCompleted is published when tigo posts a callback saying the
+	// customer paid.
+	PushCompleted struct {
+		Time             time.Time
+		ReferenceID      string
+		MFSTransactionID string
+		Amount           money.Amount
+		Description      string
+	}
+
+	// PushFailed is published when a push pay request is not sent, tigo
+	// rejects it or the callback says the payment failed. MSISDN is only
+	// known for the first two and MFSTransactionID only for the last. A
+	// request whose outcome is not known, e.g. after a timeout, publishes
+	// nothing until its callback arrives.
+	PushFailed struct {
+		Time             time.Time
+		ReferenceID      string
+		MSISDN           string
+		MFSTransactionID string
+		Amount           money.Amount
+		Code             string
+		Description      string
+		Err              error
+	}
+
+	// DisbursementSent is published when tigo confirms a disbursement
+	DisbursementSent struct {
+		Time        time.Time
+		ReferenceID string
+		MSISDN      string
+		Amount      money.Amount
+		TxnID       string
+		TxnStatus   string
+		Message     string
+	}
+
+	// DisbursementFailed is published when a disbursement is rejected or
+	// its outcome is unknown, Err tells which.
+	DisbursementFailed struct {
+		Time        time.Time
+		ReferenceID string
+		MSISDN      string
+		Amount      money.Amount
+		TxnID       string
+		TxnStatus   string
+		Message     string
+		Err         error
+	}
+
+	// NameQueried is published once the name query handler answered tigo
+	NameQueried struct {
+		Time                time.Time
+		MSISDN              string
+		CompanyName         string
+		CustomerReferenceID string
+		Result              string
+		ErrorCode           string
+		Name                string
+	}
+
+	// USSDPaymentReceived is published once the payment handler answered a
+	// wallet to account payment. Repeated deliveries of the same TxnID that
+	// are answered from the payment store are not published again.
+	USSDPaymentReceived struct {
+		Time                time.Time
+		TxnID               string
+		MSISDN              string
+		Amount              money.Amount
+		CompanyName         string
+		CustomerReferenceID string
+		SenderName          string
+		RefID               string
+		Result              string
+		ErrorCode           string
+	}
+)
+
+func (PushInitiated) Type() Type       { return TypePushInitiated }
+func (PushCompleted) Type() Type       { return TypePushCompleted }
+func (PushFailed) Type() Type          { return TypePushFailed }
+func (DisbursementSent) Type() Type    { return TypeDisbursementSent }
+func (DisbursementFailed) Type() Type  { return TypeDisbursementFailed }
+func (NameQueried) Type() Type         { return TypeNameQueried }
+func (USSDPaymentReceived) Type() Type { return TypeUSSDPaymentReceived }
+
+func (e PushInitiated) OccurredAt() time.Time       { return e.Time }
+func (e PushCompleted) OccurredAt() time.Time       { return e.Time }
+func (e PushFailed) OccurredAt() time.Time          { return e.Time }
+func (e DisbursementSent) OccurredAt() time.Time    { return e.Time }
+func (e DisbursementFailed) OccurredAt() time.Time  { return e.Time }
+func (e NameQueried) OccurredAt() time.Time         { return e.Time }
+func (e USSDPaymentReceived) OccurredAt() time.Time { return e.Time }
@@ -0,0 +1,147 @@
+/*
+ * MIT License
+ *
+ * Copyright (c) 2021 TechCraft Technologies Co. Ltd
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ *
+ */
+package events
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+)
+
+// Discard drops every event, it is the default Sink of the clients
+var Discard Sink = SinkFunc(func(context.Context, Event) {})
+
+type (
+	// Sink receives the events published by the clients. Publish is called
+	// on the goroutine that made the call or serves the tigo request, so it
+	// must be safe for concurrent use and return quickly. Use a Channel to
+	// hand events over to slow consumers.
+	Sink interface {
+		Publish(ctx context.Context, event Event)
+	}
+
+	// SinkFunc adapts a func to a Sink
+	SinkFunc func(ctx context.Context, event Event)
+
+	// Channel is a Sink that delivers events on a buffered channel. It never
+	// blocks: events published while the buffer is full are dropped and
+	// counted in Dropped.
+	Channel struct {
+		mu      sync.RWMutex
+		c       chan Event
+		closed  bool
+		dropped uint64
+	}
+
+	fanOut []Sink
+)
+
+func (f SinkFunc) Publish(ctx context.Context, event Event) {
+	f(ctx, event)
+}
+
+// Safe returns a Sink that passes events to sink and recovers from its
+// panics, so that a broken subscriber cannot break a client. A nil sink
+// yields Discard.
+func Safe(sink Sink) Sink {
+	if sink == nil {
+		return Discard
+	}
+
+	return SinkFunc(func(ctx context.Context, event Event) {
+		defer func() { _ = recover() }()
+		sink.Publish(ctx, event)
+	})
+}
+
+// FanOut returns a Sink that publishes every event to each of sinks in
+// order. A sink that panics does not keep the others from getting the
+// event, nil sinks are skipped.
+func FanOut(sinks ...Sink) Sink {
+	f := make(fanOut, 0, len(sinks))
+	for _, sink := range sinks {
+		if sink != nil {
+			f = append(f, Safe(sink))
+		}
+	}
+
+	return f
+}
+
+func (f fanOut) Publish(ctx context.Context, event Event) {
+	for _, sink := range f {
+		sink.Publish(ctx, event)
+	}
+}
+
+// NewChannel returns a Channel that buffers up to size events, values less
+// than 1 mean 1.
+func NewChannel(size int) *Channel {
+	if size < 1 {
+		size = 1
+	}
+
+	return &Channel{c: make(chan Event, size)}
+}
+
+// Events returns the channel to receive the events from, it is closed by
+// Close.
+func (c *Channel) Events() <-chan Event {
+	return c.c
+}
+
+// Dropped returns how many events were dropped because the buffer was full
+// or the Channel closed.
+func (c *Channel) Dropped() uint64 {
+	return atomic.LoadUint64(&c.dropped)
+}
+
+func (c *Channel) Publish(_ context.Context, event Event) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	if c.closed {
+		atomic.AddUint64(&c.dropped, 1)
+		return
+	}
+
+	select {
+	case c.c <- event:
+	default:
+		atomic.AddUint64(&c.dropped, 1)
+	}
+}
+
+// Close closes the channel returned by Events once the buffered events are
+// received, events published afterwards are dropped.
+func (c *Channel) Close() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if !c.closed {
+		c.closed = true
+		close(c.c)
+	}
+}
@@ -0,0 +1,77 @@
+/*
+ * MIT License
+ *
+ * Copyright (c) 2021 TechCraft Technologies Co. Ltd
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ *
+ */
+package events_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/techcraftlabs/tigopesa/events"
+	"github.com/techcraftlabs/tigopesa/money"
+)
+
+func TestChannel(t *testing.T) {
+	ch := events.NewChannel(2)
+	ctx := context.Background()
+
+	for i := 0; i < 3; i++ {
+		ch.Publish(ctx, events.PushInitiated{Time: time.Now(), Amount: money.Shillings(int64(i + 1))})
+	}
+	if n := ch.Dropped(); n != 1 {
+		t.Errorf("dropped: got %d want 1", n)
+	}
+
+	ch.Close()
+	ch.Publish(ctx, events.PushInitiated{})
+	if n := ch.Dropped(); n != 2 {
+		t.Errorf("dropped after close: got %d want 2", n)
+	}
+
+	var received []events.Event
+	for event := range ch.Events() {
+		received = append(received, event)
+	}
+	if len(received) != 2 || received[0].(events.PushInitiated).Amount != money.Shillings(1) {
+		t.Errorf("received: %+v", received)
+	}
+}
+
+func TestFanOut(t *testing.T) {
+	var got []events.Type
+	record := events.SinkFunc(func(_ context.Context, event events.Event) {
+		got = append(got, event.Type())
+	})
+	panics := events.SinkFunc(func(context.Context, events.Event) {
+		panic("subscriber bug")
+	})
+
+	sink := events.FanOut(record, panics, nil, record)
+	sink.Publish(context.Background(), events.DisbursementSent{})
+
+	if len(got) != 2 || got[0] != events.TypeDisbursementSent {
+		t.Errorf("got %v", got)
+	}
+}
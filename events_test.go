@@ -0,0 +1,203 @@
+/*
+ * MIT License
+ *
+ * Copyright (c) 2021 TechCraft Technologies Co. Ltd
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ *
+ */
+package tigopesa_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/techcraftlabs/tigopesa"
+	"github.com/techcraftlabs/tigopesa/disburse"
+	"github.com/techcraftlabs/tigopesa/events"
+	"github.com/techcraftlabs/tigopesa/ledger"
+	"github.com/techcraftlabs/tigopesa/money"
+	"github.com/techcraftlabs/tigopesa/push"
+	"github.com/techcraftlabs/tigopesa/tigotest"
+	"github.com/techcraftlabs/tigopesa/ussd"
+)
+
+func TestClient_Events(t *testing.T) {
+	server := tigotest.NewServer()
+	defer server.Close()
+
+	payments := ussd.PaymentHandleFunc(func(ctx context.Context, request ussd.PayRequest) (ussd.PayResponse, error) {
+		return ussd.PayResponse{TxnID: request.TxnID, RefID: "REF1", Result: "TS", ErrorCode: ussd.ErrSuccessTxn}, nil
+	})
+	names := ussd.NameQueryFunc(func(ctx context.Context, request ussd.NameRequest) (ussd.NameResponse, error) {
+		return ussd.NameResponse{Result: "TS", ErrorCode: ussd.NoNamecheckErr, Content: "John Doe"}, nil
+	})
+
+	received := events.NewChannel(16)
+	// neither a subscriber that panics nor one that never reads may affect
+	// the handlers
+	broken := events.SinkFunc(func(context.Context, events.Event) { panic("subscriber bug") })
+	stuck := events.NewChannel(1)
+	records := ledger.NewMemoryStore()
+
+	config := &tigopesa.Config{
+		Push:     server.PushConfig(),
+		Disburse: server.DisburseConfig(),
+		Ussd:     &ussd.Config{},
+	}
+	client := tigopesa.NewClient(config, nil, payments, names,
+		tigopesa.WithDebugMode(false),
+		tigopesa.WithEventSink(events.FanOut(broken, stuck, received)),
+		tigopesa.WithLedger(records))
+
+	callbacks := httptest.NewServer(http.HandlerFunc(client.CallbackServeHTTP))
+	defer callbacks.Close()
+	server.SetCallbackURL(callbacks.URL)
+	namecheck := httptest.NewServer(http.HandlerFunc(client.NameQueryServeHTTP))
+	defer namecheck.Close()
+	billpay := httptest.NewServer(http.HandlerFunc(client.PaymentServeHTTP))
+	defer billpay.Close()
+
+	delay := 50 * time.Millisecond
+	server.ScriptPush(tigotest.Outcome{CallbackDelay: delay}, tigotest.Outcome{CallbackFailed: true, CallbackDelay: delay})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	result, err := client.PayAndWait(ctx, push.Request{MSISDN: "0713123456", Amount: money.Shillings(1500), ReferenceID: "ORDER1"})
+	if err != nil || !result.Succeeded() {
+		t.Fatalf("first payment: %+v %v", result, err)
+	}
+	result, err = client.PayAndWait(ctx, push.Request{MSISDN: "255713123456", Amount: money.Shillings(1500), ReferenceID: "ORDER2"})
+	if err != nil || result.Succeeded() {
+		t.Fatalf("second payment should fail: %+v %v", result, err)
+	}
+
+	if _, err := client.Disburse(ctx, disburse.Request{ReferenceID: "PAY1", MSISDN: "255713123456", Amount: money.Shillings(1000)}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := server.NameQuery(ctx, namecheck.URL, ussd.NameRequest{Msisdn: "255713123456", CustomerReferenceID: "ACC1"}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := server.BillPay(ctx, billpay.URL, ussd.PayRequest{TxnID: "TXN1", Msisdn: "255713123456", Amount: money.Shillings(2500)}); err != nil {
+		t.Fatal(err)
+	}
+
+	received.Close()
+	var got []events.Type
+	for event := range received.Events() {
+		got = append(got, event.Type())
+		switch e := event.(type) {
+		case events.PushInitiated:
+			if e.MSISDN != "255713123456" || e.ReferenceID != tigotest.BillerCode+"ORDER1" && e.ReferenceID != tigotest.BillerCode+"ORDER2" {
+				t.Errorf("unexpected %+v", e)
+			}
+		case events.USSDPaymentReceived:
+			if e.TxnID != "TXN1" || e.RefID != "REF1" || e.Amount != money.Shillings(2500) {
+				t.Errorf("unexpected %+v", e)
+			}
+		}
+	}
+
+	want := []events.Type{
+		events.TypePushInitiated, events.TypePushCompleted,
+		events.TypePushInitiated, events.TypePushFailed,
+		events.TypeDisbursementSent,
+		events.TypeNameQueried,
+		events.TypeUSSDPaymentReceived,
+	}
+	if len(got) != len(want) {
+		t.Fatalf("got events %v want %v", got, want)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("event %d: got %s want %s", i, got[i], want[i])
+		}
+	}
+	paid, err := records.Get(ctx, ledger.ID(ledger.FlowPush, tigotest.BillerCode+"ORDER1"))
+	if err != nil || paid.Status != ledger.StatusSucceeded || paid.MSISDN != "255713123456" || len(paid.Transitions) != 2 {
+		t.Errorf("ledger push: %+v %v", paid, err)
+	}
+	if all, _ := records.Find(ctx, ledger.Query{}); len(all) != 5 {
+		t.Errorf("ledger: got %d transactions want 5", len(all))
+	}
+
+	if stuck.Dropped() == 0 {
+		t.Error("expected the stuck subscriber to drop events")
+	}
+}
+
+func TestClient_EventsOutcomeUnknown(t *testing.T) {
+	server := tigotest.NewServer()
+	defer server.Close()
+
+	received := events.NewChannel(16)
+	records := ledger.NewMemoryStore()
+	client := tigopesa.NewClient(&tigopesa.Config{Push: server.PushConfig()}, nil, nil, nil,
+		tigopesa.WithDebugMode(false),
+		tigopesa.WithEventSink(received),
+		tigopesa.WithLedger(records),
+		tigopesa.WithStateMachine(push.NewStateMachine()))
+
+	// the request times out after reaching tigo, which may prompt the
+	// customer all the same
+	server.ScriptPush(tigotest.Outcome{Hang: true})
+	request := push.Request{MSISDN: "255713123456", Amount: money.Shillings(1500), ReferenceID: "ORDER1"}
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	_, err := client.Pay(ctx, request)
+	cancel()
+	if err == nil {
+		t.Fatal("pay did not time out")
+	}
+
+	// paying again is rejected without failing the payment in progress
+	if _, err := client.Pay(context.Background(), request); !errors.Is(err, push.ErrPaymentInProgress) {
+		t.Fatalf("paying again: %v", err)
+	}
+
+	ref := tigotest.BillerCode + "ORDER1"
+	body, _ := json.Marshal(push.CallbackRequest{Status: true, ReferenceID: ref, MFSTransactionID: "MFS1", Amount: money.Shillings(1500)})
+	req := httptest.NewRequest(http.MethodPost, "/callback", bytes.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	rec := httptest.NewRecorder()
+	client.CallbackServeHTTP(rec, req)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("callback: %d %s", rec.Code, rec.Body)
+	}
+
+	received.Close()
+	var got []events.Type
+	for event := range received.Events() {
+		got = append(got, event.Type())
+	}
+	if len(got) != 1 || got[0] != events.TypePushCompleted {
+		t.Errorf("got events %v want only %s", got, events.TypePushCompleted)
+	}
+
+	paid, err := records.Get(context.Background(), ledger.ID(ledger.FlowPush, ref))
+	if err != nil || paid.Status != ledger.StatusSucceeded || paid.MFSTransactionID != "MFS1" {
+		t.Errorf("ledger push: %+v %v", paid, err)
+	}
+}
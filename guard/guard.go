@@ -0,0 +1,345 @@
+/*
+ * MIT License
+ *
+ * Copyright (c) 2021 TechCraft Technologies Co. Ltd
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ *
+ */
+
+// Package guard authenticates the inbound calls tigo makes to the ussd name
+// query and payment endpoints and to the push pay callback endpoint.
+//
+// A Guard can check the source address against a CIDR allowlist, HTTP basic
+// auth credentials, a shared secret header and the client certificate of a
+// mutual TLS connection. Every check is optional, a zero Config accepts all
+// calls. Rejected calls are reported to Config.Audit.
+package guard
+
+import (
+	"crypto/subtle"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+)
+
+// DefaultSecretHeader is the header checked for Config.Secret when
+// Config.SecretHeader is empty.
+const DefaultSecretHeader = "X-Tigo-Secret"
+
+var (
+	ErrAddressNotAllowed = errors.New("guard: source address not allowed")
+	ErrUnauthorized      = errors.New("guard: missing or invalid credentials")
+	ErrClientCertificate = errors.New("guard: missing or untrusted client certificate")
+)
+
+type (
+	// Config sets the checks a Guard performs, zero values disable a check.
+	Config struct {
+		// AllowedCIDRs lists the networks calls may come from, e.g.
+		// "41.222.176.0/21". Single addresses are accepted too.
+		AllowedCIDRs []string
+
+		// TrustedProxies lists the networks of the reverse proxies in front
+		// of the service. When a call arrives from a trusted proxy the client
+		// address is taken from X-Forwarded-For, skipping trusted proxies
+		// from the right. X-Forwarded-For is ignored otherwise.
+		TrustedProxies []string
+
+		// Username and Password are the HTTP basic auth credentials tigo
+		// has to send.
+		Username string
+		Password string
+
+		// Secret is the value tigo has to send in SecretHeader, which
+		// defaults to DefaultSecretHeader.
+		SecretHeader string
+		Secret       string
+
+		// RequireClientCert rejects calls that were not made over TLS with a
+		// client certificate. When ClientCAs is set the certificate has to
+		// be issued by one of them.
+		RequireClientCert bool
+		ClientCAs         *x509.CertPool
+
+		// Audit receives an Event for every rejected call, by default the
+		// events are written to os.Stderr.
+		Audit AuditFunc
+	}
+
+	// Event describes a rejected call. It never carries the credentials
+	// that were sent.
+	Event struct {
+		Time       time.Time
+		Endpoint   string
+		Method     string
+		Path       string
+		RemoteAddr string
+		ClientIP   string
+		Err        error
+	}
+
+	// AuditFunc receives the events of rejected calls
+	AuditFunc func(event Event)
+
+	// Guard checks inbound calls, it is safe for concurrent use. A nil
+	// *Guard accepts all calls.
+	Guard struct {
+		allowed      []*net.IPNet
+		proxies      []*net.IPNet
+		username     []byte
+		password     []byte
+		secretHeader string
+		secret       []byte
+		requireCert  bool
+		clientCAs    *x509.CertPool
+		audit        AuditFunc
+	}
+)
+
+// New returns a Guard that performs the checks set in config. It fails if
+// any of the CIDRs is invalid or if only one of Username and Password is set.
+func New(config Config) (*Guard, error) {
+	allowed, err := parseNetworks(config.AllowedCIDRs)
+	if err != nil {
+		return nil, err
+	}
+
+	proxies, err := parseNetworks(config.TrustedProxies)
+	if err != nil {
+		return nil, err
+	}
+
+	if (config.Username == "") != (config.Password == "") {
+		return nil, errors.New("guard: basic auth needs both a username and a password")
+	}
+
+	g := &Guard{
+		allowed:      allowed,
+		proxies:      proxies,
+		username:     []byte(config.Username),
+		password:     []byte(config.Password),
+		secretHeader: config.SecretHeader,
+		secret:       []byte(config.Secret),
+		requireCert:  config.RequireClientCert || config.ClientCAs != nil,
+		clientCAs:    config.ClientCAs,
+		audit:        config.Audit,
+	}
+
+	if g.secretHeader == "" {
+		g.secretHeader = DefaultSecretHeader
+	}
+
+	if g.audit == nil {
+		g.audit = WriterAudit(os.Stderr)
+	}
+
+	return g, nil
+}
+
+// WriterAudit returns an AuditFunc that writes a line per event to w
+func WriterAudit(w io.Writer) AuditFunc {
+	return func(e Event) {
+		_, _ = fmt.Fprintf(w, "%s guard: rejected %s call: method=%s path=%s remote=%s client=%s: %v\n",
+			e.Time.Format(time.RFC3339), e.Endpoint, e.Method, e.Path, e.RemoteAddr, e.ClientIP, e.Err)
+	}
+}
+
+// Check returns nil if r passes all the checks, otherwise an error that
+// wraps ErrAddressNotAllowed, ErrUnauthorized or ErrClientCertificate.
+func (g *Guard) Check(r *http.Request) error {
+	if g == nil {
+		return nil
+	}
+
+	if len(g.allowed) > 0 {
+		ip := g.ClientIP(r)
+		if ip == nil || !contains(g.allowed, ip) {
+			return fmt.Errorf("%w: %v", ErrAddressNotAllowed, ip)
+		}
+	}
+
+	if g.requireCert {
+		if err := g.checkCertificate(r); err != nil {
+			return err
+		}
+	}
+
+	if len(g.username) > 0 {
+		username, password, ok := r.BasicAuth()
+		if !ok || !equal(g.username, []byte(username)) || !equal(g.password, []byte(password)) {
+			return fmt.Errorf("%w: basic auth", ErrUnauthorized)
+		}
+	}
+
+	if len(g.secret) > 0 {
+		if !equal(g.secret, []byte(r.Header.Get(g.secretHeader))) {
+			return fmt.Errorf("%w: %s header", ErrUnauthorized, g.secretHeader)
+		}
+	}
+
+	return nil
+}
+
+// Allow checks r, when it is rejected Allow audits the call, replies with
+// 401 or 403 and returns false. Handlers should return when it does.
+// endpoint names the handler in the audit Event.
+func (g *Guard) Allow(w http.ResponseWriter, r *http.Request, endpoint string) bool {
+	err := g.Check(r)
+	if err == nil {
+		return true
+	}
+
+	g.audit(Event{
+		Time:       time.Now(),
+		Endpoint:   endpoint,
+		Method:     r.Method,
+		Path:       r.URL.Path,
+		RemoteAddr: r.RemoteAddr,
+		ClientIP:   fmt.Sprint(g.ClientIP(r)),
+		Err:        err,
+	})
+
+	status := http.StatusForbidden
+	if errors.Is(err, ErrUnauthorized) {
+		status = http.StatusUnauthorized
+		if len(g.username) > 0 {
+			w.Header().Set("WWW-Authenticate", `Basic realm="tigopesa"`)
+		}
+	}
+	http.Error(w, http.StatusText(status), status)
+
+	return false
+}
+
+// Protect returns a http.Handler that passes the calls Allow accepts to next
+func (g *Guard) Protect(endpoint string, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !g.Allow(w, r, endpoint) {
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// ClientIP returns the address the call came from. X-Forwarded-For is only
+// used when the call was made by one of the trusted proxies.
+func (g *Guard) ClientIP(r *http.Request) net.IP {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+
+	ip := net.ParseIP(host)
+	if ip == nil || g == nil || !contains(g.proxies, ip) {
+		return ip
+	}
+
+	var hops []string
+	for _, value := range r.Header.Values("X-Forwarded-For") {
+		hops = append(hops, strings.Split(value, ",")...)
+	}
+
+	for i := len(hops) - 1; i >= 0; i-- {
+		hop := net.ParseIP(strings.TrimSpace(hops[i]))
+		if hop == nil {
+			return nil
+		}
+		ip = hop
+		if !contains(g.proxies, hop) {
+			break
+		}
+	}
+
+	return ip
+}
+
+func (g *Guard) checkCertificate(r *http.Request) error {
+	if r.TLS == nil || len(r.TLS.PeerCertificates) == 0 {
+		return fmt.Errorf("%w: none presented", ErrClientCertificate)
+	}
+
+	if g.clientCAs == nil {
+		return nil
+	}
+
+	certs := r.TLS.PeerCertificates
+	intermediates := x509.NewCertPool()
+	for _, cert := range certs[1:] {
+		intermediates.AddCert(cert)
+	}
+
+	_, err := certs[0].Verify(x509.VerifyOptions{
+		Roots:         g.clientCAs,
+		Intermediates: intermediates,
+		KeyUsages:     []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	})
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrClientCertificate, err)
+	}
+
+	return nil
+}
+
+func parseNetworks(cidrs []string) ([]*net.IPNet, error) {
+	networks := make([]*net.IPNet, 0, len(cidrs))
+	for _, cidr := range cidrs {
+		cidr = strings.TrimSpace(cidr)
+		if !strings.Contains(cidr, "/") {
+			ip := net.ParseIP(cidr)
+			if ip == nil {
+				return nil, fmt.Errorf("guard: invalid address %q", cidr)
+			}
+			bits := 8 * net.IPv6len
+			if ip.To4() != nil {
+				ip, bits = ip.To4(), 8*net.IPv4len
+			}
+			networks = append(networks, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+
+		_, network, err := net.ParseCIDR(cidr)
+		if err != nil {
+			return nil, fmt.Errorf("guard: invalid CIDR %q: %w", cidr, err)
+		}
+		networks = append(networks, network)
+	}
+
+	return networks, nil
+}
+
+func contains(networks []*net.IPNet, ip net.IP) bool {
+	for _, network := range networks {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// equal compares the secrets in constant time
+func equal(want, got []byte) bool {
+	return subtle.ConstantTimeCompare(want, got) == 1
+}
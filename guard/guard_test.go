@@ -0,0 +1,250 @@
+/*
+ * MIT License
+ *
+ * Copyright (c) 2021 TechCraft Technologies Co. Ltd
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ *
+ */
+
+package guard_test
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"errors"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/techcraftlabs/tigopesa/guard"
+)
+
+func selfSigned(t *testing.T) *x509.Certificate {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "tigo"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cert
+}
+
+func TestGuard_Check(t *testing.T) {
+	trusted, untrusted := selfSigned(t), selfSigned(t)
+	pool := x509.NewCertPool()
+	pool.AddCert(trusted)
+
+	type call struct {
+		remote  string
+		headers map[string]string
+		user    string
+		pass    string
+		cert    *x509.Certificate
+	}
+
+	tests := []struct {
+		name   string
+		config guard.Config
+		call   call
+		want   error
+	}{
+		{
+			name: "zero config accepts all",
+			call: call{remote: "192.0.2.1:1234"},
+		},
+		{
+			name:   "allowed address",
+			config: guard.Config{AllowedCIDRs: []string{"41.222.176.0/21"}},
+			call:   call{remote: "41.222.177.10:1234"},
+		},
+		{
+			name:   "address not allowed",
+			config: guard.Config{AllowedCIDRs: []string{"41.222.176.0/21", "192.0.2.7"}},
+			call:   call{remote: "192.0.2.1:1234"},
+			want:   guard.ErrAddressNotAllowed,
+		},
+		{
+			name:   "forwarded for ignored from untrusted peer",
+			config: guard.Config{AllowedCIDRs: []string{"41.222.176.0/21"}},
+			call: call{
+				remote:  "192.0.2.1:1234",
+				headers: map[string]string{"X-Forwarded-For": "41.222.177.10"},
+			},
+			want: guard.ErrAddressNotAllowed,
+		},
+		{
+			name: "forwarded for from trusted proxies",
+			config: guard.Config{
+				AllowedCIDRs:   []string{"41.222.176.0/21"},
+				TrustedProxies: []string{"10.0.0.0/8"},
+			},
+			call: call{
+				remote:  "10.0.0.2:1234",
+				headers: map[string]string{"X-Forwarded-For": "192.0.2.1, 41.222.177.10, 10.0.0.3"},
+			},
+		},
+		{
+			name: "spoofed forwarded for",
+			config: guard.Config{
+				AllowedCIDRs:   []string{"41.222.176.0/21"},
+				TrustedProxies: []string{"10.0.0.0/8"},
+			},
+			call: call{
+				remote:  "10.0.0.2:1234",
+				headers: map[string]string{"X-Forwarded-For": "41.222.177.10, 192.0.2.1"},
+			},
+			want: guard.ErrAddressNotAllowed,
+		},
+		{
+			name:   "basic auth",
+			config: guard.Config{Username: "tigo", Password: "secret"},
+			call:   call{remote: "192.0.2.1:1234", user: "tigo", pass: "secret"},
+		},
+		{
+			name:   "wrong basic auth",
+			config: guard.Config{Username: "tigo", Password: "secret"},
+			call:   call{remote: "192.0.2.1:1234", user: "tigo", pass: "guess"},
+			want:   guard.ErrUnauthorized,
+		},
+		{
+			name:   "shared secret",
+			config: guard.Config{Secret: "s3cr3t"},
+			call: call{
+				remote:  "192.0.2.1:1234",
+				headers: map[string]string{guard.DefaultSecretHeader: "s3cr3t"},
+			},
+		},
+		{
+			name:   "missing shared secret",
+			config: guard.Config{SecretHeader: "X-Callback-Key", Secret: "s3cr3t"},
+			call: call{
+				remote:  "192.0.2.1:1234",
+				headers: map[string]string{guard.DefaultSecretHeader: "s3cr3t"},
+			},
+			want: guard.ErrUnauthorized,
+		},
+		{
+			name:   "missing client certificate",
+			config: guard.Config{RequireClientCert: true},
+			call:   call{remote: "192.0.2.1:1234"},
+			want:   guard.ErrClientCertificate,
+		},
+		{
+			name:   "trusted client certificate",
+			config: guard.Config{ClientCAs: pool},
+			call:   call{remote: "192.0.2.1:1234", cert: trusted},
+		},
+		{
+			name:   "untrusted client certificate",
+			config: guard.Config{ClientCAs: pool},
+			call:   call{remote: "192.0.2.1:1234", cert: untrusted},
+			want:   guard.ErrClientCertificate,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			g, err := guard.New(tt.config)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			r := httptest.NewRequest(http.MethodPost, "/callback", nil)
+			r.RemoteAddr = tt.call.remote
+			for k, v := range tt.call.headers {
+				r.Header.Set(k, v)
+			}
+			if tt.call.user != "" {
+				r.SetBasicAuth(tt.call.user, tt.call.pass)
+			}
+			if tt.call.cert != nil {
+				r.TLS = &tls.ConnectionState{PeerCertificates: []*x509.Certificate{tt.call.cert}}
+			}
+
+			if err := g.Check(r); !errors.Is(err, tt.want) || (tt.want == nil && err != nil) {
+				t.Errorf("got %v want %v", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestGuard_Allow(t *testing.T) {
+	var events []guard.Event
+	g, err := guard.New(guard.Config{
+		Username: "tigo",
+		Password: "secret",
+		Audit:    func(e guard.Event) { events = append(events, e) },
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var served int
+	handler := g.Protect("callback", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		served++
+	}))
+
+	r := httptest.NewRequest(http.MethodPost, "/callback", nil)
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, r)
+
+	if rec.Code != http.StatusUnauthorized || served != 0 {
+		t.Errorf("rejected call: status %d, served %d", rec.Code, served)
+	}
+
+	if len(events) != 1 || events[0].Endpoint != "callback" || !errors.Is(events[0].Err, guard.ErrUnauthorized) {
+		t.Errorf("audit events: %+v", events)
+	}
+
+	r.SetBasicAuth("tigo", "secret")
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, r)
+
+	if rec.Code != http.StatusOK || served != 1 || len(events) != 1 {
+		t.Errorf("accepted call: status %d, served %d, events %d", rec.Code, served, len(events))
+	}
+
+	if _, err := guard.New(guard.Config{AllowedCIDRs: []string{"41.222.176.0/33"}}); err == nil {
+		t.Error("invalid CIDR accepted")
+	}
+}
@@ -0,0 +1,70 @@
+/*
+ * MIT License
+ *
+ * Copyright (c) 2021 TechCraft Technologies Co. Ltd
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ *
+ */
+// Package timeout bounds the contexts the clients derive for a single
+// operation.
+package timeout
+
+import (
+	"context"
+	"reflect"
+	"time"
+)
+
+// Default is how long a single operation may take unless the Timeouts of
+// its client say otherwise.
+const Default = time.Minute
+
+// With returns a copy of ctx that is done after d or when ctx is done,
+// whichever comes first. A d of zero or less leaves ctx unbounded.
+func With(ctx context.Context, d time.Duration) (context.Context, context.CancelFunc) {
+	if d <= 0 {
+		return context.WithCancel(ctx)
+	}
+
+	return context.WithTimeout(ctx, d)
+}
+
+// Or returns d, or def when d is zero. Negative values are kept so that
+// callers can turn a default timeout off.
+func Or(d, def time.Duration) time.Duration {
+	if d == 0 {
+		return def
+	}
+
+	return d
+}
+
+// Defaults sets every time.Duration field of the struct t points to that
+// is zero to the same field of def, a struct of the same type. It fills in
+// the Timeouts of the clients, see Or.
+func Defaults(t, def interface{}) {
+	v, d := reflect.ValueOf(t).Elem(), reflect.ValueOf(def)
+	duration := reflect.TypeOf(time.Duration(0))
+	for i := 0; i < v.NumField(); i++ {
+		if field := v.Field(i); field.Type() == duration {
+			field.SetInt(int64(Or(time.Duration(field.Int()), time.Duration(d.Field(i).Int()))))
+		}
+	}
+}
@@ -0,0 +1,114 @@
+/*
+ * MIT License
+ *
+ * Copyright (c) 2021 TechCraft Technologies Co. Ltd
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ *
+ */
+
+// Package ttl keeps values in memory for a limited time, it backs the in
+// memory stores of the clients.
+package ttl
+
+import (
+	"sync"
+	"time"
+)
+
+type (
+	// Map is a map whose entries expire, it is safe for concurrent use.
+	// Expired entries are dropped as new ones are added. The zero Map is
+	// empty and ready to use.
+	Map struct {
+		mu      sync.Mutex
+		entries map[string]entry
+	}
+
+	entry struct {
+		value     interface{}
+		expiresAt time.Time
+	}
+)
+
+// Get returns the value stored for key, ok is false when there is none or
+// it has expired.
+func (m *Map) Get(key string) (value interface{}, ok bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return m.get(key, time.Now())
+}
+
+// Claim stores value for ttl unless key holds a value that has not expired,
+// in which case that value is returned with claimed false.
+func (m *Map) Claim(key string, value interface{}, ttl time.Duration) (stored interface{}, claimed bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	now := time.Now()
+	if stored, ok := m.get(key, now); ok {
+		return stored, false
+	}
+	m.put(key, value, now, ttl)
+
+	return value, true
+}
+
+// Put stores value for ttl, replacing the value stored for key if any
+func (m *Map) Put(key string, value interface{}, ttl time.Duration) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.put(key, value, time.Now(), ttl)
+}
+
+// Delete removes key, it is not an error when there is no such key.
+func (m *Map) Delete(key string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	delete(m.entries, key)
+}
+
+func (m *Map) get(key string, now time.Time) (interface{}, bool) {
+	stored, ok := m.entries[key]
+	if !ok || now.After(stored.expiresAt) {
+		return nil, false
+	}
+
+	return stored.value, true
+}
+
+func (m *Map) put(key string, value interface{}, now time.Time, ttl time.Duration) {
+	if m.entries == nil {
+		m.entries = make(map[string]entry)
+	}
+
+	for k, stored := range m.entries {
+		if now.After(stored.expiresAt) {
+			delete(m.entries, k)
+		}
+	}
+
+	m.entries[key] = entry{
+		value:     value,
+		expiresAt: now.Add(ttl),
+	}
+}
@@ -0,0 +1,220 @@
+/*
+ * MIT License
+ *
+ * Copyright (c) 2021 TechCraft Technologies Co. Ltd
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ *
+ */
+// Package ledger keeps a record of every push pay, disbursement, name query
+// and wallet to account payment made through the clients, with the status
+// transitions each went through and the ids tigo assigned to it. A Store is
+// fed by the events of the clients, see Sink and tigopesa.WithLedger, and
+// can be queried by reference, MSISDN and date range.
+package ledger
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"time"
+
+	"github.com/techcraftlabs/tigopesa/money"
+)
+
+const (
+	FlowPush         Flow = "push"
+	FlowDisbursement Flow = "disbursement"
+	FlowNameQuery    Flow = "name_query"
+	FlowPayment      Flow = "ussd_payment"
+)
+
+const (
+	StatusInitiated Status = "initiated"
+	StatusSucceeded Status = "succeeded"
+	StatusFailed    Status = "failed"
+
+	// StatusUnknown is recorded for disbursements whose outcome could not
+	// be established, see disburse.ErrOutcomeUnknown.
+	StatusUnknown Status = "unknown"
+)
+
+// ErrNotFound is returned by Store.Get when there is no transaction with
+// the given id
+var ErrNotFound = errors.New("ledger: transaction not found")
+
+type (
+	// Flow names the kind of a Transaction
+	Flow string
+
+	// Status is the state a Transaction is in
+	Status string
+
+	// Transaction is what the ledger knows about a single request. The ids
+	// are those assigned by tigo: MFSTransactionID for push pay, TxnID for
+	// disbursements and payments, and RefID, the reference returned to tigo
+	// for a payment.
+	Transaction struct {
+		ID               string       `json:"id"`
+		Flow             Flow         `json:"flow"`
+		ReferenceID      string       `json:"reference_id"`
+		MSISDN           string       `json:"msisdn,omitempty"`
+		Amount           money.Amount `json:"amount"`
+		Status           Status       `json:"status"`
+		MFSTransactionID string       `json:"mfs_transaction_id,omitempty"`
+		TxnID            string       `json:"txn_id,omitempty"`
+		RefID            string       `json:"ref_id,omitempty"`
+		Code             string       `json:"code,omitempty"`
+		Message          string       `json:"message,omitempty"`
+		CreatedAt        time.Time    `json:"created_at"`
+		UpdatedAt        time.Time    `json:"updated_at"`
+		Transitions      []Transition `json:"transitions"`
+	}
+
+	// Transition is one step in the life of a Transaction. Data holds the
+	// details of the request and response that caused it as json.
+	Transition struct {
+		Status Status          `json:"status"`
+		Event  string          `json:"event"`
+		Code   string          `json:"code,omitempty"`
+		Error  string          `json:"error,omitempty"`
+		Data   json.RawMessage `json:"data,omitempty"`
+		Time   time.Time       `json:"time"`
+	}
+
+	// Entry is an update to the Transaction with ID, which is created if it
+	// does not exist yet. Empty fields leave the recorded values as they
+	// are.
+	Entry struct {
+		ID               string
+		Flow             Flow
+		ReferenceID      string
+		MSISDN           string
+		Amount           money.Amount
+		Status           Status
+		MFSTransactionID string
+		TxnID            string
+		RefID            string
+		Code             string
+		Message          string
+		Event            string
+		Error            string
+		Data             json.RawMessage
+		Time             time.Time
+	}
+
+	// Query selects transactions, zero fields match everything. From is
+	// inclusive and To exclusive, both compare with CreatedAt. Limit values
+	// less than 1 mean no limit.
+	Query struct {
+		Flow        Flow
+		ReferenceID string
+		MSISDN      string
+		From        time.Time
+		To          time.Time
+		Limit       int
+	}
+
+	// Store records transactions. Implementations must be safe for
+	// concurrent use.
+	Store interface {
+		// Record applies entry to the transaction with entry.ID
+		Record(ctx context.Context, entry Entry) error
+
+		// Get returns the transaction with id and its transitions
+		Get(ctx context.Context, id string) (Transaction, error)
+
+		// Find returns the transactions that match query with their
+		// transitions, oldest first.
+		Find(ctx context.Context, query Query) ([]Transaction, error)
+	}
+)
+
+// ID returns the id of the transaction of flow with reference, it is how
+// push pay, disbursement and payment transactions are identified.
+func ID(flow Flow, reference string) string {
+	return string(flow) + ":" + reference
+}
+
+// Final reports whether s is a status a transaction does not leave
+func (s Status) Final() bool {
+	return s == StatusSucceeded || s == StatusFailed
+}
+
+// apply updates t with entry and appends the transition it describes. The
+// first final status sticks: a push pay callback recorded before its
+// acknowledgement, or a late callback that disagrees with the first one, is
+// kept as a transition and only fills the fields t has no value for.
+func (t *Transaction) apply(entry Entry) {
+	if t.ID == "" {
+		t.ID = entry.ID
+		t.Flow = entry.Flow
+		t.ReferenceID = entry.ReferenceID
+		t.CreatedAt = entry.Time
+	}
+
+	final := t.Status.Final()
+	set := func(field *string, value string) {
+		if value != "" && (!final || *field == "") {
+			*field = value
+		}
+	}
+	set(&t.MSISDN, entry.MSISDN)
+	set(&t.MFSTransactionID, entry.MFSTransactionID)
+	set(&t.TxnID, entry.TxnID)
+	set(&t.RefID, entry.RefID)
+	set(&t.Code, entry.Code)
+	set(&t.Message, entry.Message)
+
+	if !entry.Amount.IsZero() && (!final || t.Amount.IsZero()) {
+		t.Amount = entry.Amount
+	}
+	if !final && entry.Status != "" {
+		t.Status = entry.Status
+	}
+	if entry.Time.After(t.UpdatedAt) {
+		t.UpdatedAt = entry.Time
+	}
+
+	t.Transitions = append(t.Transitions, Transition{
+		Status: entry.Status,
+		Event:  entry.Event,
+		Code:   entry.Code,
+		Error:  entry.Error,
+		Data:   entry.Data,
+		Time:   entry.Time,
+	})
+}
+
+func (q Query) match(t Transaction) bool {
+	switch {
+	case q.Flow != "" && t.Flow != q.Flow:
+		return false
+	case q.ReferenceID != "" && t.ReferenceID != q.ReferenceID:
+		return false
+	case q.MSISDN != "" && t.MSISDN != q.MSISDN:
+		return false
+	case !q.From.IsZero() && t.CreatedAt.Before(q.From):
+		return false
+	case !q.To.IsZero() && !t.CreatedAt.Before(q.To):
+		return false
+	default:
+		return true
+	}
+}
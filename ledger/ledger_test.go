@@ -0,0 +1,148 @@
+/*
+ * MIT License
+ *
+ * Copyright (c) 2021 TechCraft Technologies Co. Ltd
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ *
+ */
+package ledger_test
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"path/filepath"
+	"testing"
+	"time"
+
+	_ "github.com/mattn/go-sqlite3"
+
+	"github.com/techcraftlabs/tigopesa/disburse"
+	"github.com/techcraftlabs/tigopesa/events"
+	"github.com/techcraftlabs/tigopesa/ledger"
+	"github.com/techcraftlabs/tigopesa/money"
+)
+
+func TestMemoryStore(t *testing.T) {
+	testStore(t, ledger.NewMemoryStore())
+}
+
+func TestSQLStore(t *testing.T) {
+	db, err := sql.Open("sqlite3", filepath.Join(t.TempDir(), "ledger.db"))
+	if err != nil {
+		t.Skipf("sqlite3 not available: %v", err)
+	}
+	defer db.Close()
+	if err := db.Ping(); err != nil {
+		t.Skipf("sqlite3 not available: %v", err)
+	}
+
+	store := ledger.NewSQLStore(db)
+	ctx := context.Background()
+	if err := store.Migrate(ctx); err != nil {
+		t.Fatal(err)
+	}
+	// migrating twice is harmless
+	if err := store.Migrate(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	testStore(t, store)
+}
+
+func testStore(t *testing.T, store ledger.Store) {
+	ctx := context.Background()
+	var failures []error
+	sink := ledger.Sink(store, func(err error) { failures = append(failures, err) })
+
+	day := time.Date(2021, 6, 3, 10, 0, 0, 0, time.UTC)
+	at := func(minutes int) time.Time { return day.Add(time.Duration(minutes) * time.Minute) }
+
+	for _, event := range []events.Event{
+		events.PushInitiated{Time: at(0), ReferenceID: "BILLERORDER1", MSISDN: "255713123456",
+			Amount: money.Shillings(1500), ResponseCode: "BILLER-18-0000-S"},
+		events.PushCompleted{Time: at(1), ReferenceID: "BILLERORDER1", MFSTransactionID: "MFS1",
+			Amount: money.Shillings(1500), Description: "paid"},
+		// a late acknowledgement does not undo the callback
+		events.PushInitiated{Time: at(1), ReferenceID: "BILLERORDER1", MSISDN: "255713123456"},
+		// nor does a late callback that disagrees with it
+		events.PushFailed{Time: at(2), ReferenceID: "BILLERORDER1", MFSTransactionID: "MFS2",
+			Code: "BILLER-18-3020-E", Description: "failed"},
+		events.DisbursementFailed{Time: at(60), ReferenceID: "PAY1", MSISDN: "255713123456",
+			Amount: money.MustParse("2000.50"), Err: &disburse.OutcomeUnknownError{ReferenceID: "PAY1", Err: errors.New("timeout")}},
+		events.NameQueried{Time: at(24 * 60), MSISDN: "255654000000", CustomerReferenceID: "ACC1",
+			Result: "TS", ErrorCode: "error000", Name: "John Doe"},
+		events.USSDPaymentReceived{Time: at(24*60 + 1), TxnID: "TXN1", MSISDN: "255654000000",
+			Amount: money.Shillings(700), CustomerReferenceID: "ACC1", RefID: "REF1", Result: "TS", ErrorCode: "error000"},
+	} {
+		sink.Publish(ctx, event)
+	}
+	if len(failures) != 0 {
+		t.Fatalf("record: %v", failures)
+	}
+
+	push, err := store.Get(ctx, ledger.ID(ledger.FlowPush, "BILLERORDER1"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if push.Status != ledger.StatusSucceeded || push.MFSTransactionID != "MFS1" || push.MSISDN != "255713123456" ||
+		push.Message != "paid" || push.Amount != money.Shillings(1500) || !push.CreatedAt.Equal(at(0)) ||
+		!push.UpdatedAt.Equal(at(2)) {
+		t.Errorf("push: %+v", push)
+	}
+	if len(push.Transitions) != 4 || push.Transitions[1].Status != ledger.StatusSucceeded ||
+		push.Transitions[1].Event != string(events.TypePushCompleted) || len(push.Transitions[1].Data) == 0 ||
+		push.Transitions[3].Status != ledger.StatusFailed || push.Transitions[3].Code != "BILLER-18-3020-E" {
+		t.Errorf("push transitions: %+v", push.Transitions)
+	}
+
+	payout, err := store.Get(ctx, ledger.ID(ledger.FlowDisbursement, "PAY1"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if payout.Status != ledger.StatusUnknown || payout.Amount != money.MustParse("2000.50") || payout.Transitions[0].Error == "" {
+		t.Errorf("disbursement: %+v", payout)
+	}
+
+	if _, err := store.Get(ctx, "push:missing"); !errors.Is(err, ledger.ErrNotFound) {
+		t.Errorf("get missing: %v", err)
+	}
+
+	found, err := store.Find(ctx, ledger.Query{MSISDN: "255713123456"})
+	if err != nil || len(found) != 2 || found[0].Flow != ledger.FlowPush || found[1].Flow != ledger.FlowDisbursement ||
+		len(found[0].Transitions) != 4 || len(found[1].Transitions) != 1 {
+		t.Errorf("by msisdn: %+v %v", found, err)
+	}
+
+	found, err = store.Find(ctx, ledger.Query{ReferenceID: "ACC1", Flow: ledger.FlowNameQuery})
+	if err != nil || len(found) != 1 || found[0].Message != "John Doe" || found[0].Status != ledger.StatusSucceeded {
+		t.Errorf("by reference: %+v %v", found, err)
+	}
+
+	found, err = store.Find(ctx, ledger.Query{From: at(24 * 60), To: at(48 * 60)})
+	if err != nil || len(found) != 2 || found[1].RefID != "REF1" || found[1].TxnID != "TXN1" {
+		t.Errorf("by date: %+v %v", found, err)
+	}
+
+	found, err = store.Find(ctx, ledger.Query{Limit: 1})
+	if err != nil || len(found) != 1 || found[0].ID != push.ID {
+		t.Errorf("limit: %+v %v", found, err)
+	}
+}
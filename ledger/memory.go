@@ -0,0 +1,102 @@
+/*
+ * MIT License
+ *
+ * Copyright (c) 2021 TechCraft Technologies Co. Ltd
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ *
+ */
+package ledger
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+)
+
+var _ Store = (*memoryStore)(nil)
+
+type memoryStore struct {
+	mu           sync.RWMutex
+	transactions map[string]*Transaction
+}
+
+// NewMemoryStore returns a Store that lives as long as the process
+func NewMemoryStore() Store {
+	return &memoryStore{
+		transactions: make(map[string]*Transaction),
+	}
+}
+
+func (m *memoryStore) Record(_ context.Context, entry Entry) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	t, ok := m.transactions[entry.ID]
+	if !ok {
+		t = new(Transaction)
+		m.transactions[entry.ID] = t
+	}
+	t.apply(entry)
+
+	return nil
+}
+
+func (m *memoryStore) Get(_ context.Context, id string) (Transaction, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	t, ok := m.transactions[id]
+	if !ok {
+		return Transaction{}, fmt.Errorf("%w: %s", ErrNotFound, id)
+	}
+
+	return t.copy(), nil
+}
+
+func (m *memoryStore) Find(_ context.Context, query Query) ([]Transaction, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	var list []Transaction
+	for _, t := range m.transactions {
+		if query.match(*t) {
+			list = append(list, t.copy())
+		}
+	}
+
+	sort.Slice(list, func(i, j int) bool {
+		if !list[i].CreatedAt.Equal(list[j].CreatedAt) {
+			return list[i].CreatedAt.Before(list[j].CreatedAt)
+		}
+		return list[i].ID < list[j].ID
+	})
+	if query.Limit > 0 && len(list) > query.Limit {
+		list = list[:query.Limit]
+	}
+
+	return list, nil
+}
+
+func (t *Transaction) copy() Transaction {
+	c := *t
+	c.Transitions = append([]Transition(nil), t.Transitions...)
+	return c
+}
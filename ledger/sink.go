@@ -0,0 +1,147 @@
+/*
+ * MIT License
+ *
+ * Copyright (c) 2021 TechCraft Technologies Co. Ltd
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ *
+ */
+package ledger
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+
+	"github.com/techcraftlabs/tigopesa/disburse"
+	"github.com/techcraftlabs/tigopesa/events"
+)
+
+// Sink returns an events.Sink that records every event in store. Events
+// are recorded before Publish returns, errors are passed to onError which
+// may be nil.
+//
+// Publish runs on the goroutine that handles the request or callback, so
+// every write holds up the reply to tigo. A store that can be slow, e.g. a
+// remote SQL database, should get the events through an events.Channel
+// drained by a goroutine of its own; mind that a full Channel drops events
+// and size it for the bursts expected:
+//
+//	ch := events.NewChannel(1024)
+//	sink := ledger.Sink(store, onError)
+//	go func() {
+//		for event := range ch.Events() {
+//			sink.Publish(context.Background(), event)
+//		}
+//	}()
+func Sink(store Store, onError func(err error)) events.Sink {
+	return events.SinkFunc(func(ctx context.Context, event events.Event) {
+		entry, ok := NewEntry(event)
+		if !ok {
+			return
+		}
+
+		if err := store.Record(ctx, entry); err != nil && onError != nil {
+			onError(err)
+		}
+	})
+}
+
+// NewEntry returns the Entry recording event, ok is false for events the
+// ledger does not know.
+func NewEntry(event events.Event) (entry Entry, ok bool) {
+	entry = Entry{
+		Event: string(event.Type()),
+		Time:  event.OccurredAt(),
+	}
+	entry.Data, _ = json.Marshal(event)
+
+	switch e := event.(type) {
+	case events.PushInitiated:
+		entry.Flow, entry.ReferenceID, entry.Status = FlowPush, e.ReferenceID, StatusInitiated
+		entry.MSISDN, entry.Amount = e.MSISDN, e.Amount
+		entry.Code, entry.Message = e.ResponseCode, e.Description
+
+	case events.PushCompleted:
+		entry.Flow, entry.ReferenceID, entry.Status = FlowPush, e.ReferenceID, StatusSucceeded
+		entry.MFSTransactionID, entry.Amount, entry.Message = e.MFSTransactionID, e.Amount, e.Description
+
+	case events.PushFailed:
+		entry.Flow, entry.ReferenceID, entry.Status = FlowPush, e.ReferenceID, StatusFailed
+		entry.MSISDN, entry.Amount, entry.MFSTransactionID = e.MSISDN, e.Amount, e.MFSTransactionID
+		entry.Code, entry.Message, entry.Error = e.Code, e.Description, errorString(e.Err)
+
+	case events.DisbursementSent:
+		entry.Flow, entry.ReferenceID, entry.Status = FlowDisbursement, e.ReferenceID, StatusSucceeded
+		entry.MSISDN, entry.Amount, entry.TxnID = e.MSISDN, e.Amount, e.TxnID
+		entry.Code, entry.Message = e.TxnStatus, e.Message
+
+	case events.DisbursementFailed:
+		entry.Flow, entry.ReferenceID, entry.Status = FlowDisbursement, e.ReferenceID, StatusFailed
+		if errors.Is(e.Err, disburse.ErrOutcomeUnknown) {
+			entry.Status = StatusUnknown
+		}
+		entry.MSISDN, entry.Amount, entry.TxnID = e.MSISDN, e.Amount, e.TxnID
+		entry.Code, entry.Message, entry.Error = e.TxnStatus, e.Message, errorString(e.Err)
+
+	case events.NameQueried:
+		// name queries carry no reference of their own, every one of them
+		// is a transaction
+		entry.Flow, entry.ReferenceID, entry.Status = FlowNameQuery, e.CustomerReferenceID, answered(e.Result)
+		entry.ID = ID(FlowNameQuery, randomKey())
+		entry.MSISDN, entry.Code, entry.Message = e.MSISDN, e.ErrorCode, e.Name
+
+	case events.USSDPaymentReceived:
+		entry.Flow, entry.ReferenceID, entry.Status = FlowPayment, e.TxnID, answered(e.Result)
+		entry.MSISDN, entry.Amount = e.MSISDN, e.Amount
+		entry.TxnID, entry.RefID, entry.Code = e.TxnID, e.RefID, e.ErrorCode
+
+	default:
+		return Entry{}, false
+	}
+
+	if entry.ID == "" {
+		entry.ID = ID(entry.Flow, entry.ReferenceID)
+	}
+
+	return entry, true
+}
+
+// answered maps the RESULT returned to tigo, TS or TF, to a status
+func answered(result string) Status {
+	if result == "TS" {
+		return StatusSucceeded
+	}
+	return StatusFailed
+}
+
+func errorString(err error) string {
+	if err == nil {
+		return ""
+	}
+	return err.Error()
+}
+
+func randomKey() string {
+	buf := make([]byte, 8)
+	_, _ = rand.Read(buf)
+	return hex.EncodeToString(buf)
+}
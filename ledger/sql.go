@@ -0,0 +1,387 @@
+/*
+ * MIT License
+ *
+ * Copyright (c) 2021 TechCraft Technologies Co. Ltd
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ *
+ */
+package ledger
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/techcraftlabs/tigopesa/money"
+)
+
+var _ Store = (*SQLStore)(nil)
+
+// maxHistoryIDs caps the ids of a transitions query, older SQLite builds
+// allow 999 parameters
+const maxHistoryIDs = 500
+
+const transactionColumns = "id, flow, reference_id, msisdn, amount, currency, status, " +
+	"mfs_transaction_id, txn_id, ref_id, code, message, created_at, updated_at"
+
+type (
+	// SQLStore is a Store kept in a database through database/sql, call
+	// Migrate once to create its tables. It sticks to plain SQL understood
+	// by SQLite and, with WithDollarPlaceholders, PostgreSQL. Times are
+	// stored as unix nanoseconds.
+	SQLStore struct {
+		db           *sql.DB
+		dollar       bool
+		transactions string
+		transitions  string
+
+		// serializes Record within the process, concurrent writers in
+		// other processes may make Record fail with a conflict
+		mu sync.Mutex
+	}
+
+	// SQLOption is a setter func to set SQLStore details
+	SQLOption func(s *SQLStore)
+
+	queryer interface {
+		QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error)
+		QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row
+	}
+)
+
+// NewSQLStore returns a Store that keeps transactions in db, in the tables
+// tigopesa_transactions and tigopesa_transitions unless WithTablePrefix
+// says otherwise.
+func NewSQLStore(db *sql.DB, opts ...SQLOption) *SQLStore {
+	s := &SQLStore{db: db}
+	WithTablePrefix("tigopesa_")(s)
+
+	for _, opt := range opts {
+		opt(s)
+	}
+
+	return s
+}
+
+// WithDollarPlaceholders makes the store use $1, $2 placeholders as
+// required by PostgreSQL instead of ?.
+func WithDollarPlaceholders() SQLOption {
+	return func(s *SQLStore) {
+		s.dollar = true
+	}
+}
+
+// WithTablePrefix sets the prefix of the table names, it is not quoted.
+func WithTablePrefix(prefix string) SQLOption {
+	return func(s *SQLStore) {
+		s.transactions = prefix + "transactions"
+		s.transitions = prefix + "transitions"
+	}
+}
+
+// Migrate creates the tables and indexes of the store if they do not exist
+func (s *SQLStore) Migrate(ctx context.Context) error {
+	statements := []string{
+		`CREATE TABLE IF NOT EXISTS ` + s.transactions + ` (
+			id VARCHAR(255) NOT NULL PRIMARY KEY,
+			flow VARCHAR(32) NOT NULL,
+			reference_id VARCHAR(255) NOT NULL,
+			msisdn VARCHAR(32) NOT NULL,
+			amount BIGINT NOT NULL,
+			currency VARCHAR(8) NOT NULL,
+			status VARCHAR(32) NOT NULL,
+			mfs_transaction_id VARCHAR(255) NOT NULL,
+			txn_id VARCHAR(255) NOT NULL,
+			ref_id VARCHAR(255) NOT NULL,
+			code VARCHAR(64) NOT NULL,
+			message TEXT NOT NULL,
+			created_at BIGINT NOT NULL,
+			updated_at BIGINT NOT NULL
+		)`,
+		`CREATE INDEX IF NOT EXISTS ` + s.transactions + `_reference ON ` + s.transactions + ` (reference_id)`,
+		`CREATE INDEX IF NOT EXISTS ` + s.transactions + `_msisdn ON ` + s.transactions + ` (msisdn)`,
+		`CREATE INDEX IF NOT EXISTS ` + s.transactions + `_created ON ` + s.transactions + ` (created_at)`,
+		`CREATE TABLE IF NOT EXISTS ` + s.transitions + ` (
+			transaction_id VARCHAR(255) NOT NULL,
+			seq INTEGER NOT NULL,
+			status VARCHAR(32) NOT NULL,
+			event VARCHAR(64) NOT NULL,
+			code VARCHAR(64) NOT NULL,
+			error TEXT NOT NULL,
+			data TEXT NOT NULL,
+			occurred_at BIGINT NOT NULL,
+			PRIMARY KEY (transaction_id, seq)
+		)`,
+	}
+
+	for _, statement := range statements {
+		if _, err := s.db.ExecContext(ctx, statement); err != nil {
+			return fmt.Errorf("ledger: migrate: %w", err)
+		}
+	}
+
+	return nil
+}
+
+func (s *SQLStore) Record(ctx context.Context, entry Entry) (err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err != nil {
+			_ = tx.Rollback()
+		}
+	}()
+
+	t, err := s.transaction(ctx, tx, entry.ID)
+	exists := err == nil
+	if err != nil && !errors.Is(err, ErrNotFound) {
+		return err
+	}
+
+	var seq int
+	if exists {
+		row := tx.QueryRowContext(ctx, s.bind(`SELECT COUNT(*) FROM `+s.transitions+` WHERE transaction_id = ?`), entry.ID)
+		if err := row.Scan(&seq); err != nil {
+			return err
+		}
+	}
+
+	t.apply(entry)
+	args := []interface{}{
+		t.Flow, t.ReferenceID, t.MSISDN, t.Amount.Minor(), t.Amount.Currency(), t.Status,
+		t.MFSTransactionID, t.TxnID, t.RefID, t.Code, t.Message, t.CreatedAt.UnixNano(), t.UpdatedAt.UnixNano(),
+	}
+	if exists {
+		_, err = tx.ExecContext(ctx, s.bind(`UPDATE `+s.transactions+` SET flow = ?, reference_id = ?, msisdn = ?, `+
+			`amount = ?, currency = ?, status = ?, mfs_transaction_id = ?, txn_id = ?, ref_id = ?, code = ?, `+
+			`message = ?, created_at = ?, updated_at = ? WHERE id = ?`), append(args, t.ID)...)
+	} else {
+		_, err = tx.ExecContext(ctx, s.bind(`INSERT INTO `+s.transactions+` (`+transactionColumns+`) `+
+			`VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`), append([]interface{}{t.ID}, args...)...)
+	}
+	if err != nil {
+		return err
+	}
+
+	transition := t.Transitions[len(t.Transitions)-1]
+	_, err = tx.ExecContext(ctx, s.bind(`INSERT INTO `+s.transitions+
+		` (transaction_id, seq, status, event, code, error, data, occurred_at) VALUES (?, ?, ?, ?, ?, ?, ?, ?)`),
+		t.ID, seq, transition.Status, transition.Event, transition.Code, transition.Error,
+		string(transition.Data), transition.Time.UnixNano())
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+func (s *SQLStore) Get(ctx context.Context, id string) (Transaction, error) {
+	t, err := s.transaction(ctx, s.db, id)
+	if err != nil {
+		return Transaction{}, err
+	}
+
+	history, err := s.history(ctx, []string{id})
+	t.Transitions = history[id]
+
+	return t, err
+}
+
+func (s *SQLStore) Find(ctx context.Context, query Query) ([]Transaction, error) {
+	var (
+		where []string
+		args  []interface{}
+	)
+	add := func(clause string, arg interface{}) {
+		where = append(where, clause)
+		args = append(args, arg)
+	}
+
+	if query.Flow != "" {
+		add("flow = ?", query.Flow)
+	}
+	if query.ReferenceID != "" {
+		add("reference_id = ?", query.ReferenceID)
+	}
+	if query.MSISDN != "" {
+		add("msisdn = ?", query.MSISDN)
+	}
+	if !query.From.IsZero() {
+		add("created_at >= ?", query.From.UnixNano())
+	}
+	if !query.To.IsZero() {
+		add("created_at < ?", query.To.UnixNano())
+	}
+
+	statement := `SELECT ` + transactionColumns + ` FROM ` + s.transactions
+	if len(where) > 0 {
+		statement += ` WHERE ` + strings.Join(where, " AND ")
+	}
+	statement += ` ORDER BY created_at, id`
+	if query.Limit > 0 {
+		statement += ` LIMIT ` + strconv.Itoa(query.Limit)
+	}
+
+	rows, err := s.db.QueryContext(ctx, s.bind(statement), args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var list []Transaction
+	for rows.Next() {
+		t, err := scanTransaction(rows)
+		if err != nil {
+			return nil, err
+		}
+		list = append(list, t)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	ids := make([]string, len(list))
+	for i := range list {
+		ids[i] = list[i].ID
+	}
+	history, err := s.history(ctx, ids)
+	if err != nil {
+		return nil, err
+	}
+	for i := range list {
+		list[i].Transitions = history[list[i].ID]
+	}
+
+	return list, nil
+}
+
+func (s *SQLStore) transaction(ctx context.Context, q queryer, id string) (Transaction, error) {
+	row := q.QueryRowContext(ctx, s.bind(`SELECT `+transactionColumns+` FROM `+s.transactions+` WHERE id = ?`), id)
+	t, err := scanTransaction(row)
+	if errors.Is(err, sql.ErrNoRows) {
+		return Transaction{}, fmt.Errorf("%w: %s", ErrNotFound, id)
+	}
+
+	return t, err
+}
+
+// history returns the transitions of the transactions with ids, keyed by
+// id, querying maxHistoryIDs of them at a time.
+func (s *SQLStore) history(ctx context.Context, ids []string) (map[string][]Transition, error) {
+	history := make(map[string][]Transition, len(ids))
+	for start := 0; start < len(ids); start += maxHistoryIDs {
+		end := start + maxHistoryIDs
+		if end > len(ids) {
+			end = len(ids)
+		}
+		if err := s.historyBatch(ctx, ids[start:end], history); err != nil {
+			return nil, err
+		}
+	}
+
+	return history, nil
+}
+
+func (s *SQLStore) historyBatch(ctx context.Context, ids []string, history map[string][]Transition) error {
+	args := make([]interface{}, len(ids))
+	for i, id := range ids {
+		args[i] = id
+	}
+	placeholders := strings.TrimSuffix(strings.Repeat("?, ", len(ids)), ", ")
+
+	rows, err := s.db.QueryContext(ctx, s.bind(`SELECT transaction_id, status, event, code, error, data, occurred_at FROM `+
+		s.transitions+` WHERE transaction_id IN (`+placeholders+`) ORDER BY transaction_id, seq`), args...)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var (
+			id         string
+			transition Transition
+			data       string
+			occurred   int64
+		)
+		err := rows.Scan(&id, &transition.Status, &transition.Event, &transition.Code, &transition.Error, &data, &occurred)
+		if err != nil {
+			return err
+		}
+		if data != "" {
+			transition.Data = []byte(data)
+		}
+		transition.Time = time.Unix(0, occurred)
+		history[id] = append(history[id], transition)
+	}
+
+	return rows.Err()
+}
+
+// bind rewrites the ? placeholders of query for the database
+func (s *SQLStore) bind(query string) string {
+	if !s.dollar {
+		return query
+	}
+
+	var b strings.Builder
+	n := 0
+	for _, r := range query {
+		if r == '?' {
+			n++
+			b.WriteString("$" + strconv.Itoa(n))
+			continue
+		}
+		b.WriteRune(r)
+	}
+
+	return b.String()
+}
+
+func scanTransaction(row interface {
+	Scan(dest ...interface{}) error
+}) (Transaction, error) {
+	var (
+		t                Transaction
+		minor            int64
+		currency         string
+		created, updated int64
+	)
+	err := row.Scan(&t.ID, &t.Flow, &t.ReferenceID, &t.MSISDN, &minor, &currency, &t.Status,
+		&t.MFSTransactionID, &t.TxnID, &t.RefID, &t.Code, &t.Message, &created, &updated)
+	if err != nil {
+		return Transaction{}, err
+	}
+
+	t.Amount = money.New(minor, currency)
+	t.CreatedAt = time.Unix(0, created)
+	t.UpdatedAt = time.Unix(0, updated)
+
+	return t, nil
+}
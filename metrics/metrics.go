@@ -0,0 +1,93 @@
+/*
+ * MIT License
+ *
+ * Copyright (c) 2021 TechCraft Technologies Co. Ltd
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ *
+ */
+
+// Package metrics lets the clients report how long every tigo operation
+// took and which result code it ended with. Recorders are plugged in with
+// the WithMetrics option of each client, see the prometheus sub package for
+// a Prometheus implementation.
+package metrics
+
+import "time"
+
+const (
+	Token     Operation = "token"
+	Pay       Operation = "push_pay"
+	Status    Operation = "push_status"
+	Callback  Operation = "push_callback"
+	Disburse  Operation = "disburse"
+	NameQuery Operation = "name_query"
+	Payment   Operation = "ussd_payment"
+)
+
+const (
+	// CodeOK is recorded for operations that succeeded without a tigo
+	// result code, like Token.
+	CodeOK = "ok"
+
+	// CodeError is recorded for operations that failed before tigo
+	// returned a result code, e.g. on network errors and timeouts.
+	CodeError = "error"
+)
+
+// Noop discards everything, it is the default Recorder of the clients
+var Noop Recorder = noop{}
+
+type (
+	// Operation names a tigo operation
+	Operation string
+
+	// Recorder receives an observation for every operation. code is the
+	// tigo result code, e.g. BILLER-30-0000-S or error000, or one of CodeOK
+	// and CodeError. Implementations must be safe for concurrent use and
+	// should not block.
+	Recorder interface {
+		Observe(op Operation, code string, duration time.Duration)
+	}
+
+	// RecorderFunc adapts a func to a Recorder
+	RecorderFunc func(op Operation, code string, duration time.Duration)
+
+	noop struct{}
+)
+
+func (f RecorderFunc) Observe(op Operation, code string, duration time.Duration) {
+	f(op, code, duration)
+}
+
+func (noop) Observe(Operation, string, time.Duration) {}
+
+// Code returns the code to record for an operation that ended with the tigo
+// result code and err: code when there is one, CodeError when err is not
+// nil and CodeOK otherwise.
+func Code(code string, err error) string {
+	switch {
+	case code != "":
+		return code
+	case err != nil:
+		return CodeError
+	default:
+		return CodeOK
+	}
+}
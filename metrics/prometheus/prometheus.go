@@ -0,0 +1,116 @@
+/*
+ * MIT License
+ *
+ * Copyright (c) 2021 TechCraft Technologies Co. Ltd
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ *
+ */
+
+// Package prometheus implements metrics.Recorder with Prometheus metrics:
+//
+//	tigopesa_requests_total{operation, code}
+//	tigopesa_request_duration_seconds{operation, code}
+//
+// The Recorder is a prometheus.Collector and must be registered:
+//
+//	recorder := prometheus.NewRecorder(prometheus.Options{})
+//	registry.MustRegister(recorder)
+//	client := tigopesa.NewClient(config, ..., tigopesa.WithMetrics(recorder))
+package prometheus
+
+import (
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/techcraftlabs/tigopesa/metrics"
+)
+
+var (
+	_ metrics.Recorder     = (*Recorder)(nil)
+	_ prometheus.Collector = (*Recorder)(nil)
+
+	// DefaultBuckets suit calls that usually take a few hundred milliseconds
+	// and time out after a minute.
+	DefaultBuckets = []float64{.05, .1, .25, .5, 1, 2.5, 5, 10, 30, 60}
+)
+
+type (
+	// Options customizes the metrics, zero values use the defaults
+	Options struct {
+		// Namespace prefixes the metric names, "tigopesa" by default
+		Namespace string
+
+		// ConstLabels are added to every metric, e.g. the service name
+		ConstLabels prometheus.Labels
+
+		// Buckets of the latency histogram, DefaultBuckets by default
+		Buckets []float64
+	}
+
+	// Recorder counts the operations and observes their latency
+	Recorder struct {
+		requests *prometheus.CounterVec
+		duration *prometheus.HistogramVec
+	}
+)
+
+// NewRecorder returns a Recorder, it has to be registered before its
+// metrics are exported.
+func NewRecorder(opts Options) *Recorder {
+	if opts.Namespace == "" {
+		opts.Namespace = "tigopesa"
+	}
+	if len(opts.Buckets) == 0 {
+		opts.Buckets = DefaultBuckets
+	}
+
+	labels := []string{"operation", "code"}
+
+	return &Recorder{
+		requests: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace:   opts.Namespace,
+			Name:        "requests_total",
+			Help:        "Number of tigo operations by operation and result code.",
+			ConstLabels: opts.ConstLabels,
+		}, labels),
+		duration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace:   opts.Namespace,
+			Name:        "request_duration_seconds",
+			Help:        "Latency of tigo operations by operation and result code.",
+			ConstLabels: opts.ConstLabels,
+			Buckets:     opts.Buckets,
+		}, labels),
+	}
+}
+
+func (r *Recorder) Observe(op metrics.Operation, code string, duration time.Duration) {
+	r.requests.WithLabelValues(string(op), code).Inc()
+	r.duration.WithLabelValues(string(op), code).Observe(duration.Seconds())
+}
+
+func (r *Recorder) Describe(ch chan<- *prometheus.Desc) {
+	r.requests.Describe(ch)
+	r.duration.Describe(ch)
+}
+
+func (r *Recorder) Collect(ch chan<- prometheus.Metric) {
+	r.requests.Collect(ch)
+	r.duration.Collect(ch)
+}
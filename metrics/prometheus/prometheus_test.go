@@ -0,0 +1,121 @@
+/*
+ * MIT License
+ *
+ * Copyright (c) 2021 TechCraft Technologies Co. Ltd
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ *
+ */
+
+package prometheus_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	prom "github.com/prometheus/client_golang/prometheus"
+	"github.com/techcraftlabs/tigopesa/disburse"
+	"github.com/techcraftlabs/tigopesa/metrics"
+	"github.com/techcraftlabs/tigopesa/metrics/prometheus"
+	"github.com/techcraftlabs/tigopesa/money"
+	"github.com/techcraftlabs/tigopesa/push"
+	"github.com/techcraftlabs/tigopesa/tigotest"
+)
+
+// counts returns the value of tigopesa_requests_total and the number of
+// latency observations for every operation and code pair
+func counts(t *testing.T, registry *prom.Registry) (map[[2]string]float64, map[[2]string]uint64) {
+	t.Helper()
+
+	families, err := registry.Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	requests := make(map[[2]string]float64)
+	observations := make(map[[2]string]uint64)
+	for _, family := range families {
+		for _, metric := range family.GetMetric() {
+			var key [2]string
+			for _, label := range metric.GetLabel() {
+				switch label.GetName() {
+				case "operation":
+					key[0] = label.GetValue()
+				case "code":
+					key[1] = label.GetValue()
+				}
+			}
+
+			switch family.GetName() {
+			case "tigopesa_requests_total":
+				requests[key] = metric.GetCounter().GetValue()
+			case "tigopesa_request_duration_seconds":
+				observations[key] = metric.GetHistogram().GetSampleCount()
+			}
+		}
+	}
+
+	return requests, observations
+}
+
+func TestRecorder(t *testing.T) {
+	server := tigotest.NewServer()
+	defer server.Close()
+
+	recorder := prometheus.NewRecorder(prometheus.Options{})
+	registry := prom.NewRegistry()
+	registry.MustRegister(recorder)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	pc := push.NewClient(server.PushConfig(), nil, push.WithDebugMode(false), push.WithMetrics(recorder))
+	server.ScriptPush(tigotest.Outcome{NoCallback: true}, tigotest.Outcome{Code: push.FailureCode, Message: "rejected"})
+	for _, ref := range []string{"ORDER1", "ORDER2"} {
+		if _, err := pc.Pay(ctx, push.Request{MSISDN: "255713123456", Amount: money.Shillings(1000), ReferenceID: ref}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	dc := disburse.NewClient(server.DisburseConfig(), disburse.WithDebugMode(false), disburse.WithMetrics(recorder))
+	server.ScriptDisburse(tigotest.Outcome{Code: disburse.ErrAmountInsufficient})
+	_, _ = dc.Disburse(ctx, disburse.Request{ReferenceID: "PAY1", MSISDN: "255713123456", Amount: money.Shillings(1000)})
+
+	server.Close()
+	_, _ = dc.Disburse(ctx, disburse.Request{ReferenceID: "PAY2", MSISDN: "255713123456", Amount: money.Shillings(1000)})
+
+	requests, observations := counts(t, registry)
+	want := map[[2]string]float64{
+		{string(metrics.Token), metrics.CodeOK}:                    1,
+		{string(metrics.Pay), push.SuccessCode}:                    1,
+		{string(metrics.Pay), push.FailureCode}:                    1,
+		{string(metrics.Disburse), disburse.ErrAmountInsufficient}: 1,
+		{string(metrics.Disburse), metrics.CodeError}:              1,
+	}
+
+	if len(requests) != len(want) {
+		t.Errorf("got series %v want %v", requests, want)
+	}
+	for key, n := range want {
+		if requests[key] != n || observations[key] != uint64(n) {
+			t.Errorf("%v: got %v requests and %d observations want %v", key, requests[key], observations[key], n)
+		}
+	}
+}
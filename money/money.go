@@ -0,0 +1,307 @@
+/*
+ * MIT License
+ *
+ * Copyright (c) 2021 TechCraft Technologies Co. Ltd
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ *
+ */
+
+// Package money represents the amounts sent to and received from tigo as an
+// integer number of minor units, cents for TZS, so that they are exact.
+//
+// Rounding policy: amounts with more precision than the currency supports,
+// e.g. "1000.005" or the float64 1000.005, are rounded to the nearest minor
+// unit with halves rounded away from zero. This is the only place amounts
+// are rounded.
+//
+// On the wire amounts are plain decimal numbers, whole amounts are written
+// without a fractional part ("1000") and others with two digits ("1000.50").
+package money
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"math/big"
+	"strconv"
+	"strings"
+
+	"github.com/techcraftlabs/tigopesa/tigoerr"
+)
+
+// TZS is the Tanzanian shilling, the currency of all tigo pesa amounts
+const TZS = "TZS"
+
+const (
+	digits = 2
+	scale  = 100
+)
+
+var (
+	ErrInvalid          = errors.New("money: invalid amount")
+	ErrOverflow         = errors.New("money: amount out of range")
+	ErrCurrencyMismatch = errors.New("money: currencies do not match")
+)
+
+type (
+	// Amount is an exact amount of money. The zero value is 0 TZS. Amounts
+	// can be compared with == as long as their currencies are the same.
+	Amount struct {
+		minor    int64
+		currency string
+	}
+
+	// Limits are the smallest and the largest amounts accepted, zero values
+	// mean no limit.
+	Limits struct {
+		Min Amount
+		Max Amount
+	}
+)
+
+// New returns the amount of minor units in currency, an empty currency is TZS
+func New(minor int64, currency string) Amount {
+	if currency == TZS {
+		currency = ""
+	}
+	return Amount{minor: minor, currency: currency}
+}
+
+// Shillings returns a whole amount of TZS
+func Shillings(n int64) Amount {
+	return Amount{minor: n * scale}
+}
+
+// Parse parses a decimal TZS amount like "1000", "1000.5" or "1e3". Extra
+// precision is rounded as described in the package documentation.
+func Parse(s string) (Amount, error) {
+	s = strings.TrimSpace(s)
+	if s == "" || strings.Trim(s, "0123456789.+-eE") != "" {
+		return Amount{}, fmt.Errorf("%w: %q", ErrInvalid, s)
+	}
+
+	r, ok := new(big.Rat).SetString(s)
+	if !ok {
+		return Amount{}, fmt.Errorf("%w: %q", ErrInvalid, s)
+	}
+
+	minor, ok := round(r.Mul(r, big.NewRat(scale, 1)))
+	if !ok {
+		return Amount{}, fmt.Errorf("%w: %q", ErrOverflow, s)
+	}
+
+	return Amount{minor: minor}, nil
+}
+
+// MustParse is like Parse but panics on error, it is meant for constants
+func MustParse(s string) Amount {
+	a, err := Parse(s)
+	if err != nil {
+		panic(err)
+	}
+	return a
+}
+
+// FromFloat converts f to a TZS amount. f is converted using its shortest
+// decimal representation, so 1000.005 becomes 1000.01 and not 1000.00.
+func FromFloat(f float64) (Amount, error) {
+	if math.IsNaN(f) || math.IsInf(f, 0) {
+		return Amount{}, fmt.Errorf("%w: %v", ErrInvalid, f)
+	}
+
+	return Parse(strconv.FormatFloat(f, 'f', -1, 64))
+}
+
+// round rounds r to an integer, halves away from zero
+func round(r *big.Rat) (int64, bool) {
+	q, rem := new(big.Int).QuoRem(r.Num(), r.Denom(), new(big.Int))
+	if rem.Sign() != 0 {
+		if rem.Abs(rem).Lsh(rem, 1).Cmp(r.Denom()) >= 0 {
+			q.Add(q, big.NewInt(int64(r.Num().Sign())))
+		}
+	}
+
+	if !q.IsInt64() {
+		return 0, false
+	}
+	return q.Int64(), true
+}
+
+// Minor returns the amount in minor units
+func (a Amount) Minor() int64 {
+	return a.minor
+}
+
+// Currency returns the ISO 4217 code of the currency of a
+func (a Amount) Currency() string {
+	if a.currency == "" {
+		return TZS
+	}
+	return a.currency
+}
+
+// IsZero reports whether a is zero
+func (a Amount) IsZero() bool {
+	return a.minor == 0
+}
+
+// Sign returns -1, 0 or 1 for negative, zero and positive amounts
+func (a Amount) Sign() int {
+	switch {
+	case a.minor < 0:
+		return -1
+	case a.minor > 0:
+		return 1
+	default:
+		return 0
+	}
+}
+
+// Cmp returns -1, 0 or 1 when a is less than, equal to or greater than b.
+// It fails with ErrCurrencyMismatch when the currencies differ.
+func (a Amount) Cmp(b Amount) (int, error) {
+	if a.currency != b.currency {
+		return 0, fmt.Errorf("%w: %s and %s", ErrCurrencyMismatch, a.Currency(), b.Currency())
+	}
+
+	switch {
+	case a.minor < b.minor:
+		return -1, nil
+	case a.minor > b.minor:
+		return 1, nil
+	default:
+		return 0, nil
+	}
+}
+
+// Add returns a + b, it fails with ErrCurrencyMismatch when the currencies
+// differ and with ErrOverflow when the sum is out of range.
+func (a Amount) Add(b Amount) (Amount, error) {
+	if a.currency != b.currency {
+		return Amount{}, fmt.Errorf("%w: %s and %s", ErrCurrencyMismatch, a.Currency(), b.Currency())
+	}
+
+	sum := a.minor + b.minor
+	if (sum > a.minor) != (b.minor > 0) {
+		return Amount{}, ErrOverflow
+	}
+
+	return Amount{minor: sum, currency: a.currency}, nil
+}
+
+// Float64 returns a in major units, use it only for display or for APIs
+// that need a float64.
+func (a Amount) Float64() float64 {
+	f, _ := new(big.Rat).SetFrac64(a.minor, scale).Float64()
+	return f
+}
+
+// String formats a as a decimal number of major units without currency
+func (a Amount) String() string {
+	minor := a.minor
+	sign := ""
+	if minor < 0 {
+		sign = "-"
+	}
+
+	major, cents := minor/scale, minor%scale
+	if major < 0 {
+		major = -major
+	}
+	if cents < 0 {
+		cents = -cents
+	}
+
+	if cents == 0 {
+		return fmt.Sprintf("%s%d", sign, major)
+	}
+
+	return fmt.Sprintf("%s%d.%0*d", sign, major, digits, cents)
+}
+
+// MarshalText writes a as String does, it is used for xml elements
+func (a Amount) MarshalText() ([]byte, error) {
+	return []byte(a.String()), nil
+}
+
+// UnmarshalText parses text with Parse
+func (a *Amount) UnmarshalText(text []byte) error {
+	parsed, err := Parse(string(text))
+	if err != nil {
+		return err
+	}
+	*a = parsed
+	return nil
+}
+
+// MarshalJSON writes a as a json number
+func (a Amount) MarshalJSON() ([]byte, error) {
+	return []byte(a.String()), nil
+}
+
+// UnmarshalJSON accepts a json number or a string holding one, null leaves
+// a unchanged.
+func (a *Amount) UnmarshalJSON(data []byte) error {
+	if string(data) == "null" {
+		return nil
+	}
+
+	if len(data) > 0 && data[0] == '"' {
+		var s string
+		if err := json.Unmarshal(data, &s); err != nil {
+			return err
+		}
+		return a.UnmarshalText([]byte(s))
+	}
+
+	return a.UnmarshalText(data)
+}
+
+// Check returns nil when a is positive and within the limits. Otherwise the
+// error wraps tigoerr.ErrInvalidAmount, tigoerr.ErrAmountTooLow or
+// tigoerr.ErrAmountTooHigh, or ErrCurrencyMismatch.
+func (l Limits) Check(a Amount) error {
+	if a.Sign() <= 0 {
+		return fmt.Errorf("money: %s must be greater than zero: %w", a, tigoerr.ErrInvalidAmount)
+	}
+
+	if !l.Min.IsZero() {
+		cmp, err := a.Cmp(l.Min)
+		if err != nil {
+			return err
+		}
+		if cmp < 0 {
+			return fmt.Errorf("money: %s is below the minimum of %s: %w", a, l.Min, tigoerr.ErrAmountTooLow)
+		}
+	}
+
+	if !l.Max.IsZero() {
+		cmp, err := a.Cmp(l.Max)
+		if err != nil {
+			return err
+		}
+		if cmp > 0 {
+			return fmt.Errorf("money: %s is above the maximum of %s: %w", a, l.Max, tigoerr.ErrAmountTooHigh)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,145 @@
+/*
+ * MIT License
+ *
+ * Copyright (c) 2021 TechCraft Technologies Co. Ltd
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ *
+ */
+
+package money_test
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"testing"
+
+	"github.com/techcraftlabs/tigopesa/money"
+	"github.com/techcraftlabs/tigopesa/tigoerr"
+)
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		in    string
+		minor int64
+		out   string
+		err   error
+	}{
+		{in: "1000", minor: 100000, out: "1000"},
+		{in: " 1000.5 ", minor: 100050, out: "1000.50"},
+		{in: "0.1", minor: 10, out: "0.10"},
+		{in: "1e3", minor: 100000, out: "1000"},
+		{in: "1000.005", minor: 100001, out: "1000.01"},
+		{in: "1000.0049", minor: 100000, out: "1000"},
+		{in: "-2.345", minor: -235, out: "-2.35"},
+		{in: "-0.5", minor: -50, out: "-0.50"},
+		{in: "", err: money.ErrInvalid},
+		{in: "1,000", err: money.ErrInvalid},
+		{in: "1/3", err: money.ErrInvalid},
+		{in: "0x10", err: money.ErrInvalid},
+		{in: "NaN", err: money.ErrInvalid},
+		{in: "1e30", err: money.ErrOverflow},
+	}
+
+	for _, tt := range tests {
+		a, err := money.Parse(tt.in)
+		if !errors.Is(err, tt.err) || (tt.err == nil && err != nil) {
+			t.Errorf("Parse(%q): error %v want %v", tt.in, err, tt.err)
+			continue
+		}
+		if err != nil {
+			continue
+		}
+		if a.Minor() != tt.minor || a.String() != tt.out || a.Currency() != money.TZS {
+			t.Errorf("Parse(%q) = %d %q %s, want %d %q", tt.in, a.Minor(), a, a.Currency(), tt.minor, tt.out)
+		}
+	}
+}
+
+func TestFromFloat(t *testing.T) {
+	for f, want := range map[float64]string{
+		0.1 + 0.2: "0.30",
+		1000.005:  "1000.01",
+		2.675:     "2.68",
+		1e6:       "1000000",
+	} {
+		a, err := money.FromFloat(f)
+		if err != nil || a.String() != want {
+			t.Errorf("FromFloat(%v) = %s %v, want %s", f, a, err, want)
+		}
+	}
+}
+
+func TestAmount_Encoding(t *testing.T) {
+	type message struct {
+		XMLName xml.Name     `xml:"COMMAND" json:"-"`
+		Amount  money.Amount `xml:"AMOUNT" json:"Amount"`
+	}
+
+	in := message{Amount: money.Shillings(1500000)}
+
+	buf, err := json.Marshal(in)
+	if err != nil || string(buf) != `{"Amount":1500000}` {
+		t.Errorf("json: %s %v", buf, err)
+	}
+
+	buf, err = xml.Marshal(in)
+	if err != nil || string(buf) != `<COMMAND><AMOUNT>1500000</AMOUNT></COMMAND>` {
+		t.Errorf("xml: %s %v", buf, err)
+	}
+
+	var out message
+	if err := json.Unmarshal([]byte(`{"Amount":"250.75"}`), &out); err != nil || out.Amount != money.MustParse("250.75") {
+		t.Errorf("json string: %v %v", out.Amount, err)
+	}
+
+	if err := xml.Unmarshal([]byte(`<COMMAND><AMOUNT>250.00</AMOUNT></COMMAND>`), &out); err != nil || out.Amount != money.Shillings(250) {
+		t.Errorf("xml: %v %v", out.Amount, err)
+	}
+}
+
+func TestLimits_Check(t *testing.T) {
+	limits := money.Limits{Min: money.Shillings(100), Max: money.Shillings(3000000)}
+
+	tests := map[money.Amount]error{
+		money.Shillings(100):       nil,
+		money.Shillings(3000000):   nil,
+		money.MustParse("99.99"):   tigoerr.ErrAmountTooLow,
+		money.Shillings(3000001):   tigoerr.ErrAmountTooHigh,
+		{}:                         tigoerr.ErrInvalidAmount,
+		money.New(10000, "KES"):    money.ErrCurrencyMismatch,
+		money.New(-100, money.TZS): tigoerr.ErrInvalidAmount,
+	}
+
+	for amount, want := range tests {
+		err := limits.Check(amount)
+		if !errors.Is(err, want) || (want == nil && err != nil) {
+			t.Errorf("Check(%s %s) = %v, want %v", amount, amount.Currency(), err, want)
+		}
+	}
+
+	if err := (money.Limits{}).Check(money.Shillings(1e9)); err != nil {
+		t.Errorf("no limits: %v", err)
+	}
+
+	if _, err := money.Shillings(1).Add(money.New(1, "KES")); !errors.Is(err, money.ErrCurrencyMismatch) {
+		t.Errorf("Add: %v", err)
+	}
+}
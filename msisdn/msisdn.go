@@ -0,0 +1,177 @@
+/*
+ * MIT License
+ *
+ * Copyright (c) 2021 TechCraft Technologies Co. Ltd
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ *
+ */
+
+// Package msisdn normalizes Tanzanian phone numbers to the international
+// format without a leading plus that tigo expects, e.g. 255713123456, and
+// checks that they belong to the Tigo Pesa network.
+//
+// All the common ways of writing a number are accepted:
+//
+//	0713 123 456, 713123456, +255 713 123 456, 255-713-123-456, 00255713123456
+package msisdn
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// CountryCode is the calling code of Tanzania
+const CountryCode = "255"
+
+// subscriberDigits is the length of a number without country code or
+// trunk prefix 0
+const subscriberDigits = 9
+
+var (
+	// ErrInvalid matches every error returned by this package
+	ErrInvalid = errors.New("invalid msisdn")
+
+	ErrMalformed    = fmt.Errorf("%w: not a phone number", ErrInvalid)
+	ErrNotTanzanian = fmt.Errorf("%w: not a Tanzanian number", ErrInvalid)
+	ErrNotTigo      = fmt.Errorf("%w: not a Tigo Pesa number", ErrInvalid)
+
+	// TigoPrefixes are the network codes of Tigo Pesa numbers, the two
+	// digits after the country code.
+	TigoPrefixes = []string{"71", "65", "67", "77"}
+
+	// Default accepts Tigo Pesa numbers only, it is used by the clients
+	// unless configured otherwise.
+	Default Normalizer = New(TigoPrefixes...)
+
+	// Identity returns the numbers unchanged, use it to opt out of
+	// normalization.
+	Identity Normalizer = NormalizerFunc(func(msisdn string) (string, error) {
+		return msisdn, nil
+	})
+)
+
+var _ error = (*Error)(nil)
+
+type (
+	// Normalizer turns a number as written by a user into the format sent
+	// to tigo, or fails with an error matching ErrInvalid.
+	Normalizer interface {
+		Normalize(msisdn string) (string, error)
+	}
+
+	// NormalizerFunc adapts a func to a Normalizer
+	NormalizerFunc func(msisdn string) (string, error)
+
+	// Error describes why Number was rejected, Err is ErrMalformed,
+	// ErrNotTanzanian or ErrNotTigo which all wrap ErrInvalid.
+	Error struct {
+		Number string
+		Err    error
+	}
+
+	prefixNormalizer []string
+)
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("%q: %v", e.Number, e.Err)
+}
+
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+func (f NormalizerFunc) Normalize(msisdn string) (string, error) {
+	return f(msisdn)
+}
+
+// New returns a Normalizer that accepts Tanzanian numbers whose network
+// code is one of prefixes and fails with ErrNotTigo for others. With no
+// prefixes every Tanzanian number is accepted.
+func New(prefixes ...string) Normalizer {
+	return prefixNormalizer(append([]string(nil), prefixes...))
+}
+
+func (p prefixNormalizer) Normalize(msisdn string) (string, error) {
+	number, err := Parse(msisdn)
+	if err != nil {
+		return "", err
+	}
+
+	if len(p) == 0 {
+		return number, nil
+	}
+
+	network := number[len(CountryCode) : len(CountryCode)+2]
+	for _, prefix := range p {
+		if network == prefix {
+			return number, nil
+		}
+	}
+
+	return "", &Error{Number: msisdn, Err: ErrNotTigo}
+}
+
+// Normalize normalizes msisdn with Default
+func Normalize(msisdn string) (string, error) {
+	return Default.Normalize(msisdn)
+}
+
+// Parse returns msisdn in the format 255XXXXXXXXX without checking the
+// network. Spaces, dashes, dots and parentheses are ignored.
+func Parse(msisdn string) (string, error) {
+	var b strings.Builder
+	for i, c := range strings.TrimSpace(msisdn) {
+		switch {
+		case c >= '0' && c <= '9':
+			b.WriteRune(c)
+		case c == ' ' || c == '-' || c == '.' || c == '(' || c == ')':
+		case c == '+' && i == 0:
+		default:
+			return "", &Error{Number: msisdn, Err: ErrMalformed}
+		}
+	}
+
+	digits := b.String()
+	international := strings.HasPrefix(strings.TrimSpace(msisdn), "+")
+	if !international && strings.HasPrefix(digits, "00") {
+		digits, international = digits[2:], true
+	}
+
+	switch {
+	case strings.HasPrefix(digits, CountryCode) && len(digits) == len(CountryCode)+subscriberDigits:
+		digits = digits[len(CountryCode):]
+
+	case international || len(digits) > subscriberDigits+1:
+		if !strings.HasPrefix(digits, CountryCode) {
+			return "", &Error{Number: msisdn, Err: ErrNotTanzanian}
+		}
+		return "", &Error{Number: msisdn, Err: ErrMalformed}
+
+	case strings.HasPrefix(digits, "0") && len(digits) == subscriberDigits+1:
+		digits = digits[1:]
+	}
+
+	if len(digits) != subscriberDigits || digits[0] == '0' {
+		return "", &Error{Number: msisdn, Err: ErrMalformed}
+	}
+
+	return CountryCode + digits, nil
+}
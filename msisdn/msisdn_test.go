@@ -0,0 +1,86 @@
+/*
+ * MIT License
+ *
+ * Copyright (c) 2021 TechCraft Technologies Co. Ltd
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ *
+ */
+
+package msisdn_test
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/techcraftlabs/tigopesa/msisdn"
+)
+
+func TestNormalize(t *testing.T) {
+	tests := []struct {
+		in   string
+		want string
+		err  error
+	}{
+		{in: "255713123456", want: "255713123456"},
+		{in: "0713123456", want: "255713123456"},
+		{in: "713123456", want: "255713123456"},
+		{in: "+255 713 123 456", want: "255713123456"},
+		{in: "255-654-123-456", want: "255654123456"},
+		{in: "(0677) 123.456", want: "255677123456"},
+		{in: "00255773123456", want: "255773123456"},
+		{in: "0754123456", err: msisdn.ErrNotTigo},
+		{in: "+254712345678", err: msisdn.ErrNotTanzanian},
+		{in: "254712345678", err: msisdn.ErrNotTanzanian},
+		{in: "07131234", err: msisdn.ErrMalformed},
+		{in: "2557131234567", err: msisdn.ErrMalformed},
+		{in: "0713-12345a", err: msisdn.ErrMalformed},
+		{in: "255+713123456", err: msisdn.ErrMalformed},
+		{in: "", err: msisdn.ErrMalformed},
+	}
+
+	for _, tt := range tests {
+		got, err := msisdn.Normalize(tt.in)
+		if tt.err != nil {
+			if !errors.Is(err, tt.err) || !errors.Is(err, msisdn.ErrInvalid) {
+				t.Errorf("Normalize(%q) = %q %v, want %v", tt.in, got, err, tt.err)
+			}
+			continue
+		}
+		if err != nil || got != tt.want {
+			t.Errorf("Normalize(%q) = %q %v, want %q", tt.in, got, err, tt.want)
+		}
+	}
+}
+
+func TestNew(t *testing.T) {
+	all := msisdn.New()
+	if got, err := all.Normalize("0754123456"); err != nil || got != "255754123456" {
+		t.Errorf("any network: %q %v", got, err)
+	}
+
+	only71 := msisdn.New("71")
+	if _, err := only71.Normalize("0653123456"); !errors.Is(err, msisdn.ErrNotTigo) {
+		t.Errorf("custom prefixes: %v", err)
+	}
+
+	if got, _ := msisdn.Identity.Normalize("0713 123 456"); got != "0713 123 456" {
+		t.Errorf("identity: %q", got)
+	}
+}
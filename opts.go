@@ -26,6 +26,18 @@
 package tigopesa
 
 import (
+	"fmt"
+	"github.com/techcraftlabs/tigopesa/disburse"
+	"github.com/techcraftlabs/tigopesa/events"
+	"github.com/techcraftlabs/tigopesa/guard"
+	"github.com/techcraftlabs/tigopesa/ledger"
+	"github.com/techcraftlabs/tigopesa/metrics"
+	"github.com/techcraftlabs/tigopesa/money"
+	"github.com/techcraftlabs/tigopesa/msisdn"
+	"github.com/techcraftlabs/tigopesa/push"
+	"github.com/techcraftlabs/tigopesa/redact"
+	"github.com/techcraftlabs/tigopesa/ussd"
+	"go.opentelemetry.io/otel/trace"
 	"io"
 	"net/http"
 )
@@ -72,3 +84,163 @@ func WithHTTPClient(httpClient *http.Client) ClientOption {
 		client.base = httpClient
 	}
 }
+
+// WithTokenStore sets the push.TokenStore used to keep the push pay access
+// token. By default the token is kept in memory, see push.NewFileTokenStore
+// to share it between processes.
+func WithTokenStore(store push.TokenStore) ClientOption {
+	return func(client *Client) {
+		client.tokenStore = store
+	}
+}
+
+// WithDisburseRetryPolicy sets the disburse.RetryPolicy used when disbursing
+// funds. By default disbursements are not retried.
+func WithDisburseRetryPolicy(policy disburse.RetryPolicy) ClientOption {
+	return func(client *Client) {
+		client.retryPolicy = policy
+	}
+}
+
+// WithRedactor sets the redact.Redactor used by all the clients to mask
+// secrets in debug logs. Use redact.New(redact.WithMSISDNMasking()) to
+// mask the MSISDNs too.
+func WithRedactor(r *redact.Redactor) ClientOption {
+	return func(client *Client) {
+		client.redactor = r
+	}
+}
+
+// WithPushOptions passes opts to push.NewClient, e.g. push.WithIdempotency.
+// They are applied after the options derived from the Client settings.
+func WithPushOptions(opts ...push.ClientOption) ClientOption {
+	return func(client *Client) {
+		client.pushOpts = append(client.pushOpts, opts...)
+	}
+}
+
+// WithDisburseOptions passes opts to disburse.NewClient. They are applied
+// after the options derived from the Client settings.
+func WithDisburseOptions(opts ...disburse.ClientOption) ClientOption {
+	return func(client *Client) {
+		client.disburseOpts = append(client.disburseOpts, opts...)
+	}
+}
+
+// WithUssdOptions passes opts to ussd.NewClient. They are applied after the
+// options derived from the Client settings.
+func WithUssdOptions(opts ...ussd.ClientOption) ClientOption {
+	return func(client *Client) {
+		client.ussdOpts = append(client.ussdOpts, opts...)
+	}
+}
+
+// WithInboundGuard sets the guard.Guard that authenticates the calls tigo
+// makes to the push callback and the ussd name query and payment handlers.
+func WithInboundGuard(g *guard.Guard) ClientOption {
+	return func(client *Client) {
+		client.pushOpts = append(client.pushOpts, push.WithInboundGuard(g))
+		client.ussdOpts = append(client.ussdOpts, ussd.WithInboundGuard(g))
+	}
+}
+
+// WithAmountLimits sets the smallest and largest amounts accepted by push
+// pay, disbursements and wallet to account payments.
+func WithAmountLimits(limits money.Limits) ClientOption {
+	return func(client *Client) {
+		client.pushOpts = append(client.pushOpts, push.WithAmountLimits(limits))
+		client.disburseOpts = append(client.disburseOpts, disburse.WithAmountLimits(limits))
+		client.ussdOpts = append(client.ussdOpts, ussd.WithAmountLimits(limits))
+	}
+}
+
+// WithMSISDNNormalizer sets the msisdn.Normalizer used by all the clients,
+// by default numbers are normalized with msisdn.Default. Requests with numbers
+// it rejects fail, see ussd.WithMSISDNPassThrough for inbound ones.
+func WithMSISDNNormalizer(normalizer msisdn.Normalizer) ClientOption {
+	return func(client *Client) {
+		client.pushOpts = append(client.pushOpts, push.WithMSISDNNormalizer(normalizer))
+		client.disburseOpts = append(client.disburseOpts, disburse.WithMSISDNNormalizer(normalizer))
+		client.ussdOpts = append(client.ussdOpts, ussd.WithMSISDNNormalizer(normalizer))
+	}
+}
+
+// WithMetrics sets the metrics.Recorder used by all the clients, see the
+// metrics/prometheus package for a Prometheus implementation.
+func WithMetrics(recorder metrics.Recorder) ClientOption {
+	return func(client *Client) {
+		client.pushOpts = append(client.pushOpts, push.WithMetrics(recorder))
+		client.disburseOpts = append(client.disburseOpts, disburse.WithMetrics(recorder))
+		client.ussdOpts = append(client.ussdOpts, ussd.WithMetrics(recorder))
+	}
+}
+
+// WithTracerProvider sets the OpenTelemetry TracerProvider used by all the
+// clients, the global provider is used by default.
+func WithTracerProvider(provider trace.TracerProvider) ClientOption {
+	return func(client *Client) {
+		client.pushOpts = append(client.pushOpts, push.WithTracerProvider(provider))
+		client.disburseOpts = append(client.disburseOpts, disburse.WithTracerProvider(provider))
+		client.ussdOpts = append(client.ussdOpts, ussd.WithTracerProvider(provider))
+	}
+}
+
+// Timeouts groups the per operation timeouts of the clients, zero values
+// keep the defaults of each package.
+type Timeouts struct {
+	Push     push.Timeouts
+	Disburse disburse.Timeouts
+	Ussd     ussd.Timeouts
+}
+
+// WithTimeouts sets how long each outbound call and inbound handler may
+// take, see push.Timeouts, disburse.Timeouts and ussd.Timeouts. Caller
+// contexts and the context of incoming requests always apply as well.
+func WithTimeouts(timeouts Timeouts) ClientOption {
+	return func(client *Client) {
+		client.pushOpts = append(client.pushOpts, push.WithTimeouts(timeouts.Push))
+		client.disburseOpts = append(client.disburseOpts, disburse.WithTimeouts(timeouts.Disburse))
+		client.ussdOpts = append(client.ussdOpts, ussd.WithTimeouts(timeouts.Ussd))
+	}
+}
+
+// EventSink receives the typed lifecycle events of every push pay,
+// disbursement, name query and wallet to account payment made through a
+// Client, see the events package for the events and the in process
+// Channel and FanOut sinks.
+type EventSink = events.Sink
+
+// WithEventSink sets the EventSink used by all the clients. Subscribers
+// that panic do not affect the clients, use events.NewChannel to decouple
+// slow ones from the HTTP handlers. Several sinks can be set, each gets every event.
+func WithEventSink(sink EventSink) ClientOption {
+	return func(client *Client) {
+		if sink == nil {
+			return
+		}
+		client.sinks = append(client.sinks, sink)
+	}
+}
+
+// WithLedger records every transaction in store, see the ledger package.
+// Failures to record are written to the logger. Entries are written before
+// the reply to tigo is sent, use WithEventSink with a ledger.Sink behind an
+// events.Channel to write them in the background instead.
+func WithLedger(store ledger.Store) ClientOption {
+	return func(client *Client) {
+		if store == nil {
+			return
+		}
+		client.sinks = append(client.sinks, ledger.Sink(store, func(err error) {
+			_, _ = fmt.Fprintf(client.logger, "tigopesa: ledger: %v\n", err)
+		}))
+	}
+}
+
+// WithStateMachine tracks every push payment in m, see
+// push.WithStateMachine.
+func WithStateMachine(m *push.StateMachine) ClientOption {
+	return func(client *Client) {
+		client.pushOpts = append(client.pushOpts, push.WithStateMachine(m))
+	}
+}
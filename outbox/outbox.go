@@ -0,0 +1,471 @@
+/*
+ * MIT License
+ *
+ * Copyright (c) 2021 TechCraft Technologies Co. Ltd
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ *
+ */
+// Package outbox decouples acknowledging tigo from the work done for its
+// push pay callbacks and wallet to account payments. An Outbox is passed to
+// the clients in place of the push.CallbackHandler and ussd.PaymentHandler:
+// it durably records every request in a Store, acknowledges it and later
+// delivers it to the real handlers. Deliveries are retried until they
+// succeed or run out of attempts, in which case the message is kept as a
+// dead letter. Delivered messages are remembered for a while, see
+// WithRetention, so that tigo posting the same request again is not
+// delivered twice. Delivery is still at least once, a crash right after a
+// handler returned makes the Outbox deliver that message again, so handlers
+// must be idempotent.
+package outbox
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/techcraftlabs/tigopesa/internal/backoff"
+	"github.com/techcraftlabs/tigopesa/internal/timeout"
+	"github.com/techcraftlabs/tigopesa/push"
+	"github.com/techcraftlabs/tigopesa/ussd"
+)
+
+const (
+	KindCallback Kind = "push_callback"
+	KindPayment  Kind = "ussd_payment"
+)
+
+const (
+	defaultPollInterval = 5 * time.Second
+	defaultRetention    = 24 * time.Hour
+	defaultTimeout      = timeout.Default
+	dispatchBatch       = 100
+)
+
+var (
+	_ push.CallbackHandler = (*Outbox)(nil)
+	_ ussd.PaymentHandler  = (*Outbox)(nil)
+)
+
+var (
+	// ErrNotFound is returned by Requeue when there is no dead message
+	// with the given ID.
+	ErrNotFound = errors.New("outbox: message not found")
+
+	// ErrNoHandler is recorded for messages of a kind the Outbox has no
+	// handler for, they are retried like any other failure.
+	ErrNoHandler = errors.New("outbox: no handler")
+)
+
+// DefaultRetryPolicy makes up to 10 attempts waiting 1s, 2s, 4s and so on in
+// between, never more than 5 minutes.
+var DefaultRetryPolicy = RetryPolicy{
+	MaxAttempts:    10,
+	InitialBackoff: time.Second,
+	MaxBackoff:     5 * time.Minute,
+	Multiplier:     2,
+}
+
+type (
+	// Kind tells which handler a Message is delivered to
+	Kind string
+
+	// Message is a recorded request waiting to be delivered. Payload is the
+	// json encoded push.CallbackRequest or ussd.PayRequest.
+	Message struct {
+		ID          string          `json:"id"`
+		Kind        Kind            `json:"kind"`
+		Payload     json.RawMessage `json:"payload"`
+		CreatedAt   time.Time       `json:"created_at"`
+		Attempts    int             `json:"attempts"`
+		NextAttempt time.Time       `json:"next_attempt"`
+		LastError   string          `json:"last_error,omitempty"`
+		Dead        bool            `json:"dead,omitempty"`
+
+		// Delivered is set once a handler accepted the message, it is
+		// kept until DeliveredAt is older than the retention window.
+		Delivered   bool      `json:"delivered,omitempty"`
+		DeliveredAt time.Time `json:"delivered_at"`
+	}
+
+	// RetryPolicy decides how failed deliveries are retried. The wait
+	// before attempt n+1 is InitialBackoff * Multiplier^(n-1) capped at
+	// MaxBackoff, after MaxAttempts the message becomes a dead letter.
+	RetryPolicy struct {
+		// MaxAttempts values less than 1 mean a single attempt
+		MaxAttempts    int
+		InitialBackoff time.Duration
+		MaxBackoff     time.Duration
+		// Multiplier values less than 1 mean the backoff stays the same
+		Multiplier float64
+	}
+
+	// Outbox records inbound requests and delivers them to the handlers
+	Outbox struct {
+		store     Store
+		callbacks push.CallbackHandler
+		payments  ussd.PaymentHandler
+		policy    RetryPolicy
+		poll      time.Duration
+		retention time.Duration
+		timeout   time.Duration
+		reference func(request ussd.PayRequest) string
+		wake      chan struct{}
+
+		// only one dispatch runs at a time so that a message is not
+		// delivered twice concurrently
+		dispatchMu sync.Mutex
+	}
+
+	// Option is a setter func to set Outbox details
+	Option func(o *Outbox)
+)
+
+// New returns an Outbox that keeps messages in store and delivers them to
+// callbacks and payments, either of which may be nil when the Outbox is not
+// used for that kind of request. Call Run to start delivering.
+func New(store Store, callbacks push.CallbackHandler, payments ussd.PaymentHandler, opts ...Option) *Outbox {
+	o := &Outbox{
+		store:     store,
+		callbacks: callbacks,
+		payments:  payments,
+		policy:    DefaultRetryPolicy,
+		poll:      defaultPollInterval,
+		retention: defaultRetention,
+		timeout:   defaultTimeout,
+		reference: func(request ussd.PayRequest) string { return request.TxnID },
+		wake:      make(chan struct{}, 1),
+	}
+
+	for _, opt := range opts {
+		opt(o)
+	}
+
+	return o
+}
+
+// WithRetryPolicy sets the RetryPolicy of failed deliveries, by default
+// DefaultRetryPolicy is used.
+func WithRetryPolicy(policy RetryPolicy) Option {
+	return func(o *Outbox) {
+		o.policy = policy
+	}
+}
+
+// WithPollInterval sets how often Run looks for messages due for a retry,
+// new messages are delivered right away. The default is 5 seconds, values
+// of zero or less are ignored.
+func WithPollInterval(interval time.Duration) Option {
+	return func(o *Outbox) {
+		if interval <= 0 {
+			return
+		}
+		o.poll = interval
+	}
+}
+
+// WithRetention sets how long delivered messages are remembered, a request
+// tigo posts again within that window is acknowledged without being
+// delivered again. The default is 24 hours, values of zero or less are
+// ignored.
+func WithRetention(retention time.Duration) Option {
+	return func(o *Outbox) {
+		if retention <= 0 {
+			return
+		}
+		o.retention = retention
+	}
+}
+
+// WithDeliveryTimeout sets how long a single delivery may take before it
+// counts as failed and is retried, so that a stuck handler does not hold up
+// the messages after it. A handler that ignores its context is left running
+// and may still finish, handlers are idempotent anyway. The default is 1
+// minute, zero is ignored and negative values turn the limit off.
+func WithDeliveryTimeout(d time.Duration) Option {
+	return func(o *Outbox) {
+		o.timeout = timeout.Or(d, o.timeout)
+	}
+}
+
+// WithPaymentReference sets the func that picks the REFID returned to tigo
+// for a recorded payment, by default the TXNID of the payment is used. A nil
+// func is ignored.
+func WithPaymentReference(reference func(request ussd.PayRequest) string) Option {
+	return func(o *Outbox) {
+		if reference == nil {
+			return
+		}
+		o.reference = reference
+	}
+}
+
+// Handle records the callback and acknowledges it, it implements
+// push.CallbackHandler. An error is returned only when the callback could
+// not be recorded, tigo is then expected to post it again.
+func (o *Outbox) Handle(ctx context.Context, request push.CallbackRequest) (push.CallbackResponse, error) {
+	if err := o.record(ctx, KindCallback, request.ReferenceID, request); err != nil {
+		return push.CallbackResponse{}, err
+	}
+
+	return push.CallbackResponse{
+		ResponseCode:        push.SuccessCode,
+		ResponseDescription: request.Description,
+		ResponseStatus:      request.Status,
+		ReferenceID:         request.ReferenceID,
+	}, nil
+}
+
+// HandlePayRequest records the payment and accepts it, it implements
+// ussd.PaymentHandler. Payments can not be rejected once recorded, validate
+// them with a ussd.PaymentMiddleware in front of the client if needed.
+func (o *Outbox) HandlePayRequest(ctx context.Context, request ussd.PayRequest) (ussd.PayResponse, error) {
+	if err := o.record(ctx, KindPayment, request.TxnID, request); err != nil {
+		return ussd.PayResponse{}, err
+	}
+
+	return ussd.PayResponse{
+		TxnID:     request.TxnID,
+		RefID:     o.reference(request),
+		Result:    "TS",
+		ErrorCode: ussd.ErrSuccessTxn,
+		Msisdn:    request.Msisdn,
+	}, nil
+}
+
+func (o *Outbox) record(ctx context.Context, kind Kind, key string, request interface{}) error {
+	payload, err := json.Marshal(request)
+	if err != nil {
+		return err
+	}
+
+	if key == "" {
+		if key, err = randomID(); err != nil {
+			return err
+		}
+	}
+
+	now := time.Now()
+	err = o.store.Add(ctx, Message{
+		ID:          fmt.Sprintf("%s:%s", kind, key),
+		Kind:        kind,
+		Payload:     payload,
+		CreatedAt:   now,
+		NextAttempt: now,
+	})
+	if err != nil {
+		return fmt.Errorf("outbox: record %s: %w", kind, err)
+	}
+
+	o.notify()
+
+	return nil
+}
+
+// Run delivers messages until ctx is done, it returns ctx.Err(). Messages
+// left in the Store by a previous run are delivered first.
+func (o *Outbox) Run(ctx context.Context) error {
+	ticker := time.NewTicker(o.poll)
+	defer ticker.Stop()
+
+	for {
+		// store errors are transient as far as Run is concerned, the
+		// messages are picked up again on the next tick
+		_, _ = o.Dispatch(ctx)
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		case <-o.wake:
+		}
+	}
+}
+
+// Dispatch makes one delivery attempt for every message that is due and
+// returns how many were delivered. Delivered messages older than the
+// retention window are removed.
+func (o *Outbox) Dispatch(ctx context.Context) (int, error) {
+	o.dispatchMu.Lock()
+	defer o.dispatchMu.Unlock()
+
+	if err := o.store.Prune(ctx, time.Now().Add(-o.retention)); err != nil {
+		return 0, err
+	}
+
+	delivered := 0
+	for {
+		messages, err := o.store.Due(ctx, time.Now(), dispatchBatch)
+		if err != nil || len(messages) == 0 {
+			return delivered, err
+		}
+
+		for _, message := range messages {
+			if ctx.Err() != nil {
+				return delivered, ctx.Err()
+			}
+
+			ok, err := o.attempt(ctx, message)
+			if err != nil {
+				return delivered, err
+			}
+			if ok {
+				delivered++
+			}
+		}
+
+		if len(messages) < dispatchBatch {
+			return delivered, nil
+		}
+	}
+}
+
+// attempt delivers message once and records the outcome, it reports
+// whether the delivery succeeded.
+func (o *Outbox) attempt(ctx context.Context, message Message) (bool, error) {
+	err := o.deliver(ctx, message)
+	if err == nil {
+		message.Attempts++
+		message.LastError = ""
+		message.Delivered = true
+		message.DeliveredAt = time.Now()
+		return true, o.store.Save(ctx, message)
+	}
+
+	message.Attempts++
+	message.LastError = err.Error()
+	if message.Attempts >= o.policy.attempts() {
+		message.Dead = true
+	} else {
+		message.NextAttempt = time.Now().Add(o.policy.backoff(message.Attempts))
+	}
+
+	return false, o.store.Save(ctx, message)
+}
+
+// deliver hands message to its handler and gives up waiting for it once the
+// delivery timeout is over.
+func (o *Outbox) deliver(ctx context.Context, message Message) error {
+	ctx, cancel := timeout.With(ctx, o.timeout)
+	defer cancel()
+
+	done := make(chan error, 1)
+	go func() {
+		done <- o.handle(ctx, message)
+	}()
+
+	select {
+	case err := <-done:
+		return err
+	case <-ctx.Done():
+		return fmt.Errorf("outbox: deliver %s: %w", message.ID, ctx.Err())
+	}
+}
+
+func (o *Outbox) handle(ctx context.Context, message Message) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("outbox: handler panic: %v", r)
+		}
+	}()
+
+	switch message.Kind {
+	case KindCallback:
+		if o.callbacks == nil {
+			return ErrNoHandler
+		}
+		var request push.CallbackRequest
+		if err := json.Unmarshal(message.Payload, &request); err != nil {
+			return err
+		}
+		_, err = o.callbacks.Handle(ctx, request)
+		return err
+
+	case KindPayment:
+		if o.payments == nil {
+			return ErrNoHandler
+		}
+		var request ussd.PayRequest
+		if err := json.Unmarshal(message.Payload, &request); err != nil {
+			return err
+		}
+		_, err = o.payments.HandlePayRequest(ctx, request)
+		return err
+
+	default:
+		return fmt.Errorf("%w for %q", ErrNoHandler, message.Kind)
+	}
+}
+
+// DeadLetters returns the messages that ran out of attempts
+func (o *Outbox) DeadLetters(ctx context.Context) ([]Message, error) {
+	return o.store.DeadLetters(ctx)
+}
+
+// Requeue gives the dead message with id a fresh set of attempts
+func (o *Outbox) Requeue(ctx context.Context, id string) error {
+	message, ok, err := o.store.Get(ctx, id)
+	if err != nil {
+		return err
+	}
+	if !ok || !message.Dead {
+		return fmt.Errorf("%w: %s", ErrNotFound, id)
+	}
+
+	message.Dead = false
+	message.Attempts = 0
+	message.NextAttempt = time.Now()
+	if err := o.store.Save(ctx, message); err != nil {
+		return err
+	}
+
+	o.notify()
+
+	return nil
+}
+
+func (p RetryPolicy) attempts() int {
+	return backoff.Policy(p).Attempts()
+}
+
+// backoff returns how long to wait after the given attempt
+func (p RetryPolicy) backoff(attempt int) time.Duration {
+	return backoff.Policy(p).Wait(attempt)
+}
+
+// notify wakes Run up without waiting for the next poll
+func (o *Outbox) notify() {
+	select {
+	case o.wake <- struct{}{}:
+	default:
+	}
+}
+
+func randomID() (string, error) {
+	buf := make([]byte, 16)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(buf), nil
+}
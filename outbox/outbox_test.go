@@ -0,0 +1,316 @@
+/*
+ * MIT License
+ *
+ * Copyright (c) 2021 TechCraft Technologies Co. Ltd
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ *
+ */
+package outbox_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/techcraftlabs/tigopesa/money"
+	"github.com/techcraftlabs/tigopesa/outbox"
+	"github.com/techcraftlabs/tigopesa/push"
+	"github.com/techcraftlabs/tigopesa/ussd"
+)
+
+func postCallback(t *testing.T, client *push.Client, request push.CallbackRequest) push.CallbackResponse {
+	t.Helper()
+	body, _ := json.Marshal(request)
+	req := httptest.NewRequest(http.MethodPost, "/callback", bytes.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	rec := httptest.NewRecorder()
+	client.CallbackServeHTTP(rec, req)
+
+	var response push.CallbackResponse
+	if err := json.NewDecoder(rec.Body).Decode(&response); err != nil {
+		t.Fatalf("decode callback response: %v", err)
+	}
+	return response
+}
+
+func TestOutbox_Callback(t *testing.T) {
+	var received []push.CallbackRequest
+	handler := push.CallbackHandlerFunc(func(ctx context.Context, request push.CallbackRequest) (push.CallbackResponse, error) {
+		received = append(received, request)
+		return push.CallbackResponse{}, nil
+	})
+
+	o := outbox.New(outbox.NewMemoryStore(), handler, nil)
+	client := push.NewClient(&push.Config{}, o, push.WithDebugMode(false))
+
+	callback := push.CallbackRequest{Status: true, ReferenceID: "BILLER1", MFSTransactionID: "MFS1", Amount: money.Shillings(1000)}
+	response := postCallback(t, client, callback)
+	if response.ResponseCode != push.SuccessCode || response.ReferenceID != "BILLER1" {
+		t.Errorf("ack: got %+v", response)
+	}
+	// tigo posting the same callback again is recorded once
+	postCallback(t, client, callback)
+
+	if len(received) != 0 {
+		t.Fatal("handler called before dispatch")
+	}
+
+	n, err := o.Dispatch(context.Background())
+	if err != nil || n != 1 {
+		t.Fatalf("dispatch: %d %v", n, err)
+	}
+	callback.RawAmount = "1000"
+	if len(received) != 1 || received[0] != callback {
+		t.Errorf("received: %+v", received)
+	}
+
+	if n, _ := o.Dispatch(context.Background()); n != 0 {
+		t.Errorf("delivered %d messages again", n)
+	}
+
+	// tigo posting the callback again after it was delivered
+	postCallback(t, client, callback)
+	if n, _ := o.Dispatch(context.Background()); n != 0 || len(received) != 1 {
+		t.Errorf("redelivered callback dispatched again: %d %+v", n, received)
+	}
+}
+
+func TestOutbox_Retention(t *testing.T) {
+	store := outbox.NewMemoryStore()
+	payments := ussd.PaymentHandleFunc(func(ctx context.Context, request ussd.PayRequest) (ussd.PayResponse, error) {
+		return ussd.PayResponse{}, nil
+	})
+	o := outbox.New(store, nil, payments, outbox.WithRetention(50*time.Millisecond))
+	ctx := context.Background()
+
+	if _, err := o.HandlePayRequest(ctx, ussd.PayRequest{TxnID: "TXN1", Amount: money.Shillings(100)}); err != nil {
+		t.Fatal(err)
+	}
+	if n, err := o.Dispatch(ctx); err != nil || n != 1 {
+		t.Fatalf("dispatch: %d %v", n, err)
+	}
+
+	message, ok, err := store.Get(ctx, "ussd_payment:TXN1")
+	if err != nil || !ok || !message.Delivered {
+		t.Fatalf("delivered message not kept: %+v %t %v", message, ok, err)
+	}
+
+	time.Sleep(100 * time.Millisecond)
+	if _, err := o.Dispatch(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok, _ := store.Get(ctx, "ussd_payment:TXN1"); ok {
+		t.Error("delivered message kept after the retention window")
+	}
+}
+
+func TestOutbox_DeadLetters(t *testing.T) {
+	fail := true
+	attempts := 0
+	payments := ussd.PaymentHandleFunc(func(ctx context.Context, request ussd.PayRequest) (ussd.PayResponse, error) {
+		attempts++
+		if attempts == 1 {
+			panic("handler bug")
+		}
+		if fail {
+			return ussd.PayResponse{}, errors.New("database down")
+		}
+		return ussd.PayResponse{}, nil
+	})
+
+	o := outbox.New(outbox.NewMemoryStore(), nil, payments,
+		outbox.WithRetryPolicy(outbox.RetryPolicy{MaxAttempts: 2}),
+		outbox.WithPaymentReference(func(request ussd.PayRequest) string { return "REF-" + request.TxnID }))
+	ctx := context.Background()
+
+	response, err := o.HandlePayRequest(ctx, ussd.PayRequest{TxnID: "TXN1", Msisdn: "255713123456", Amount: money.Shillings(500)})
+	if err != nil || response.RefID != "REF-TXN1" || response.ErrorCode != ussd.ErrSuccessTxn {
+		t.Fatalf("ack: %+v %v", response, err)
+	}
+
+	for i := 0; i < 3; i++ {
+		if _, err := o.Dispatch(ctx); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if attempts != 2 {
+		t.Errorf("attempts: got %d want 2", attempts)
+	}
+
+	dead, err := o.DeadLetters(ctx)
+	if err != nil || len(dead) != 1 {
+		t.Fatalf("dead letters: %+v %v", dead, err)
+	}
+	if dead[0].Kind != outbox.KindPayment || dead[0].Attempts != 2 || dead[0].LastError != "database down" {
+		t.Errorf("dead letter: %+v", dead[0])
+	}
+
+	if err := o.Requeue(ctx, "missing"); !errors.Is(err, outbox.ErrNotFound) {
+		t.Errorf("requeue missing: %v", err)
+	}
+
+	fail = false
+	if err := o.Requeue(ctx, dead[0].ID); err != nil {
+		t.Fatal(err)
+	}
+	if n, err := o.Dispatch(ctx); err != nil || n != 1 {
+		t.Fatalf("dispatch after requeue: %d %v", n, err)
+	}
+	if dead, _ := o.DeadLetters(ctx); len(dead) != 0 {
+		t.Errorf("dead letters after requeue: %+v", dead)
+	}
+}
+
+func TestFileStore_SurvivesRestart(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "outbox.jsonl")
+	ctx := context.Background()
+
+	store, err := outbox.OpenFileStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the process crashes before anything is dispatched
+	crashed := outbox.New(store, nil, nil)
+	for _, txn := range []string{"TXN1", "TXN2"} {
+		if _, err := crashed.HandlePayRequest(ctx, ussd.PayRequest{TxnID: txn, Amount: money.Shillings(100)}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := store.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	store, err = outbox.OpenFileStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+
+	var (
+		mu       sync.Mutex
+		received []string
+		done     = make(chan struct{})
+	)
+	payments := ussd.PaymentHandleFunc(func(ctx context.Context, request ussd.PayRequest) (ussd.PayResponse, error) {
+		mu.Lock()
+		defer mu.Unlock()
+		received = append(received, request.TxnID)
+		if len(received) == 2 {
+			close(done)
+		}
+		return ussd.PayResponse{}, nil
+	})
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	go func() { _ = outbox.New(store, nil, payments).Run(ctx) }()
+
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("recorded payments were not delivered after the restart")
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	if received[0] != "TXN1" || received[1] != "TXN2" {
+		t.Errorf("received: %v", received)
+	}
+}
+
+func TestOutbox_DeliveryTimeout(t *testing.T) {
+	ctx := context.Background()
+	stuck := make(chan struct{})
+	defer close(stuck)
+
+	payments := ussd.PaymentHandleFunc(func(_ context.Context, request ussd.PayRequest) (ussd.PayResponse, error) {
+		if request.TxnID == "TXN1" {
+			// ignores its context
+			<-stuck
+		}
+		return ussd.PayResponse{}, nil
+	})
+
+	store := outbox.NewMemoryStore()
+	o := outbox.New(store, nil, payments, outbox.WithDeliveryTimeout(50*time.Millisecond))
+	for _, txn := range []string{"TXN1", "TXN2"} {
+		if _, err := o.HandlePayRequest(ctx, ussd.PayRequest{TxnID: txn, Amount: money.Shillings(100)}); err != nil {
+			t.Fatal(err)
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	start := time.Now()
+	if n, err := o.Dispatch(ctx); err != nil || n != 1 {
+		t.Fatalf("dispatch: %d %v", n, err)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("dispatch waited %s for the stuck handler", elapsed)
+	}
+
+	message, ok, err := store.Get(ctx, "ussd_payment:TXN1")
+	if err != nil || !ok || message.Delivered || message.Attempts != 1 || message.LastError == "" {
+		t.Errorf("stuck delivery: %+v %v", message, err)
+	}
+}
+
+func TestFileStore_Compacts(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "outbox.jsonl")
+	ctx := context.Background()
+
+	store, err := outbox.OpenFileStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+
+	message := outbox.Message{ID: "ussd_payment:TXN1", Kind: outbox.KindPayment, Payload: json.RawMessage(`{}`)}
+	for i := 1; i <= 3000; i++ {
+		message.Attempts = i
+		if err := store.Save(ctx, message); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	buf, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if lines := bytes.Count(buf, []byte("\n")); lines > 2000 {
+		t.Errorf("file holds %d records for a single message", lines)
+	}
+
+	reopened, err := outbox.OpenFileStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer reopened.Close()
+	if got, ok, _ := reopened.Get(ctx, message.ID); !ok || got.Attempts != 3000 {
+		t.Errorf("after compaction: %+v %v", got, ok)
+	}
+}
@@ -0,0 +1,461 @@
+/*
+ * MIT License
+ *
+ * Copyright (c) 2021 TechCraft Technologies Co. Ltd
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ *
+ */
+package outbox
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+)
+
+var (
+	_ Store = (*memoryStore)(nil)
+	_ Store = (*FileStore)(nil)
+)
+
+type (
+	// Store keeps the messages of an Outbox until they are delivered and
+	// pruned. Implementations must be safe for concurrent use and a
+	// message must be durable once Add or Save returns.
+	Store interface {
+		// Add records message unless a message with the same ID is
+		// already pending, dead or delivered.
+		Add(ctx context.Context, message Message) error
+
+		// Get returns ok false when there is no message with id
+		Get(ctx context.Context, id string) (message Message, ok bool, err error)
+
+		// Save replaces the stored message with the same ID
+		Save(ctx context.Context, message Message) error
+
+		// Delete removes the message with id, it is not an error when
+		// there is none.
+		Delete(ctx context.Context, id string) error
+
+		// Prune removes the delivered messages whose DeliveredAt is
+		// before before.
+		Prune(ctx context.Context, before time.Time) error
+
+		// Due returns up to limit messages that are neither dead nor
+		// delivered and whose NextAttempt is not after now, oldest first.
+		Due(ctx context.Context, now time.Time, limit int) ([]Message, error)
+
+		// DeadLetters returns the dead messages, oldest first
+		DeadLetters(ctx context.Context) ([]Message, error)
+	}
+
+	memoryStore struct {
+		mu       sync.RWMutex
+		messages map[string]Message
+	}
+
+	// FileStore is a Store that appends every change to a file as a json
+	// line and syncs it to disk before returning. A write that fails is cut
+	// off the file again. The file is compacted when it is opened and once
+	// most of its records are outdated.
+	FileStore struct {
+		mu       sync.Mutex
+		path     string
+		file     *os.File
+		messages map[string]Message
+
+		// size and records describe the file up to the last complete
+		// record, damaged is set when a failed write could not be cut off
+		size    int64
+		records int
+		damaged bool
+	}
+
+	fileRecord struct {
+		Op      string   `json:"op"`
+		ID      string   `json:"id,omitempty"`
+		Message *Message `json:"message,omitempty"`
+	}
+)
+
+const (
+	opPut    = "put"
+	opDelete = "delete"
+
+	// compactSlack is how many outdated records the file may hold on top
+	// of twice the number of messages before it is compacted
+	compactSlack = 1024
+)
+
+// NewMemoryStore returns a Store that lives as long as the process, messages
+// are lost when it crashes so it is only useful for tests.
+func NewMemoryStore() Store {
+	return &memoryStore{
+		messages: make(map[string]Message),
+	}
+}
+
+func (m *memoryStore) Add(_ context.Context, message Message) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, ok := m.messages[message.ID]; !ok {
+		m.messages[message.ID] = message
+	}
+	return nil
+}
+
+func (m *memoryStore) Get(_ context.Context, id string) (Message, bool, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	message, ok := m.messages[id]
+	return message, ok, nil
+}
+
+func (m *memoryStore) Save(_ context.Context, message Message) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.messages[message.ID] = message
+	return nil
+}
+
+func (m *memoryStore) Delete(_ context.Context, id string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.messages, id)
+	return nil
+}
+
+func (m *memoryStore) Prune(_ context.Context, before time.Time) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, id := range expired(m.messages, before) {
+		delete(m.messages, id)
+	}
+	return nil
+}
+
+func (m *memoryStore) Due(_ context.Context, now time.Time, limit int) ([]Message, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return due(m.messages, now, limit), nil
+}
+
+func (m *memoryStore) DeadLetters(_ context.Context) ([]Message, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return dead(m.messages), nil
+}
+
+// OpenFileStore opens the store at path creating it if it does not exist.
+// Messages left by a previous run are loaded so that they are delivered
+// again.
+func OpenFileStore(path string) (*FileStore, error) {
+	buf, err := os.ReadFile(path)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return nil, err
+	}
+
+	messages := make(map[string]Message)
+	lines := bytes.Split(buf, []byte("\n"))
+	for i, line := range lines {
+		if len(bytes.TrimSpace(line)) == 0 {
+			continue
+		}
+
+		var record fileRecord
+		if err := json.Unmarshal(line, &record); err != nil {
+			// a crash while writing leaves a truncated last line, any
+			// other broken line means the file is not an outbox
+			if i == len(lines)-1 {
+				continue
+			}
+			return nil, fmt.Errorf("outbox: %s line %d: %w", path, i+1, err)
+		}
+
+		switch {
+		case record.Op == opPut && record.Message != nil:
+			messages[record.Message.ID] = *record.Message
+		case record.Op == opDelete:
+			delete(messages, record.ID)
+		default:
+			return nil, fmt.Errorf("outbox: %s line %d: unknown record %q", path, i+1, record.Op)
+		}
+	}
+
+	f := &FileStore{
+		path:     path,
+		messages: messages,
+	}
+	if err := f.compact(); err != nil {
+		return nil, err
+	}
+
+	return f, nil
+}
+
+// compact rewrites the file with one record per message and opens it for
+// appending.
+func (f *FileStore) compact() error {
+	ids := make([]string, 0, len(f.messages))
+	for id := range f.messages {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+
+	var buf bytes.Buffer
+	for _, id := range ids {
+		message := f.messages[id]
+		line, err := json.Marshal(fileRecord{Op: opPut, Message: &message})
+		if err != nil {
+			return err
+		}
+		buf.Write(append(line, '\n'))
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(f.path), filepath.Base(f.path)+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(buf.Bytes()); err != nil {
+		_ = tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		_ = tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp.Name(), f.path); err != nil {
+		return err
+	}
+
+	file, err := os.OpenFile(f.path, os.O_WRONLY|os.O_APPEND, 0o600)
+	if err != nil {
+		// the old file is gone, appending to it would lose the records
+		f.damaged = true
+		return err
+	}
+	if f.file != nil {
+		_ = f.file.Close()
+	}
+	f.file = file
+	f.size = int64(buf.Len())
+	f.records = len(ids)
+	f.damaged = false
+
+	return nil
+}
+
+func (f *FileStore) Add(_ context.Context, message Message) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if _, ok := f.messages[message.ID]; ok {
+		return nil
+	}
+
+	return f.write(fileRecord{Op: opPut, Message: &message}, func() {
+		f.messages[message.ID] = message
+	})
+}
+
+func (f *FileStore) Get(_ context.Context, id string) (Message, bool, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	message, ok := f.messages[id]
+	return message, ok, nil
+}
+
+func (f *FileStore) Save(_ context.Context, message Message) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	return f.write(fileRecord{Op: opPut, Message: &message}, func() {
+		f.messages[message.ID] = message
+	})
+}
+
+func (f *FileStore) Delete(_ context.Context, id string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if _, ok := f.messages[id]; !ok {
+		return nil
+	}
+
+	return f.write(fileRecord{Op: opDelete, ID: id}, func() {
+		delete(f.messages, id)
+	})
+}
+
+func (f *FileStore) Prune(_ context.Context, before time.Time) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	for _, id := range expired(f.messages, before) {
+		err := f.write(fileRecord{Op: opDelete, ID: id}, func() {
+			delete(f.messages, id)
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (f *FileStore) Due(_ context.Context, now time.Time, limit int) ([]Message, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return due(f.messages, now, limit), nil
+}
+
+func (f *FileStore) DeadLetters(_ context.Context) ([]Message, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return dead(f.messages), nil
+}
+
+// write appends record to the file, syncs it and then calls apply
+func (f *FileStore) write(record fileRecord, apply func()) error {
+	if f.file == nil {
+		return errors.New("outbox: store is closed")
+	}
+
+	if f.damaged {
+		// rewrite the file from memory rather than append after a
+		// truncated record
+		if err := f.compact(); err != nil {
+			return err
+		}
+	}
+
+	buf, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+
+	line := append(buf, '\n')
+	if _, err := f.file.Write(line); err != nil {
+		f.rollback()
+		return err
+	}
+
+	if err := f.file.Sync(); err != nil {
+		f.rollback()
+		return err
+	}
+
+	f.size += int64(len(line))
+	f.records++
+	apply()
+
+	if f.records > 2*len(f.messages)+compactSlack {
+		// the record is durable already, a failed compaction is retried
+		// on a later write
+		_ = f.compact()
+	}
+
+	return nil
+}
+
+// rollback cuts a partially written record off the file so that it does
+// not end up in the middle of the file once the next record is appended.
+func (f *FileStore) rollback() {
+	if err := f.file.Truncate(f.size); err != nil {
+		f.damaged = true
+	}
+}
+
+// Close closes the underlying file
+func (f *FileStore) Close() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.file == nil {
+		return nil
+	}
+
+	err := f.file.Close()
+	f.file = nil
+
+	return err
+}
+
+func due(messages map[string]Message, now time.Time, limit int) []Message {
+	var list []Message
+	for _, message := range messages {
+		if !message.Dead && !message.Delivered && !message.NextAttempt.After(now) {
+			list = append(list, message)
+		}
+	}
+
+	sortMessages(list)
+	if limit > 0 && len(list) > limit {
+		list = list[:limit]
+	}
+
+	return list
+}
+
+// expired returns the IDs of the messages delivered before before
+func expired(messages map[string]Message, before time.Time) []string {
+	var ids []string
+	for id, message := range messages {
+		if message.Delivered && message.DeliveredAt.Before(before) {
+			ids = append(ids, id)
+		}
+	}
+
+	return ids
+}
+
+func dead(messages map[string]Message) []Message {
+	var list []Message
+	for _, message := range messages {
+		if message.Dead {
+			list = append(list, message)
+		}
+	}
+
+	sortMessages(list)
+
+	return list
+}
+
+func sortMessages(list []Message) {
+	sort.Slice(list, func(i, j int) bool {
+		if !list[i].CreatedAt.Equal(list[j].CreatedAt) {
+			return list[i].CreatedAt.Before(list[j].CreatedAt)
+		}
+		return list[i].ID < list[j].ID
+	})
+}
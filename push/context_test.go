@@ -0,0 +1,114 @@
+/*
+ * MIT License
+ *
+ * Copyright (c) 2021 TechCraft Technologies Co. Ltd
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ *
+ */
+package push_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/techcraftlabs/tigopesa/money"
+	"github.com/techcraftlabs/tigopesa/push"
+	"github.com/techcraftlabs/tigopesa/tigotest"
+)
+
+func TestClient_PayCancel(t *testing.T) {
+	server := tigotest.NewServer()
+	defer server.Close()
+
+	client := push.NewClient(server.PushConfig(), nil, push.WithDebugMode(false))
+	server.ScriptPush(tigotest.Outcome{Hang: true})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(50*time.Millisecond, cancel)
+
+	start := time.Now()
+	_, err := client.Pay(ctx, push.Request{MSISDN: "255713123456", Amount: money.Shillings(1000), ReferenceID: "ORDER1"})
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf("expected context canceled got %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Errorf("pay returned %v after the context was canceled", elapsed)
+	}
+}
+
+func TestClient_TokenTimeout(t *testing.T) {
+	server := tigotest.NewServer()
+	defer server.Close()
+
+	client := push.NewClient(server.PushConfig(), nil, push.WithDebugMode(false),
+		push.WithTimeouts(push.Timeouts{Token: 50 * time.Millisecond}))
+	server.ScriptToken(tigotest.Outcome{Hang: true}, tigotest.Outcome{Hang: true})
+
+	start := time.Now()
+	_, err := client.Token(context.Background())
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("expected deadline exceeded got %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Errorf("token returned after %v", elapsed)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := client.Token(ctx); !errors.Is(err, context.Canceled) {
+		t.Errorf("expected context canceled got %v", err)
+	}
+}
+
+type requestKey struct{}
+
+func TestClient_CallbackContext(t *testing.T) {
+	var (
+		value    interface{}
+		deadline time.Time
+	)
+	handler := push.CallbackHandlerFunc(func(ctx context.Context, request push.CallbackRequest) (push.CallbackResponse, error) {
+		value = ctx.Value(requestKey{})
+		deadline, _ = ctx.Deadline()
+		return push.CallbackResponse{ResponseCode: push.SuccessCode, ReferenceID: request.ReferenceID}, nil
+	})
+	client := push.NewClient(&push.Config{}, handler, push.WithDebugMode(false),
+		push.WithTimeouts(push.Timeouts{Callback: 5 * time.Second}))
+
+	body, _ := json.Marshal(push.CallbackRequest{Status: true, ReferenceID: "REF1", Amount: money.Shillings(1000)})
+	req := httptest.NewRequest(http.MethodPost, "/callback", bytes.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	req = req.WithContext(context.WithValue(req.Context(), requestKey{}, "request"))
+
+	client.CallbackServeHTTP(httptest.NewRecorder(), req)
+
+	if value != "request" {
+		t.Errorf("handler context is not derived from the request")
+	}
+	if left := time.Until(deadline); left <= 0 || left > 5*time.Second {
+		t.Errorf("unexpected handler deadline %v", deadline)
+	}
+}
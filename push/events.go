@@ -0,0 +1,85 @@
+/*
+ * MIT License
+ *
+ * Copyright (c) 2021 TechCraft Technologies Co. Ltd
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ *
+ */
+package push
+
+import (
+	"context"
+	"time"
+
+	"github.com/techcraftlabs/tigopesa/events"
+)
+
+// publishPay publishes events.PushInitiated when tigo accepted request and
+// events.PushFailed when it was rejected, see rejected. Nothing is published
+// when the outcome is not known, e.g. after a timeout, or when a payment
+// with the same ReferenceID is in progress: the callback tells how it ends.
+func (c *Client) publishPay(ctx context.Context, request payRequest, response PayResponse, err error) {
+	if err == nil && response.ResponseStatus {
+		c.events.Publish(ctx, events.PushInitiated{
+			Time:         time.Now(),
+			ReferenceID:  request.ReferenceID,
+			MSISDN:       request.CustomerMSISDN,
+			Amount:       request.Amount,
+			ResponseCode: response.ResponseCode,
+			Description:  response.ResponseDescription,
+		})
+		return
+	}
+
+	if !rejected(response, err) {
+		return
+	}
+
+	c.events.Publish(ctx, events.PushFailed{
+		Time:        time.Now(),
+		ReferenceID: request.ReferenceID,
+		MSISDN:      request.CustomerMSISDN,
+		Amount:      request.Amount,
+		Code:        response.ResponseCode,
+		Description: response.ResponseDescription,
+		Err:         err,
+	})
+}
+
+func (c *Client) publishCallback(ctx context.Context, request CallbackRequest) {
+	if request.Status {
+		c.events.Publish(ctx, events.PushCompleted{
+			Time:             time.Now(),
+			ReferenceID:      request.ReferenceID,
+			MFSTransactionID: request.MFSTransactionID,
+			Amount:           request.Amount,
+			Description:      request.Description,
+		})
+		return
+	}
+
+	c.events.Publish(ctx, events.PushFailed{
+		Time:             time.Now(),
+		ReferenceID:      request.ReferenceID,
+		MFSTransactionID: request.MFSTransactionID,
+		Amount:           request.Amount,
+		Description:      request.Description,
+	})
+}
@@ -0,0 +1,174 @@
+/*
+ * MIT License
+ *
+ * Copyright (c) 2021 TechCraft Technologies Co. Ltd
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ *
+ */
+
+package push
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/techcraftlabs/tigopesa/internal/ttl"
+)
+
+const defaultIdempotencyWindow = 24 * time.Hour
+
+var _ IdempotencyStore = (*memoryIdempotencyStore)(nil)
+
+type (
+	// IdempotencyStore keeps an IdempotencyEntry for every push pay request
+	// for a while, keyed by the ReferenceID sent to tigo. See WithIdempotency.
+	// Get returns ok false when key is not stored or has expired.
+	//
+	// Claim stores entry unless key is already stored and not expired, in
+	// which case it returns the stored entry and claimed false. It must be
+	// atomic across every client sharing the store, e.g. SET NX in Redis or
+	// an INSERT guarded by a unique key in SQL, so that only one of them
+	// sends a request.
+	IdempotencyStore interface {
+		Get(ctx context.Context, key string) (entry IdempotencyEntry, ok bool, err error)
+		Claim(ctx context.Context, key string, entry IdempotencyEntry, ttl time.Duration) (stored IdempotencyEntry, claimed bool, err error)
+		Put(ctx context.Context, key string, entry IdempotencyEntry, ttl time.Duration) error
+		Delete(ctx context.Context, key string) error
+	}
+
+	// IdempotencyEntry is what an IdempotencyStore keeps for a request. A
+	// Pending entry is recorded before the request is sent and replaced by
+	// the Response once tigo answers.
+	IdempotencyEntry struct {
+		Response PayResponse `json:"response"`
+		Pending  bool        `json:"pending"`
+	}
+
+	memoryIdempotencyStore struct {
+		entries ttl.Map
+	}
+
+	// payCall is a push pay request in flight, duplicates wait for done
+	payCall struct {
+		done     chan struct{}
+		response PayResponse
+		err      error
+	}
+)
+
+// NewMemoryIdempotencyStore returns an IdempotencyStore that keeps the
+// entries in memory, expired entries are dropped as new ones are added.
+func NewMemoryIdempotencyStore() IdempotencyStore {
+	return &memoryIdempotencyStore{}
+}
+
+func (m *memoryIdempotencyStore) Get(_ context.Context, key string) (IdempotencyEntry, bool, error) {
+	stored, ok := m.entries.Get(key)
+	if !ok {
+		return IdempotencyEntry{}, false, nil
+	}
+
+	return stored.(IdempotencyEntry), true, nil
+}
+
+func (m *memoryIdempotencyStore) Claim(_ context.Context, key string, entry IdempotencyEntry, ttl time.Duration) (IdempotencyEntry, bool, error) {
+	stored, claimed := m.entries.Claim(key, entry, ttl)
+	return stored.(IdempotencyEntry), claimed, nil
+}
+
+func (m *memoryIdempotencyStore) Put(_ context.Context, key string, entry IdempotencyEntry, ttl time.Duration) error {
+	m.entries.Put(key, entry, ttl)
+	return nil
+}
+
+func (m *memoryIdempotencyStore) Delete(_ context.Context, key string) error {
+	m.entries.Delete(key)
+	return nil
+}
+
+// payOnce sends the request unless a request with the same ReferenceID has
+// already been sent within the idempotency window. A duplicate of a request
+// that was answered gets the stored response, one of a request whose
+// outcome is not known fails with ErrPaymentInProgress. A duplicate that
+// arrives while the first request is in flight in this process waits for
+// it and gets the same result.
+//
+// The request is claimed as pending in the store before it is sent, so that
+// clients sharing the store send it once. It is forgotten when it was not
+// sent or tigo turned it down with an error, see rejected, so that it can
+// be corrected and sent again. After a timeout or any other error tigo may
+// have prompted the customer all the same so the entry stays pending until
+// the window is over. Use QueryStatus to find out.
+func (c *Client) payOnce(ctx context.Context, request Request) (PayResponse, error) {
+	key := fmt.Sprintf("%s%s", c.Config.BillerCode, request.ReferenceID)
+
+	// requests in flight are looked up first, their entry is pending
+	c.inflightMu.Lock()
+	if call, ok := c.inflight[key]; ok {
+		c.inflightMu.Unlock()
+		select {
+		case <-ctx.Done():
+			return PayResponse{}, ctx.Err()
+		case <-call.done:
+			return call.response, call.err
+		}
+	}
+
+	if c.inflight == nil {
+		c.inflight = make(map[string]*payCall)
+	}
+	call := &payCall{done: make(chan struct{})}
+	c.inflight[key] = call
+	c.inflightMu.Unlock()
+
+	defer func() {
+		c.inflightMu.Lock()
+		delete(c.inflight, key)
+		c.inflightMu.Unlock()
+		close(call.done)
+	}()
+
+	stored, claimed, err := c.idempotency.Claim(ctx, key, IdempotencyEntry{Pending: true}, c.idempotencyWindow)
+	switch {
+	case err != nil:
+		call.err = err
+		return PayResponse{}, call.err
+	case !claimed && stored.Pending:
+		call.err = fmt.Errorf("%w: %s", ErrPaymentInProgress, key)
+		return PayResponse{}, call.err
+	case !claimed:
+		call.response = stored.Response
+		return call.response, nil
+	}
+
+	call.response, call.err = c.pay(ctx, request)
+	switch {
+	case call.err == nil:
+		// the request has been sent, failing to store its response must
+		// not make the caller send it again
+		_ = c.idempotency.Put(ctx, key, IdempotencyEntry{Response: call.response}, c.idempotencyWindow)
+
+	case !sent(call.err) || rejected(call.response, call.err):
+		_ = c.idempotency.Delete(ctx, key)
+	}
+
+	return call.response, call.err
+}
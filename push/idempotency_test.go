@@ -0,0 +1,199 @@
+/*
+ * MIT License
+ *
+ * Copyright (c) 2021 TechCraft Technologies Co. Ltd
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ *
+ */
+
+package push_test
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/techcraftlabs/tigopesa/money"
+	"github.com/techcraftlabs/tigopesa/push"
+	"github.com/techcraftlabs/tigopesa/tigotest"
+)
+
+func TestClient_PayIdempotency(t *testing.T) {
+	server := tigotest.NewServer()
+	defer server.Close()
+	server.ScriptPush(
+		tigotest.Outcome{NoCallback: true, Delay: 100 * time.Millisecond},
+		tigotest.Outcome{NoCallback: true},
+	)
+
+	client := push.NewClient(server.PushConfig(), nil, push.WithDebugMode(false),
+		push.WithIdempotency(nil, time.Minute))
+
+	order := push.Request{MSISDN: "255713123456", Amount: money.Shillings(1000), ReferenceID: "ORDER1"}
+
+	var wg sync.WaitGroup
+	responses := make([]push.PayResponse, 5)
+	for i := range responses {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			res, err := client.Pay(context.Background(), order)
+			if err != nil {
+				t.Errorf("pay %d: %v", i, err)
+			}
+			responses[i] = res
+		}(i)
+	}
+	wg.Wait()
+
+	// a retry after the first request completed
+	res, err := client.Pay(context.Background(), order)
+	if err != nil {
+		t.Fatal(err)
+	}
+	responses = append(responses, res)
+
+	for i, res := range responses {
+		if res != responses[0] || !res.ResponseStatus {
+			t.Errorf("response %d: got %+v want %+v", i, res, responses[0])
+		}
+	}
+
+	if n := len(server.PushRequests()); n != 1 {
+		t.Fatalf("push requests: got %d want 1", n)
+	}
+
+	order.ReferenceID = "ORDER2"
+	if _, err := client.Pay(context.Background(), order); err != nil {
+		t.Fatal(err)
+	}
+
+	if n := len(server.PushRequests()); n != 2 {
+		t.Errorf("push requests: got %d want 2", n)
+	}
+}
+
+func TestClient_PayIdempotencyOutcomeUnknown(t *testing.T) {
+	server := tigotest.NewServer()
+	defer server.Close()
+	server.ScriptPush(tigotest.Outcome{Hang: true})
+
+	client := push.NewClient(server.PushConfig(), nil, push.WithDebugMode(false),
+		push.WithIdempotency(nil, time.Minute), push.WithTimeouts(push.Timeouts{Pay: 100 * time.Millisecond}))
+
+	order := push.Request{MSISDN: "255713123456", Amount: money.Shillings(1000), ReferenceID: "ORDER1"}
+	if _, err := client.Pay(context.Background(), order); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("expected deadline exceeded got %v", err)
+	}
+
+	// the customer may have been prompted, the retry must not prompt again
+	if _, err := client.Pay(context.Background(), order); !errors.Is(err, push.ErrPaymentInProgress) {
+		t.Fatalf("expected payment in progress got %v", err)
+	}
+
+	if n := len(server.PushRequests()); n != 1 {
+		t.Errorf("push requests: got %d want 1", n)
+	}
+}
+
+func TestClient_PayIdempotencyNotSent(t *testing.T) {
+	server := tigotest.NewServer()
+	defer server.Close()
+
+	client := push.NewClient(server.PushConfig(), nil, push.WithDebugMode(false),
+		push.WithIdempotency(nil, time.Minute))
+
+	order := push.Request{MSISDN: "0713", Amount: money.Shillings(1000), ReferenceID: "ORDER1"}
+	if _, err := client.Pay(context.Background(), order); err == nil {
+		t.Fatal("expected invalid msisdn error")
+	}
+
+	order.MSISDN = "255713123456"
+	if _, err := client.Pay(context.Background(), order); err != nil {
+		t.Fatalf("request that was never sent not retried: %v", err)
+	}
+}
+
+func TestClient_PayIdempotencyRejected(t *testing.T) {
+	server := tigotest.NewServer()
+	defer server.Close()
+	server.ScriptPush(
+		tigotest.Outcome{HTTPStatus: http.StatusBadRequest, Code: "BILLER-18-3001-E", Message: "invalid request"},
+		tigotest.Outcome{NoCallback: true},
+	)
+
+	client := push.NewClient(server.PushConfig(), nil, push.WithDebugMode(false),
+		push.WithIdempotency(nil, time.Minute))
+
+	// tigo turned the request down, retrying it must not wait for the window
+	order := push.Request{MSISDN: "255713123456", Amount: money.Shillings(1000), ReferenceID: "ORDER1"}
+	if _, err := client.Pay(context.Background(), order); !errors.Is(err, push.ErrUnexpectedStatus) {
+		t.Fatalf("expected unexpected status got %v", err)
+	}
+	if res, err := client.Pay(context.Background(), order); err != nil || !res.ResponseStatus {
+		t.Fatalf("retry of a rejected request: %+v %v", res, err)
+	}
+
+	if n := len(server.PushRequests()); n != 2 {
+		t.Errorf("push requests: got %d want 2", n)
+	}
+}
+
+func TestClient_PayIdempotencySharedStore(t *testing.T) {
+	server := tigotest.NewServer()
+	defer server.Close()
+	server.ScriptPush(tigotest.Outcome{NoCallback: true, Delay: 100 * time.Millisecond})
+
+	// two replicas sharing a store send the request once
+	store := push.NewMemoryIdempotencyStore()
+	clients := []*push.Client{
+		push.NewClient(server.PushConfig(), nil, push.WithDebugMode(false), push.WithIdempotency(store, time.Minute)),
+		push.NewClient(server.PushConfig(), nil, push.WithDebugMode(false), push.WithIdempotency(store, time.Minute)),
+	}
+
+	order := push.Request{MSISDN: "255713123456", Amount: money.Shillings(1000), ReferenceID: "ORDER1"}
+	var (
+		wg         sync.WaitGroup
+		mu         sync.Mutex
+		inProgress int
+	)
+	for _, client := range clients {
+		wg.Add(1)
+		go func(client *push.Client) {
+			defer wg.Done()
+			_, err := client.Pay(context.Background(), order)
+			if errors.Is(err, push.ErrPaymentInProgress) {
+				mu.Lock()
+				inProgress++
+				mu.Unlock()
+			} else if err != nil {
+				t.Errorf("pay: %v", err)
+			}
+		}(client)
+	}
+	wg.Wait()
+
+	if n := len(server.PushRequests()); n != 1 || inProgress != 1 {
+		t.Errorf("push requests: got %d want 1, %d in progress want 1", n, inProgress)
+	}
+}
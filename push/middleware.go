@@ -0,0 +1,57 @@
+/*
+ * MIT License
+ *
+ * Copyright (c) 2021 TechCraft Technologies Co. Ltd
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ *
+ */
+
+package push
+
+// CallbackMiddleware wraps a CallbackHandler to run logic like validation,
+// logging or metrics on the decoded CallbackRequest and CallbackResponse.
+type CallbackMiddleware func(next CallbackHandler) CallbackHandler
+
+// Use adds middlewares to the chain that wraps the CallbackHandler. The
+// first middleware added is the outermost one.
+func (c *Client) Use(middlewares ...CallbackMiddleware) {
+	c.mwMu.Lock()
+	defer c.mwMu.Unlock()
+	c.middlewares = append(c.middlewares, middlewares...)
+}
+
+// callbackHandler returns the CallbackHandler wrapped by the middlewares.
+// Clients that only use PayAndWait may not set a CallbackHandler, in that
+// case callbacks are acknowledged as received.
+func (c *Client) callbackHandler() CallbackHandler {
+	var handler CallbackHandler = CallbackHandlerFunc(acknowledge)
+	if c.CallbackHandler != nil {
+		handler = c.CallbackHandler
+	}
+
+	c.mwMu.RLock()
+	defer c.mwMu.RUnlock()
+
+	for i := len(c.middlewares) - 1; i >= 0; i-- {
+		handler = c.middlewares[i](handler)
+	}
+
+	return handler
+}
@@ -0,0 +1,89 @@
+/*
+ * MIT License
+ *
+ * Copyright (c) 2021 TechCraft Technologies Co. Ltd
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ *
+ */
+
+package push_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/techcraftlabs/tigopesa/money"
+	"github.com/techcraftlabs/tigopesa/push"
+)
+
+func TestClient_Use(t *testing.T) {
+	client := push.NewClient(&push.Config{BillerCode: "BILLER"}, nil, push.WithDebugMode(false))
+
+	var seen []string
+	client.Use(func(next push.CallbackHandler) push.CallbackHandler {
+		return push.CallbackHandlerFunc(func(ctx context.Context, request push.CallbackRequest) (push.CallbackResponse, error) {
+			seen = append(seen, request.ReferenceID)
+			return next.Handle(ctx, request)
+		})
+	}, func(next push.CallbackHandler) push.CallbackHandler {
+		return push.CallbackHandlerFunc(func(ctx context.Context, request push.CallbackRequest) (push.CallbackResponse, error) {
+			if request.Amount.IsZero() {
+				return push.CallbackResponse{
+					ResponseCode:        push.FailureCode,
+					ResponseDescription: "missing amount",
+					ReferenceID:         request.ReferenceID,
+				}, nil
+			}
+			return next.Handle(ctx, request)
+		})
+	})
+
+	callback := func(request push.CallbackRequest) push.CallbackResponse {
+		body, _ := json.Marshal(request)
+		req := httptest.NewRequest(http.MethodPost, "/callback", bytes.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		rec := httptest.NewRecorder()
+		client.CallbackServeHTTP(rec, req)
+
+		var response push.CallbackResponse
+		if err := json.NewDecoder(rec.Body).Decode(&response); err != nil {
+			t.Fatalf("decode callback response: %v", err)
+		}
+		return response
+	}
+
+	res := callback(push.CallbackRequest{Status: true, ReferenceID: "BILLER1", Amount: money.Shillings(1000)})
+	if res.ResponseCode != push.SuccessCode {
+		t.Errorf("valid callback: got %+v", res)
+	}
+
+	res = callback(push.CallbackRequest{Status: true, ReferenceID: "BILLER2"})
+	if res.ResponseCode != push.FailureCode {
+		t.Errorf("invalid callback: got %+v", res)
+	}
+
+	if len(seen) != 2 || seen[0] != "BILLER1" || seen[1] != "BILLER2" {
+		t.Errorf("outer middleware saw %v", seen)
+	}
+}
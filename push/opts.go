@@ -26,8 +26,18 @@
 package push
 
 import (
+	"github.com/techcraftlabs/tigopesa/events"
+	"github.com/techcraftlabs/tigopesa/guard"
+	"github.com/techcraftlabs/tigopesa/internal/timeout"
+	"github.com/techcraftlabs/tigopesa/internal/tracing"
+	"github.com/techcraftlabs/tigopesa/metrics"
+	"github.com/techcraftlabs/tigopesa/money"
+	"github.com/techcraftlabs/tigopesa/msisdn"
+	"github.com/techcraftlabs/tigopesa/redact"
+	"go.opentelemetry.io/otel/trace"
 	"io"
 	"net/http"
+	"time"
 )
 
 // ClientOption is a setter func to set Client details like
@@ -72,3 +82,145 @@ func WithHTTPClient(httpClient *http.Client) ClientOption {
 		client.base.Http = httpClient
 	}
 }
+
+// WithTokenStore replaces the default in memory TokenStore with store. Use
+// this to share one token between several clients or processes, see
+// NewFileTokenStore. A nil store is ignored.
+func WithTokenStore(store TokenStore) ClientOption {
+	return func(client *Client) {
+		if store == nil {
+			return
+		}
+		client.tokenStore = store
+	}
+}
+
+// WithTokenRefreshMargin sets how long before the token expires the client
+// asks tigo for a new one. The default margin is 60 seconds, negative values
+// are ignored.
+func WithTokenRefreshMargin(margin time.Duration) ClientOption {
+	return func(client *Client) {
+		if margin < 0 {
+			return
+		}
+		client.tokenRefreshMargin = margin
+	}
+}
+
+// WithRedactor sets the redact.Redactor used to mask secrets like the PIN,
+// passwords and tokens in debug logs. By default secrets are masked and
+// MSISDNs are not, a nil r is ignored.
+func WithRedactor(r *redact.Redactor) ClientOption {
+	return func(client *Client) {
+		if r == nil {
+			return
+		}
+		client.redactor = r
+	}
+}
+
+// WithIdempotency makes Pay return the first PayResponse for a ReferenceID
+// that is paid again within window instead of sending a new request, a
+// concurrent duplicate waits for the request already in flight. When the
+// first request ended without a response, e.g. it timed out, duplicates fail
+// with ErrPaymentInProgress until window is over, while one tigo turned down
+// can be sent again. Share store between replicas to send a request once
+// across them. If store is nil entries are kept in memory, a window of zero
+// or less means 24 hours.
+func WithIdempotency(store IdempotencyStore, window time.Duration) ClientOption {
+	return func(client *Client) {
+		if store == nil {
+			store = NewMemoryIdempotencyStore()
+		}
+		if window <= 0 {
+			window = defaultIdempotencyWindow
+		}
+		client.idempotency = store
+		client.idempotencyWindow = window
+	}
+}
+
+// WithInboundGuard sets the guard.Guard that authenticates the push pay callbacks
+// tigo sends, rejected calls are answered with 401 or 403 before they are
+// decoded. By default all calls are accepted.
+func WithInboundGuard(g *guard.Guard) ClientOption {
+	return func(client *Client) {
+		client.guard = g
+	}
+}
+
+// WithAmountLimits sets the smallest and largest amounts Pay sends, zero
+// values mean no limit. Amounts that are not positive are always rejected.
+func WithAmountLimits(limits money.Limits) ClientOption {
+	return func(client *Client) {
+		client.limits = limits
+	}
+}
+
+// WithMSISDNNormalizer sets the msisdn.Normalizer applied to Request.MSISDN before
+// it is sent, numbers it rejects fail Pay.
+// By default msisdn.Default is used, msisdn.Identity turns normalization
+// off. A nil normalizer is ignored.
+func WithMSISDNNormalizer(normalizer msisdn.Normalizer) ClientOption {
+	return func(client *Client) {
+		if normalizer == nil {
+			return
+		}
+		client.normalizer = normalizer
+	}
+}
+
+// WithMetrics sets the metrics.Recorder that observes Token, Pay, QueryStatus
+// and the callbacks,
+// a nil recorder is ignored.
+func WithMetrics(recorder metrics.Recorder) ClientOption {
+	return func(client *Client) {
+		if recorder == nil {
+			return
+		}
+		client.metrics = recorder
+	}
+}
+
+// WithTracerProvider sets the OpenTelemetry TracerProvider used to trace
+// Token, Pay, QueryStatus and the callbacks. The global provider is used by
+// default.
+func WithTracerProvider(provider trace.TracerProvider) ClientOption {
+	return func(client *Client) {
+		client.tracer = tracing.Tracer(provider, "push")
+	}
+}
+
+// WithTimeouts sets how long Token, Pay, QueryStatus and the callback
+// handler may take, zero fields keep the value from DefaultTimeouts.
+func WithTimeouts(timeouts Timeouts) ClientOption {
+	return func(client *Client) {
+		client.timeouts = timeouts
+		timeout.Defaults(&client.timeouts, DefaultTimeouts)
+	}
+}
+
+// WithEventSink sets the events.Sink that gets an event for every push pay
+// request and callback, a sink that panics does not affect the client.
+// A nil sink is ignored.
+func WithEventSink(sink events.Sink) ClientOption {
+	return func(client *Client) {
+		if sink == nil {
+			return
+		}
+		client.events = events.Safe(sink)
+	}
+}
+
+// WithStateMachine makes the client track every push payment in m. Paying a
+// ReferenceID that is still in progress fails with ErrPaymentInProgress, one
+// that succeeded with ErrAlreadyPaid. Callbacks for already final payments,
+// duplicates of one being handled and, unless m was built
+// WithUnknownReferences, those for references m does not track are answered
+// with FailureCode without reaching the CallbackHandler. A payment becomes
+// final once the handler took its callback.
+func WithStateMachine(m *StateMachine) ClientOption {
+	return func(client *Client) {
+		client.machine = m
+	}
+}
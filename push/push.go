@@ -27,17 +27,40 @@ package push
 
 import (
 	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
-	"math"
 	"net/http"
 	"net/url"
+	"strings"
+	"sync"
 	"time"
 
 	"github.com/techcraftlabs/base"
+	"github.com/techcraftlabs/tigopesa/events"
+	"github.com/techcraftlabs/tigopesa/guard"
+	"github.com/techcraftlabs/tigopesa/internal/timeout"
+	"github.com/techcraftlabs/tigopesa/internal/tracing"
+	"github.com/techcraftlabs/tigopesa/metrics"
+	"github.com/techcraftlabs/tigopesa/money"
+	"github.com/techcraftlabs/tigopesa/msisdn"
+	"github.com/techcraftlabs/tigopesa/redact"
+	"go.opentelemetry.io/otel/trace"
 )
 
 var (
 	_ base.RequestInformer = (*requestType)(nil)
+	_ http.RoundTripper    = statusRecorder{}
+)
+
+var (
+	// ErrUnexpectedStatus is matched by the error returned when tigo answers
+	// a request with a status code of 400 or above.
+	ErrUnexpectedStatus = errors.New("push: unexpected http status")
+
+	// ErrNoAccessToken is returned by Token when tigo replies without an
+	// access token.
+	ErrNoAccessToken = errors.New("push: no access token in response")
 )
 
 const (
@@ -62,11 +85,11 @@ type (
 	// from Config
 	// PayRequest is used by Client without the need to specify BillerMSISDN
 	payRequest struct {
-		CustomerMSISDN string  `json:"CustomerMSISDN"`
-		BillerMSISDN   string  `json:"BillerMSISDN"`
-		Amount         float64 `json:"Amount"`
-		Remarks        string  `json:"Remarks,omitempty"`
-		ReferenceID    string  `json:"ReferenceID"`
+		CustomerMSISDN string       `json:"CustomerMSISDN"`
+		BillerMSISDN   string       `json:"BillerMSISDN"`
+		Amount         money.Amount `json:"Amount"`
+		Remarks        string       `json:"Remarks,omitempty"`
+		ReferenceID    string       `json:"ReferenceID"`
 	}
 
 	PayResponse struct {
@@ -78,11 +101,15 @@ type (
 	}
 
 	CallbackRequest struct {
-		Status           bool   `json:"Status"`
-		Description      string `json:"Description"`
-		MFSTransactionID string `json:"MFSTransactionID,omitempty"`
-		ReferenceID      string `json:"ReferenceID"`
-		Amount           string `json:"Amount"`
+		Status           bool         `json:"Status"`
+		Description      string       `json:"Description"`
+		MFSTransactionID string       `json:"MFSTransactionID,omitempty"`
+		ReferenceID      string       `json:"ReferenceID"`
+		Amount           money.Amount `json:"Amount"`
+
+		// RawAmount is the Amount as tigo sent it. An empty or malformed
+		// amount leaves Amount zero rather than failing the callback.
+		RawAmount string `json:"-"`
 	}
 
 	CallbackResponse struct {
@@ -93,10 +120,10 @@ type (
 	}
 
 	Request struct {
-		MSISDN      string  `json:"msisdn"`
-		Amount      float64 `json:"amount"`
-		Remarks     string  `json:"remarks,omitempty"`
-		ReferenceID string  `json:"referenceID"`
+		MSISDN      string       `json:"msisdn"`
+		Amount      money.Amount `json:"amount"`
+		Remarks     string       `json:"remarks,omitempty"`
+		ReferenceID string       `json:"referenceID"`
 	}
 
 	Config struct {
@@ -108,6 +135,14 @@ type (
 		BillerMSISDN      string
 		BillerCode        string
 		PushPayEndpoint   string
+
+		// StatusEndpoint is the transaction status inquiry endpoint used by
+		// QueryStatus, it is optional.
+		StatusEndpoint string
+
+		// CallbackURL is the URL registered with tigo for push pay callbacks,
+		// it is not used by Client but by tools that serve CallbackServeHTTP.
+		CallbackURL string
 	}
 
 	CallbackHandler interface {
@@ -121,26 +156,58 @@ type (
 		*Config
 		base            *base.Client
 		CallbackHandler CallbackHandler
-		token           *string
-		tokenExpires    time.Time
 		rv              base.Receiver
 		rp              base.Replier
+		redactor        *redact.Redactor
+		limits          money.Limits
+		normalizer      msisdn.Normalizer
+		metrics         metrics.Recorder
+		tracer          trace.Tracer
+
+		tokenStore         TokenStore
+		tokenRefreshMargin time.Duration
+		refreshing         chan struct{}
+
+		waitersMu sync.Mutex
+		waiters   map[string]chan CallbackRequest
+
+		idempotency       IdempotencyStore
+		idempotencyWindow time.Duration
+		inflightMu        sync.Mutex
+		inflight          map[string]*payCall
+
+		mwMu        sync.RWMutex
+		middlewares []CallbackMiddleware
+
+		guard    *guard.Guard
+		timeouts Timeouts
+		events   events.Sink
+		machine  *StateMachine
 	}
 )
 
 func NewClient(config *Config, handler CallbackHandler, opts ...ClientOption) *Client {
 	client := &Client{
-		Config:          config,
-		CallbackHandler: handler,
-		token:           new(string),
-		tokenExpires:    time.Now(),
-		base:            base.NewClient(),
+		Config:             config,
+		CallbackHandler:    handler,
+		base:               base.NewClient(),
+		redactor:           redact.New(),
+		normalizer:         msisdn.Default,
+		metrics:            metrics.Noop,
+		tracer:             tracing.Tracer(nil, "push"),
+		tokenStore:         NewMemoryTokenStore(),
+		tokenRefreshMargin: defaultTokenRefreshMargin,
+		refreshing:         make(chan struct{}, 1),
+		timeouts:           DefaultTimeouts,
+		events:             events.Discard,
 	}
 
 	for _, opt := range opts {
 		opt(client)
 	}
 
+	client.base.Logger = client.redactor.Writer(client.base.Logger)
+	client.base.Http = recordStatus(client.base.Http)
 	lg, dm := client.base.Logger, client.base.DebugMode
 
 	client.rp = base.NewReplier(lg, dm)
@@ -157,64 +224,315 @@ func (handler CallbackHandlerFunc) Handle(ctx context.Context, request CallbackR
 	return handler(ctx, request)
 }
 
+// Pay sends a push pay request to tigo, the customer gets a ussd prompt to
+// confirm the payment and the result is posted later to CallbackServeHTTP.
+// With WithIdempotency a repeated ReferenceID returns the first response
+// instead of prompting the customer again, or ErrPaymentInProgress while
+// the outcome of the first request is not known. request.MSISDN is normalized
+// before it is sent, see WithMSISDNNormalizer.
 func (c *Client) Pay(ctx context.Context, request Request) (response PayResponse, err error) {
-	amount := math.Round(request.Amount*100)/100
+	ctx, span := c.tracer.Start(ctx, "tigopesa.push.pay",
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(tracing.ReferenceID.String(c.Config.BillerCode+request.ReferenceID)))
+
+	start := time.Now()
+	defer func() {
+		c.metrics.Observe(metrics.Pay, metrics.Code(response.ResponseCode, err), time.Since(start))
+		tracing.End(span, response.ResponseCode, err)
+	}()
+
+	ctx, cancel := timeout.With(ctx, c.timeouts.Pay)
+	defer cancel()
+
+	if c.idempotency != nil {
+		return c.payOnce(ctx, request)
+	}
+
+	return c.pay(ctx, request)
+}
+
+// Check returns request as Pay sends it, with its MSISDN normalized, or the
+// error Pay fails with before sending anything: an amount outside the limits
+// set with WithAmountLimits or an invalid MSISDN.
+func (c *Client) Check(request Request) (Request, error) {
+	if err := c.limits.Check(request.Amount); err != nil {
+		return request, err
+	}
+
+	normalized, err := c.normalizer.Normalize(request.MSISDN)
+	if err != nil {
+		return request, err
+	}
+	request.MSISDN = normalized
+
+	return request, nil
+}
+
+func (c *Client) pay(ctx context.Context, request Request) (response PayResponse, err error) {
 	var billPayReq = payRequest{
 		CustomerMSISDN: request.MSISDN,
 		BillerMSISDN:   c.BillerMSISDN,
-		Amount:         amount,
+		Amount:         request.Amount,
 		Remarks:        request.Remarks,
 		ReferenceID:    fmt.Sprintf("%s%s", c.Config.BillerCode, request.ReferenceID),
 	}
 
+	defer func() {
+		c.publishPay(ctx, billPayReq, response, err)
+	}()
+
+	checked, err := c.Check(request)
+	if err != nil {
+		return response, unsentError{err}
+	}
+	billPayReq.CustomerMSISDN = checked.MSISDN
+
+	if c.machine != nil {
+		if err := c.machine.Initiate(billPayReq.ReferenceID); err != nil {
+			return response, unsentError{err}
+		}
+	}
+
+	err = c.send(ctx, push, c.Config.PushPayEndpoint, billPayReq, &response)
+	if err != nil {
+		// a request that was sent may have prompted the customer all the
+		// same, its payment stays initiated until the callback arrives or
+		// it expires
+		if c.machine != nil && !sent(err) {
+			_ = c.machine.Fail(billPayReq.ReferenceID)
+		}
+		return response, err
+	}
+
+	if c.machine != nil {
+		_ = c.machine.Acknowledge(billPayReq.ReferenceID, response)
+	}
+
+	return response, nil
+}
+
+// accept claims the transition of the payment of callback when a
+// StateMachine is set, the error tells why the callback is rejected: the
+// payment is final, its callback is being handled or, unless the machine
+// was built WithUnknownReferences, it is not tracked. complete must be
+// called with the ref returned once the handler returned.
+func (c *Client) accept(callback CallbackRequest) (string, error) {
+	if c.machine == nil {
+		return "", nil
+	}
+
+	return c.machine.claim(c.references(callback.ReferenceID), callback)
+}
+
+// complete releases the claim of accept, the payment becomes final when the
+// handler took the callback.
+func (c *Client) complete(ref string, callback CallbackRequest, handled bool) {
+	if c.machine == nil {
+		return
+	}
+
+	c.machine.settle(ref, callback, handled)
+}
+
+// references returns the keys a callback with ref is looked up with: ref as
+// received and with the BillerCode prefix added.
+func (c *Client) references(ref string) []string {
+	return []string{ref, fmt.Sprintf("%s%s", c.Config.BillerCode, ref)}
+}
+
+// unsentError is the error of a request that never reached tigo or that
+// tigo turned down without processing it, see sent.
+type unsentError struct {
+	err error
+}
+
+func (e unsentError) Error() string {
+	return e.err.Error()
+}
+
+func (e unsentError) Unwrap() error {
+	return e.err
+}
+
+// sent reports whether tigo may have processed the request that failed
+// with err.
+func sent(err error) bool {
+	var unsent unsentError
+	return !errors.As(err, &unsent)
+}
+
+// rejected reports whether the push pay request that returned response and
+// err certainly did not prompt the customer: tigo turned it down, or it was
+// never sent for another reason than a payment with the same ReferenceID
+// being in progress.
+func rejected(response PayResponse, err error) bool {
+	switch {
+	case err == nil:
+		return !response.ResponseStatus
+	case !sent(err):
+		return !errors.Is(err, ErrPaymentInProgress)
+	default:
+		return response.ResponseCode != "" && !response.ResponseStatus
+	}
+}
+
+// send makes an authorized request to tigo. A request rejected with
+// http.StatusUnauthorized is sent once more with a freshly issued token,
+// whatever the body of the rejection is.
+func (c *Client) send(ctx context.Context, rt requestType, endpoint string, payload, v interface{}) error {
 	token, err := c.checkToken(ctx)
 	if err != nil {
-		return PayResponse{}, err
+		return unsentError{err}
+	}
+
+	statusCode, err := c.do(ctx, rt, endpoint, token, payload, v)
+	if statusCode != http.StatusUnauthorized {
+		return err
+	}
+
+	token, err = c.refreshToken(ctx, token)
+	if err != nil {
+		return unsentError{err}
 	}
 
+	_, err = c.do(ctx, rt, endpoint, token, payload, v)
+
+	return err
+}
+
+// do sends a single request and returns the status code of the response,
+// it is zero when no response arrived. A status code of 400 or above is
+// reported as ErrUnexpectedStatus, v is filled all the same when the body
+// could be decoded. A request answered with a 4xx status is not sent.
+func (c *Client) do(ctx context.Context, rt requestType, endpoint, token string, payload, v interface{}) (int, error) {
 	authHeader := map[string]string{
 		"Authorization": fmt.Sprintf("bearer %s", token),
 		"Username":      c.Config.Username,
 		"Password":      c.Config.Password,
 	}
+	tracing.Inject(ctx, authHeader)
+
 	var requestOpts []base.RequestOption
 	moreHeaderOpt := base.WithMoreHeaders(authHeader)
 	//basicAuth := base.WithBasicAuth(c.PushConfig.Username, c.PushConfig.Password)
 	requestOpts = append(requestOpts, moreHeaderOpt)
 
-	req := base.MakeInternalRequest(c.BaseURL, c.Config.PushPayEndpoint, push, billPayReq, requestOpts...)
-	_, err = c.base.Do(context.TODO(), req, &response)
+	req := base.MakeInternalRequest(c.BaseURL, endpoint, rt, payload, requestOpts...)
 
-	if err != nil {
-		return response, err
+	var statusCode int
+	res, err := c.base.Do(context.WithValue(ctx, statusKey{}, &statusCode), req, v)
+	if err == nil && res.Error != nil {
+		err = fmt.Errorf("%w: %d", ErrUnexpectedStatus, statusCode)
+	}
+	if err != nil && statusCode >= http.StatusBadRequest && statusCode < http.StatusInternalServerError {
+		// tigo turned the request down without processing it
+		return statusCode, unsentError{err}
 	}
 
-	return response, nil
+	return statusCode, err
+}
+
+// statusKey is the context key of the *int statusRecorder fills with the
+// status code of the response. base.Client drops the response when it can
+// not decode the body, the status code is needed all the same to tell a
+// rejected token apart.
+type statusKey struct{}
+
+type statusRecorder struct {
+	next http.RoundTripper
+}
+
+func (s statusRecorder) RoundTrip(r *http.Request) (*http.Response, error) {
+	res, err := s.next.RoundTrip(r)
+	if code, ok := r.Context().Value(statusKey{}).(*int); ok && res != nil {
+		*code = res.StatusCode
+	}
+
+	return res, err
+}
+
+// recordStatus returns a copy of hc whose transport records status codes,
+// hc itself is left untouched as it may be shared.
+func recordStatus(hc *http.Client) *http.Client {
+	if _, ok := hc.Transport.(statusRecorder); ok {
+		return hc
+	}
+
+	next := hc.Transport
+	if next == nil {
+		next = http.DefaultTransport
+	}
+
+	recorded := *hc
+	recorded.Transport = statusRecorder{next: next}
+
+	return &recorded
 }
 
 func (c *Client) CallbackServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if !c.guard.Allow(w, r, callback.String()) {
+		return
+	}
 
 	var (
-		callbackRequest CallbackRequest
+		callbackRequest  CallbackRequest
+		callbackResponse CallbackResponse
+		err              error
 	)
-	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+
+	ctx, span := c.tracer.Start(tracing.Extract(r), "tigopesa.push.callback",
+		trace.WithSpanKind(trace.SpanKindServer))
+
+	start := time.Now()
+	defer func() {
+		// a failed callback gets no CallbackResponse whatever the handler set
+		code := metrics.CodeError
+		if err == nil {
+			code = metrics.Code(callbackResponse.ResponseCode, nil)
+		}
+		c.metrics.Observe(metrics.Callback, code, time.Since(start))
+		tracing.End(span, code, err)
+	}()
+
+	ctx, cancel := timeout.With(ctx, c.timeouts.Callback)
 	defer cancel()
 	//callbackRequest := new(CallbackRequest)
 	statusCode := 200
 
-	_, err := c.rv.Receive(ctx, callback.String(), r, &callbackRequest)
+	_, err = c.rv.Receive(ctx, callback.String(), r, &callbackRequest)
 	if err != nil {
 		statusCode = http.StatusInternalServerError
 		http.Error(w, err.Error(), statusCode)
 		return
 	}
 
-	callbackResponse, err := c.CallbackHandler.Handle(ctx, callbackRequest)
+	span.SetAttributes(
+		tracing.ReferenceID.String(callbackRequest.ReferenceID),
+		tracing.TxnID.String(callbackRequest.MFSTransactionID),
+	)
 
-	if err != nil {
-		statusCode = http.StatusInternalServerError
-		http.Error(w, err.Error(), statusCode)
-		return
+	if ref, rejected := c.accept(callbackRequest); rejected != nil {
+		// the handler never sees callbacks the state machine rejects
+		callbackResponse = CallbackResponse{
+			ResponseCode:        FailureCode,
+			ResponseDescription: rejected.Error(),
+			ReferenceID:         callbackRequest.ReferenceID,
+		}
+	} else {
+		c.publishCallback(ctx, callbackRequest)
+
+		callbackResponse, err = c.handle(ctx, ref, callbackRequest)
+
+		if err != nil {
+			// the payment is not final yet so that tigo can deliver the
+			// callback again
+			statusCode = http.StatusInternalServerError
+			http.Error(w, err.Error(), statusCode)
+			return
+		}
+
+		// PayAndWait only returns once the handler took the callback
+		c.notify(callbackRequest)
 	}
 
 	var responseOpts []base.ResponseOption
@@ -229,41 +547,97 @@ func (c *Client) CallbackServeHTTP(w http.ResponseWriter, r *http.Request) {
 
 }
 
-func (c *Client) checkToken(ctx context.Context) (string, error) {
-	var token string
-	if *c.token == "" {
-		str, err := c.Token(ctx)
-		if err != nil {
-			return "", err
-		}
-		token = fmt.Sprintf("%s", str.AccessToken)
-	}
-	//Add Auth Header
-	if *c.token != "" {
-		if !c.tokenExpires.IsZero() && time.Until(c.tokenExpires) < (60*time.Second) {
-			if _, err := c.Token(ctx); err != nil {
-				return "", err
-			}
+// handle runs the callback handler and completes the payment claimed by
+// accept under ref, even when the handler panics.
+func (c *Client) handle(ctx context.Context, ref string, callback CallbackRequest) (response CallbackResponse, err error) {
+	handled := false
+	defer func() {
+		c.complete(ref, callback, handled)
+	}()
+
+	response, err = c.callbackHandler().Handle(ctx, callback)
+	handled = err == nil
+
+	return response, err
+}
+
+// MarshalJSON encodes the callback like encoding/json does, a malformed
+// RawAmount is written as Amount so that it survives being stored and
+// decoded again, e.g. by the outbox package.
+func (r CallbackRequest) MarshalJSON() ([]byte, error) {
+	type plain CallbackRequest
+	if _, err := money.Parse(r.RawAmount); r.RawAmount == "" || err == nil {
+		return json.Marshal(plain(r))
+	}
+
+	return json.Marshal(struct {
+		plain
+		Amount string `json:"Amount"`
+	}{plain: plain(r), Amount: r.RawAmount})
+}
+
+// UnmarshalJSON decodes the callback like encoding/json does except for
+// Amount, see RawAmount.
+func (r *CallbackRequest) UnmarshalJSON(data []byte) error {
+	type plain CallbackRequest
+	wire := struct {
+		*plain
+		Amount json.RawMessage `json:"Amount"`
+	}{plain: (*plain)(r)}
+
+	if err := json.Unmarshal(data, &wire); err != nil {
+		return err
+	}
+
+	r.RawAmount = strings.TrimSpace(string(wire.Amount))
+	if r.RawAmount == "null" {
+		r.RawAmount = ""
+	}
+	if strings.HasPrefix(r.RawAmount, `"`) {
+		if err := json.Unmarshal(wire.Amount, &r.RawAmount); err != nil {
+			return err
 		}
-		token = *c.token
 	}
 
-	return token, nil
+	r.Amount = money.Amount{}
+	if amount, err := money.Parse(r.RawAmount); err == nil {
+		r.Amount = amount
+	}
+
+	return nil
+}
+
+func acknowledge(_ context.Context, request CallbackRequest) (CallbackResponse, error) {
+	return CallbackResponse{
+		ResponseCode:        SuccessCode,
+		ResponseDescription: request.Description,
+		ResponseStatus:      request.Status,
+		ReferenceID:         request.ReferenceID,
+	}, nil
 }
 
-func (c *Client) Token(ctx context.Context) (TokenResponse, error) {
+func (c *Client) Token(ctx context.Context) (_ TokenResponse, err error) {
+	ctx, span := c.tracer.Start(ctx, "tigopesa.push.token", trace.WithSpanKind(trace.SpanKindClient))
+
+	start := time.Now()
+	defer func() {
+		c.metrics.Observe(metrics.Token, metrics.Code("", err), time.Since(start))
+		tracing.End(span, "", err)
+	}()
+
 	var form = url.Values{}
 	form.Set("username", c.Username)
 	form.Set("password", c.Password)
 	form.Set("grant_type", c.PasswordGrantType)
 
-	ctx, cancel := context.WithTimeout(ctx, time.Minute)
+	ctx, cancel := timeout.With(ctx, c.timeouts.Token)
 	defer cancel()
 
 	headers := map[string]string{
 		"Content-Type":  "application/x-www-form-urlencoded",
 		"Cache-Control": "no-cache",
 	}
+	tracing.Inject(ctx, headers)
 
 	var requestOptions []base.RequestOption
 	headersOption := base.WithRequestHeaders(headers)
@@ -273,20 +647,33 @@ func (c *Client) Token(ctx context.Context) (TokenResponse, error) {
 
 	var tokenResponse TokenResponse
 
-	_, err := c.base.Do(context.TODO(), request, &tokenResponse)
+	res, err := c.base.Do(ctx, request, &tokenResponse)
 
 	if err != nil {
 		return TokenResponse{}, err
 	}
 
-	token := tokenResponse.AccessToken
+	// a rejected request must not replace the token other clients use
+	if res.Error != nil {
+		return TokenResponse{}, fmt.Errorf("%w: %d", ErrUnexpectedStatus, res.StatusCode)
+	}
+
+	if tokenResponse.AccessToken == "" {
+		return TokenResponse{}, ErrNoAccessToken
+	}
 
-	c.token = &token
+	var expiresAt time.Time
+	if tokenResponse.ExpiresIn > 0 {
+		expiresAt = time.Now().Add(time.Duration(tokenResponse.ExpiresIn) * time.Second)
+	}
 
-	//This set the value to when a new token will set above will be expired
-	//the minus 10 is an overhead a margin for error.
-	tokenExpiresAt := time.Now().Add(time.Duration(tokenResponse.ExpiresIn-10) * time.Second)
-	c.tokenExpires = tokenExpiresAt
+	err = c.tokenStore.Store(ctx, Token{
+		AccessToken: tokenResponse.AccessToken,
+		ExpiresAt:   expiresAt,
+	})
+	if err != nil {
+		return TokenResponse{}, err
+	}
 
 	return tokenResponse, nil
 
@@ -29,8 +29,12 @@ import (
 	"bytes"
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"github.com/techcraftlabs/tigopesa/money"
 	"github.com/techcraftlabs/tigopesa/push"
+	"github.com/techcraftlabs/tigopesa/tigoerr"
+	"github.com/techcraftlabs/tigopesa/tigotest"
 	"net/http"
 	"net/http/httptest"
 	"testing"
@@ -76,7 +80,7 @@ func TestHealthCheckHandler(t *testing.T) {
 		Description:      "this is test",
 		MFSTransactionID: "TWTSVBSVBSGFSYA",
 		ReferenceID:      "WWTYTYW6W67WTW",
-		Amount:           "10000",
+		Amount:           money.Shillings(10000),
 	}
 
 	buf, _ := json.Marshal(reqPayload)
@@ -108,3 +112,79 @@ func TestHealthCheckHandler(t *testing.T) {
 			rr.Body.String(), expected)
 	}
 }
+
+func TestCallbackServeHTTP_Amount(t *testing.T) {
+	tests := []struct {
+		name   string
+		amount string
+		want   money.Amount
+		raw    string
+	}{
+		{"number", `1500.50`, money.MustParse("1500.50"), "1500.50"},
+		{"string", `"1500"`, money.Shillings(1500), "1500"},
+		{"empty", `""`, money.Amount{}, ""},
+		{"malformed", `"1,500"`, money.Amount{}, "1,500"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got push.CallbackRequest
+			handler := push.CallbackHandlerFunc(func(ctx context.Context, request push.CallbackRequest) (push.CallbackResponse, error) {
+				got = request
+				return push.CallbackResponse{ResponseCode: push.SuccessCode, ReferenceID: request.ReferenceID}, nil
+			})
+			client := push.NewClient(&push.Config{}, handler, push.WithDebugMode(false))
+
+			body := fmt.Sprintf(`{"Status":true,"Description":"ok","ReferenceID":"REF001","Amount":%s}`, tt.amount)
+			req := httptest.NewRequest(http.MethodPost, "/tigopesa/callback", bytes.NewBufferString(body))
+			req.Header.Set("Content-Type", "application/json")
+			rr := httptest.NewRecorder()
+
+			client.CallbackServeHTTP(rr, req)
+
+			if rr.Code != http.StatusOK {
+				t.Fatalf("status code: got %d want %d: %s", rr.Code, http.StatusOK, rr.Body)
+			}
+
+			if got.ReferenceID != "REF001" || got.Amount != tt.want || got.RawAmount != tt.raw {
+				t.Errorf("callback: got %+v want amount %s raw %q", got, tt.want, tt.raw)
+			}
+
+			// stored callbacks, e.g. in an outbox, keep the amount as sent
+			buf, err := json.Marshal(got)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var decoded push.CallbackRequest
+			if err := json.Unmarshal(buf, &decoded); err != nil || (tt.raw != "" && decoded != got) {
+				t.Errorf("round trip: got %+v %v want %+v", decoded, err, got)
+			}
+		})
+	}
+}
+
+func TestClient_PayAmountLimits(t *testing.T) {
+	server := tigotest.NewServer()
+	defer server.Close()
+
+	limits := money.Limits{Min: money.Shillings(500), Max: money.Shillings(1000000)}
+
+	client := push.NewClient(server.PushConfig(), nil, push.WithDebugMode(false), push.WithAmountLimits(limits))
+	_, err := client.Pay(context.Background(), push.Request{MSISDN: "255713123456", Amount: money.Shillings(100), ReferenceID: "ORDER1"})
+	if !errors.Is(err, tigoerr.ErrAmountTooLow) || len(server.PushRequests()) != 0 {
+		t.Errorf("pay: %v, %d requests sent", err, len(server.PushRequests()))
+	}
+}
+
+func TestClient_PayMSISDNNormalization(t *testing.T) {
+	server := tigotest.NewServer()
+	defer server.Close()
+
+	client := push.NewClient(server.PushConfig(), nil, push.WithDebugMode(false))
+	if _, err := client.Pay(context.Background(), push.Request{MSISDN: "0713 123 456", Amount: money.Shillings(1000), ReferenceID: "ORDER1"}); err != nil {
+		t.Fatal(err)
+	}
+	if pushes := server.PushRequests(); len(pushes) != 1 || pushes[0].CustomerMSISDN != "255713123456" {
+		t.Errorf("push requests: %+v", pushes)
+	}
+}
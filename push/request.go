@@ -9,6 +9,7 @@ const (
 	token requestType = iota
 	push
 	callback
+	status
 )
 
 func (r requestType) String() string {
@@ -37,6 +38,9 @@ func (r requestType) Name() string {
 	case callback:
 		return "callback"
 
+	case status:
+		return "status"
+
 	default:
 		return ""
 	}
@@ -51,7 +55,7 @@ func (r requestType) Group() string {
 	case token:
 		return "authorization"
 
-	case push:
+	case push, status:
 		return "collection"
 
 	case callback:
@@ -0,0 +1,434 @@
+/*
+ * MIT License
+ *
+ * Copyright (c) 2021 TechCraft Technologies Co. Ltd
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ *
+ */
+package push
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+const (
+	// StateInitiated is the state of a push pay request that is being sent
+	StateInitiated State = "initiated"
+
+	// StateAcknowledged means tigo accepted the request and prompted the
+	// customer, see PayResponse.ResponseStatus.
+	StateAcknowledged State = "acknowledged"
+
+	// StateSucceeded and StateFailed are final, they come from the
+	// callback or from tigo rejecting the request.
+	StateSucceeded State = "succeeded"
+	StateFailed    State = "failed"
+
+	// StateExpired means no callback arrived in time. A late callback
+	// still moves the payment to StateSucceeded or StateFailed.
+	StateExpired State = "expired"
+)
+
+const (
+	defaultExpiry    = 5 * time.Minute
+	defaultRetention = 24 * time.Hour
+)
+
+var (
+	// ErrUnknownReference is returned for a callback with a ReferenceID
+	// the StateMachine does not track.
+	ErrUnknownReference = errors.New("push: unknown reference")
+
+	// ErrInvalidTransition is matched by a *TransitionError
+	ErrInvalidTransition = errors.New("push: invalid state transition")
+
+	// ErrPaymentInProgress is returned when a ReferenceID is paid again
+	// before the previous payment with it reached a final state or expired.
+	ErrPaymentInProgress = errors.New("push: payment in progress")
+
+	// ErrAlreadyPaid is returned when a ReferenceID whose payment succeeded
+	// is paid again.
+	ErrAlreadyPaid = errors.New("push: payment already succeeded")
+)
+
+// transitions lists the states each state may move to. A callback may be
+// posted before the acknowledgement reaches the client, hence initiated to
+// succeeded and failed.
+var transitions = map[State][]State{
+	StateInitiated:    {StateAcknowledged, StateSucceeded, StateFailed, StateExpired},
+	StateAcknowledged: {StateSucceeded, StateFailed, StateExpired},
+	StateExpired:      {StateSucceeded, StateFailed},
+}
+
+type (
+	// State is where a push payment is in its life, see StateMachine
+	State string
+
+	// Transition is a change of state of the payment with ReferenceID
+	Transition struct {
+		ReferenceID string
+		From        State
+		To          State
+		Time        time.Time
+	}
+
+	// TransitionHook is called after every transition, in order and
+	// outside of any lock. It must not block.
+	TransitionHook func(transition Transition)
+
+	// TransitionError is returned for a transition the payment can not
+	// make, e.g. a second callback for a payment that already succeeded.
+	TransitionError struct {
+		ReferenceID string
+		From        State
+		To          State
+	}
+
+	// StateMachine tracks the state of push payments from the request to
+	// the callback. Payments expire when no callback arrives in time and
+	// final ones are remembered for a while so that repeated callbacks
+	// are rejected. Use it with WithStateMachine, it is kept in memory.
+	StateMachine struct {
+		mu        sync.Mutex
+		payments  map[string]*tracked
+		expiry    time.Duration
+		retention time.Duration
+		hooks     []TransitionHook
+		unknown   bool
+	}
+
+	// StateMachineOption is a setter func to set StateMachine details
+	StateMachineOption func(m *StateMachine)
+
+	// tracked is a payment, claimed is set while the handler of its
+	// callback runs. A payment with no state was not initiated by the
+	// StateMachine, its callback was accepted with WithUnknownReferences.
+	tracked struct {
+		state   State
+		timer   *time.Timer
+		claimed bool
+	}
+)
+
+// Status returns the Status reported for a payment in state s
+func (s State) Status() Status {
+	switch s {
+	case StateInitiated, StateAcknowledged:
+		return StatusPending
+	case StateSucceeded:
+		return StatusSuccess
+	case StateFailed:
+		return StatusFailed
+	default:
+		return StatusUnknown
+	}
+}
+
+// Final reports whether s will not change anymore
+func (s State) Final() bool {
+	return s == StateSucceeded || s == StateFailed
+}
+
+func (e *TransitionError) Error() string {
+	return fmt.Sprintf("push: %s can not move from %s to %s", e.ReferenceID, e.From, e.To)
+}
+
+func (e *TransitionError) Is(target error) bool {
+	return target == ErrInvalidTransition
+}
+
+// NewStateMachine returns a StateMachine that expires payments after 5
+// minutes without a callback and remembers final ones for 24 hours.
+func NewStateMachine(opts ...StateMachineOption) *StateMachine {
+	m := &StateMachine{
+		payments:  make(map[string]*tracked),
+		expiry:    defaultExpiry,
+		retention: defaultRetention,
+	}
+
+	for _, opt := range opts {
+		opt(m)
+	}
+
+	return m
+}
+
+// WithExpiry sets how long after it is initiated a payment expires if no
+// callback arrived, values of zero or less are ignored.
+func WithExpiry(expiry time.Duration) StateMachineOption {
+	return func(m *StateMachine) {
+		if expiry <= 0 {
+			return
+		}
+		m.expiry = expiry
+	}
+}
+
+// WithRetention sets how long payments are remembered once they are final
+// or expired, values of zero or less are ignored.
+func WithRetention(retention time.Duration) StateMachineOption {
+	return func(m *StateMachine) {
+		if retention <= 0 {
+			return
+		}
+		m.retention = retention
+	}
+}
+
+// WithTransitionHook adds hook to the hooks called after every transition
+func WithTransitionHook(hook TransitionHook) StateMachineOption {
+	return func(m *StateMachine) {
+		if hook == nil {
+			return
+		}
+		m.hooks = append(m.hooks, hook)
+	}
+}
+
+// WithUnknownReferences makes callbacks for references the StateMachine does
+// not track, e.g. of payments made before a restart, reach the handler
+// instead of being rejected with ErrUnknownReference.
+func WithUnknownReferences() StateMachineOption {
+	return func(m *StateMachine) {
+		m.unknown = true
+	}
+}
+
+// State returns the state of the payment with ref, ok is false when it is
+// not tracked.
+func (m *StateMachine) State(ref string) (state State, ok bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	p, ok := m.payments[ref]
+	if !ok || p.state == "" {
+		return "", false
+	}
+
+	return p.state, true
+}
+
+// Initiate starts tracking the payment with ref. A ref that is still being
+// paid, or whose callback is being handled, is rejected with
+// ErrPaymentInProgress and one that succeeded with ErrAlreadyPaid. One that
+// failed or expired starts over.
+func (m *StateMachine) Initiate(ref string) error {
+	m.mu.Lock()
+	from := State("")
+	if p, ok := m.payments[ref]; ok {
+		switch {
+		case p.claimed || p.state == StateInitiated || p.state == StateAcknowledged:
+			m.mu.Unlock()
+			return fmt.Errorf("%w: %s", ErrPaymentInProgress, ref)
+		case p.state == StateSucceeded:
+			m.mu.Unlock()
+			return fmt.Errorf("%w: %s", ErrAlreadyPaid, ref)
+		}
+		from = p.state
+		p.timer.Stop()
+	}
+
+	p := &tracked{state: StateInitiated}
+	p.timer = time.AfterFunc(m.expiry, func() { _ = m.move(ref, StateExpired, p) })
+	m.payments[ref] = p
+	m.mu.Unlock()
+
+	m.notify(Transition{ReferenceID: ref, From: from, To: StateInitiated, Time: time.Now()})
+
+	return nil
+}
+
+// Acknowledge records the reply of tigo to the request, the payment is
+// acknowledged when response.ResponseStatus is true and failed otherwise.
+// A payment whose callback arrived first keeps its state.
+func (m *StateMachine) Acknowledge(ref string, response PayResponse) error {
+	to := StateAcknowledged
+	if !response.ResponseStatus {
+		to = StateFailed
+	}
+
+	m.mu.Lock()
+	p, ok := m.payments[ref]
+	if ok && p.state.Final() && to == StateAcknowledged {
+		m.mu.Unlock()
+		return nil
+	}
+	m.mu.Unlock()
+
+	return m.move(ref, to, nil)
+}
+
+// Complete records the callback of the payment with ref
+func (m *StateMachine) Complete(ref string, callback CallbackRequest) error {
+	return m.move(ref, callbackState(callback), nil)
+}
+
+// claim reserves the transition the callback makes for the payment tracked
+// under the first of refs that is known, so that duplicates are rejected
+// while the handler runs. It returns the ref claimed, settle must be called
+// with it once the handler returned.
+func (m *StateMachine) claim(refs []string, callback CallbackRequest) (string, error) {
+	to := callbackState(callback)
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, ref := range refs {
+		p, ok := m.payments[ref]
+		if !ok {
+			continue
+		}
+		if p.claimed || !allowed(p.state, to) {
+			return ref, &TransitionError{ReferenceID: ref, From: p.state, To: to}
+		}
+		p.claimed = true
+		return ref, nil
+	}
+
+	ref := refs[0]
+	if !m.unknown {
+		return ref, fmt.Errorf("%w: %s", ErrUnknownReference, ref)
+	}
+
+	p := &tracked{claimed: true}
+	p.timer = time.AfterFunc(m.retention, func() { m.forget(ref, p) })
+	m.payments[ref] = p
+
+	return ref, nil
+}
+
+// settle releases the claim on ref, the payment moves to the state of the
+// callback when it was handled and stays where it was otherwise.
+func (m *StateMachine) settle(ref string, callback CallbackRequest, handled bool) {
+	to := callbackState(callback)
+
+	m.mu.Lock()
+	p, ok := m.payments[ref]
+	if !ok || !p.claimed {
+		m.mu.Unlock()
+		return
+	}
+	p.claimed = false
+
+	from := p.state
+	if !handled && from == "" {
+		p.timer.Stop()
+		delete(m.payments, ref)
+		m.mu.Unlock()
+		return
+	}
+
+	moved := handled && (from == "" || allowed(from, to))
+	if moved {
+		p.state = to
+	}
+	if moved || from.Final() || from == StateExpired {
+		// the retention timer may have fired during the claim
+		p.timer.Stop()
+		p.timer = time.AfterFunc(m.retention, func() { m.forget(ref, p) })
+	}
+	m.mu.Unlock()
+
+	if !moved {
+		return
+	}
+
+	m.notify(Transition{ReferenceID: ref, From: from, To: to, Time: time.Now()})
+}
+
+// Fail marks the payment with ref failed, e.g. because the request could not
+// be sent.
+func (m *StateMachine) Fail(ref string) error {
+	return m.move(ref, StateFailed, nil)
+}
+
+// Expire marks the payment with ref expired, it is called when no callback
+// arrived in time.
+func (m *StateMachine) Expire(ref string) error {
+	return m.move(ref, StateExpired, nil)
+}
+
+// move changes the state of the payment with ref to to. When expect is not
+// nil nothing happens unless ref is still tracked by expect, which keeps the
+// timers of a previous payment with the same ref from affecting this one.
+func (m *StateMachine) move(ref string, to State, expect *tracked) error {
+	m.mu.Lock()
+	p, ok := m.payments[ref]
+	if expect != nil && p != expect {
+		m.mu.Unlock()
+		return nil
+	}
+	if !ok {
+		m.mu.Unlock()
+		return fmt.Errorf("%w: %s", ErrUnknownReference, ref)
+	}
+
+	from := p.state
+	if !allowed(from, to) {
+		m.mu.Unlock()
+		return &TransitionError{ReferenceID: ref, From: from, To: to}
+	}
+
+	p.state = to
+	if to.Final() || to == StateExpired {
+		p.timer.Stop()
+		p.timer = time.AfterFunc(m.retention, func() { m.forget(ref, p) })
+	}
+	m.mu.Unlock()
+
+	m.notify(Transition{ReferenceID: ref, From: from, To: to, Time: time.Now()})
+
+	return nil
+}
+
+// forget stops tracking ref unless it has been initiated again meanwhile
+func (m *StateMachine) forget(ref string, p *tracked) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.payments[ref] == p && !p.claimed && (p.state.Final() || p.state == StateExpired || p.state == "") {
+		delete(m.payments, ref)
+	}
+}
+
+func (m *StateMachine) notify(transition Transition) {
+	for _, hook := range m.hooks {
+		hook(transition)
+	}
+}
+
+func callbackState(callback CallbackRequest) State {
+	if callback.Status {
+		return StateSucceeded
+	}
+	return StateFailed
+}
+
+func allowed(from, to State) bool {
+	for _, state := range transitions[from] {
+		if state == to {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,317 @@
+/*
+ * MIT License
+ *
+ * Copyright (c) 2021 TechCraft Technologies Co. Ltd
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ *
+ */
+package push_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/techcraftlabs/tigopesa/money"
+	"github.com/techcraftlabs/tigopesa/push"
+	"github.com/techcraftlabs/tigopesa/tigotest"
+)
+
+func TestStateMachine(t *testing.T) {
+	var (
+		mu   sync.Mutex
+		seen []push.Transition
+	)
+	m := push.NewStateMachine(
+		push.WithExpiry(50*time.Millisecond),
+		push.WithTransitionHook(func(transition push.Transition) {
+			mu.Lock()
+			defer mu.Unlock()
+			seen = append(seen, transition)
+		}))
+
+	if err := m.Complete("UNKNOWN", push.CallbackRequest{Status: true}); !errors.Is(err, push.ErrUnknownReference) {
+		t.Errorf("unknown reference: %v", err)
+	}
+
+	if err := m.Initiate("REF1"); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.Initiate("REF1"); !errors.Is(err, push.ErrPaymentInProgress) {
+		t.Errorf("initiate twice: %v", err)
+	}
+	if err := m.Acknowledge("REF1", push.PayResponse{ResponseStatus: true}); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.Complete("REF1", push.CallbackRequest{Status: true}); err != nil {
+		t.Fatal(err)
+	}
+
+	err := m.Complete("REF1", push.CallbackRequest{Status: false})
+	var transitionErr *push.TransitionError
+	if !errors.As(err, &transitionErr) || !errors.Is(err, push.ErrInvalidTransition) ||
+		transitionErr.From != push.StateSucceeded || transitionErr.To != push.StateFailed {
+		t.Errorf("second callback: %v", err)
+	}
+	if state, _ := m.State("REF1"); state != push.StateSucceeded || state.Status() != push.StatusSuccess {
+		t.Errorf("state after second callback: %s", state)
+	}
+	if err := m.Initiate("REF1"); !errors.Is(err, push.ErrAlreadyPaid) {
+		t.Errorf("initiate after success: %v", err)
+	}
+
+	// a payment without callback expires, a late callback still settles it
+	if err := m.Initiate("REF2"); err != nil {
+		t.Fatal(err)
+	}
+	_ = m.Acknowledge("REF2", push.PayResponse{ResponseStatus: true})
+	deadline := time.Now().Add(5 * time.Second)
+	for state, _ := m.State("REF2"); state != push.StateExpired; state, _ = m.State("REF2") {
+		if time.Now().After(deadline) {
+			t.Fatalf("REF2 did not expire, state %s", state)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if err := m.Complete("REF2", push.CallbackRequest{Status: false}); err != nil {
+		t.Errorf("late callback: %v", err)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	want := []push.State{
+		push.StateInitiated, push.StateAcknowledged, push.StateSucceeded,
+		push.StateInitiated, push.StateAcknowledged, push.StateExpired, push.StateFailed,
+	}
+	if len(seen) != len(want) {
+		t.Fatalf("transitions: %+v", seen)
+	}
+	for i, state := range want {
+		if seen[i].To != state {
+			t.Errorf("transition %d: got %s want %s", i, seen[i].To, state)
+		}
+	}
+	if seen[1].From != push.StateInitiated || seen[1].ReferenceID != "REF1" {
+		t.Errorf("transition 1: %+v", seen[1])
+	}
+}
+
+func TestClient_StateMachine(t *testing.T) {
+	server := tigotest.NewServer()
+	defer server.Close()
+
+	var (
+		handled []string
+		failing = true
+	)
+	handler := push.CallbackHandlerFunc(func(ctx context.Context, request push.CallbackRequest) (push.CallbackResponse, error) {
+		handled = append(handled, request.ReferenceID)
+		if failing {
+			failing = false
+			return push.CallbackResponse{}, errors.New("handler down")
+		}
+		return push.CallbackResponse{ResponseCode: push.SuccessCode, ReferenceID: request.ReferenceID}, nil
+	})
+	m := push.NewStateMachine()
+	client := push.NewClient(server.PushConfig(), handler, push.WithDebugMode(false), push.WithStateMachine(m))
+
+	server.ScriptPush(tigotest.Outcome{NoCallback: true})
+	request := push.Request{MSISDN: "255713123456", Amount: money.Shillings(1000), ReferenceID: "ORDER1"}
+	if _, err := client.Pay(context.Background(), request); err != nil {
+		t.Fatal(err)
+	}
+	ref := tigotest.BillerCode + "ORDER1"
+	if state, _ := m.State(ref); state != push.StateAcknowledged {
+		t.Fatalf("state after pay: %s", state)
+	}
+
+	if _, err := client.Pay(context.Background(), request); !errors.Is(err, push.ErrPaymentInProgress) {
+		t.Errorf("paying again: %v", err)
+	}
+
+	// a callback the handler fails on leaves the payment open for tigo to
+	// deliver it again
+	callback := push.CallbackRequest{Status: true, ReferenceID: ref, MFSTransactionID: "MFS1", Amount: money.Shillings(1000)}
+	if code := postCallbackStatus(client, callback); code != http.StatusInternalServerError {
+		t.Errorf("failed callback: status %d", code)
+	}
+	if state, _ := m.State(ref); state != push.StateAcknowledged {
+		t.Errorf("state after failed callback: %s", state)
+	}
+	if res := postCallback(t, client, callback); res.ResponseCode != push.SuccessCode {
+		t.Errorf("callback: %+v", res)
+	}
+	if res := postCallback(t, client, callback); res.ResponseCode != push.FailureCode {
+		t.Errorf("second callback: %+v", res)
+	}
+
+	// references the state machine does not know are rejected
+	unknown := callback
+	unknown.ReferenceID = tigotest.BillerCode + "UNKNOWN"
+	if res := postCallback(t, client, unknown); res.ResponseCode != push.FailureCode {
+		t.Errorf("unknown callback: %+v", res)
+	}
+
+	// the payment succeeded, paying it again would prompt the customer twice
+	if _, err := client.Pay(context.Background(), request); !errors.Is(err, push.ErrAlreadyPaid) {
+		t.Errorf("paying a paid reference: %v", err)
+	}
+
+	if want := []string{ref, ref}; !reflect.DeepEqual(handled, want) {
+		t.Errorf("handled %v want %v", handled, want)
+	}
+	if state, _ := m.State(ref); state != push.StateSucceeded {
+		t.Errorf("final state: %s", state)
+	}
+}
+
+func TestClient_StateMachineUnknownReferences(t *testing.T) {
+	server := tigotest.NewServer()
+	defer server.Close()
+
+	handled := 0
+	handler := push.CallbackHandlerFunc(func(ctx context.Context, request push.CallbackRequest) (push.CallbackResponse, error) {
+		handled++
+		return push.CallbackResponse{ResponseCode: push.SuccessCode, ReferenceID: request.ReferenceID}, nil
+	})
+	m := push.NewStateMachine(push.WithUnknownReferences())
+	client := push.NewClient(server.PushConfig(), handler, push.WithDebugMode(false), push.WithStateMachine(m))
+
+	// payments made before a restart reach the handler once
+	callback := push.CallbackRequest{Status: true, ReferenceID: tigotest.BillerCode + "ORDER1", Amount: money.Shillings(1000)}
+	if res := postCallback(t, client, callback); res.ResponseCode != push.SuccessCode {
+		t.Errorf("unknown callback: %+v", res)
+	}
+	if res := postCallback(t, client, callback); res.ResponseCode != push.FailureCode {
+		t.Errorf("second unknown callback: %+v", res)
+	}
+	if handled != 1 {
+		t.Errorf("handler called %d times want 1", handled)
+	}
+	if state, _ := m.State(callback.ReferenceID); state != push.StateSucceeded {
+		t.Errorf("state: %s", state)
+	}
+}
+
+func TestClient_StateMachineConcurrentCallbacks(t *testing.T) {
+	server := tigotest.NewServer()
+	defer server.Close()
+
+	var (
+		handled int32
+		entered = make(chan struct{})
+		release = make(chan struct{})
+	)
+	handler := push.CallbackHandlerFunc(func(ctx context.Context, request push.CallbackRequest) (push.CallbackResponse, error) {
+		if atomic.AddInt32(&handled, 1) == 1 {
+			close(entered)
+			<-release
+		}
+		return push.CallbackResponse{ResponseCode: push.SuccessCode, ReferenceID: request.ReferenceID}, nil
+	})
+	m := push.NewStateMachine()
+	client := push.NewClient(server.PushConfig(), handler, push.WithDebugMode(false), push.WithStateMachine(m))
+
+	server.ScriptPush(tigotest.Outcome{NoCallback: true})
+	if _, err := client.Pay(context.Background(), push.Request{MSISDN: "255713123456", Amount: money.Shillings(1000), ReferenceID: "ORDER1"}); err != nil {
+		t.Fatal(err)
+	}
+
+	// the callback is looked up with the biller code prefix too
+	callback := push.CallbackRequest{Status: true, ReferenceID: "ORDER1", Amount: money.Shillings(1000)}
+	first := make(chan int, 1)
+	go func() { first <- postCallbackStatus(client, callback) }()
+	<-entered
+
+	// a duplicate posted while the first is being handled is rejected
+	if res := postCallback(t, client, callback); res.ResponseCode != push.FailureCode {
+		t.Errorf("concurrent duplicate: %+v", res)
+	}
+	close(release)
+	if code := <-first; code != http.StatusOK {
+		t.Errorf("first callback: status %d", code)
+	}
+
+	if n := atomic.LoadInt32(&handled); n != 1 {
+		t.Errorf("handler called %d times want 1", n)
+	}
+	if state, _ := m.State(tigotest.BillerCode + "ORDER1"); state != push.StateSucceeded {
+		t.Errorf("state: %s", state)
+	}
+}
+
+func TestClient_StateMachineNotSent(t *testing.T) {
+	server := tigotest.NewServer()
+	defer server.Close()
+
+	m := push.NewStateMachine()
+	client := push.NewClient(server.PushConfig(), nil, push.WithDebugMode(false), push.WithStateMachine(m))
+
+	// no token, no request: the payment fails and can be made again
+	server.ScriptToken(tigotest.Outcome{HTTPStatus: http.StatusInternalServerError})
+	request := push.Request{MSISDN: "255713123456", Amount: money.Shillings(1000), ReferenceID: "ORDER1"}
+	if _, err := client.Pay(context.Background(), request); err == nil {
+		t.Fatal("pay without a token succeeded")
+	}
+	ref := tigotest.BillerCode + "ORDER1"
+	if state, _ := m.State(ref); state != push.StateFailed {
+		t.Errorf("state after failed send: %s", state)
+	}
+
+	server.ScriptPush(tigotest.Outcome{NoCallback: true})
+	if _, err := client.Pay(context.Background(), request); err != nil {
+		t.Errorf("paying again: %v", err)
+	}
+	if state, _ := m.State(ref); state != push.StateAcknowledged {
+		t.Errorf("state after paying again: %s", state)
+	}
+}
+
+func postCallback(t *testing.T, client *push.Client, request push.CallbackRequest) push.CallbackResponse {
+	t.Helper()
+	body, _ := json.Marshal(request)
+	req := httptest.NewRequest(http.MethodPost, "/callback", bytes.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	rec := httptest.NewRecorder()
+	client.CallbackServeHTTP(rec, req)
+
+	var response push.CallbackResponse
+	if err := json.NewDecoder(rec.Body).Decode(&response); err != nil {
+		t.Fatalf("decode callback response: %v", err)
+	}
+	return response
+}
+
+func postCallbackStatus(client *push.Client, request push.CallbackRequest) int {
+	body, _ := json.Marshal(request)
+	req := httptest.NewRequest(http.MethodPost, "/callback", bytes.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	rec := httptest.NewRecorder()
+	client.CallbackServeHTTP(rec, req)
+
+	return rec.Code
+}
@@ -0,0 +1,158 @@
+/*
+ * MIT License
+ *
+ * Copyright (c) 2021 TechCraft Technologies Co. Ltd
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ *
+ */
+
+package push
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/techcraftlabs/tigopesa/internal/timeout"
+	"github.com/techcraftlabs/tigopesa/internal/tracing"
+	"github.com/techcraftlabs/tigopesa/metrics"
+	"github.com/techcraftlabs/tigopesa/money"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const (
+	StatusPending Status = "pending"
+	StatusSuccess Status = "success"
+	StatusFailed  Status = "failed"
+	StatusUnknown Status = "unknown"
+)
+
+// ErrStatusNotConfigured is returned by QueryStatus when Config.StatusEndpoint
+// is not set.
+var ErrStatusNotConfigured = errors.New("push: status endpoint not configured")
+
+type (
+	// Status is the normalized state of a push pay transaction
+	Status string
+
+	statusRequest struct {
+		BillerMSISDN string `json:"BillerMSISDN"`
+		ReferenceID  string `json:"ReferenceID"`
+	}
+
+	// StatusResponse is the reply of the transaction status inquiry.
+	// TransactionStatus carries the state of the transaction, the
+	// Response* fields describe the inquiry itself.
+	StatusResponse struct {
+		ResponseCode        string       `json:"ResponseCode"`
+		ResponseStatus      bool         `json:"ResponseStatus"`
+		ResponseDescription string       `json:"ResponseDescription"`
+		ReferenceID         string       `json:"ReferenceID"`
+		MFSTransactionID    string       `json:"MFSTransactionID,omitempty"`
+		Amount              money.Amount `json:"Amount"`
+		TransactionStatus   string       `json:"TransactionStatus"`
+	}
+)
+
+// Final reports whether s will not change anymore
+func (s Status) Final() bool {
+	return s == StatusSuccess || s == StatusFailed
+}
+
+// ParseStatus normalizes the transaction status names used by tigo, names
+// it does not know are StatusUnknown.
+func ParseStatus(name string) Status {
+	switch strings.ToLower(strings.TrimSpace(name)) {
+	case "success", "successful", "succeeded", "completed", "complete":
+		return StatusSuccess
+
+	case "pending", "initiated", "processing", "in_progress", "in progress":
+		return StatusPending
+
+	case "fail", "failed", "failure", "rejected", "cancelled", "canceled", "expired", "timeout":
+		return StatusFailed
+
+	default:
+		return StatusUnknown
+	}
+}
+
+// QueryStatus asks tigo for the state of the push pay request with the given
+// ReferenceID. Use it when a callback is overdue. referenceID is the one
+// passed to Pay, the BillerCode prefix is added the same way Pay adds it.
+//
+// A final status fills PayResult.Callback as if the callback had arrived,
+// so the result can be handled the same way as the one of PayAndWait. When
+// tigo does not know the reference the status is StatusUnknown.
+func (c *Client) QueryStatus(ctx context.Context, referenceID string) (result PayResult, err error) {
+	ref := fmt.Sprintf("%s%s", c.Config.BillerCode, referenceID)
+
+	ctx, span := c.tracer.Start(ctx, "tigopesa.push.status",
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(tracing.ReferenceID.String(ref)))
+
+	var response StatusResponse
+	start := time.Now()
+	defer func() {
+		c.metrics.Observe(metrics.Status, metrics.Code(response.ResponseCode, err), time.Since(start))
+		tracing.End(span, response.ResponseCode, err)
+	}()
+
+	ctx, cancel := timeout.With(ctx, c.timeouts.Status)
+	defer cancel()
+
+	result = PayResult{
+		ReferenceID: ref,
+		Status:      StatusUnknown,
+	}
+
+	if c.Config.StatusEndpoint == "" {
+		return result, ErrStatusNotConfigured
+	}
+
+	request := statusRequest{
+		BillerMSISDN: c.BillerMSISDN,
+		ReferenceID:  ref,
+	}
+
+	if err = c.send(ctx, status, c.Config.StatusEndpoint, request, &response); err != nil {
+		return result, err
+	}
+
+	result.Inquiry = &response
+	if !response.ResponseStatus {
+		return result, nil
+	}
+
+	result.Status = ParseStatus(response.TransactionStatus)
+	if result.Status.Final() {
+		result.Callback = CallbackRequest{
+			Status:           result.Status == StatusSuccess,
+			Description:      response.ResponseDescription,
+			MFSTransactionID: response.MFSTransactionID,
+			ReferenceID:      ref,
+			Amount:           response.Amount,
+		}
+	}
+
+	return result, nil
+}
@@ -0,0 +1,99 @@
+/*
+ * MIT License
+ *
+ * Copyright (c) 2021 TechCraft Technologies Co. Ltd
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ *
+ */
+
+package push_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/techcraftlabs/tigopesa/money"
+	"github.com/techcraftlabs/tigopesa/push"
+	"github.com/techcraftlabs/tigopesa/tigotest"
+)
+
+func TestClient_QueryStatus(t *testing.T) {
+	server := tigotest.NewServer()
+	defer server.Close()
+
+	client := push.NewClient(server.PushConfig(), nil, push.WithDebugMode(false))
+	ctx := context.Background()
+
+	server.ScriptPush(
+		tigotest.Outcome{NoCallback: true, CallbackDelay: 200 * time.Millisecond},
+		tigotest.Outcome{NoCallback: true, CallbackFailed: true},
+		tigotest.Outcome{NoCallback: true},
+	)
+
+	// the last reference starts with the biller code, it is prefixed all the same
+	for _, ref := range []string{"REF001", "REF002", tigotest.BillerCode + "REF003"} {
+		res, err := client.Pay(ctx, push.Request{MSISDN: "255713123456", Amount: money.Shillings(1000), ReferenceID: ref})
+		if err != nil || !res.ResponseStatus {
+			t.Fatalf("pay %s: %+v %v", ref, res, err)
+		}
+	}
+
+	result, err := client.QueryStatus(ctx, "REF001")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Status != push.StatusPending || result.ReferenceID != tigotest.BillerCode+"REF001" {
+		t.Errorf("before completion: got %+v", result)
+	}
+
+	time.Sleep(300 * time.Millisecond)
+
+	result, err = client.QueryStatus(ctx, "REF001")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !result.Succeeded() || result.Callback.MFSTransactionID == "" || result.Callback.Amount != money.Shillings(1000) {
+		t.Errorf("after completion: got %+v", result)
+	}
+
+	result, err = client.QueryStatus(ctx, "REF002")
+	if err != nil || result.Status != push.StatusFailed || result.Callback.Status {
+		t.Errorf("failed payment: got %+v %v", result, err)
+	}
+
+	result, err = client.QueryStatus(ctx, tigotest.BillerCode+"REF003")
+	if err != nil || result.Status == push.StatusUnknown || result.ReferenceID != tigotest.BillerCode+tigotest.BillerCode+"REF003" {
+		t.Errorf("reference starting with the biller code: got %+v %v", result, err)
+	}
+
+	result, err = client.QueryStatus(ctx, "MISSING")
+	if err != nil || result.Status != push.StatusUnknown {
+		t.Errorf("unknown reference: got %+v %v", result, err)
+	}
+
+	config := server.PushConfig()
+	config.StatusEndpoint = ""
+	client = push.NewClient(config, nil, push.WithDebugMode(false))
+	if _, err := client.QueryStatus(ctx, "REF001"); !errors.Is(err, push.ErrStatusNotConfigured) {
+		t.Errorf("no endpoint: got %v", err)
+	}
+}
@@ -0,0 +1,58 @@
+/*
+ * MIT License
+ *
+ * Copyright (c) 2021 TechCraft Technologies Co. Ltd
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ *
+ */
+package push
+
+import (
+	"time"
+
+	"github.com/techcraftlabs/tigopesa/internal/timeout"
+)
+
+// Timeouts bounds each push pay operation, the caller's context still
+// applies on top of them. A zero field keeps the default from
+// DefaultTimeouts, a negative one leaves the operation bounded only by its
+// context.
+type Timeouts struct {
+	// Token bounds a single access token request.
+	Token time.Duration
+
+	// Pay bounds Pay, including the token request it may need.
+	Pay time.Duration
+
+	// Status bounds QueryStatus.
+	Status time.Duration
+
+	// Callback bounds the CallbackHandler, its context is derived from the
+	// context of the incoming http.Request.
+	Callback time.Duration
+}
+
+// DefaultTimeouts are the Timeouts used unless WithTimeouts says otherwise.
+var DefaultTimeouts = Timeouts{
+	Token:    timeout.Default,
+	Pay:      timeout.Default,
+	Status:   timeout.Default,
+	Callback: timeout.Default,
+}
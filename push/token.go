@@ -0,0 +1,331 @@
+/*
+ * MIT License
+ *
+ * Copyright (c) 2021 TechCraft Technologies Co. Ltd
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ *
+ */
+
+package push
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+const (
+	defaultTokenRefreshMargin = 60 * time.Second
+	// defaultStaleLockAfter stays well above DefaultTimeouts.Token so that
+	// the lock of a slow but live holder is not taken over, see
+	// WithStaleLockAfter
+	defaultStaleLockAfter = 2 * time.Minute
+	lockPollInterval      = 50 * time.Millisecond
+)
+
+var (
+	_ TokenStore  = (*memoryTokenStore)(nil)
+	_ TokenStore  = (*FileTokenStore)(nil)
+	_ TokenLocker = (*FileTokenStore)(nil)
+)
+
+type (
+	// Token is the access token issued by tigo together with the time
+	// it stops being valid. A zero ExpiresAt means tigo did not say when
+	// the token expires, such a token is used until tigo rejects it.
+	Token struct {
+		AccessToken string    `json:"access_token"`
+		ExpiresAt   time.Time `json:"expires_at"`
+	}
+
+	// TokenStore keeps the access token used by Client. Load returns a zero
+	// Token and a nil error when there is no token stored yet.
+	//
+	// A single TokenStore can be shared by many Client instances. Stores that
+	// are shared between processes should also implement TokenLocker so that
+	// only one of the processes asks tigo for a new token at a time.
+	TokenStore interface {
+		Load(ctx context.Context) (Token, error)
+		Store(ctx context.Context, token Token) error
+	}
+
+	// TokenLocker is implemented by a TokenStore that can serialize token
+	// refreshes across processes. Lock blocks until the lock is acquired or
+	// ctx is done, the returned func releases the lock.
+	TokenLocker interface {
+		Lock(ctx context.Context) (unlock func(), err error)
+	}
+
+	memoryTokenStore struct {
+		mu    sync.RWMutex
+		token Token
+	}
+
+	// FileTokenStore is a TokenStore that keeps the token in a json file so
+	// that several processes on the same host can share a single token.
+	// Refreshes are serialized using a lock file that sits next to the token
+	// file.
+	FileTokenStore struct {
+		path       string
+		lockPath   string
+		staleAfter time.Duration
+		mu         sync.Mutex
+	}
+
+	// FileTokenStoreOption configures a FileTokenStore
+	FileTokenStoreOption func(f *FileTokenStore)
+)
+
+// NewMemoryTokenStore returns a TokenStore that keeps the token in memory.
+// It is the default TokenStore used by Client.
+func NewMemoryTokenStore() TokenStore {
+	return &memoryTokenStore{}
+}
+
+func (m *memoryTokenStore) Load(_ context.Context) (Token, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return m.token, nil
+}
+
+func (m *memoryTokenStore) Store(_ context.Context, token Token) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.token = token
+	return nil
+}
+
+// NewFileTokenStore returns a FileTokenStore that keeps the token at path.
+func NewFileTokenStore(path string, opts ...FileTokenStoreOption) *FileTokenStore {
+	f := &FileTokenStore{
+		path:       path,
+		lockPath:   path + ".lock",
+		staleAfter: defaultStaleLockAfter,
+	}
+
+	for _, opt := range opts {
+		opt(f)
+	}
+
+	return f
+}
+
+// WithStaleLockAfter sets how old a lock file must be before it is
+// considered abandoned by a crashed process, 2 minutes by default. It must
+// stay above the Token timeout of every client sharing the store, see
+// Timeouts, or the lock of a slow refresh is taken over. Values of zero or
+// less are ignored.
+func WithStaleLockAfter(d time.Duration) FileTokenStoreOption {
+	return func(f *FileTokenStore) {
+		if d <= 0 {
+			return
+		}
+		f.staleAfter = d
+	}
+}
+
+func (f *FileTokenStore) Load(_ context.Context) (Token, error) {
+	var token Token
+	buf, err := os.ReadFile(f.path)
+	if errors.Is(err, os.ErrNotExist) {
+		return Token{}, nil
+	}
+	if err != nil {
+		return Token{}, err
+	}
+
+	if len(buf) == 0 {
+		return Token{}, nil
+	}
+
+	if err := json.Unmarshal(buf, &token); err != nil {
+		return Token{}, fmt.Errorf("push: invalid token file %s: %w", f.path, err)
+	}
+
+	return token, nil
+}
+
+// Store writes the token to a temporary file first and then renames it
+// so that readers never see a partially written token.
+func (f *FileTokenStore) Store(_ context.Context, token Token) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	buf, err := json.Marshal(token)
+	if err != nil {
+		return err
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(f.path), filepath.Base(f.path)+".*.tmp")
+	if err != nil {
+		return err
+	}
+
+	if _, err := tmp.Write(buf); err != nil {
+		_ = tmp.Close()
+		_ = os.Remove(tmp.Name())
+		return err
+	}
+
+	if err := tmp.Close(); err != nil {
+		_ = os.Remove(tmp.Name())
+		return err
+	}
+
+	return os.Rename(tmp.Name(), f.path)
+}
+
+// Lock creates the lock file exclusively, waiting for other holders to
+// release it. A lock file older than the time set with WithStaleLockAfter
+// is considered abandoned by a crashed process and is removed. The lock file holds a token unique
+// to this holder, unlock leaves the file alone when it was taken over in
+// the meantime.
+func (f *FileTokenStore) Lock(ctx context.Context) (func(), error) {
+	owner, err := lockOwner()
+	if err != nil {
+		return nil, err
+	}
+
+	for {
+		file, err := os.OpenFile(f.lockPath, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0o600)
+		if err == nil {
+			_, err = file.Write(owner)
+			if cerr := file.Close(); err == nil {
+				err = cerr
+			}
+			if err != nil {
+				_ = os.Remove(f.lockPath)
+				return nil, err
+			}
+
+			return func() {
+				f.unlock(owner)
+			}, nil
+		}
+
+		if !errors.Is(err, os.ErrExist) {
+			return nil, err
+		}
+
+		if info, err := os.Stat(f.lockPath); err == nil && time.Since(info.ModTime()) > f.staleAfter {
+			_ = os.Remove(f.lockPath)
+			continue
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(lockPollInterval):
+		}
+	}
+}
+
+// unlock removes the lock file if it still belongs to owner
+func (f *FileTokenStore) unlock(owner []byte) {
+	buf, err := os.ReadFile(f.lockPath)
+	if err != nil || !bytes.Equal(buf, owner) {
+		return
+	}
+
+	_ = os.Remove(f.lockPath)
+}
+
+func lockOwner() ([]byte, error) {
+	buf := make([]byte, 16)
+	if _, err := rand.Read(buf); err != nil {
+		return nil, err
+	}
+
+	return []byte(hex.EncodeToString(buf)), nil
+}
+
+// valid reports whether token can still be used without asking
+// tigo for a new one.
+func (c *Client) valid(token Token) bool {
+	if token.AccessToken == "" {
+		return false
+	}
+
+	if token.ExpiresAt.IsZero() {
+		return true
+	}
+
+	return time.Until(token.ExpiresAt) > c.tokenRefreshMargin
+}
+
+// checkToken returns the stored token if it is still valid otherwise
+// it asks tigo for a new one.
+func (c *Client) checkToken(ctx context.Context) (string, error) {
+	token, err := c.tokenStore.Load(ctx)
+	if err != nil {
+		return "", err
+	}
+
+	if c.valid(token) {
+		return token.AccessToken, nil
+	}
+
+	return c.refreshToken(ctx, token.AccessToken)
+}
+
+// refreshToken asks tigo for a new token to replace stale. Only one refresh
+// runs at a time, callers that were waiting for it reuse the token it fetched
+// instead of asking tigo again. Waiting for the refresh of another caller
+// stops when ctx is done.
+func (c *Client) refreshToken(ctx context.Context, stale string) (string, error) {
+	select {
+	case c.refreshing <- struct{}{}:
+	case <-ctx.Done():
+		return "", ctx.Err()
+	}
+	defer func() { <-c.refreshing }()
+
+	if locker, ok := c.tokenStore.(TokenLocker); ok {
+		unlock, err := locker.Lock(ctx)
+		if err != nil {
+			return "", err
+		}
+		defer unlock()
+	}
+
+	token, err := c.tokenStore.Load(ctx)
+	if err != nil {
+		return "", err
+	}
+
+	if token.AccessToken != stale && c.valid(token) {
+		return token.AccessToken, nil
+	}
+
+	res, err := c.Token(ctx)
+	if err != nil {
+		return "", err
+	}
+
+	return res.AccessToken, nil
+}
@@ -0,0 +1,337 @@
+/*
+ * MIT License
+ *
+ * Copyright (c) 2021 TechCraft Technologies Co. Ltd
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ *
+ */
+
+package push_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/techcraftlabs/tigopesa/money"
+	"github.com/techcraftlabs/tigopesa/push"
+)
+
+// tokenServer issues a new token on every token request and rejects pay
+// requests that do not carry the latest token when strict is set.
+type tokenServer struct {
+	issued int32
+	strict bool
+	plain  bool
+	latest atomic.Value
+}
+
+func (s *tokenServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	switch r.URL.Path {
+	case "/token":
+		n := atomic.AddInt32(&s.issued, 1)
+		token := fmt.Sprintf("token-%d", n)
+		s.latest.Store(token)
+		time.Sleep(20 * time.Millisecond)
+		_ = json.NewEncoder(w).Encode(push.TokenResponse{
+			AccessToken: token,
+			TokenType:   "bearer",
+			ExpiresIn:   3600,
+		})
+
+	case "/push":
+		auth := strings.TrimPrefix(r.Header.Get("Authorization"), "bearer ")
+		if s.strict && auth != s.latest.Load() {
+			if s.plain {
+				http.Error(w, "Unauthorized", http.StatusUnauthorized)
+				return
+			}
+			w.WriteHeader(http.StatusUnauthorized)
+			_, _ = w.Write([]byte(`{"ResponseCode":"BILLER-30-3030-E","ResponseStatus":false}`))
+			return
+		}
+		_ = json.NewEncoder(w).Encode(push.PayResponse{
+			ResponseCode:   push.SuccessCode,
+			ResponseStatus: true,
+		})
+	}
+}
+
+func newTokenTestClient(url string, opts ...push.ClientOption) *push.Client {
+	conf := &push.Config{
+		Username:          "user",
+		Password:          "pass",
+		PasswordGrantType: "password",
+		BaseURL:           url,
+		TokenEndpoint:     "/token",
+		BillerMSISDN:      "255713000000",
+		BillerCode:        "BILLER",
+		PushPayEndpoint:   "/push",
+	}
+	opts = append(opts, push.WithDebugMode(false))
+	return push.NewClient(conf, testHandler(1), opts...)
+}
+
+func payConcurrently(t *testing.T, clients ...*push.Client) {
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		for _, client := range clients {
+			wg.Add(1)
+			go func(client *push.Client, i int) {
+				defer wg.Done()
+				_, err := client.Pay(context.Background(), push.Request{
+					MSISDN:      "255713123456",
+					Amount:      money.Shillings(1000),
+					ReferenceID: fmt.Sprintf("REF%d", i),
+				})
+				if err != nil {
+					t.Errorf("pay: %v", err)
+				}
+			}(client, i)
+		}
+	}
+	wg.Wait()
+}
+
+func TestClient_TokenSingleFlight(t *testing.T) {
+	ts := &tokenServer{}
+	server := httptest.NewServer(ts)
+	defer server.Close()
+
+	payConcurrently(t, newTokenTestClient(server.URL))
+
+	if got := atomic.LoadInt32(&ts.issued); got != 1 {
+		t.Errorf("token requests: got %d want 1", got)
+	}
+}
+
+func TestClient_TokenRefreshOnUnauthorized(t *testing.T) {
+	ts := &tokenServer{strict: true}
+	server := httptest.NewServer(ts)
+	defer server.Close()
+
+	store := push.NewMemoryTokenStore()
+	err := store.Store(context.Background(), push.Token{
+		AccessToken: "revoked",
+		ExpiresAt:   time.Now().Add(time.Hour),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	payConcurrently(t, newTokenTestClient(server.URL, push.WithTokenStore(store)))
+
+	if got := atomic.LoadInt32(&ts.issued); got != 1 {
+		t.Errorf("token requests: got %d want 1", got)
+	}
+
+	token, _ := store.Load(context.Background())
+	if token.AccessToken != "token-1" {
+		t.Errorf("stored token: got %q want %q", token.AccessToken, "token-1")
+	}
+}
+
+func TestClient_TokenRefreshOnPlainUnauthorized(t *testing.T) {
+	ts := &tokenServer{strict: true, plain: true}
+	server := httptest.NewServer(ts)
+	defer server.Close()
+
+	store := push.NewMemoryTokenStore()
+	err := store.Store(context.Background(), push.Token{
+		AccessToken: "revoked",
+		ExpiresAt:   time.Now().Add(time.Hour),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	payConcurrently(t, newTokenTestClient(server.URL, push.WithTokenStore(store)))
+
+	if got := atomic.LoadInt32(&ts.issued); got != 1 {
+		t.Errorf("token requests: got %d want 1", got)
+	}
+}
+
+func TestClient_TokenRejected(t *testing.T) {
+	tests := []struct {
+		name    string
+		status  int
+		body    string
+		wantErr error
+	}{
+		{"unauthorized", http.StatusUnauthorized, `{"error":"invalid_grant"}`, push.ErrUnexpectedStatus},
+		{"server error", http.StatusInternalServerError, `{}`, push.ErrUnexpectedStatus},
+		{"empty token", http.StatusOK, `{"token_type":"bearer"}`, push.ErrNoAccessToken},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("Content-Type", "application/json")
+				w.WriteHeader(tt.status)
+				_, _ = w.Write([]byte(tt.body))
+			}))
+			defer server.Close()
+
+			store := push.NewMemoryTokenStore()
+			client := newTokenTestClient(server.URL, push.WithTokenStore(store))
+
+			if _, err := client.Token(context.Background()); !errors.Is(err, tt.wantErr) {
+				t.Fatalf("err: got %v want %v", err, tt.wantErr)
+			}
+
+			if token, _ := store.Load(context.Background()); token.AccessToken != "" {
+				t.Errorf("stored token: got %q want none", token.AccessToken)
+			}
+		})
+	}
+}
+
+func TestClient_TokenProactiveRenewal(t *testing.T) {
+	ts := &tokenServer{}
+	server := httptest.NewServer(ts)
+	defer server.Close()
+
+	store := push.NewMemoryTokenStore()
+	err := store.Store(context.Background(), push.Token{
+		AccessToken: "expiring",
+		ExpiresAt:   time.Now().Add(30 * time.Second),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	payConcurrently(t, newTokenTestClient(server.URL, push.WithTokenStore(store),
+		push.WithTokenRefreshMargin(time.Minute)))
+
+	if got := atomic.LoadInt32(&ts.issued); got != 1 {
+		t.Errorf("token requests: got %d want 1", got)
+	}
+}
+
+func TestFileTokenStore_Shared(t *testing.T) {
+	ts := &tokenServer{}
+	server := httptest.NewServer(ts)
+	defer server.Close()
+
+	path := filepath.Join(t.TempDir(), "token.json")
+
+	// each client has its own store instance as if they were running
+	// in different processes
+	c1 := newTokenTestClient(server.URL, push.WithTokenStore(push.NewFileTokenStore(path)))
+	c2 := newTokenTestClient(server.URL, push.WithTokenStore(push.NewFileTokenStore(path)))
+
+	payConcurrently(t, c1, c2)
+
+	if got := atomic.LoadInt32(&ts.issued); got != 1 {
+		t.Errorf("token requests: got %d want 1", got)
+	}
+}
+
+func TestFileTokenStore_UnlockKeepsTakenOverLock(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "token.json")
+	store := push.NewFileTokenStore(path)
+
+	unlock, err := store.Lock(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// another process took the lock over as if it were stale
+	if err := os.WriteFile(path+".lock", []byte("other"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	unlock()
+
+	if _, err := os.Stat(path + ".lock"); err != nil {
+		t.Errorf("lock of the other holder was removed: %v", err)
+	}
+}
+
+func TestClient_TokenRefreshWaitHonoursContext(t *testing.T) {
+	release := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/token" {
+			<-release
+		}
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(push.TokenResponse{AccessToken: "token", ExpiresIn: 3600})
+	}))
+	defer server.Close()
+	timer := time.AfterFunc(2*time.Second, func() { close(release) })
+	defer func() {
+		if timer.Stop() {
+			close(release)
+		}
+	}()
+
+	client := newTokenTestClient(server.URL)
+	go func() {
+		// holds the refresh until tigo answers
+		_, _ = client.Pay(context.Background(), push.Request{MSISDN: "255713123456", Amount: money.Shillings(1000), ReferenceID: "REF0"})
+	}()
+	time.Sleep(50 * time.Millisecond)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+
+	start := time.Now()
+	_, err := client.Pay(ctx, push.Request{MSISDN: "255713123456", Amount: money.Shillings(1000), ReferenceID: "REF1"})
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("expected deadline exceeded got %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("waited %s for the refresh of another caller", elapsed)
+	}
+}
+
+func TestFileTokenStore_StaleLockAfter(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "token.json")
+	// a crashed process left its lock behind
+	if err := os.WriteFile(path+".lock", []byte("other"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+	if _, err := push.NewFileTokenStore(path).Lock(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("fresh lock taken over: %v", err)
+	}
+
+	ctx, cancel = context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	unlock, err := push.NewFileTokenStore(path, push.WithStaleLockAfter(100*time.Millisecond)).Lock(ctx)
+	if err != nil {
+		t.Fatalf("stale lock not taken over: %v", err)
+	}
+	unlock()
+}
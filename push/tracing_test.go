@@ -0,0 +1,132 @@
+/*
+ * MIT License
+ *
+ * Copyright (c) 2021 TechCraft Technologies Co. Ltd
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ *
+ */
+
+package push_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/techcraftlabs/tigopesa/money"
+	"github.com/techcraftlabs/tigopesa/push"
+	"github.com/techcraftlabs/tigopesa/tigotest"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/propagation"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+)
+
+func attr(span sdktrace.ReadOnlySpan, key attribute.Key) string {
+	for _, kv := range span.Attributes() {
+		if kv.Key == key {
+			return kv.Value.Emit()
+		}
+	}
+	return ""
+}
+
+func TestClient_Tracing(t *testing.T) {
+	otel.SetTextMapPropagator(propagation.TraceContext{})
+	defer otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator())
+
+	recorder := tracetest.NewSpanRecorder()
+	provider := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder))
+
+	server := tigotest.NewServer()
+	defer server.Close()
+
+	var handlerSpan trace.SpanContext
+	handler := push.CallbackHandlerFunc(func(ctx context.Context, request push.CallbackRequest) (push.CallbackResponse, error) {
+		handlerSpan = trace.SpanContextFromContext(ctx)
+		return push.CallbackResponse{ResponseCode: push.SuccessCode, ReferenceID: request.ReferenceID}, nil
+	})
+
+	client := push.NewClient(server.PushConfig(), handler, push.WithDebugMode(false), push.WithTracerProvider(provider))
+
+	ctx, parent := provider.Tracer("checkout").Start(context.Background(), "checkout")
+	_, err := client.Pay(ctx, push.Request{MSISDN: "255713123456", Amount: money.Shillings(1000), ReferenceID: "ORDER1"})
+	parent.End()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// a callback sent with a trace context continues that trace
+	remote := trace.NewSpanContext(trace.SpanContextConfig{
+		TraceID:    trace.TraceID{1},
+		SpanID:     trace.SpanID{1},
+		TraceFlags: trace.FlagsSampled,
+	})
+	body, _ := json.Marshal(push.CallbackRequest{
+		Status:           true,
+		ReferenceID:      tigotest.BillerCode + "ORDER1",
+		MFSTransactionID: "MFS0001",
+		Amount:           money.Shillings(1000),
+	})
+	req := httptest.NewRequest(http.MethodPost, "/callback", bytes.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	otel.GetTextMapPropagator().Inject(trace.ContextWithRemoteSpanContext(context.Background(), remote), propagation.HeaderCarrier(req.Header))
+	client.CallbackServeHTTP(httptest.NewRecorder(), req)
+
+	spans := make(map[string]sdktrace.ReadOnlySpan)
+	for _, span := range recorder.Ended() {
+		spans[span.Name()] = span
+	}
+
+	pay, ok := spans["tigopesa.push.pay"]
+	if !ok {
+		t.Fatalf("no pay span in %v", spans)
+	}
+	if pay.Parent().SpanID() != parent.SpanContext().SpanID() || pay.SpanKind() != trace.SpanKindClient {
+		t.Errorf("pay span is not a client child of checkout: %+v", pay.Parent())
+	}
+	if attr(pay, "tigopesa.reference_id") != tigotest.BillerCode+"ORDER1" || attr(pay, "tigopesa.result_code") != push.SuccessCode {
+		t.Errorf("pay attributes: %v", pay.Attributes())
+	}
+
+	token, ok := spans["tigopesa.push.token"]
+	if !ok || token.Parent().SpanID() != pay.SpanContext().SpanID() {
+		t.Errorf("token span is not a child of pay: %v", ok)
+	}
+
+	callback, ok := spans["tigopesa.push.callback"]
+	if !ok {
+		t.Fatalf("no callback span in %v", spans)
+	}
+	if callback.SpanContext().TraceID() != remote.TraceID() || callback.SpanKind() != trace.SpanKindServer {
+		t.Errorf("callback span does not continue the remote trace")
+	}
+	if attr(callback, "tigopesa.txn_id") != "MFS0001" {
+		t.Errorf("callback attributes: %v", callback.Attributes())
+	}
+	if handlerSpan.SpanID() != callback.SpanContext().SpanID() {
+		t.Errorf("handler context does not carry the callback span")
+	}
+}
@@ -0,0 +1,166 @@
+/*
+ * MIT License
+ *
+ * Copyright (c) 2021 TechCraft Technologies Co. Ltd
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ *
+ */
+
+package push
+
+import (
+	"context"
+	"errors"
+	"fmt"
+)
+
+var (
+	// ErrPayRejected is returned by PayAndWait when tigo does not accept
+	// the push pay request, in that case no callback is expected.
+	ErrPayRejected = errors.New("push: pay request rejected")
+
+	// ErrAlreadyWaiting is returned by PayAndWait when another call is
+	// already waiting for a callback with the same ReferenceID.
+	ErrAlreadyWaiting = errors.New("push: already waiting for reference")
+)
+
+type (
+	// PayResult is the final result of a push pay request. It combines the
+	// acknowledgement returned by Pay with the callback tigo sent later, or
+	// with the reply of QueryStatus in Inquiry.
+	PayResult struct {
+		ReferenceID string          `json:"referenceID"`
+		Status      Status          `json:"status"`
+		Response    PayResponse     `json:"response"`
+		Callback    CallbackRequest `json:"callback"`
+		Inquiry     *StatusResponse `json:"inquiry,omitempty"`
+	}
+)
+
+// Succeeded reports whether the customer completed the payment
+func (r PayResult) Succeeded() bool {
+	if r.Status != "" {
+		return r.Status == StatusSuccess
+	}
+
+	return r.Response.ResponseStatus && r.Callback.Status
+}
+
+// PayAndWait sends the push pay request and blocks until tigo posts its
+// callback to CallbackServeHTTP or ctx is done. The callback is still passed
+// to the CallbackHandler as usual and PayAndWait returns once the handler
+// took it, while a callback the handler fails keeps it waiting for tigo to
+// deliver the callback again.
+//
+// The callback is matched using the ReferenceID sent to tigo, that is
+// request.ReferenceID prefixed with Config.BillerCode.
+func (c *Client) PayAndWait(ctx context.Context, request Request) (PayResult, error) {
+	ref := fmt.Sprintf("%s%s", c.Config.BillerCode, request.ReferenceID)
+	result := PayResult{
+		ReferenceID: ref,
+		Status:      StatusUnknown,
+	}
+
+	callbacks, err := c.register(ref)
+	if err != nil {
+		return result, err
+	}
+	defer c.unregister(ref)
+
+	response, err := c.Pay(ctx, request)
+	result.Response = response
+	if err != nil {
+		return result, err
+	}
+
+	if !response.ResponseStatus {
+		result.Status = StatusFailed
+		return result, fmt.Errorf("%w: %s: %s", ErrPayRejected,
+			response.ResponseCode, response.ResponseDescription)
+	}
+
+	result.Status = StatusPending
+
+	select {
+	case <-ctx.Done():
+		return result, fmt.Errorf("push: waiting for callback of %s: %w", ref, ctx.Err())
+
+	case callback := <-callbacks:
+		result.Callback = callback
+		result.Status = StatusFailed
+		if callback.Status {
+			result.Status = StatusSuccess
+		}
+		return result, nil
+	}
+}
+
+// register must be called before the request is sent as tigo may post
+// the callback before Pay returns.
+func (c *Client) register(ref string) (<-chan CallbackRequest, error) {
+	c.waitersMu.Lock()
+	defer c.waitersMu.Unlock()
+
+	if c.waiters == nil {
+		c.waiters = make(map[string]chan CallbackRequest)
+	}
+
+	if _, ok := c.waiters[ref]; ok {
+		return nil, fmt.Errorf("%w: %s", ErrAlreadyWaiting, ref)
+	}
+
+	ch := make(chan CallbackRequest, 1)
+	c.waiters[ref] = ch
+
+	return ch, nil
+}
+
+func (c *Client) unregister(ref string) {
+	c.waitersMu.Lock()
+	defer c.waitersMu.Unlock()
+	delete(c.waiters, ref)
+}
+
+// notify hands the callback to the PayAndWait call waiting for it, if any.
+// Callbacks are looked up by the ReferenceID as received and with the
+// BillerCode prefix added.
+func (c *Client) notify(request CallbackRequest) {
+	c.waitersMu.Lock()
+	defer c.waitersMu.Unlock()
+
+	var (
+		ch chan CallbackRequest
+		ok bool
+	)
+	for _, ref := range c.references(request.ReferenceID) {
+		if ch, ok = c.waiters[ref]; ok {
+			break
+		}
+	}
+	if !ok {
+		return
+	}
+
+	select {
+	case ch <- request:
+	default:
+		// a callback has already been delivered for this reference
+	}
+}
@@ -0,0 +1,208 @@
+/*
+ * MIT License
+ *
+ * Copyright (c) 2021 TechCraft Technologies Co. Ltd
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ *
+ */
+
+package push_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/techcraftlabs/tigopesa/money"
+	"github.com/techcraftlabs/tigopesa/push"
+)
+
+type countingHandler int32
+
+func (h *countingHandler) Handle(_ context.Context, request push.CallbackRequest) (push.CallbackResponse, error) {
+	atomic.AddInt32((*int32)(h), 1)
+	return push.CallbackResponse{
+		ResponseCode:   push.SuccessCode,
+		ResponseStatus: true,
+		ReferenceID:    request.ReferenceID,
+	}, nil
+}
+
+// gateway acknowledges every push pay request and, unless silent is set,
+// posts the callback to callbackURL shortly after.
+func gateway(t *testing.T, callbackURL *string, silent bool) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		if r.URL.Path == "/token" {
+			_ = json.NewEncoder(w).Encode(push.TokenResponse{AccessToken: "token", ExpiresIn: 3600})
+			return
+		}
+
+		var req struct {
+			ReferenceID string
+			Amount      float64
+		}
+		_ = json.NewDecoder(r.Body).Decode(&req)
+		_ = json.NewEncoder(w).Encode(push.PayResponse{
+			ResponseCode:   push.SuccessCode,
+			ResponseStatus: true,
+			ReferenceID:    req.ReferenceID,
+		})
+
+		if silent {
+			return
+		}
+
+		go func() {
+			time.Sleep(50 * time.Millisecond)
+			buf, _ := json.Marshal(push.CallbackRequest{
+				Status:           true,
+				Description:      "success",
+				MFSTransactionID: "MFS123",
+				ReferenceID:      req.ReferenceID,
+				Amount:           money.Shillings(1000),
+			})
+			res, err := http.Post(*callbackURL, "application/json", bytes.NewReader(buf))
+			if err != nil {
+				t.Errorf("post callback: %v", err)
+				return
+			}
+			_ = res.Body.Close()
+		}()
+	}))
+}
+
+func TestClient_PayAndWait(t *testing.T) {
+	var callbackURL string
+	server := gateway(t, &callbackURL, false)
+	defer server.Close()
+
+	handler := new(countingHandler)
+	client := newTokenTestClient(server.URL)
+	client.SetCallbackHandler(handler)
+
+	callbacks := httptest.NewServer(http.HandlerFunc(client.CallbackServeHTTP))
+	defer callbacks.Close()
+	callbackURL = callbacks.URL
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	result, err := client.PayAndWait(ctx, push.Request{
+		MSISDN:      "255713123456",
+		Amount:      money.Shillings(1000),
+		ReferenceID: "ORDER1",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !result.Succeeded() {
+		t.Errorf("expected payment to succeed: %+v", result)
+	}
+
+	if result.ReferenceID != "BILLERORDER1" || result.Callback.MFSTransactionID != "MFS123" {
+		t.Errorf("unexpected result: %+v", result)
+	}
+
+	if got := atomic.LoadInt32((*int32)(handler)); got != 1 {
+		t.Errorf("callback handler calls: got %d want 1", got)
+	}
+}
+
+func TestClient_PayAndWaitTimeout(t *testing.T) {
+	var callbackURL string
+	server := gateway(t, &callbackURL, true)
+	defer server.Close()
+
+	client := newTokenTestClient(server.URL)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+
+	result, err := client.PayAndWait(ctx, push.Request{
+		MSISDN:      "255713123456",
+		Amount:      money.Shillings(1000),
+		ReferenceID: "ORDER2",
+	})
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("expected deadline exceeded got %v", err)
+	}
+
+	if !result.Response.ResponseStatus {
+		t.Errorf("expected acknowledgement to be returned: %+v", result)
+	}
+}
+
+func TestClient_PayAndWaitHandlerFailed(t *testing.T) {
+	var callbackURL string
+	server := gateway(t, &callbackURL, false)
+	defer server.Close()
+
+	var calls int32
+	failed := make(chan push.CallbackRequest, 1)
+	client := newTokenTestClient(server.URL)
+	client.SetCallbackHandler(push.CallbackHandlerFunc(func(_ context.Context, request push.CallbackRequest) (push.CallbackResponse, error) {
+		if atomic.AddInt32(&calls, 1) == 1 {
+			failed <- request
+			return push.CallbackResponse{}, errors.New("database down")
+		}
+		return push.CallbackResponse{ResponseCode: push.SuccessCode, ResponseStatus: true, ReferenceID: request.ReferenceID}, nil
+	}))
+
+	callbacks := httptest.NewServer(http.HandlerFunc(client.CallbackServeHTTP))
+	defer callbacks.Close()
+	callbackURL = callbacks.URL
+
+	go func() {
+		// tigo delivers the callback the handler failed again
+		request := <-failed
+		time.Sleep(100 * time.Millisecond)
+		buf, _ := json.Marshal(request)
+		res, err := http.Post(callbackURL, "application/json", bytes.NewReader(buf))
+		if err != nil {
+			t.Errorf("post callback: %v", err)
+			return
+		}
+		_ = res.Body.Close()
+	}()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	result, err := client.PayAndWait(ctx, push.Request{
+		MSISDN:      "255713123456",
+		Amount:      money.Shillings(1000),
+		ReferenceID: "ORDER3",
+	})
+	if err != nil || !result.Succeeded() {
+		t.Fatalf("expected payment to succeed: %+v %v", result, err)
+	}
+
+	if got := atomic.LoadInt32(&calls); got != 2 {
+		t.Errorf("callback handler calls: got %d want 2", got)
+	}
+}
@@ -0,0 +1,300 @@
+/*
+ * MIT License
+ *
+ * Copyright (c) 2021 TechCraft Technologies Co. Ltd
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ *
+ */
+// Package reconcile matches the transactions listed in tigo settlement
+// statements with those recorded by the ledger, and reports what is on one
+// side only, what was settled for a different amount and what tigo and the
+// ledger disagree on the outcome of.
+//
+// Statements are csv exports read with ParseStatement:
+//
+//	Transaction Date,Transaction ID,Reference,MSISDN,Amount,Status
+//	2021-09-01 10:15:00,MP210901.1015.A00001,BILLER0001,255713123456,"1,000.00",Success
+//	2021-09-01 11:20:31,MP210901.1120.A00002,PAYROLL-0001,255654123456,-150000,Success
+package reconcile
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"io"
+	"strconv"
+	"time"
+
+	"github.com/techcraftlabs/tigopesa/ledger"
+)
+
+const (
+	KindMatched           Kind = "matched"
+	KindMissingOnOurSide  Kind = "missing_on_our_side"
+	KindMissingOnTigoSide Kind = "missing_on_tigo_side"
+	KindAmountMismatch    Kind = "amount_mismatch"
+	KindStatusMismatch    Kind = "status_mismatch"
+)
+
+const (
+	ByTxnID       = "txn_id"
+	ByReferenceID = "reference_id"
+)
+
+var reportHeader = []string{
+	"kind", "matched_by",
+	"statement_line", "statement_reference", "statement_txn_id", "statement_amount", "statement_time",
+	"statement_status",
+	"transaction_id", "flow", "reference_id", "txn_id", "amount", "status", "created_at",
+}
+
+type (
+	// Kind is the outcome of reconciling a statement line or a transaction
+	Kind string
+
+	// Item is a statement line, a ledger transaction or both. MatchedBy is
+	// ByTxnID or ByReferenceID when both are set. Transaction is a copy
+	// without its transitions.
+	Item struct {
+		Kind        Kind                `json:"kind"`
+		MatchedBy   string              `json:"matched_by,omitempty"`
+		Line        *Line               `json:"statement,omitempty"`
+		Transaction *ledger.Transaction `json:"transaction,omitempty"`
+	}
+
+	// Summary counts the items of a Report
+	Summary struct {
+		Matched           int `json:"matched"`
+		MissingOnOurSide  int `json:"missing_on_our_side"`
+		MissingOnTigoSide int `json:"missing_on_tigo_side"`
+		AmountMismatch    int `json:"amount_mismatch"`
+		StatusMismatch    int `json:"status_mismatch"`
+	}
+
+	// Report is the result of Match. MissingOnOurSide has the settled
+	// statement lines with no transaction, MissingOnTigoSide the
+	// transactions that succeeded or whose outcome is unknown and are not
+	// in the statement. StatusMismatch has the lines tigo settled for a
+	// transaction that failed and those it did not settle for one that
+	// succeeded.
+	Report struct {
+		Summary           Summary `json:"summary"`
+		Matched           []Item  `json:"matched"`
+		MissingOnOurSide  []Item  `json:"missing_on_our_side"`
+		MissingOnTigoSide []Item  `json:"missing_on_tigo_side"`
+		AmountMismatch    []Item  `json:"amount_mismatch"`
+		StatusMismatch    []Item  `json:"status_mismatch"`
+	}
+)
+
+// Match reconciles the statement lines with transactions. A line matches
+// the transaction with its txn id, the TxnID or MFSTransactionID tigo
+// returned, and otherwise the transaction with its reference, the
+// ReferenceID or the RefID returned for a wallet to account payment. Each
+// transaction matches one line at most, so a line listed twice is missing
+// on our side the second time. Name queries are ignored as they move no
+// money, and transactions that failed or were only initiated are not
+// expected in the statement.
+//
+// Settled lines, see Line.Settled, are matched first so that a failed
+// attempt listed next to the settled one does not take its transaction.
+// A line that was not settled is only reported when it matches a
+// transaction that succeeded.
+func Match(lines []Line, transactions []ledger.Transaction) Report {
+	var (
+		report      Report
+		byTxnID     = make(map[string]int)
+		byReference = make(map[string]int)
+		matched     = make([]bool, len(transactions))
+	)
+
+	index := func(m map[string]int, key string, i int) {
+		if _, ok := m[key]; key != "" && !ok {
+			m[key] = i
+		}
+	}
+	for i, txn := range transactions {
+		if txn.Flow == ledger.FlowNameQuery {
+			continue
+		}
+		index(byTxnID, txn.TxnID, i)
+		index(byTxnID, txn.MFSTransactionID, i)
+		index(byReference, txn.ReferenceID, i)
+		index(byReference, txn.RefID, i)
+	}
+
+	find := func(line Line) (int, string) {
+		if i, ok := byTxnID[line.TxnID]; ok && line.TxnID != "" && !matched[i] {
+			return i, ByTxnID
+		}
+		if i, ok := byReference[line.ReferenceID]; ok && line.ReferenceID != "" && !matched[i] {
+			return i, ByReferenceID
+		}
+		return -1, ""
+	}
+
+	for _, settled := range []bool{true, false} {
+		for _, line := range lines {
+			if line.Settled() != settled {
+				continue
+			}
+
+			line := line
+			i, by := find(line)
+			if i < 0 {
+				if settled {
+					report.MissingOnOurSide = append(report.MissingOnOurSide, Item{
+						Kind: KindMissingOnOurSide,
+						Line: &line,
+					})
+				}
+				continue
+			}
+
+			matched[i] = true
+			txn := transactions[i]
+			item := Item{Kind: KindMatched, MatchedBy: by, Line: &line, Transaction: summarize(txn)}
+			if (settled && txn.Status == ledger.StatusFailed) || (!settled && txn.Status == ledger.StatusSucceeded) {
+				item.Kind = KindStatusMismatch
+				report.StatusMismatch = append(report.StatusMismatch, item)
+				continue
+			}
+			if c, err := line.Amount.Cmp(txn.Amount); err != nil || c != 0 {
+				item.Kind = KindAmountMismatch
+				report.AmountMismatch = append(report.AmountMismatch, item)
+				continue
+			}
+			report.Matched = append(report.Matched, item)
+		}
+	}
+
+	for i, txn := range transactions {
+		if matched[i] || txn.Flow == ledger.FlowNameQuery {
+			continue
+		}
+		if txn.Status != ledger.StatusSucceeded && txn.Status != ledger.StatusUnknown {
+			continue
+		}
+		report.MissingOnTigoSide = append(report.MissingOnTigoSide, Item{
+			Kind:        KindMissingOnTigoSide,
+			Transaction: summarize(txn),
+		})
+	}
+
+	report.Summary = Summary{
+		Matched:           len(report.Matched),
+		MissingOnOurSide:  len(report.MissingOnOurSide),
+		MissingOnTigoSide: len(report.MissingOnTigoSide),
+		AmountMismatch:    len(report.AmountMismatch),
+		StatusMismatch:    len(report.StatusMismatch),
+	}
+
+	return report
+}
+
+// MatchStore reconciles the statement lines with the transactions store
+// recorded from from, inclusive, to to, exclusive. The period should cover
+// the statement with some slack, as transactions are recorded when they
+// are initiated and tigo may settle them later.
+func MatchStore(ctx context.Context, lines []Line, store ledger.Store, from, to time.Time) (Report, error) {
+	transactions, err := store.Find(ctx, ledger.Query{From: from, To: to})
+	if err != nil {
+		return Report{}, err
+	}
+
+	return Match(lines, transactions), nil
+}
+
+func summarize(txn ledger.Transaction) *ledger.Transaction {
+	txn.Transitions = nil
+	return &txn
+}
+
+// Items returns all the items of r, mismatches and missing items first
+func (r Report) Items() []Item {
+	items := make([]Item, 0, len(r.Matched)+len(r.MissingOnOurSide)+len(r.MissingOnTigoSide)+
+		len(r.AmountMismatch)+len(r.StatusMismatch))
+	items = append(items, r.StatusMismatch...)
+	items = append(items, r.AmountMismatch...)
+	items = append(items, r.MissingOnOurSide...)
+	items = append(items, r.MissingOnTigoSide...)
+	items = append(items, r.Matched...)
+
+	return items
+}
+
+// WriteJSON writes r to w as an indented json document
+func (r Report) WriteJSON(w io.Writer) error {
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+
+	return encoder.Encode(r)
+}
+
+// WriteCSV writes a row for every item of r in the order of Items. The
+// statement columns are empty for items missing on our side and the
+// transaction columns for those missing on tigo side.
+func (r Report) WriteCSV(w io.Writer) error {
+	writer := csv.NewWriter(w)
+	if err := writer.Write(reportHeader); err != nil {
+		return err
+	}
+
+	for _, item := range r.Items() {
+		record := make([]string, 0, len(reportHeader))
+		record = append(record, string(item.Kind), item.MatchedBy)
+
+		if line := item.Line; line != nil {
+			record = append(record,
+				strconv.Itoa(line.Line), line.ReferenceID, line.TxnID, line.Amount.String(), formatTime(line.Time),
+				line.Status)
+		} else {
+			record = append(record, "", "", "", "", "", "")
+		}
+
+		if txn := item.Transaction; txn != nil {
+			txnID := txn.TxnID
+			if txnID == "" {
+				txnID = txn.MFSTransactionID
+			}
+			record = append(record,
+				txn.ID, string(txn.Flow), txn.ReferenceID, txnID, txn.Amount.String(),
+				string(txn.Status), formatTime(txn.CreatedAt))
+		} else {
+			record = append(record, "", "", "", "", "", "", "")
+		}
+
+		if err := writer.Write(record); err != nil {
+			return err
+		}
+	}
+
+	writer.Flush()
+
+	return writer.Error()
+}
+
+func formatTime(t time.Time) string {
+	if t.IsZero() {
+		return ""
+	}
+
+	return t.Format(time.RFC3339)
+}
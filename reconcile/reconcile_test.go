@@ -0,0 +1,301 @@
+/*
+ * MIT License
+ *
+ * Copyright (c) 2021 TechCraft Technologies Co. Ltd
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ *
+ */
+package reconcile_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/techcraftlabs/tigopesa/ledger"
+	"github.com/techcraftlabs/tigopesa/money"
+	"github.com/techcraftlabs/tigopesa/reconcile"
+)
+
+const statement = "\ufeffTransaction Date,Transaction ID,Reference,MSISDN,Amount,Status\n" +
+	"2021-09-01 10:15:00,MP1,BILLER0001,255713123456,\"1,000.00\",Success\n" +
+	"2021-09-01 11:20:31,TX2,PAYROLL-0001,255654123456,-150000,Success\n" +
+	"\n" +
+	"2021-09-01 12:00:00,TX3,PAYROLL-0002,255654123457,-5000,Success\n" +
+	"2021-09-01 13:00:00,TX4,,255713000000,2500,Success\n"
+
+func TestParseStatement(t *testing.T) {
+	lines, err := reconcile.ParseStatement(strings.NewReader(statement))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(lines) != 4 {
+		t.Fatalf("got %d lines, want 4", len(lines))
+	}
+
+	want := reconcile.Line{
+		Line:        3,
+		ReferenceID: "PAYROLL-0001",
+		TxnID:       "TX2",
+		Amount:      money.Shillings(150000),
+		MSISDN:      "255654123456",
+		Time:        time.Date(2021, 9, 1, 11, 20, 31, 0, time.UTC),
+		Status:      "Success",
+	}
+	if got := lines[1]; got != want {
+		t.Errorf("got %+v, want %+v", got, want)
+	}
+	if lines[0].Amount != money.Shillings(1000) {
+		t.Errorf("thousands separator: got %v", lines[0].Amount)
+	}
+	if lines[2].Line != 5 {
+		t.Errorf("blank lines are counted: got line %d", lines[2].Line)
+	}
+}
+
+func TestParseStatement_Errors(t *testing.T) {
+	tests := []struct {
+		name  string
+		input string
+		want  error
+		count int
+	}{
+		{"empty", "", reconcile.ErrMissingColumn, 1},
+		{"no ids", "msisdn,amount\n255713123456,1000\n", reconcile.ErrMissingColumn, 1},
+		{"no amount", "reference,txn_id\nA,B\n", reconcile.ErrMissingColumn, 1},
+		{"amount", "reference,amount\nA,ten\nB,1000\n", reconcile.ErrInvalidAmount, 1},
+		{"time", "reference,amount,date\nA,10,yesterday\n", reconcile.ErrInvalidTime, 1},
+		{"id", "reference,txn_id,amount\n,,1000\nA,,x\n", reconcile.ErrMissingID, 2},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			lines, err := reconcile.ParseStatement(strings.NewReader(tt.input))
+			if lines != nil {
+				t.Errorf("got lines %v", lines)
+			}
+			if !errors.Is(err, tt.want) {
+				t.Fatalf("got %v, want %v", err, tt.want)
+			}
+
+			var errs reconcile.Errors
+			if !errors.As(err, &errs) || len(errs) != tt.count {
+				t.Errorf("got %d problems, want %d: %v", len(errs), tt.count, err)
+			}
+		})
+	}
+}
+
+func transactions() []ledger.Transaction {
+	day := time.Date(2021, 9, 1, 9, 0, 0, 0, time.UTC)
+	return []ledger.Transaction{
+		{
+			ID: ledger.ID(ledger.FlowPush, "BILLER0001"), Flow: ledger.FlowPush, ReferenceID: "BILLER0001",
+			MFSTransactionID: "MP1", Amount: money.Shillings(1000), Status: ledger.StatusSucceeded, CreatedAt: day,
+			Transitions: []ledger.Transition{{Status: ledger.StatusSucceeded}},
+		},
+		{
+			ID: ledger.ID(ledger.FlowDisbursement, "PAYROLL-0001"), Flow: ledger.FlowDisbursement,
+			ReferenceID: "PAYROLL-0001", Amount: money.Shillings(150000), Status: ledger.StatusUnknown, CreatedAt: day,
+		},
+		{
+			ID: ledger.ID(ledger.FlowDisbursement, "PAYROLL-0002"), Flow: ledger.FlowDisbursement,
+			ReferenceID: "PAYROLL-0002", TxnID: "TX3", Amount: money.Shillings(50000),
+			Status: ledger.StatusSucceeded, CreatedAt: day,
+		},
+		{
+			ID: ledger.ID(ledger.FlowDisbursement, "PAYROLL-0003"), Flow: ledger.FlowDisbursement,
+			ReferenceID: "PAYROLL-0003", TxnID: "TX9", Amount: money.Shillings(1000),
+			Status: ledger.StatusSucceeded, CreatedAt: day,
+		},
+		{
+			ID: ledger.ID(ledger.FlowDisbursement, "PAYROLL-0004"), Flow: ledger.FlowDisbursement,
+			ReferenceID: "PAYROLL-0004", Amount: money.Shillings(1000), Status: ledger.StatusFailed, CreatedAt: day,
+		},
+		{
+			ID: "name_query:x", Flow: ledger.FlowNameQuery, ReferenceID: "TX4",
+			Status: ledger.StatusSucceeded, CreatedAt: day,
+		},
+	}
+}
+
+func TestMatch(t *testing.T) {
+	lines, err := reconcile.ParseStatement(strings.NewReader(statement))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	report := reconcile.Match(lines, transactions())
+
+	want := reconcile.Summary{Matched: 2, MissingOnOurSide: 1, MissingOnTigoSide: 1, AmountMismatch: 1}
+	if report.Summary != want {
+		t.Fatalf("got %+v, want %+v", report.Summary, want)
+	}
+
+	if m := report.Matched[0]; m.MatchedBy != reconcile.ByTxnID || m.Transaction.ReferenceID != "BILLER0001" {
+		t.Errorf("push pay: got %+v", m)
+	}
+	if m := report.Matched[0]; m.Transaction.Transitions != nil {
+		t.Errorf("transitions are not reported: got %v", m.Transaction.Transitions)
+	}
+	if m := report.Matched[1]; m.MatchedBy != reconcile.ByReferenceID || m.Transaction.ReferenceID != "PAYROLL-0001" {
+		t.Errorf("unknown outcome: got %+v", m)
+	}
+	if m := report.AmountMismatch[0]; m.Line.TxnID != "TX3" || m.Kind != reconcile.KindAmountMismatch {
+		t.Errorf("amount mismatch: got %+v", m)
+	}
+	if m := report.MissingOnOurSide[0]; m.Line.TxnID != "TX4" || m.Transaction != nil {
+		t.Errorf("missing on our side: got %+v", m)
+	}
+	if m := report.MissingOnTigoSide[0]; m.Transaction.ReferenceID != "PAYROLL-0003" || m.Line != nil {
+		t.Errorf("missing on tigo side: got %+v", m)
+	}
+}
+
+func TestMatch_Duplicates(t *testing.T) {
+	lines := []reconcile.Line{
+		{Line: 2, TxnID: "MP1", Amount: money.Shillings(1000)},
+		{Line: 3, TxnID: "MP1", Amount: money.Shillings(1000)},
+	}
+
+	report := reconcile.Match(lines, transactions()[:1])
+	if report.Summary.Matched != 1 || report.Summary.MissingOnOurSide != 1 {
+		t.Errorf("got %+v", report.Summary)
+	}
+	if report.MissingOnOurSide[0].Line.Line != 3 {
+		t.Errorf("second line should be missing: got %+v", report.MissingOnOurSide[0])
+	}
+}
+
+func TestMatch_Status(t *testing.T) {
+	lines := []reconcile.Line{
+		// a failed attempt listed before the settled one
+		{Line: 2, ReferenceID: "BILLER0001", Amount: money.Shillings(1000), Status: "Failed"},
+		{Line: 3, TxnID: "MP1", Amount: money.Shillings(1000), Status: "Success"},
+		// tigo settled what the ledger recorded as failed
+		{Line: 4, ReferenceID: "PAYROLL-0004", Amount: money.Shillings(1000), Status: "SUCCESSFUL"},
+		// tigo reversed what the ledger recorded as succeeded
+		{Line: 5, TxnID: "TX3", Amount: money.Shillings(50000), Status: "Reversed"},
+		// lines that moved no money are not missing on our side
+		{Line: 6, TxnID: "TX10", Amount: money.Shillings(700), Status: "Failed"},
+	}
+
+	report := reconcile.Match(lines, transactions())
+
+	want := reconcile.Summary{Matched: 1, MissingOnTigoSide: 2, StatusMismatch: 2}
+	if report.Summary != want {
+		t.Fatalf("got %+v, want %+v", report.Summary, want)
+	}
+	if m := report.Matched[0]; m.Line.Line != 3 || m.Transaction.ReferenceID != "BILLER0001" {
+		t.Errorf("matched: got %+v", m)
+	}
+	if m := report.StatusMismatch[0]; m.Line.Line != 4 || m.Kind != reconcile.KindStatusMismatch ||
+		m.Transaction.Status != ledger.StatusFailed {
+		t.Errorf("settled failure: got %+v", m)
+	}
+	if m := report.StatusMismatch[1]; m.Line.Line != 5 || m.Transaction.Status != ledger.StatusSucceeded {
+		t.Errorf("reversed success: got %+v", m)
+	}
+}
+
+func TestMatchStore(t *testing.T) {
+	ctx := context.Background()
+	store := ledger.NewMemoryStore()
+	at := time.Date(2021, 9, 1, 9, 0, 0, 0, time.UTC)
+
+	entries := []ledger.Entry{
+		{
+			ID: ledger.ID(ledger.FlowPayment, "TX5"), Flow: ledger.FlowPayment, ReferenceID: "TX5", TxnID: "TX5",
+			RefID: "REF5", Amount: money.Shillings(700), Status: ledger.StatusSucceeded, Time: at,
+		},
+		{
+			ID: ledger.ID(ledger.FlowPayment, "TX6"), Flow: ledger.FlowPayment, ReferenceID: "TX6", TxnID: "TX6",
+			Amount: money.Shillings(700), Status: ledger.StatusSucceeded, Time: at.AddDate(0, 1, 0),
+		},
+	}
+	for _, entry := range entries {
+		if err := store.Record(ctx, entry); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	lines := []reconcile.Line{{Line: 2, ReferenceID: "REF5", Amount: money.Shillings(700)}}
+	report, err := reconcile.MatchStore(ctx, lines, store, at.AddDate(0, 0, -1), at.AddDate(0, 0, 1))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := reconcile.Summary{Matched: 1}
+	if report.Summary != want {
+		t.Errorf("got %+v, want %+v", report.Summary, want)
+	}
+}
+
+func TestReport_Write(t *testing.T) {
+	lines, err := reconcile.ParseStatement(strings.NewReader(statement))
+	if err != nil {
+		t.Fatal(err)
+	}
+	report := reconcile.Match(lines, transactions())
+
+	var buf bytes.Buffer
+	if err := report.WriteJSON(&buf); err != nil {
+		t.Fatal(err)
+	}
+
+	var decoded reconcile.Report
+	if err := json.Unmarshal(buf.Bytes(), &decoded); err != nil {
+		t.Fatal(err)
+	}
+	if decoded.Summary != report.Summary || decoded.AmountMismatch[0].Line.Amount != money.Shillings(5000) {
+		t.Errorf("json round trip: got %+v", decoded)
+	}
+
+	buf.Reset()
+	if err := report.WriteCSV(&buf); err != nil {
+		t.Fatal(err)
+	}
+
+	records, err := csv.NewReader(&buf).ReadAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(records) != 6 || len(records[0]) != 15 {
+		t.Fatalf("got %d records, want header and 5 items", len(records))
+	}
+
+	want := []string{
+		"amount_mismatch", "txn_id", "5", "PAYROLL-0002", "TX3", "5000", "2021-09-01T12:00:00Z", "Success",
+		"disbursement:PAYROLL-0002", "disbursement", "PAYROLL-0002", "TX3", "50000", "succeeded",
+		"2021-09-01T09:00:00Z",
+	}
+	if got := strings.Join(records[1], ","); got != strings.Join(want, ",") {
+		t.Errorf("got  %s\nwant %s", got, strings.Join(want, ","))
+	}
+	if records[3][0] != "missing_on_tigo_side" || records[3][2] != "" || records[3][8] != "disbursement:PAYROLL-0003" {
+		t.Errorf("missing on tigo side: got %v", records[3])
+	}
+}
@@ -0,0 +1,375 @@
+/*
+ * MIT License
+ *
+ * Copyright (c) 2021 TechCraft Technologies Co. Ltd
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ *
+ */
+package reconcile
+
+import (
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/techcraftlabs/tigopesa/money"
+)
+
+const (
+	ColumnReference = "reference"
+	ColumnTxnID     = "txn_id"
+	ColumnAmount    = "amount"
+	ColumnMSISDN    = "msisdn"
+	ColumnTime      = "time"
+	ColumnStatus    = "status"
+
+	// spreadsheet programs often start csv exports with a byte order mark
+	byteOrderMark = "\ufeff"
+)
+
+var (
+	ErrMissingColumn = errors.New("missing column")
+	ErrMissingID     = errors.New("missing reference and txn id")
+	ErrInvalidAmount = errors.New("invalid amount")
+	ErrInvalidTime   = errors.New("invalid time")
+)
+
+var (
+	_ error = (*LineError)(nil)
+	_ error = (Errors)(nil)
+
+	// aliases maps the normalized headers used by the different statement
+	// exports to the columns they hold
+	aliases = map[string]string{
+		"reference":             ColumnReference,
+		"reference_id":          ColumnReference,
+		"referenceid":           ColumnReference,
+		"external_reference":    ColumnReference,
+		"external_reference_id": ColumnReference,
+		"biller_reference":      ColumnReference,
+		"txn_id":                ColumnTxnID,
+		"txnid":                 ColumnTxnID,
+		"transaction_id":        ColumnTxnID,
+		"mfs_transaction_id":    ColumnTxnID,
+		"receipt":               ColumnTxnID,
+		"receipt_no":            ColumnTxnID,
+		"amount":                ColumnAmount,
+		"transaction_amount":    ColumnAmount,
+		"msisdn":                ColumnMSISDN,
+		"customer_msisdn":       ColumnMSISDN,
+		"phone":                 ColumnMSISDN,
+		"time":                  ColumnTime,
+		"date":                  ColumnTime,
+		"transaction_date":      ColumnTime,
+		"transaction_time":      ColumnTime,
+		"status":                ColumnStatus,
+		"transaction_status":    ColumnStatus,
+	}
+
+	// settled lists the normalized statuses of lines tigo settled
+	settled = map[string]bool{
+		"success":    true,
+		"successful": true,
+		"succeeded":  true,
+		"completed":  true,
+		"complete":   true,
+		"settled":    true,
+	}
+
+	// DefaultTimeLayouts are the layouts tried, in order, to parse the
+	// time column of a statement
+	DefaultTimeLayouts = []string{
+		time.RFC3339,
+		"2006-01-02 15:04:05",
+		"2006-01-02T15:04:05",
+		"02/01/2006 15:04:05",
+		"02/01/2006 15:04",
+		"2006-01-02",
+		"02/01/2006",
+	}
+)
+
+type (
+	// Line is a transaction listed in a tigo statement. Line is its line
+	// number in the file starting at 1. Amount is the absolute value of the
+	// amount in the file, exports list payouts as negative amounts.
+	Line struct {
+		Line        int          `json:"line"`
+		ReferenceID string       `json:"reference_id,omitempty"`
+		TxnID       string       `json:"txn_id,omitempty"`
+		Amount      money.Amount `json:"amount"`
+		MSISDN      string       `json:"msisdn,omitempty"`
+		Time        time.Time    `json:"time"`
+		Status      string       `json:"status,omitempty"`
+	}
+
+	// LineError is a problem with a single value of the statement.
+	// Line is the line number in the file starting at 1.
+	LineError struct {
+		Line   int
+		Column string
+		Value  string
+		Err    error
+	}
+
+	// Errors is returned by ParseStatement with every problem found in the
+	// file
+	Errors []*LineError
+
+	// ParseOption changes how ParseStatement reads the file
+	ParseOption func(p *parser)
+
+	parser struct {
+		layouts  []string
+		location *time.Location
+	}
+)
+
+// Settled reports whether tigo settled the line, i.e. its status is empty
+// or one of the words the exports use for success. Failed, reversed and
+// pending lines moved no money.
+func (l Line) Settled() bool {
+	status := strings.ToLower(strings.TrimSpace(l.Status))
+	return status == "" || settled[status]
+}
+
+// WithTimeLayouts sets the layouts tried to parse the time column instead
+// of DefaultTimeLayouts.
+func WithTimeLayouts(layouts ...string) ParseOption {
+	return func(p *parser) {
+		if len(layouts) == 0 {
+			return
+		}
+		p.layouts = layouts
+	}
+}
+
+// WithLocation sets the time zone of times without one, tigo statements
+// are in East Africa Time and UTC is used by default.
+func WithLocation(location *time.Location) ParseOption {
+	return func(p *parser) {
+		if location == nil {
+			return
+		}
+		p.location = location
+	}
+}
+
+func (e *LineError) Error() string {
+	if e.Column == "" {
+		return fmt.Sprintf("line %d: %v", e.Line, e.Err)
+	}
+
+	return fmt.Sprintf("line %d: %s %q: %v", e.Line, e.Column, e.Value, e.Err)
+}
+
+func (e *LineError) Unwrap() error {
+	return e.Err
+}
+
+func (e Errors) Error() string {
+	msgs := make([]string, len(e))
+	for i, err := range e {
+		msgs[i] = err.Error()
+	}
+
+	return strings.Join(msgs, "\n")
+}
+
+// Is reports whether any of the problems matches target
+func (e Errors) Is(target error) bool {
+	for _, err := range e {
+		if errors.Is(err, target) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// ParseStatement reads the transactions of a tigo statement export from r.
+// The file must have a header row with an amount column and a reference or
+// a txn id column, the msisdn, time and status columns are optional and
+// other columns are ignored. Headers are matched ignoring case, spaces and
+// the names used by the different exports, e.g. "Transaction ID" and
+// "Receipt No" both name the txn id. The whole file is validated before
+// returning, when there are problems the returned error is Errors with one
+// *LineError for each of them and no lines are returned.
+func ParseStatement(r io.Reader, opts ...ParseOption) ([]Line, error) {
+	p := &parser{layouts: DefaultTimeLayouts, location: time.UTC}
+	for _, opt := range opts {
+		opt(p)
+	}
+
+	reader := csv.NewReader(r)
+	reader.TrimLeadingSpace = true
+	reader.FieldsPerRecord = -1
+
+	header, err := reader.Read()
+	if errors.Is(err, io.EOF) {
+		return nil, Errors{{Line: 1, Err: fmt.Errorf("%w: empty file", ErrMissingColumn)}}
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	columns, err := columnIndexes(header)
+	if err != nil {
+		return nil, err
+	}
+
+	var (
+		lines    []Line
+		problems Errors
+	)
+
+	for {
+		record, err := reader.Read()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		number, _ := reader.FieldPos(0)
+		if isBlank(record) {
+			continue
+		}
+
+		value := func(column string) string {
+			index, ok := columns[column]
+			if !ok || index >= len(record) {
+				return ""
+			}
+			return strings.TrimSpace(record[index])
+		}
+
+		line, errs := p.parseRecord(number, value)
+		if len(errs) > 0 {
+			problems = append(problems, errs...)
+			continue
+		}
+
+		lines = append(lines, line)
+	}
+
+	if len(problems) > 0 {
+		return nil, problems
+	}
+
+	return lines, nil
+}
+
+func (p *parser) parseRecord(number int, value func(string) string) (Line, Errors) {
+	var errs Errors
+	problem := func(column, v string, err error) {
+		errs = append(errs, &LineError{Line: number, Column: column, Value: v, Err: err})
+	}
+
+	line := Line{
+		Line:        number,
+		ReferenceID: value(ColumnReference),
+		TxnID:       value(ColumnTxnID),
+		MSISDN:      value(ColumnMSISDN),
+		Status:      value(ColumnStatus),
+	}
+
+	if line.ReferenceID == "" && line.TxnID == "" {
+		errs = append(errs, &LineError{Line: number, Err: ErrMissingID})
+	}
+
+	rawAmount := value(ColumnAmount)
+	amount, err := money.Parse(strings.ReplaceAll(rawAmount, ",", ""))
+	if err != nil {
+		problem(ColumnAmount, rawAmount, ErrInvalidAmount)
+	}
+	if amount.Sign() < 0 {
+		amount = money.New(-amount.Minor(), amount.Currency())
+	}
+	line.Amount = amount
+
+	if rawTime := value(ColumnTime); rawTime != "" {
+		t, ok := p.parseTime(rawTime)
+		if !ok {
+			problem(ColumnTime, rawTime, ErrInvalidTime)
+		}
+		line.Time = t
+	}
+
+	return line, errs
+}
+
+func (p *parser) parseTime(value string) (time.Time, bool) {
+	for _, layout := range p.layouts {
+		if t, err := time.ParseInLocation(layout, value, p.location); err == nil {
+			return t, true
+		}
+	}
+
+	return time.Time{}, false
+}
+
+func columnIndexes(header []string) (map[string]int, error) {
+	columns := make(map[string]int)
+	for i, name := range header {
+		name = strings.ToLower(strings.TrimSpace(strings.TrimPrefix(name, byteOrderMark)))
+		name = strings.NewReplacer(" ", "_", "-", "_", ".", "").Replace(name)
+		column, ok := aliases[name]
+		if !ok {
+			continue
+		}
+		if _, ok := columns[column]; !ok {
+			columns[column] = i
+		}
+	}
+
+	var problems Errors
+	if _, ok := columns[ColumnAmount]; !ok {
+		problems = append(problems, &LineError{Line: 1, Err: fmt.Errorf("%w: %s", ErrMissingColumn, ColumnAmount)})
+	}
+	_, hasReference := columns[ColumnReference]
+	_, hasTxnID := columns[ColumnTxnID]
+	if !hasReference && !hasTxnID {
+		problems = append(problems, &LineError{
+			Line: 1,
+			Err:  fmt.Errorf("%w: %s or %s", ErrMissingColumn, ColumnReference, ColumnTxnID),
+		})
+	}
+
+	if len(problems) > 0 {
+		return nil, problems
+	}
+
+	return columns, nil
+}
+
+func isBlank(record []string) bool {
+	for _, field := range record {
+		if strings.TrimSpace(field) != "" {
+			return false
+		}
+	}
+
+	return true
+}
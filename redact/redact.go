@@ -0,0 +1,168 @@
+/*
+ * MIT License
+ *
+ * Copyright (c) 2021 TechCraft Technologies Co. Ltd
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ *
+ */
+
+// Package redact masks secrets in the request and response dumps written
+// by the clients in debug mode so that the debug output can be shipped to a
+// log aggregator.
+//
+// PINs, passwords, Authorization headers and access tokens are always
+// masked. Masking of MSISDNs can be enabled with WithMSISDNMasking.
+package redact
+
+import (
+	"io"
+	"regexp"
+	"strings"
+)
+
+// Mask replaces the masked secrets
+const Mask = "[REDACTED]"
+
+var (
+	_ io.Writer = (*writer)(nil)
+
+	// every rule has three groups: the text before the value, the value
+	// itself and the text after it
+	secretRules = []*regexp.Regexp{
+		// http headers in request and response dumps
+		regexp.MustCompile(`(?im)^((?:authorization|proxy-authorization|password|cookie|set-cookie)[ \t]*:[ \t]*)([^\r\n]*)()`),
+		// xml elements like <PIN>1234</PIN>
+		regexp.MustCompile(`(?i)(<(?:pin|password|access_token)>)([^<]*)(</(?:pin|password|access_token)>)`),
+		// json fields like "access_token":"..."
+		regexp.MustCompile(`(?i)("(?:pin|password|access_token|refresh_token)"\s*:\s*")((?:[^"\\]|\\.)*)(")`),
+		// url encoded forms like username=user&password=secret
+		regexp.MustCompile(`(?i)((?:^|[&?\s])(?:pin|password|access_token|refresh_token)=)([^&\s]*)()`),
+	}
+
+	msisdnRules = []*regexp.Regexp{
+		regexp.MustCompile(`(?i)(<[a-z_]*msisdn\d*>)([^<]*)(</[a-z_]*msisdn\d*>)`),
+		regexp.MustCompile(`(?i)("[a-z_]*msisdn\d*"\s*:\s*")([^"]*)(")`),
+	}
+)
+
+type (
+	// Redactor masks secrets in text, it is safe for concurrent use
+	Redactor struct {
+		maskMSISDN bool
+	}
+
+	// Option configures a Redactor
+	Option func(r *Redactor)
+
+	writer struct {
+		out      io.Writer
+		redactor *Redactor
+	}
+)
+
+// New returns a Redactor that masks secrets and, when configured so, MSISDNs
+func New(opts ...Option) *Redactor {
+	r := &Redactor{}
+	for _, opt := range opts {
+		opt(r)
+	}
+
+	return r
+}
+
+// WithMSISDNMasking makes the Redactor mask MSISDNs too, only the country code
+// and the last 3 digits are kept e.g. 255******456
+func WithMSISDNMasking() Option {
+	return func(r *Redactor) {
+		r.maskMSISDN = true
+	}
+}
+
+// Redact returns s with all the secrets masked
+func (r *Redactor) Redact(s string) string {
+	for _, rule := range secretRules {
+		s = replace(rule, s, func(string) string {
+			return Mask
+		})
+	}
+
+	if r.maskMSISDN {
+		for _, rule := range msisdnRules {
+			s = replace(rule, s, maskMSISDN)
+		}
+	}
+
+	return s
+}
+
+// Writer returns an io.Writer that redacts everything written to it before
+// passing it to out. If out is already a redacting writer its redactor is
+// replaced instead of redacting twice.
+//
+// Each call to Write is redacted on its own, a secret split between two
+// calls will not be masked.
+func (r *Redactor) Writer(out io.Writer) io.Writer {
+	if w, ok := out.(*writer); ok {
+		out = w.out
+	}
+
+	return &writer{
+		out:      out,
+		redactor: r,
+	}
+}
+
+func (w *writer) Write(p []byte) (int, error) {
+	if _, err := io.WriteString(w.out, w.redactor.Redact(string(p))); err != nil {
+		return 0, err
+	}
+
+	return len(p), nil
+}
+
+// replace calls fn with the value matched by every match of rule and
+// replaces the value with what fn returns.
+func replace(rule *regexp.Regexp, s string, fn func(string) string) string {
+	matches := rule.FindAllStringSubmatchIndex(s, -1)
+	if matches == nil {
+		return s
+	}
+
+	var b strings.Builder
+	last := 0
+	for _, m := range matches {
+		start, end := m[4], m[5]
+		b.WriteString(s[last:start])
+		b.WriteString(fn(s[start:end]))
+		last = end
+	}
+	b.WriteString(s[last:])
+
+	return b.String()
+}
+
+func maskMSISDN(msisdn string) string {
+	const keepStart, keepEnd = 3, 3
+	if len(msisdn) <= keepStart+keepEnd {
+		return strings.Repeat("*", len(msisdn))
+	}
+
+	return msisdn[:keepStart] + strings.Repeat("*", len(msisdn)-keepStart-keepEnd) + msisdn[len(msisdn)-keepEnd:]
+}
@@ -0,0 +1,119 @@
+/*
+ * MIT License
+ *
+ * Copyright (c) 2021 TechCraft Technologies Co. Ltd
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ *
+ */
+
+package redact_test
+
+import (
+	"bytes"
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/techcraftlabs/tigopesa/disburse"
+	"github.com/techcraftlabs/tigopesa/money"
+	"github.com/techcraftlabs/tigopesa/push"
+	"github.com/techcraftlabs/tigopesa/redact"
+	"github.com/techcraftlabs/tigopesa/tigotest"
+)
+
+func TestRedactor_Redact(t *testing.T) {
+	tests := []struct {
+		name string
+		in   string
+		want string
+		opts []redact.Option
+	}{
+		{
+			name: "headers",
+			in:   "POST /push HTTP/1.1\r\nAuthorization: bearer abc.def\r\nPassword: secret\r\nUsername: user\r\n",
+			want: "POST /push HTTP/1.1\r\nAuthorization: [REDACTED]\r\nPassword: [REDACTED]\r\nUsername: user\r\n",
+		},
+		{
+			name: "xml",
+			in:   "<COMMAND><PIN>1234</PIN><MSISDN1>255713123456</MSISDN1></COMMAND>",
+			want: "<COMMAND><PIN>[REDACTED]</PIN><MSISDN1>255713123456</MSISDN1></COMMAND>",
+		},
+		{
+			name: "json",
+			in:   `{"access_token":"abc\"def","token_type":"bearer","Password":"x"}`,
+			want: `{"access_token":"[REDACTED]","token_type":"bearer","Password":"[REDACTED]"}`,
+		},
+		{
+			name: "form",
+			in:   "grant_type=password&password=s3cret&username=user",
+			want: "grant_type=password&password=[REDACTED]&username=user",
+		},
+		{
+			name: "msisdn",
+			in:   `<MSISDN>255713123456</MSISDN> {"CustomerMSISDN":"255713123456","msisdn":"0713123456"}`,
+			want: `<MSISDN>255******456</MSISDN> {"CustomerMSISDN":"255******456","msisdn":"071****456"}`,
+			opts: []redact.Option{redact.WithMSISDNMasking()},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := redact.New(tt.opts...).Redact(tt.in); got != tt.want {
+				t.Errorf("Redact()\ngot  %q\nwant %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestClientsDebugOutput(t *testing.T) {
+	server := tigotest.NewServer()
+	defer server.Close()
+	server.ScriptPush(tigotest.Outcome{NoCallback: true})
+
+	var logs bytes.Buffer
+	ctx := context.Background()
+
+	dc := disburse.NewClient(server.DisburseConfig(), disburse.WithLogger(&logs), disburse.WithDebugMode(true))
+	if _, err := dc.Disburse(ctx, disburse.Request{ReferenceID: "REF1", MSISDN: "255713123456", Amount: money.Shillings(1000)}); err != nil {
+		t.Fatal(err)
+	}
+
+	pc := push.NewClient(server.PushConfig(), nil, push.WithLogger(&logs), push.WithDebugMode(true),
+		push.WithRedactor(redact.New(redact.WithMSISDNMasking())))
+	if _, err := pc.Pay(ctx, push.Request{MSISDN: "255713123456", Amount: money.Shillings(1000), ReferenceID: "REF2"}); err != nil {
+		t.Fatal(err)
+	}
+
+	out := logs.String()
+	if !strings.Contains(out, redact.Mask) {
+		t.Fatalf("nothing was redacted:\n%s", out)
+	}
+
+	for _, secret := range []string{
+		"<PIN>" + tigotest.PIN,
+		"password=" + tigotest.Password,
+		"Password: " + tigotest.Password,
+		"tigotest-token-1",
+		`"CustomerMSISDN":"255713123456"`,
+	} {
+		if strings.Contains(out, secret) {
+			t.Errorf("debug output contains %q:\n%s", secret, out)
+		}
+	}
+}
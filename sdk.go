@@ -4,7 +4,9 @@ import (
 	"context"
 	"github.com/techcraftlabs/base/io"
 	"github.com/techcraftlabs/tigopesa/disburse"
+	"github.com/techcraftlabs/tigopesa/events"
 	"github.com/techcraftlabs/tigopesa/push"
+	"github.com/techcraftlabs/tigopesa/redact"
 	"github.com/techcraftlabs/tigopesa/ussd"
 	stdio "io"
 	"net/http"
@@ -29,6 +31,15 @@ type (
 		p         *push.Client
 		u         *ussd.Client
 		d         *disburse.Client
+
+		tokenStore  push.TokenStore
+		retryPolicy disburse.RetryPolicy
+		redactor    *redact.Redactor
+		sinks       []events.Sink
+
+		pushOpts     []push.ClientOption
+		disburseOpts []disburse.ClientOption
+		ussdOpts     []ussd.ClientOption
 	}
 
 	Config struct {
@@ -46,14 +57,56 @@ func (c *Client) Pay(ctx context.Context, request push.Request) (push.PayRespons
 	return c.p.Pay(ctx, request)
 }
 
+// CheckPay returns request as Pay sends it or the error Pay fails with
+// before calling tigo, see push.Client.Check
+func (c *Client) CheckPay(request push.Request) (push.Request, error) {
+	return c.p.Check(request)
+}
+
+// PayAndWait sends push pay request and waits for its callback, see push.Client.PayAndWait
+func (c *Client) PayAndWait(ctx context.Context, request push.Request) (push.PayResult, error) {
+	return c.p.PayAndWait(ctx, request)
+}
+
+// QueryStatus asks tigo for the state of a push pay request, see push.Client.QueryStatus
+func (c *Client) QueryStatus(ctx context.Context, referenceID string) (push.PayResult, error) {
+	return c.p.QueryStatus(ctx, referenceID)
+}
+
 func (c *Client) CallbackServeHTTP(writer http.ResponseWriter, r *http.Request) {
 	c.p.CallbackServeHTTP(writer, r)
 }
 
+// UseCallback adds middlewares to the push callback handler, see push.Client.Use
+func (c *Client) UseCallback(middlewares ...push.CallbackMiddleware) {
+	c.p.Use(middlewares...)
+}
+
+// UsePayment adds middlewares to the ussd payment handler, see ussd.Client.UsePayment
+func (c *Client) UsePayment(middlewares ...ussd.PaymentMiddleware) {
+	c.u.UsePayment(middlewares...)
+}
+
+// UseNameQuery adds middlewares to the ussd name query handler, see ussd.Client.UseNameQuery
+func (c *Client) UseNameQuery(middlewares ...ussd.NameQueryMiddleware) {
+	c.u.UseNameQuery(middlewares...)
+}
+
 func (c *Client) Disburse(ctx context.Context, request disburse.Request) (disburse.Response, error) {
 	return c.d.Disburse(ctx, request)
 }
 
+// CheckDisburse returns request as Disburse sends it or the error Disburse
+// fails with before calling tigo, see disburse.Client.Check
+func (c *Client) CheckDisburse(request disburse.Request) (disburse.Request, error) {
+	return c.d.Check(request)
+}
+
+// DisburseBatch sends many disbursements at once, see disburse.Client.DisburseBatch
+func (c *Client) DisburseBatch(ctx context.Context, requests []disburse.Request, opts disburse.BatchOptions) (disburse.BatchReport, error) {
+	return c.d.DisburseBatch(ctx, requests, opts)
+}
+
 func (c *Client) NameQueryServeHTTP(writer http.ResponseWriter, request *http.Request) {
 	c.u.NameQueryServeHTTP(writer, request)
 }
@@ -80,16 +133,41 @@ func NewClient(config *Config, handler push.CallbackHandler, paymentHandler ussd
 		opt(client)
 	}
 
+	var sink events.Sink = events.Discard
+	if len(client.sinks) > 0 {
+		sink = events.FanOut(client.sinks...)
+	}
+
 	disburseConfig := config.Disburse
 	pushConfig := config.Push
 	ussdConfig := config.Ussd
-	client.d = disburse.NewClient(disburseConfig, disburse.WithLogger(client.logger),
-		disburse.WithDebugMode(client.debugMode), disburse.WithHTTPClient(client.base))
-	client.u = ussd.NewClient(ussdConfig, paymentHandler, queryHandler,
+	disburseOpts := []disburse.ClientOption{
+		disburse.WithLogger(client.logger),
+		disburse.WithDebugMode(client.debugMode),
+		disburse.WithHTTPClient(client.base),
+		disburse.WithRetryPolicy(client.retryPolicy),
+		disburse.WithRedactor(client.redactor),
+		disburse.WithEventSink(sink),
+	}
+	client.d = disburse.NewClient(disburseConfig, append(disburseOpts, client.disburseOpts...)...)
+
+	ussdOpts := []ussd.ClientOption{
 		ussd.WithDebugMode(client.debugMode),
 		ussd.WithLogger(client.logger),
-		ussd.WithHTTPClient(client.base))
-	client.p = push.NewClient(pushConfig, handler, push.WithLogger(client.logger),
-		push.WithDebugMode(client.debugMode), push.WithHTTPClient(client.base))
+		ussd.WithHTTPClient(client.base),
+		ussd.WithRedactor(client.redactor),
+		ussd.WithEventSink(sink),
+	}
+	client.u = ussd.NewClient(ussdConfig, paymentHandler, queryHandler, append(ussdOpts, client.ussdOpts...)...)
+
+	pushOpts := []push.ClientOption{
+		push.WithLogger(client.logger),
+		push.WithDebugMode(client.debugMode),
+		push.WithHTTPClient(client.base),
+		push.WithTokenStore(client.tokenStore),
+		push.WithRedactor(client.redactor),
+		push.WithEventSink(sink),
+	}
+	client.p = push.NewClient(pushConfig, handler, append(pushOpts, client.pushOpts...)...)
 	return client
 }
@@ -0,0 +1,153 @@
+/*
+ * MIT License
+ *
+ * Copyright (c) 2021 TechCraft Technologies Co. Ltd
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ *
+ */
+
+// Package tigoerr maps the result codes returned by tigo (error000 to error111)
+// to Go errors that can be inspected with errors.Is and errors.As.
+//
+//	res, err := client.Disburse(ctx, request)
+//	if errors.Is(err, tigoerr.ErrInsufficientFunds) {
+//		// top up the disbursement account
+//	}
+//	if errors.Is(err, tigoerr.ErrRetryable) {
+//		// safe to send again with the same reference
+//	}
+package tigoerr
+
+import (
+	"errors"
+	"fmt"
+)
+
+const (
+	CodeSuccess                  = "error000"
+	CodeServiceNotAvailable      = "error001"
+	CodeInvalidCustomerRefNum    = "error010"
+	CodeCustomerRefNumLocked     = "error011"
+	CodeInvalidAmount            = "error012"
+	CodeAmountInsufficient       = "error013"
+	CodeAmountTooHigh            = "error014"
+	CodeAmountTooLow             = "error015"
+	CodeInvalidPayment           = "error016"
+	CodeGeneralError             = "error100"
+	CodeRetryConditionNoResponse = "error111"
+)
+
+var (
+	ErrServiceNotAvailable = errors.New("service not available")
+	ErrInvalidReference    = errors.New("invalid customer reference number")
+	ErrReferenceLocked     = errors.New("customer reference number locked")
+	ErrInvalidAmount       = errors.New("invalid amount")
+	ErrInsufficientFunds   = errors.New("amount insufficient")
+	ErrAmountTooHigh       = errors.New("amount too high")
+	ErrAmountTooLow        = errors.New("amount too low")
+	ErrInvalidPayment      = errors.New("invalid payment")
+	ErrGeneral             = errors.New("general error")
+	ErrNoResponse          = errors.New("retry condition: no response")
+	ErrUnknownCode         = errors.New("unknown result code")
+
+	// ErrRetryable matches every *Error whose code means the request can be
+	// sent again, see IsRetryable.
+	ErrRetryable = errors.New("retryable error")
+)
+
+var (
+	_ error = (*Error)(nil)
+
+	kinds = map[string]error{
+		CodeServiceNotAvailable:      ErrServiceNotAvailable,
+		CodeInvalidCustomerRefNum:    ErrInvalidReference,
+		CodeCustomerRefNumLocked:     ErrReferenceLocked,
+		CodeInvalidAmount:            ErrInvalidAmount,
+		CodeAmountInsufficient:       ErrInsufficientFunds,
+		CodeAmountTooHigh:            ErrAmountTooHigh,
+		CodeAmountTooLow:             ErrAmountTooLow,
+		CodeInvalidPayment:           ErrInvalidPayment,
+		CodeGeneralError:             ErrGeneral,
+		CodeRetryConditionNoResponse: ErrNoResponse,
+	}
+
+	retryable = map[string]bool{
+		CodeServiceNotAvailable:      true,
+		CodeRetryConditionNoResponse: true,
+	}
+)
+
+// Error is a non successful result code returned by tigo. It unwraps to
+// one of the ErrXXX values of this package and also matches ErrRetryable
+// when the code is retryable.
+type Error struct {
+	Code    string
+	Message string
+	kind    error
+}
+
+func (e *Error) Error() string {
+	if e.Message == "" {
+		return fmt.Sprintf("tigo: %s: %v", e.Code, e.kind)
+	}
+
+	return fmt.Sprintf("tigo: %s: %v: %s", e.Code, e.kind, e.Message)
+}
+
+func (e *Error) Unwrap() error {
+	return e.kind
+}
+
+func (e *Error) Is(target error) bool {
+	return target == ErrRetryable && IsRetryable(e.Code)
+}
+
+// Retryable reports whether the request can be sent again with the same
+// reference. It is the same as IsRetryable(e.Code).
+func (e *Error) Retryable() bool {
+	return IsRetryable(e.Code)
+}
+
+// FromCode returns the *Error for code with message as the message
+// sent by tigo. It returns nil when code is CodeSuccess. Codes this
+// package does not know unwrap to ErrUnknownCode.
+func FromCode(code, message string) error {
+	if code == CodeSuccess {
+		return nil
+	}
+
+	kind, ok := kinds[code]
+	if !ok {
+		kind = ErrUnknownCode
+	}
+
+	return &Error{
+		Code:    code,
+		Message: message,
+		kind:    kind,
+	}
+}
+
+// IsRetryable reports whether a request that failed with code can be
+// sent again. Only CodeServiceNotAvailable and CodeRetryConditionNoResponse
+// are retryable, every other code is final.
+func IsRetryable(code string) bool {
+	return retryable[code]
+}
@@ -0,0 +1,101 @@
+/*
+ * MIT License
+ *
+ * Copyright (c) 2021 TechCraft Technologies Co. Ltd
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ *
+ */
+
+package tigoerr_test
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/techcraftlabs/tigopesa/tigoerr"
+)
+
+func TestFromCode(t *testing.T) {
+	tests := []struct {
+		name      string
+		code      string
+		want      error
+		retryable bool
+	}{
+		{
+			name: "success",
+			code: tigoerr.CodeSuccess,
+			want: nil,
+		},
+		{
+			name:      "service not available",
+			code:      tigoerr.CodeServiceNotAvailable,
+			want:      tigoerr.ErrServiceNotAvailable,
+			retryable: true,
+		},
+		{
+			name: "insufficient funds",
+			code: tigoerr.CodeAmountInsufficient,
+			want: tigoerr.ErrInsufficientFunds,
+		},
+		{
+			name: "amount too high",
+			code: tigoerr.CodeAmountTooHigh,
+			want: tigoerr.ErrAmountTooHigh,
+		},
+		{
+			name:      "no response",
+			code:      tigoerr.CodeRetryConditionNoResponse,
+			want:      tigoerr.ErrNoResponse,
+			retryable: true,
+		},
+		{
+			name: "unknown",
+			code: "error999",
+			want: tigoerr.ErrUnknownCode,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tigoerr.FromCode(tt.code, "message")
+			if tt.want == nil {
+				if err != nil {
+					t.Fatalf("FromCode() = %v, want nil", err)
+				}
+				return
+			}
+
+			wrapped := fmt.Errorf("disburse: %w", err)
+			if !errors.Is(wrapped, tt.want) {
+				t.Errorf("errors.Is(%v, %v) = false", wrapped, tt.want)
+			}
+
+			if got := errors.Is(wrapped, tigoerr.ErrRetryable); got != tt.retryable {
+				t.Errorf("errors.Is(%v, ErrRetryable) = %v, want %v", wrapped, got, tt.retryable)
+			}
+
+			var e *tigoerr.Error
+			if !errors.As(wrapped, &e) || e.Code != tt.code || e.Message != "message" {
+				t.Errorf("errors.As(%v) = %+v", wrapped, e)
+			}
+		})
+	}
+}
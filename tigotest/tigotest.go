@@ -0,0 +1,600 @@
+/*
+ * MIT License
+ *
+ * Copyright (c) 2021 TechCraft Technologies Co. Ltd
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ *
+ */
+
+// Package tigotest provides a fake tigo gateway for integration tests.
+//
+// Server plays every role tigo has towards an integration: it issues push
+// pay tokens, accepts push pay requests, posts their callbacks and answers
+// status inquiries about them, answers disbursement (REQMFCI) requests and sends namecheck and wallet to account
+// payment requests to the ussd handlers under test.
+//
+//	server := tigotest.NewServer()
+//	defer server.Close()
+//
+//	client := push.NewClient(server.PushConfig(), handler)
+//	callbacks := httptest.NewServer(http.HandlerFunc(client.CallbackServeHTTP))
+//	server.SetCallbackURL(callbacks.URL)
+//
+//	server.ScriptPush(tigotest.Outcome{CallbackFailed: true})
+//	result, err := client.PayAndWait(ctx, request)
+//
+// Every role replies with success unless scripted otherwise, scripted
+// outcomes are used once each in the order they were given.
+package tigotest
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"time"
+
+	"github.com/techcraftlabs/tigopesa/disburse"
+	"github.com/techcraftlabs/tigopesa/money"
+	"github.com/techcraftlabs/tigopesa/push"
+)
+
+const (
+	TokenEndpoint    = "/token"
+	PushPayEndpoint  = "/push"
+	StatusEndpoint   = "/push/status"
+	DisburseEndpoint = "/disburse"
+
+	Username     = "tigotest"
+	Password     = "tigotest"
+	BillerMSISDN = "255713000000"
+	BillerCode   = "TEST"
+	AccountName  = "TIGOTEST"
+	BrandID      = "1001"
+	PIN          = "1234"
+
+	tokenLifetime = 3600
+)
+
+type (
+	// Outcome scripts how the server handles a single request.
+	Outcome struct {
+		// HTTPStatus is the status code of the reply, zero means 200
+		HTTPStatus int
+
+		// Code is the result code of the reply: the ResponseCode of a push
+		// pay acknowledgement or the TXNSTATUS of a disbursement. The
+		// default is push.SuccessCode and error000 respectively.
+		Code    string
+		Message string
+
+		// Delay is how long the server waits before replying
+		Delay time.Duration
+
+		// Hang keeps the request open until the client gives up, use it
+		// to simulate timeouts.
+		Hang bool
+
+		// CallbackDelay is how long after the acknowledgement the push pay
+		// callback is posted. CallbackFailed makes the callback report the
+		// customer did not complete the payment and NoCallback means tigo
+		// never posts it, the transaction still completes and its status
+		// can be queried.
+		CallbackDelay  time.Duration
+		CallbackFailed bool
+		NoCallback     bool
+	}
+
+	// PushRequest is a push pay request received by the server
+	PushRequest struct {
+		CustomerMSISDN string
+		BillerMSISDN   string
+		Amount         json.Number
+		Remarks        string
+		ReferenceID    string
+		Authorization  string
+	}
+
+	// DisburseRequest is a disbursement request received by the server
+	DisburseRequest struct {
+		XMLName     xml.Name `xml:"COMMAND"`
+		Type        string   `xml:"TYPE"`
+		ReferenceID string   `xml:"REFERENCEID"`
+		Msisdn      string   `xml:"MSISDN"`
+		PIN         string   `xml:"PIN"`
+		Msisdn1     string   `xml:"MSISDN1"`
+		Amount      string   `xml:"AMOUNT"`
+		SenderName  string   `xml:"SENDERNAME"`
+		Language1   string   `xml:"LANGUAGE1"`
+		BrandID     string   `xml:"BRAND_ID"`
+	}
+
+	disburseResponse struct {
+		XMLName     xml.Name `xml:"COMMAND"`
+		Type        string   `xml:"TYPE"`
+		ReferenceID string   `xml:"REFERENCEID"`
+		TxnID       string   `xml:"TXNID"`
+		TxnStatus   string   `xml:"TXNSTATUS"`
+		Message     string   `xml:"MESSAGE"`
+	}
+
+	statusRequest struct {
+		BillerMSISDN string
+		ReferenceID  string
+	}
+
+	// Server is a fake tigo gateway backed by httptest.Server
+	Server struct {
+		*httptest.Server
+
+		mu             sync.Mutex
+		callbackURL    string
+		tokens         map[string]bool
+		tokenCount     int
+		txnCount       int
+		tokenScript    []Outcome
+		pushScript     []Outcome
+		disburseScript []Outcome
+		pushes         []PushRequest
+		disbursements  []DisburseRequest
+		transactions   map[string]push.StatusResponse
+
+		http      *http.Client
+		callbacks sync.WaitGroup
+		closed    chan struct{}
+		closeOnce sync.Once
+	}
+)
+
+// NewServer starts a Server, it must be closed with Close
+func NewServer() *Server {
+	s := &Server{
+		tokens:       make(map[string]bool),
+		transactions: make(map[string]push.StatusResponse),
+		http:         &http.Client{Timeout: 10 * time.Second},
+		closed:       make(chan struct{}),
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc(TokenEndpoint, s.handleToken)
+	mux.HandleFunc(PushPayEndpoint, s.handlePush)
+	mux.HandleFunc(StatusEndpoint, s.handleStatus)
+	mux.HandleFunc(DisburseEndpoint, s.handleDisburse)
+	s.Server = httptest.NewServer(mux)
+
+	return s
+}
+
+// Close releases requests kept open by Outcome.Hang, shuts the server down
+// and waits for pending push callbacks to be posted.
+func (s *Server) Close() {
+	s.closeOnce.Do(func() {
+		close(s.closed)
+		s.Server.Close()
+		s.callbacks.Wait()
+	})
+}
+
+// PushConfig returns the push.Config pointing to this server
+func (s *Server) PushConfig() *push.Config {
+	return &push.Config{
+		Username:          Username,
+		Password:          Password,
+		PasswordGrantType: "password",
+		BaseURL:           s.URL,
+		TokenEndpoint:     TokenEndpoint,
+		BillerMSISDN:      BillerMSISDN,
+		BillerCode:        BillerCode,
+		PushPayEndpoint:   PushPayEndpoint,
+		StatusEndpoint:    StatusEndpoint,
+	}
+}
+
+// DisburseConfig returns the disburse.Config pointing to this server
+func (s *Server) DisburseConfig() *disburse.Config {
+	return &disburse.Config{
+		AccountName:   AccountName,
+		AccountMSISDN: BillerMSISDN,
+		BrandID:       BrandID,
+		PIN:           PIN,
+		RequestURL:    s.URL + DisburseEndpoint,
+	}
+}
+
+// SetCallbackURL sets where push pay callbacks are posted, usually the
+// URL of a server running push.Client.CallbackServeHTTP.
+func (s *Server) SetCallbackURL(url string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.callbackURL = url
+}
+
+// ScriptToken queues outcomes for the next token requests
+func (s *Server) ScriptToken(outcomes ...Outcome) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.tokenScript = append(s.tokenScript, outcomes...)
+}
+
+// ScriptPush queues outcomes for the next push pay requests
+func (s *Server) ScriptPush(outcomes ...Outcome) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.pushScript = append(s.pushScript, outcomes...)
+}
+
+// ScriptDisburse queues outcomes for the next disbursement requests
+func (s *Server) ScriptDisburse(outcomes ...Outcome) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.disburseScript = append(s.disburseScript, outcomes...)
+}
+
+// RevokeTokens makes every token issued so far invalid, push pay requests
+// using them are rejected with http.StatusUnauthorized.
+func (s *Server) RevokeTokens() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.tokens = make(map[string]bool)
+}
+
+// TokenRequests returns the number of tokens issued
+func (s *Server) TokenRequests() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.tokenCount
+}
+
+// PushRequests returns the push pay requests received so far
+func (s *Server) PushRequests() []PushRequest {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]PushRequest(nil), s.pushes...)
+}
+
+// DisburseRequests returns the disbursement requests received so far
+func (s *Server) DisburseRequests() []DisburseRequest {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]DisburseRequest(nil), s.disbursements...)
+}
+
+func next(script *[]Outcome) Outcome {
+	if len(*script) == 0 {
+		return Outcome{}
+	}
+
+	outcome := (*script)[0]
+	*script = (*script)[1:]
+
+	return outcome
+}
+
+// wait applies the Delay and Hang of outcome, it returns false if the
+// client went away in the meantime.
+func (s *Server) wait(r *http.Request, outcome Outcome) bool {
+	if outcome.Hang {
+		select {
+		case <-r.Context().Done():
+		case <-s.closed:
+		}
+		return false
+	}
+
+	if outcome.Delay <= 0 {
+		return true
+	}
+
+	timer := time.NewTimer(outcome.Delay)
+	defer timer.Stop()
+
+	select {
+	case <-r.Context().Done():
+		return false
+	case <-s.closed:
+		return false
+	case <-timer.C:
+		return true
+	}
+}
+
+func status(outcome Outcome) int {
+	if outcome.HTTPStatus == 0 {
+		return http.StatusOK
+	}
+
+	return outcome.HTTPStatus
+}
+
+func writeJSON(w http.ResponseWriter, statusCode int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(statusCode)
+	_ = json.NewEncoder(w).Encode(v)
+}
+
+func writeXML(w http.ResponseWriter, statusCode int, v interface{}) {
+	w.Header().Set("Content-Type", "application/xml")
+	w.WriteHeader(statusCode)
+	_ = xml.NewEncoder(w).Encode(v)
+}
+
+func (s *Server) handleToken(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	outcome := next(&s.tokenScript)
+	s.mu.Unlock()
+
+	if !s.wait(r, outcome) {
+		return
+	}
+
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	badCredentials := r.PostForm.Get("username") != Username || r.PostForm.Get("password") != Password
+	if outcome.HTTPStatus >= http.StatusBadRequest || badCredentials {
+		statusCode := outcome.HTTPStatus
+		if statusCode == 0 {
+			statusCode = http.StatusUnauthorized
+		}
+		writeJSON(w, statusCode, map[string]string{
+			"error":             "invalid_grant",
+			"error_description": "invalid username or password",
+		})
+		return
+	}
+
+	s.mu.Lock()
+	s.tokenCount++
+	token := fmt.Sprintf("tigotest-token-%d", s.tokenCount)
+	s.tokens[token] = true
+	s.mu.Unlock()
+
+	writeJSON(w, http.StatusOK, push.TokenResponse{
+		AccessToken: token,
+		TokenType:   "bearer",
+		ExpiresIn:   tokenLifetime,
+	})
+}
+
+func (s *Server) handlePush(w http.ResponseWriter, r *http.Request) {
+	var request PushRequest
+	decoder := json.NewDecoder(r.Body)
+	decoder.UseNumber()
+	if err := decoder.Decode(&request); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	request.Authorization = r.Header.Get("Authorization")
+
+	amount, err := money.Parse(request.Amount.String())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	s.mu.Lock()
+	outcome := next(&s.pushScript)
+	s.pushes = append(s.pushes, request)
+	authorized := s.authorized(request.Authorization)
+	s.mu.Unlock()
+
+	if !s.wait(r, outcome) {
+		return
+	}
+
+	if !authorized {
+		writeJSON(w, http.StatusUnauthorized, push.PayResponse{
+			ResponseCode:        push.FailureCode,
+			ResponseDescription: "unauthorized",
+			ReferenceID:         request.ReferenceID,
+		})
+		return
+	}
+
+	code := outcome.Code
+	if code == "" {
+		code = push.SuccessCode
+	}
+	accepted := code == push.SuccessCode && status(outcome) < http.StatusBadRequest
+
+	description := outcome.Message
+	if description == "" && accepted {
+		description = "Success"
+	}
+
+	writeJSON(w, status(outcome), push.PayResponse{
+		ResponseCode:        code,
+		ResponseStatus:      accepted,
+		ResponseDescription: description,
+		ReferenceID:         request.ReferenceID,
+	})
+
+	if accepted {
+		s.mu.Lock()
+		s.transactions[request.ReferenceID] = push.StatusResponse{
+			ReferenceID:       request.ReferenceID,
+			Amount:            amount,
+			TransactionStatus: "PENDING",
+		}
+		s.mu.Unlock()
+
+		s.callbacks.Add(1)
+		go s.callback(request, outcome)
+	}
+}
+
+// authorized must be called with s.mu held
+func (s *Server) authorized(header string) bool {
+	var token string
+	if _, err := fmt.Sscanf(header, "bearer %s", &token); err != nil {
+		return false
+	}
+
+	return s.tokens[token]
+}
+
+func (s *Server) callback(request PushRequest, outcome Outcome) {
+	defer s.callbacks.Done()
+
+	if outcome.CallbackDelay > 0 {
+		select {
+		case <-s.closed:
+			return
+		case <-time.After(outcome.CallbackDelay):
+		}
+	}
+
+	s.mu.Lock()
+	url := s.callbackURL
+	s.txnCount++
+	txnID := fmt.Sprintf("MFS%08d", s.txnCount)
+	transaction := s.transactions[request.ReferenceID]
+	transaction.TransactionStatus = "SUCCESS"
+	transaction.MFSTransactionID = txnID
+	if outcome.CallbackFailed {
+		transaction.TransactionStatus = "FAILED"
+		transaction.MFSTransactionID = ""
+	}
+	s.transactions[request.ReferenceID] = transaction
+	s.mu.Unlock()
+
+	if url == "" || outcome.NoCallback {
+		return
+	}
+
+	callback := push.CallbackRequest{
+		Status:           true,
+		Description:      "Transaction completed successfully",
+		MFSTransactionID: txnID,
+		ReferenceID:      request.ReferenceID,
+		Amount:           transaction.Amount,
+	}
+	if outcome.CallbackFailed {
+		callback.Status = false
+		callback.Description = "Transaction cancelled by customer"
+		callback.MFSTransactionID = ""
+	}
+
+	buf, err := json.Marshal(callback)
+	if err != nil {
+		return
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() {
+		select {
+		case <-s.closed:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(buf))
+	if err != nil {
+		return
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	res, err := s.http.Do(req)
+	if err != nil {
+		return
+	}
+	_ = res.Body.Close()
+}
+
+func (s *Server) handleStatus(w http.ResponseWriter, r *http.Request) {
+	var request statusRequest
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	s.mu.Lock()
+	authorized := s.authorized(r.Header.Get("Authorization"))
+	transaction, found := s.transactions[request.ReferenceID]
+	s.mu.Unlock()
+
+	if !authorized {
+		writeJSON(w, http.StatusUnauthorized, push.StatusResponse{
+			ResponseCode:        push.FailureCode,
+			ResponseDescription: "unauthorized",
+			ReferenceID:         request.ReferenceID,
+		})
+		return
+	}
+
+	if !found {
+		writeJSON(w, http.StatusOK, push.StatusResponse{
+			ResponseCode:        push.FailureCode,
+			ResponseDescription: "transaction not found",
+			ReferenceID:         request.ReferenceID,
+		})
+		return
+	}
+
+	transaction.ResponseCode = push.SuccessCode
+	transaction.ResponseStatus = true
+	transaction.ResponseDescription = "Success"
+	writeJSON(w, http.StatusOK, transaction)
+}
+
+func (s *Server) handleDisburse(w http.ResponseWriter, r *http.Request) {
+	var request DisburseRequest
+	if err := xml.NewDecoder(r.Body).Decode(&request); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	s.mu.Lock()
+	outcome := next(&s.disburseScript)
+	s.disbursements = append(s.disbursements, request)
+	s.txnCount++
+	txnID := fmt.Sprintf("MP%08d", s.txnCount)
+	s.mu.Unlock()
+
+	if !s.wait(r, outcome) {
+		return
+	}
+
+	code := outcome.Code
+	if code == "" {
+		code = disburse.ErrSuccessTxn
+	}
+
+	message := outcome.Message
+	if message == "" {
+		message = fmt.Sprintf("transaction %s finished with %s", request.ReferenceID, code)
+	}
+
+	writeXML(w, status(outcome), disburseResponse{
+		Type:        "RMFCI",
+		ReferenceID: request.ReferenceID,
+		TxnID:       txnID,
+		TxnStatus:   code,
+		Message:     message,
+	})
+}
@@ -0,0 +1,188 @@
+/*
+ * MIT License
+ *
+ * Copyright (c) 2021 TechCraft Technologies Co. Ltd
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ *
+ */
+
+package tigotest_test
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/techcraftlabs/tigopesa/disburse"
+	"github.com/techcraftlabs/tigopesa/money"
+	"github.com/techcraftlabs/tigopesa/push"
+	"github.com/techcraftlabs/tigopesa/tigoerr"
+	"github.com/techcraftlabs/tigopesa/tigotest"
+	"github.com/techcraftlabs/tigopesa/ussd"
+)
+
+func newPushClient(t *testing.T, server *tigotest.Server) *push.Client {
+	client := push.NewClient(server.PushConfig(), nil, push.WithDebugMode(false))
+	callbacks := httptest.NewServer(http.HandlerFunc(client.CallbackServeHTTP))
+	t.Cleanup(callbacks.Close)
+	server.SetCallbackURL(callbacks.URL)
+	return client
+}
+
+func TestServer_Push(t *testing.T) {
+	server := tigotest.NewServer()
+	defer server.Close()
+
+	client := newPushClient(t, server)
+	server.ScriptPush(tigotest.Outcome{}, tigotest.Outcome{CallbackFailed: true, CallbackDelay: 10 * time.Millisecond})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	request := push.Request{MSISDN: "255713123456", Amount: money.Shillings(1500), ReferenceID: "ORDER1"}
+	result, err := client.PayAndWait(ctx, request)
+	if err != nil || !result.Succeeded() {
+		t.Fatalf("first payment: %+v %v", result, err)
+	}
+
+	request.ReferenceID = "ORDER2"
+	result, err = client.PayAndWait(ctx, request)
+	if err != nil || result.Succeeded() {
+		t.Fatalf("second payment should fail: %+v %v", result, err)
+	}
+
+	pushes := server.PushRequests()
+	if len(pushes) != 2 || pushes[0].ReferenceID != tigotest.BillerCode+"ORDER1" || pushes[0].Amount != "1500" {
+		t.Errorf("unexpected push requests: %+v", pushes)
+	}
+
+	if n := server.TokenRequests(); n != 1 {
+		t.Errorf("token requests: got %d want 1", n)
+	}
+}
+
+func TestServer_PushRevokedToken(t *testing.T) {
+	server := tigotest.NewServer()
+	defer server.Close()
+
+	client := newPushClient(t, server)
+	server.ScriptPush(tigotest.Outcome{NoCallback: true}, tigotest.Outcome{NoCallback: true})
+
+	request := push.Request{MSISDN: "255713123456", Amount: money.Shillings(1500), ReferenceID: "ORDER1"}
+	if _, err := client.Pay(context.Background(), request); err != nil {
+		t.Fatal(err)
+	}
+
+	server.RevokeTokens()
+
+	response, err := client.Pay(context.Background(), request)
+	if err != nil || !response.ResponseStatus {
+		t.Fatalf("pay after revoke: %+v %v", response, err)
+	}
+
+	if n := server.TokenRequests(); n != 2 {
+		t.Errorf("token requests: got %d want 2", n)
+	}
+}
+
+func TestServer_Disburse(t *testing.T) {
+	server := tigotest.NewServer()
+	defer server.Close()
+
+	client := disburse.NewClient(server.DisburseConfig(), disburse.WithDebugMode(false))
+	server.ScriptDisburse(tigotest.Outcome{Code: disburse.ErrAmountTooHigh}, tigotest.Outcome{Hang: true})
+
+	request := disburse.Request{ReferenceID: "PAY1", MSISDN: "255713123456", Amount: money.Shillings(1000)}
+	_, err := client.Disburse(context.Background(), request)
+	if !errors.Is(err, tigoerr.ErrAmountTooHigh) {
+		t.Fatalf("expected amount too high got %v", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	_, err = client.Disburse(ctx, request)
+	if !errors.Is(err, disburse.ErrOutcomeUnknown) {
+		t.Fatalf("expected outcome unknown got %v", err)
+	}
+
+	res, err := client.Disburse(context.Background(), request)
+	if err != nil || res.TxnStatus != disburse.ErrSuccessTxn {
+		t.Fatalf("expected success got %+v %v", res, err)
+	}
+
+	received := server.DisburseRequests()
+	if len(received) != 3 || received[0].PIN != tigotest.PIN || received[0].Msisdn1 != "255713123456" {
+		t.Errorf("unexpected disbursement requests: %+v", received)
+	}
+}
+
+func TestServer_USSD(t *testing.T) {
+	server := tigotest.NewServer()
+	defer server.Close()
+
+	payments := ussd.PaymentHandleFunc(func(ctx context.Context, request ussd.PayRequest) (ussd.PayResponse, error) {
+		return ussd.PayResponse{
+			TxnID:     request.TxnID,
+			RefID:     "REF" + request.TxnID,
+			Result:    "TS",
+			ErrorCode: ussd.ErrSuccessTxn,
+			Msisdn:    request.Msisdn,
+		}, nil
+	})
+	names := ussd.NameQueryFunc(func(ctx context.Context, request ussd.NameRequest) (ussd.NameResponse, error) {
+		return ussd.NameResponse{
+			Result:    "TS",
+			ErrorCode: ussd.NoNamecheckErr,
+			Msisdn:    request.Msisdn,
+			Content:   "John Doe",
+		}, nil
+	})
+	client := ussd.NewClient(&ussd.Config{}, payments, names, ussd.WithDebugMode(false))
+
+	namecheck := httptest.NewServer(http.HandlerFunc(client.NameQueryServeHTTP))
+	defer namecheck.Close()
+	billpay := httptest.NewServer(http.HandlerFunc(client.PaymentServeHTTP))
+	defer billpay.Close()
+
+	ctx := context.Background()
+	name, err := server.NameQuery(ctx, namecheck.URL, ussd.NameRequest{
+		Msisdn:              "255713123456",
+		CompanyName:         "COMPANY",
+		CustomerReferenceID: "ACC001",
+	})
+	if err != nil || name.Content != "John Doe" || name.Msisdn != "255713123456" {
+		t.Fatalf("name query: %+v %v", name, err)
+	}
+
+	pay, err := server.BillPay(ctx, billpay.URL, ussd.PayRequest{
+		TxnID:               "TXN001",
+		Msisdn:              "255713123456",
+		Amount:              money.Shillings(2500),
+		CompanyName:         "COMPANY",
+		CustomerReferenceID: "ACC001",
+		SenderName:          "John Doe",
+	})
+	if err != nil || pay.RefID != "REFTXN001" || pay.ErrorCode != ussd.ErrSuccessTxn {
+		t.Fatalf("bill pay: %+v %v", pay, err)
+	}
+}
@@ -0,0 +1,142 @@
+/*
+ * MIT License
+ *
+ * Copyright (c) 2021 TechCraft Technologies Co. Ltd
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ *
+ */
+
+package tigotest
+
+import (
+	"bytes"
+	"context"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+
+	"github.com/techcraftlabs/tigopesa/ussd"
+)
+
+const (
+	syncLookup  = "SYNC_LOOKUP"
+	syncBillPay = "SYNC_BILLPAY"
+)
+
+type (
+	nameCommand struct {
+		XMLName             xml.Name `xml:"COMMAND"`
+		Type                string   `xml:"TYPE"`
+		Msisdn              string   `xml:"MSISDN"`
+		CompanyName         string   `xml:"COMPANYNAME"`
+		CustomerReferenceID string   `xml:"CUSTOMERREFERENCEID"`
+	}
+
+	payCommand struct {
+		XMLName             xml.Name `xml:"COMMAND"`
+		Type                string   `xml:"TYPE"`
+		TxnID               string   `xml:"TXNID"`
+		Msisdn              string   `xml:"MSISDN"`
+		Amount              string   `xml:"AMOUNT"`
+		CompanyName         string   `xml:"COMPANYNAME"`
+		CustomerReferenceID string   `xml:"CUSTOMERREFERENCEID"`
+		SenderName          string   `xml:"SENDERNAME"`
+	}
+
+	// HTTPError is returned when the ussd handler does not reply
+	// with http.StatusOK
+	HTTPError struct {
+		StatusCode int
+		Body       string
+	}
+)
+
+func (e *HTTPError) Error() string {
+	return fmt.Sprintf("tigotest: handler replied %d: %s", e.StatusCode, e.Body)
+}
+
+// NameQuery sends a SYNC_LOOKUP request to url, the URL of a server running
+// ussd.Client.NameQueryServeHTTP, like tigo does before a customer pays.
+// Use ctx to give the handler a deadline.
+func (s *Server) NameQuery(ctx context.Context, url string, request ussd.NameRequest) (ussd.NameResponse, error) {
+	command := nameCommand{
+		Type:                syncLookup,
+		Msisdn:              request.Msisdn,
+		CompanyName:         request.CompanyName,
+		CustomerReferenceID: request.CustomerReferenceID,
+	}
+
+	var response ussd.NameResponse
+	err := s.send(ctx, url, command, &response)
+
+	return response, err
+}
+
+// BillPay sends a SYNC_BILLPAY request to url, the URL of a server running
+// ussd.Client.PaymentServeHTTP, like tigo does when a customer pays through
+// ussd. Calling BillPay again with the same request simulates tigo resending
+// a payment it got no reply for. Use ctx to give the handler a deadline.
+func (s *Server) BillPay(ctx context.Context, url string, request ussd.PayRequest) (ussd.PayResponse, error) {
+	command := payCommand{
+		Type:                syncBillPay,
+		TxnID:               request.TxnID,
+		Msisdn:              request.Msisdn,
+		Amount:              request.Amount.String(),
+		CompanyName:         request.CompanyName,
+		CustomerReferenceID: request.CustomerReferenceID,
+		SenderName:          request.SenderName,
+	}
+
+	var response ussd.PayResponse
+	err := s.send(ctx, url, command, &response)
+
+	return response, err
+}
+
+func (s *Server) send(ctx context.Context, url string, command, v interface{}) error {
+	buf, err := xml.Marshal(command)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(buf))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/xml")
+
+	res, err := s.http.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	body, err := io.ReadAll(res.Body)
+	if err != nil {
+		return err
+	}
+
+	if res.StatusCode != http.StatusOK {
+		return &HTTPError{StatusCode: res.StatusCode, Body: string(bytes.TrimSpace(body))}
+	}
+
+	return xml.Unmarshal(body, v)
+}
@@ -0,0 +1,85 @@
+/*
+ * MIT License
+ *
+ * Copyright (c) 2021 TechCraft Technologies Co. Ltd
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ *
+ */
+package ussd_test
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/techcraftlabs/tigopesa/money"
+	"github.com/techcraftlabs/tigopesa/tigotest"
+	"github.com/techcraftlabs/tigopesa/ussd"
+)
+
+func TestClient_HandlerContext(t *testing.T) {
+	server := tigotest.NewServer()
+	defer server.Close()
+
+	done := make(chan error, 1)
+	payments := ussd.PaymentHandleFunc(func(ctx context.Context, request ussd.PayRequest) (ussd.PayResponse, error) {
+		<-ctx.Done()
+		done <- ctx.Err()
+		return ussd.PayResponse{}, ctx.Err()
+	})
+	names := ussd.NameQueryFunc(func(ctx context.Context, request ussd.NameRequest) (ussd.NameResponse, error) {
+		<-ctx.Done()
+		done <- ctx.Err()
+		return ussd.NameResponse{}, ctx.Err()
+	})
+	client := ussd.NewClient(&ussd.Config{}, payments, names, ussd.WithDebugMode(false),
+		ussd.WithTimeouts(ussd.Timeouts{NameQuery: 50 * time.Millisecond, Payment: time.Minute}))
+
+	namecheck := httptest.NewServer(http.HandlerFunc(client.NameQueryServeHTTP))
+	defer namecheck.Close()
+	billpay := httptest.NewServer(http.HandlerFunc(client.PaymentServeHTTP))
+	defer billpay.Close()
+
+	_, _ = server.NameQuery(context.Background(), namecheck.URL, ussd.NameRequest{Msisdn: "255713123456"})
+	select {
+	case err := <-done:
+		if !errors.Is(err, context.DeadlineExceeded) {
+			t.Errorf("name query: expected deadline exceeded got %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("name query handler was not stopped by its timeout")
+	}
+
+	// tigo going away must cancel the payment handler long before its timeout
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(50*time.Millisecond, cancel)
+	_, _ = server.BillPay(ctx, billpay.URL, ussd.PayRequest{TxnID: "TXN1", Msisdn: "255713123456", Amount: money.Shillings(1000)})
+	select {
+	case err := <-done:
+		if !errors.Is(err, context.Canceled) {
+			t.Errorf("payment: expected context canceled got %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("payment handler was not canceled with the request")
+	}
+}
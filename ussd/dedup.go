@@ -0,0 +1,156 @@
+/*
+ * MIT License
+ *
+ * Copyright (c) 2021 TechCraft Technologies Co. Ltd
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ *
+ */
+
+package ussd
+
+import (
+	"context"
+	"time"
+
+	"github.com/techcraftlabs/tigopesa/internal/ttl"
+)
+
+const defaultPaymentTTL = 24 * time.Hour
+
+var _ PaymentStore = (*memoryPaymentStore)(nil)
+
+type (
+	// PaymentStore keeps the PayResponse returned for every wallet to account
+	// payment keyed by the TXNID tigo assigned to it, so that a payment tigo
+	// resends is answered without calling the PaymentHandler again. Get
+	// returns ok false when txnID is not stored or has expired.
+	PaymentStore interface {
+		Get(ctx context.Context, txnID string) (response PayResponse, ok bool, err error)
+		Put(ctx context.Context, txnID string, response PayResponse, ttl time.Duration) error
+	}
+
+	memoryPaymentStore struct {
+		entries ttl.Map
+	}
+
+	// paymentCall is a payment being handled, replays wait for done
+	paymentCall struct {
+		done     chan struct{}
+		response PayResponse
+		err      error
+	}
+)
+
+// NewMemoryPaymentStore returns a PaymentStore that keeps the responses in
+// memory, expired responses are dropped as new ones are added. It is the
+// default PaymentStore used by Client.
+func NewMemoryPaymentStore() PaymentStore {
+	return &memoryPaymentStore{}
+}
+
+func (m *memoryPaymentStore) Get(_ context.Context, txnID string) (PayResponse, bool, error) {
+	stored, ok := m.entries.Get(txnID)
+	if !ok {
+		return PayResponse{}, false, nil
+	}
+
+	return stored.(PayResponse), true, nil
+}
+
+func (m *memoryPaymentStore) Put(_ context.Context, txnID string, response PayResponse, ttl time.Duration) error {
+	m.entries.Put(txnID, response, ttl)
+	return nil
+}
+
+// handlePayment passes request to the PaymentHandler unless a payment with
+// the same TxnID has already been handled, in which case the stored response
+// is returned. A replay that arrives while the first payment is still being
+// handled waits for it and gets the same result.
+//
+// Responses are stored only when the PaymentHandler returns no error.
+// Payments outside the limits set by WithAmountLimits are rejected without
+// calling the PaymentHandler.
+func (c *Client) handlePayment(ctx context.Context, request PayRequest) (PayResponse, error) {
+	if err := c.limits.Check(request.Amount); err != nil {
+		return rejectAmount(request, err), nil
+	}
+
+	number, err := c.normalize(request.Msisdn)
+	if err != nil {
+		return rejectPayMSISDN(request, err), nil
+	}
+	request.Msisdn = number
+
+	txnID := request.TxnID
+	if txnID == "" {
+		return c.receivePayment(ctx, request)
+	}
+
+	response, ok, err := c.payments.Get(ctx, txnID)
+	if err != nil {
+		return PayResponse{}, err
+	}
+	if ok {
+		return response, nil
+	}
+
+	c.inflightMu.Lock()
+	if call, ok := c.inflight[txnID]; ok {
+		c.inflightMu.Unlock()
+		select {
+		case <-ctx.Done():
+			return PayResponse{}, ctx.Err()
+		case <-call.done:
+			return call.response, call.err
+		}
+	}
+
+	if c.inflight == nil {
+		c.inflight = make(map[string]*paymentCall)
+	}
+	call := &paymentCall{done: make(chan struct{})}
+	c.inflight[txnID] = call
+	c.inflightMu.Unlock()
+
+	defer func() {
+		c.inflightMu.Lock()
+		delete(c.inflight, txnID)
+		c.inflightMu.Unlock()
+		close(call.done)
+	}()
+
+	// the first call may have completed between the lookup above and
+	// registering this one as in flight
+	call.response, ok, call.err = c.payments.Get(ctx, txnID)
+	if call.err != nil || ok {
+		return call.response, call.err
+	}
+
+	call.response, call.err = c.receivePayment(ctx, request)
+	if call.err != nil {
+		return call.response, call.err
+	}
+
+	// the payment has been handled, failing to store its response must
+	// not turn into an error reply that makes tigo send it again
+	_ = c.payments.Put(ctx, txnID, call.response, c.paymentTTL)
+
+	return call.response, nil
+}
@@ -0,0 +1,108 @@
+/*
+ * MIT License
+ *
+ * Copyright (c) 2021 TechCraft Technologies Co. Ltd
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ *
+ */
+
+package ussd_test
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/techcraftlabs/tigopesa/money"
+	"github.com/techcraftlabs/tigopesa/tigotest"
+	"github.com/techcraftlabs/tigopesa/ussd"
+)
+
+func TestClient_PaymentDeduplication(t *testing.T) {
+	server := tigotest.NewServer()
+	defer server.Close()
+
+	var credited int32
+	handler := ussd.PaymentHandleFunc(func(ctx context.Context, request ussd.PayRequest) (ussd.PayResponse, error) {
+		n := atomic.AddInt32(&credited, 1)
+		time.Sleep(100 * time.Millisecond)
+		return ussd.PayResponse{
+			TxnID:     request.TxnID,
+			RefID:     fmt.Sprintf("RECEIPT%d", n),
+			Result:    "TS",
+			ErrorCode: ussd.ErrSuccessTxn,
+			Msisdn:    request.Msisdn,
+		}, nil
+	})
+
+	client := ussd.NewClient(&ussd.Config{}, handler, nil, ussd.WithDebugMode(false))
+	payments := httptest.NewServer(http.HandlerFunc(client.PaymentServeHTTP))
+	defer payments.Close()
+
+	payment := ussd.PayRequest{
+		TxnID:               "TXN001",
+		Msisdn:              "255713123456",
+		Amount:              money.Shillings(5000),
+		CompanyName:         "COMPANY",
+		CustomerReferenceID: "ACC001",
+	}
+
+	var wg sync.WaitGroup
+	responses := make([]ussd.PayResponse, 4)
+	for i := range responses {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			res, err := server.BillPay(context.Background(), payments.URL, payment)
+			if err != nil {
+				t.Errorf("bill pay %d: %v", i, err)
+			}
+			responses[i] = res
+		}(i)
+	}
+	wg.Wait()
+
+	replay, err := server.BillPay(context.Background(), payments.URL, payment)
+	if err != nil {
+		t.Fatal(err)
+	}
+	responses = append(responses, replay)
+
+	for i, res := range responses {
+		if res.RefID != "RECEIPT1" {
+			t.Errorf("response %d: got %+v", i, res)
+		}
+	}
+
+	if n := atomic.LoadInt32(&credited); n != 1 {
+		t.Errorf("handler calls: got %d want 1", n)
+	}
+
+	payment.TxnID = "TXN002"
+	res, err := server.BillPay(context.Background(), payments.URL, payment)
+	if err != nil || res.RefID != "RECEIPT2" {
+		t.Errorf("new payment: %+v %v", res, err)
+	}
+}
@@ -0,0 +1,68 @@
+/*
+ * MIT License
+ *
+ * Copyright (c) 2021 TechCraft Technologies Co. Ltd
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ *
+ */
+package ussd
+
+import (
+	"context"
+	"time"
+
+	"github.com/techcraftlabs/tigopesa/events"
+)
+
+// receivePayment passes request to the PaymentHandler and publishes
+// events.USSDPaymentReceived once it has been answered.
+func (c *Client) receivePayment(ctx context.Context, request PayRequest) (PayResponse, error) {
+	response, err := c.paymentHandler().HandlePayRequest(ctx, request)
+	if err != nil {
+		return response, err
+	}
+
+	c.events.Publish(ctx, events.USSDPaymentReceived{
+		Time:                time.Now(),
+		TxnID:               request.TxnID,
+		MSISDN:              request.Msisdn,
+		Amount:              request.Amount,
+		CompanyName:         request.CompanyName,
+		CustomerReferenceID: request.CustomerReferenceID,
+		SenderName:          request.SenderName,
+		RefID:               response.RefID,
+		Result:              response.Result,
+		ErrorCode:           response.ErrorCode,
+	})
+
+	return response, nil
+}
+
+func (c *Client) publishNameQuery(ctx context.Context, request NameRequest, response NameResponse) {
+	c.events.Publish(ctx, events.NameQueried{
+		Time:                time.Now(),
+		MSISDN:              request.Msisdn,
+		CompanyName:         request.CompanyName,
+		CustomerReferenceID: request.CustomerReferenceID,
+		Result:              response.Result,
+		ErrorCode:           response.ErrorCode,
+		Name:                response.Content,
+	})
+}
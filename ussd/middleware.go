@@ -0,0 +1,76 @@
+/*
+ * MIT License
+ *
+ * Copyright (c) 2021 TechCraft Technologies Co. Ltd
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ *
+ */
+
+package ussd
+
+type (
+	// PaymentMiddleware wraps a PaymentHandler to run logic like validation,
+	// logging or metrics on the decoded PayRequest and PayResponse.
+	PaymentMiddleware func(next PaymentHandler) PaymentHandler
+
+	// NameQueryMiddleware wraps a NameQueryHandler, see PaymentMiddleware
+	NameQueryMiddleware func(next NameQueryHandler) NameQueryHandler
+)
+
+// UsePayment adds middlewares to the chain that wraps the PaymentHandler.
+// The first middleware added is the outermost one. Payments that tigo
+// resends are answered before the chain runs, see WithPaymentStore.
+func (c *Client) UsePayment(middlewares ...PaymentMiddleware) {
+	c.mwMu.Lock()
+	defer c.mwMu.Unlock()
+	c.paymentMiddlewares = append(c.paymentMiddlewares, middlewares...)
+}
+
+// UseNameQuery adds middlewares to the chain that wraps the NameQueryHandler.
+// The first middleware added is the outermost one.
+func (c *Client) UseNameQuery(middlewares ...NameQueryMiddleware) {
+	c.mwMu.Lock()
+	defer c.mwMu.Unlock()
+	c.nameQueryMiddlewares = append(c.nameQueryMiddlewares, middlewares...)
+}
+
+func (c *Client) paymentHandler() PaymentHandler {
+	c.mwMu.RLock()
+	defer c.mwMu.RUnlock()
+
+	handler := c.ph
+	for i := len(c.paymentMiddlewares) - 1; i >= 0; i-- {
+		handler = c.paymentMiddlewares[i](handler)
+	}
+
+	return handler
+}
+
+func (c *Client) nameQueryHandler() NameQueryHandler {
+	c.mwMu.RLock()
+	defer c.mwMu.RUnlock()
+
+	handler := c.nh
+	for i := len(c.nameQueryMiddlewares) - 1; i >= 0; i-- {
+		handler = c.nameQueryMiddlewares[i](handler)
+	}
+
+	return handler
+}
@@ -0,0 +1,109 @@
+/*
+ * MIT License
+ *
+ * Copyright (c) 2021 TechCraft Technologies Co. Ltd
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ *
+ */
+
+package ussd_test
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+
+	"github.com/techcraftlabs/tigopesa/money"
+	"github.com/techcraftlabs/tigopesa/tigotest"
+	"github.com/techcraftlabs/tigopesa/ussd"
+)
+
+func TestClient_Middlewares(t *testing.T) {
+	server := tigotest.NewServer()
+	defer server.Close()
+
+	var calls []string
+	trace := func(name string) ussd.PaymentMiddleware {
+		return func(next ussd.PaymentHandler) ussd.PaymentHandler {
+			return ussd.PaymentHandleFunc(func(ctx context.Context, request ussd.PayRequest) (ussd.PayResponse, error) {
+				calls = append(calls, name)
+				return next.HandlePayRequest(ctx, request)
+			})
+		}
+	}
+
+	payments := ussd.PaymentHandleFunc(func(ctx context.Context, request ussd.PayRequest) (ussd.PayResponse, error) {
+		calls = append(calls, "handler")
+		return ussd.PayResponse{TxnID: request.TxnID, Result: "TS", ErrorCode: ussd.ErrSuccessTxn}, nil
+	})
+
+	names := ussd.NameQueryFunc(func(ctx context.Context, request ussd.NameRequest) (ussd.NameResponse, error) {
+		t.Error("name query handler called for an unknown reference")
+		return ussd.NameResponse{}, nil
+	})
+
+	client := ussd.NewClient(&ussd.Config{}, payments, names, ussd.WithDebugMode(false))
+	client.UsePayment(trace("first"), trace("second"))
+	client.UseNameQuery(func(next ussd.NameQueryHandler) ussd.NameQueryHandler {
+		return ussd.NameQueryFunc(func(ctx context.Context, request ussd.NameRequest) (ussd.NameResponse, error) {
+			if request.CustomerReferenceID != "ACC001" {
+				return ussd.NameResponse{
+					Result:    "TF",
+					ErrorCode: ussd.ErrNameNotRegistered,
+					Msisdn:    request.Msisdn,
+				}, nil
+			}
+			return next.HandleNameQuery(ctx, request)
+		})
+	})
+
+	paySrv := httptest.NewServer(http.HandlerFunc(client.PaymentServeHTTP))
+	defer paySrv.Close()
+	nameSrv := httptest.NewServer(http.HandlerFunc(client.NameQueryServeHTTP))
+	defer nameSrv.Close()
+
+	_, err := server.BillPay(context.Background(), paySrv.URL, ussd.PayRequest{
+		TxnID:               "TXN001",
+		Msisdn:              "255713123456",
+		Amount:              money.Shillings(1000),
+		CustomerReferenceID: "ACC001",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if want := []string{"first", "second", "handler"}; !reflect.DeepEqual(calls, want) {
+		t.Errorf("calls: got %v want %v", calls, want)
+	}
+
+	res, err := server.NameQuery(context.Background(), nameSrv.URL, ussd.NameRequest{
+		Msisdn:              "255713123456",
+		CustomerReferenceID: "UNKNOWN",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if res.ErrorCode != ussd.ErrNameNotRegistered {
+		t.Errorf("name query: got %+v", res)
+	}
+}
@@ -26,8 +26,18 @@
 package ussd
 
 import (
+	"github.com/techcraftlabs/tigopesa/events"
+	"github.com/techcraftlabs/tigopesa/guard"
+	"github.com/techcraftlabs/tigopesa/internal/timeout"
+	"github.com/techcraftlabs/tigopesa/internal/tracing"
+	"github.com/techcraftlabs/tigopesa/metrics"
+	"github.com/techcraftlabs/tigopesa/money"
+	"github.com/techcraftlabs/tigopesa/msisdn"
+	"github.com/techcraftlabs/tigopesa/redact"
+	"go.opentelemetry.io/otel/trace"
 	"io"
 	"net/http"
+	"time"
 )
 
 // ClientOption is a setter func to set Client details like
@@ -72,3 +82,112 @@ func WithHTTPClient(httpClient *http.Client) ClientOption {
 		client.base.Http = httpClient
 	}
 }
+
+// WithRedactor sets the redact.Redactor used to mask secrets like the PIN,
+// passwords and tokens in debug logs. By default secrets are masked and
+// MSISDNs are not, a nil r is ignored.
+func WithRedactor(r *redact.Redactor) ClientOption {
+	return func(client *Client) {
+		if r == nil {
+			return
+		}
+		client.redactor = r
+	}
+}
+
+// WithPaymentStore replaces the in memory PaymentStore used to recognise
+// payments tigo resends. Responses are kept for ttl, values of zero or less
+// mean 24 hours. A nil store is ignored.
+func WithPaymentStore(store PaymentStore, ttl time.Duration) ClientOption {
+	return func(client *Client) {
+		if store == nil {
+			return
+		}
+		if ttl <= 0 {
+			ttl = defaultPaymentTTL
+		}
+		client.payments = store
+		client.paymentTTL = ttl
+	}
+}
+
+// WithInboundGuard sets the guard.Guard that authenticates the name queries and payments
+// tigo sends, rejected calls are answered with 401 or 403 before they are
+// decoded. By default all calls are accepted.
+func WithInboundGuard(g *guard.Guard) ClientOption {
+	return func(client *Client) {
+		client.guard = g
+	}
+}
+
+// WithAmountLimits sets the smallest and largest amounts accepted for wallet to account payments, zero
+// values mean no limit. Amounts that are not positive are always rejected.
+func WithAmountLimits(limits money.Limits) ClientOption {
+	return func(client *Client) {
+		client.limits = limits
+	}
+}
+
+// WithMSISDNNormalizer sets the msisdn.Normalizer applied to the MSISDN of
+// name queries and payments before they reach the handlers, requests for
+// numbers it rejects are answered with ErrNameInvalidFormat and
+// ErrInvalidPayment, see WithMSISDNPassThrough.
+// By default msisdn.Default is used, msisdn.Identity turns normalization
+// off. A nil normalizer is ignored.
+func WithMSISDNNormalizer(normalizer msisdn.Normalizer) ClientOption {
+	return func(client *Client) {
+		if normalizer == nil {
+			return
+		}
+		client.normalizer = normalizer
+	}
+}
+
+// WithMSISDNPassThrough makes the client pass numbers the msisdn.Normalizer
+// rejects to the handlers unchanged instead of rejecting the request.
+func WithMSISDNPassThrough() ClientOption {
+	return func(client *Client) {
+		client.passThrough = true
+	}
+}
+
+// WithMetrics sets the metrics.Recorder that observes the name queries and
+// payments,
+// a nil recorder is ignored.
+func WithMetrics(recorder metrics.Recorder) ClientOption {
+	return func(client *Client) {
+		if recorder == nil {
+			return
+		}
+		client.metrics = recorder
+	}
+}
+
+// WithTracerProvider sets the OpenTelemetry TracerProvider used to trace
+// the name queries and payments, the global provider is used by default.
+func WithTracerProvider(provider trace.TracerProvider) ClientOption {
+	return func(client *Client) {
+		client.tracer = tracing.Tracer(provider, "ussd")
+	}
+}
+
+// WithTimeouts sets how long the name query and payment handlers may take,
+// zero fields keep the value from DefaultTimeouts.
+func WithTimeouts(timeouts Timeouts) ClientOption {
+	return func(client *Client) {
+		client.timeouts = timeouts
+		timeout.Defaults(&client.timeouts, DefaultTimeouts)
+	}
+}
+
+// WithEventSink sets the events.Sink that gets an event for every name
+// query and payment answered, a sink that panics does not affect the
+// client. A nil sink is ignored.
+func WithEventSink(sink events.Sink) ClientOption {
+	return func(client *Client) {
+		if sink == nil {
+			return
+		}
+		client.events = events.Safe(sink)
+	}
+}
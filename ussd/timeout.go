@@ -0,0 +1,50 @@
+/*
+ * MIT License
+ *
+ * Copyright (c) 2021 TechCraft Technologies Co. Ltd
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ *
+ */
+package ussd
+
+import (
+	"time"
+
+	"github.com/techcraftlabs/tigopesa/internal/timeout"
+)
+
+// Timeouts bounds the handlers of the requests tigo makes, their context is
+// derived from the context of the incoming http.Request so they are also
+// cancelled when tigo goes away. A zero field keeps the default from
+// DefaultTimeouts, a negative one leaves the handler bounded only by the
+// request.
+type Timeouts struct {
+	// NameQuery bounds the NameQueryHandler.
+	NameQuery time.Duration
+
+	// Payment bounds the PaymentHandler.
+	Payment time.Duration
+}
+
+// DefaultTimeouts are the Timeouts used unless WithTimeouts says otherwise.
+var DefaultTimeouts = Timeouts{
+	NameQuery: timeout.Default,
+	Payment:   timeout.Default,
+}
@@ -28,8 +28,20 @@ package ussd
 import (
 	"context"
 	"encoding/xml"
+	"errors"
 	"github.com/techcraftlabs/base"
+	"github.com/techcraftlabs/tigopesa/events"
+	"github.com/techcraftlabs/tigopesa/guard"
+	"github.com/techcraftlabs/tigopesa/internal/timeout"
+	"github.com/techcraftlabs/tigopesa/internal/tracing"
+	"github.com/techcraftlabs/tigopesa/metrics"
+	"github.com/techcraftlabs/tigopesa/money"
+	"github.com/techcraftlabs/tigopesa/msisdn"
+	"github.com/techcraftlabs/tigopesa/redact"
+	"github.com/techcraftlabs/tigopesa/tigoerr"
+	"go.opentelemetry.io/otel/trace"
 	"net/http"
+	"sync"
 	"time"
 )
 
@@ -42,17 +54,17 @@ const (
 	ErrNameUserSuspended = "error030"
 	NoNamecheckErr       = "error000"
 
-	ErrSuccessTxn               = "error000"
-	ErrServiceNotAvailable      = "error001"
-	ErrInvalidCustomerRefNumber = "error010"
-	ErrCustomerRefNumLocked     = "error011"
-	ErrInvalidAmount            = "error012"
-	ErrAmountInsufficient       = "error013"
-	ErrAmountTooHigh            = "error014"
-	ErrAmountTooLow             = "error015"
-	ErrInvalidPayment           = "error016"
-	ErrGeneralError             = "error100"
-	ErrRetryConditionNoResponse = "error111"
+	ErrSuccessTxn               = tigoerr.CodeSuccess
+	ErrServiceNotAvailable      = tigoerr.CodeServiceNotAvailable
+	ErrInvalidCustomerRefNumber = tigoerr.CodeInvalidCustomerRefNum
+	ErrCustomerRefNumLocked     = tigoerr.CodeCustomerRefNumLocked
+	ErrInvalidAmount            = tigoerr.CodeInvalidAmount
+	ErrAmountInsufficient       = tigoerr.CodeAmountInsufficient
+	ErrAmountTooHigh            = tigoerr.CodeAmountTooHigh
+	ErrAmountTooLow             = tigoerr.CodeAmountTooLow
+	ErrInvalidPayment           = tigoerr.CodeInvalidPayment
+	ErrGeneralError             = tigoerr.CodeGeneralError
+	ErrRetryConditionNoResponse = tigoerr.CodeRetryConditionNoResponse
 )
 
 var (
@@ -116,24 +128,24 @@ type (
 	}
 
 	PayRequest struct {
-		TxnID               string  `xml:"TXNID"`
-		Msisdn              string  `xml:"MSISDN"`
-		Amount              float64 `xml:"AMOUNT"`
-		CompanyName         string  `xml:"COMPANYNAME"`
-		CustomerReferenceID string  `xml:"CUSTOMERREFERENCEID"`
-		SenderName          string  `xml:"SENDERNAME"`
+		TxnID               string       `xml:"TXNID"`
+		Msisdn              string       `xml:"MSISDN"`
+		Amount              money.Amount `xml:"AMOUNT"`
+		CompanyName         string       `xml:"COMPANYNAME"`
+		CustomerReferenceID string       `xml:"CUSTOMERREFERENCEID"`
+		SenderName          string       `xml:"SENDERNAME"`
 	}
 
 	payRequest struct {
-		XMLName             xml.Name `xml:"COMMAND"`
-		Text                string   `xml:",chardata"`
-		TYPE                string   `xml:"TYPE"`
-		TxnID               string   `xml:"TXNID"`
-		Msisdn              string   `xml:"MSISDN"`
-		Amount              float64  `xml:"AMOUNT"`
-		CompanyName         string   `xml:"COMPANYNAME"`
-		CustomerReferenceID string   `xml:"CUSTOMERREFERENCEID"`
-		SenderName          string   `xml:"SENDERNAME"`
+		XMLName             xml.Name     `xml:"COMMAND"`
+		Text                string       `xml:",chardata"`
+		TYPE                string       `xml:"TYPE"`
+		TxnID               string       `xml:"TXNID"`
+		Msisdn              string       `xml:"MSISDN"`
+		Amount              money.Amount `xml:"AMOUNT"`
+		CompanyName         string       `xml:"COMPANYNAME"`
+		CustomerReferenceID string       `xml:"CUSTOMERREFERENCEID"`
+		SenderName          string       `xml:"SENDERNAME"`
 	}
 
 	PayResponse struct {
@@ -174,8 +186,27 @@ type (
 		rp   base.Replier
 		base *base.Client
 		*Config
-		ph PaymentHandler
-		nh NameQueryHandler
+		ph          PaymentHandler
+		nh          NameQueryHandler
+		redactor    *redact.Redactor
+		limits      money.Limits
+		normalizer  msisdn.Normalizer
+		passThrough bool
+		metrics     metrics.Recorder
+		tracer      trace.Tracer
+
+		payments   PaymentStore
+		paymentTTL time.Duration
+		inflightMu sync.Mutex
+		inflight   map[string]*paymentCall
+
+		mwMu                 sync.RWMutex
+		paymentMiddlewares   []PaymentMiddleware
+		nameQueryMiddlewares []NameQueryMiddleware
+
+		guard    *guard.Guard
+		timeouts Timeouts
+		events   events.Sink
 	}
 )
 
@@ -198,6 +229,71 @@ func transformPayRequest(request payRequest) PayRequest {
 	}
 }
 
+// observe records an inbound request and ends its span, one that failed
+// gets no response whatever code the handler set.
+func (c *Client) observe(span trace.Span, op metrics.Operation, code string, err error, start time.Time) {
+	if err != nil {
+		code = ""
+	}
+	c.metrics.Observe(op, metrics.Code(code, err), time.Since(start))
+	tracing.End(span, code, err)
+}
+
+// normalize returns number normalized by the Normalizer, or the error it
+// rejected number with unless WithMSISDNPassThrough is set in which case
+// number is returned unchanged.
+func (c *Client) normalize(number string) (string, error) {
+	normalized, err := c.normalizer.Normalize(number)
+	if err != nil {
+		if c.passThrough {
+			return number, nil
+		}
+		return number, err
+	}
+
+	return normalized, nil
+}
+
+// rejectNameMSISDN answers a name query for a number the Normalizer rejects
+func rejectNameMSISDN(request NameRequest, err error) NameResponse {
+	return NameResponse{
+		Result:    "TF",
+		ErrorCode: ErrNameInvalidFormat,
+		ErrorDesc: err.Error(),
+		Msisdn:    request.Msisdn,
+	}
+}
+
+// rejectPayMSISDN answers a payment from a number the Normalizer rejects
+func rejectPayMSISDN(request PayRequest, err error) PayResponse {
+	return PayResponse{
+		TxnID:            request.TxnID,
+		Result:           "TF",
+		ErrorCode:        ErrInvalidPayment,
+		ErrorDescription: err.Error(),
+		Msisdn:           request.Msisdn,
+	}
+}
+
+// rejectAmount answers a payment that failed the amount limits check
+func rejectAmount(request PayRequest, err error) PayResponse {
+	code := ErrInvalidAmount
+	switch {
+	case errors.Is(err, tigoerr.ErrAmountTooLow):
+		code = ErrAmountTooLow
+	case errors.Is(err, tigoerr.ErrAmountTooHigh):
+		code = ErrAmountTooHigh
+	}
+
+	return PayResponse{
+		TxnID:            request.TxnID,
+		Result:           "TF",
+		ErrorCode:        code,
+		ErrorDescription: err.Error(),
+		Msisdn:           request.Msisdn,
+	}
+}
+
 func transformToXMLNameResponse(response NameResponse) nameResponse {
 	return nameResponse{
 		Type:      syncLookupResponse,
@@ -227,16 +323,25 @@ func transformToXMLPayResponse(response PayResponse) payResponse {
 func NewClient(config *Config, handler PaymentHandler, queryHandler NameQueryHandler, opts ...ClientOption) *Client {
 	client := &Client{
 
-		Config: config,
-		ph:     handler,
-		nh:     queryHandler,
-		base:   base.NewClient(),
+		Config:     config,
+		ph:         handler,
+		nh:         queryHandler,
+		base:       base.NewClient(),
+		redactor:   redact.New(),
+		normalizer: msisdn.Default,
+		metrics:    metrics.Noop,
+		tracer:     tracing.Tracer(nil, "ussd"),
+		payments:   NewMemoryPaymentStore(),
+		paymentTTL: defaultPaymentTTL,
+		timeouts:   DefaultTimeouts,
+		events:     events.Discard,
 	}
 
 	for _, opt := range opts {
 		opt(client)
 	}
 
+	client.base.Logger = client.redactor.Writer(client.base.Logger)
 	lg, dm := client.base.Logger, client.base.DebugMode
 
 	client.rp = base.NewReplier(lg, dm)
@@ -262,20 +367,49 @@ func (handler NameQueryFunc) HandleNameQuery(ctx context.Context, request NameRe
 }
 
 func (c *Client) NameQueryServeHTTP(writer http.ResponseWriter, request *http.Request) {
+	if !c.guard.Allow(writer, request, "name query") {
+		return
+	}
 
-	ctx, cancel := context.WithTimeout(context.TODO(), 60*time.Second)
+	var (
+		response NameResponse
+		err      error
+	)
+
+	ctx, span := c.tracer.Start(tracing.Extract(request), "tigopesa.ussd.name_query",
+		trace.WithSpanKind(trace.SpanKindServer))
+
+	start := time.Now()
+	defer func() {
+		c.observe(span, metrics.NameQuery, response.ErrorCode, err, start)
+	}()
+
+	ctx, cancel := timeout.With(ctx, c.timeouts.NameQuery)
 	defer cancel()
 	var req nameRequest
 
-	_, err := c.rv.Receive(ctx, "name query", request, &req)
+	_, err = c.rv.Receive(ctx, "name query", request, &req)
 	if err != nil {
 		http.Error(writer, err.Error(), http.StatusInternalServerError)
+		return
 	}
 
-	response, err := c.nh.HandleNameQuery(ctx, transformNameRequest(req))
+	nameRequest := transformNameRequest(req)
+	span.SetAttributes(tracing.CustomerReferenceID.String(nameRequest.CustomerReferenceID))
 
-	if err != nil {
-		http.Error(writer, err.Error(), http.StatusInternalServerError)
+	if number, invalid := c.normalize(nameRequest.Msisdn); invalid != nil {
+		// the handler never sees numbers the Normalizer rejects
+		response = rejectNameMSISDN(nameRequest, invalid)
+	} else {
+		nameRequest.Msisdn = number
+		response, err = c.nameQueryHandler().HandleNameQuery(ctx, nameRequest)
+
+		if err != nil {
+			http.Error(writer, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		c.publishNameQuery(ctx, nameRequest, response)
 	}
 
 	var opts []base.ResponseOption
@@ -293,22 +427,48 @@ func (c *Client) NameQueryServeHTTP(writer http.ResponseWriter, request *http.Re
 }
 
 func (c *Client) PaymentServeHTTP(writer http.ResponseWriter, request *http.Request) {
+	if !c.guard.Allow(writer, request, "payment request") {
+		return
+	}
 
-	ctx, cancel := context.WithTimeout(context.TODO(), 60*time.Second)
+	var (
+		response PayResponse
+		err      error
+	)
+
+	ctx, span := c.tracer.Start(tracing.Extract(request), "tigopesa.ussd.payment",
+		trace.WithSpanKind(trace.SpanKindServer))
+
+	start := time.Now()
+	defer func() {
+		if response.RefID != "" {
+			span.SetAttributes(tracing.ReferenceID.String(response.RefID))
+		}
+		c.observe(span, metrics.Payment, response.ErrorCode, err, start)
+	}()
+
+	ctx, cancel := timeout.With(ctx, c.timeouts.Payment)
 	defer cancel()
 
 	var req payRequest
 
-	_, err := c.rv.Receive(ctx, "payment request", request, &req)
+	_, err = c.rv.Receive(ctx, "payment request", request, &req)
 	if err != nil {
 		http.Error(writer, err.Error(), http.StatusInternalServerError)
 		return
 	}
 
-	response, err := c.ph.HandlePayRequest(ctx, transformPayRequest(req))
+	payRequest := transformPayRequest(req)
+	span.SetAttributes(
+		tracing.TxnID.String(payRequest.TxnID),
+		tracing.CustomerReferenceID.String(payRequest.CustomerReferenceID),
+	)
+
+	response, err = c.handlePayment(ctx, payRequest)
 
 	if err != nil {
 		http.Error(writer, err.Error(), http.StatusInternalServerError)
+		return
 	}
 
 	var opts []base.ResponseOption
@@ -0,0 +1,143 @@
+/*
+ * MIT License
+ *
+ * Copyright (c) 2021 TechCraft Technologies Co. Ltd
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ *
+ */
+
+package ussd_test
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/techcraftlabs/tigopesa/money"
+	"github.com/techcraftlabs/tigopesa/tigotest"
+	"github.com/techcraftlabs/tigopesa/ussd"
+)
+
+func TestClient_PaymentAmountLimits(t *testing.T) {
+	server := tigotest.NewServer()
+	defer server.Close()
+
+	limits := money.Limits{Min: money.Shillings(500), Max: money.Shillings(1000000)}
+
+	payments := ussd.PaymentHandleFunc(func(ctx context.Context, request ussd.PayRequest) (ussd.PayResponse, error) {
+		t.Error("payment handler called for an amount out of limits")
+		return ussd.PayResponse{}, nil
+	})
+	client := ussd.NewClient(&ussd.Config{}, payments, nil, ussd.WithDebugMode(false), ussd.WithAmountLimits(limits))
+	billpay := httptest.NewServer(http.HandlerFunc(client.PaymentServeHTTP))
+	defer billpay.Close()
+
+	pay, err := server.BillPay(context.Background(), billpay.URL, ussd.PayRequest{
+		TxnID:  "TXN001",
+		Msisdn: "255713123456",
+		Amount: money.MustParse("499.99"),
+	})
+	if err != nil || pay.ErrorCode != ussd.ErrAmountTooLow || pay.Result != "TF" {
+		t.Errorf("ussd: %+v %v", pay, err)
+	}
+}
+
+func TestClient_PaymentMSISDNNormalization(t *testing.T) {
+	server := tigotest.NewServer()
+	defer server.Close()
+
+	var received string
+	payments := ussd.PaymentHandleFunc(func(ctx context.Context, request ussd.PayRequest) (ussd.PayResponse, error) {
+		received = request.Msisdn
+		return ussd.PayResponse{TxnID: request.TxnID, Result: "TS", ErrorCode: ussd.ErrSuccessTxn}, nil
+	})
+	client := ussd.NewClient(&ussd.Config{}, payments, nil, ussd.WithDebugMode(false))
+	billpay := httptest.NewServer(http.HandlerFunc(client.PaymentServeHTTP))
+	defer billpay.Close()
+
+	if _, err := server.BillPay(context.Background(), billpay.URL, ussd.PayRequest{TxnID: "TXN1", Msisdn: "+255 713 123 456", Amount: money.Shillings(1000)}); err != nil {
+		t.Fatal(err)
+	}
+	if received != "255713123456" {
+		t.Errorf("ussd msisdn: got %q", received)
+	}
+}
+
+func TestClient_InvalidMSISDN(t *testing.T) {
+	server := tigotest.NewServer()
+	defer server.Close()
+
+	tests := []struct {
+		name   string
+		opts   []ussd.ClientOption
+		reject bool
+	}{
+		{name: "rejected by default", reject: true},
+		{name: "passed through", opts: []ussd.ClientOption{ussd.WithMSISDNPassThrough()}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var received []string
+			payments := ussd.PaymentHandleFunc(func(ctx context.Context, request ussd.PayRequest) (ussd.PayResponse, error) {
+				received = append(received, request.Msisdn)
+				return ussd.PayResponse{TxnID: request.TxnID, Result: "TS", ErrorCode: ussd.ErrSuccessTxn}, nil
+			})
+			names := ussd.NameQueryFunc(func(ctx context.Context, request ussd.NameRequest) (ussd.NameResponse, error) {
+				received = append(received, request.Msisdn)
+				return ussd.NameResponse{Result: "TS", ErrorCode: ussd.NoNamecheckErr, Content: "John Doe"}, nil
+			})
+			opts := append([]ussd.ClientOption{ussd.WithDebugMode(false)}, tt.opts...)
+			client := ussd.NewClient(&ussd.Config{}, payments, names, opts...)
+			billpay := httptest.NewServer(http.HandlerFunc(client.PaymentServeHTTP))
+			defer billpay.Close()
+			namecheck := httptest.NewServer(http.HandlerFunc(client.NameQueryServeHTTP))
+			defer namecheck.Close()
+
+			ctx := context.Background()
+			pay, err := server.BillPay(ctx, billpay.URL, ussd.PayRequest{TxnID: "TXN1", Msisdn: "12345", Amount: money.Shillings(1000)})
+			if err != nil {
+				t.Fatal(err)
+			}
+			name, err := server.NameQuery(ctx, namecheck.URL, ussd.NameRequest{Msisdn: "12345", CustomerReferenceID: "ACC1"})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if !tt.reject {
+				if len(received) != 2 || received[0] != "12345" || received[1] != "12345" || pay.Result != "TS" || name.Result != "TS" {
+					t.Errorf("passed through: %v %+v %+v", received, pay, name)
+				}
+				return
+			}
+
+			if len(received) != 0 {
+				t.Errorf("handlers called with %v", received)
+			}
+			if pay.Result != "TF" || pay.ErrorCode != ussd.ErrInvalidPayment {
+				t.Errorf("payment: %+v", pay)
+			}
+			if name.Result != "TF" || name.ErrorCode != ussd.ErrNameInvalidFormat {
+				t.Errorf("name query: %+v", name)
+			}
+		})
+	}
+}